@@ -0,0 +1,136 @@
+package caskdb
+
+import "time"
+
+// auto_merge file has the background scheduler which merges the store once it has
+// enough dead data, so that nobody has to remember to call Merge. Every
+// CheckInterval, it reads the Stats of the store and merges it if any of the
+// thresholds of AutoMerge is crossed.
+//
+// Merge holds up all the reads and the writes while it runs, so the scheduler never
+// merges more often than once every MinInterval, counting from the end of the last
+// merge, whether it was started by the scheduler or by an explicit Merge. A failed
+// merge waits for MinInterval too, instead of being retried on every check.
+
+const (
+	// DefaultAutoMergeCheckInterval is how often the thresholds are checked, when no
+	// interval is given
+	DefaultAutoMergeCheckInterval = time.Minute
+	// DefaultAutoMergeMinInterval is the least time between two merges of the
+	// scheduler, when none is given
+	DefaultAutoMergeMinInterval = 10 * time.Minute
+)
+
+// AutoMerge is when the store merges itself. The store is merged once any of the
+// thresholds is crossed, a zero threshold is not checked. The zero AutoMerge never
+// merges
+type AutoMerge struct {
+	// DeadRatio merges once the part of the data files taken by the dead records,
+	// from 0 to 1, is over it. Check Stats.DeadRatio
+	DeadRatio float64
+	// DeadSize merges once the dead records take more than this many bytes. Check
+	// Stats.DeadSize
+	DeadSize int64
+	// DataFiles merges once there are more data files than this. Merge packs the
+	// live records into as few files as the max file size allows, so this must be
+	// well above the files the live data needs, else every check merges
+	DataFiles int
+	// CheckInterval is how often the thresholds are checked, and MinInterval is the
+	// least time between two merges. If they are not positive,
+	// DefaultAutoMergeCheckInterval and DefaultAutoMergeMinInterval are used
+	CheckInterval time.Duration
+	MinInterval   time.Duration
+}
+
+// enabled tells whether any of the thresholds is set
+func (a AutoMerge) enabled() bool {
+	return a.DeadRatio > 0 || a.DeadSize > 0 || a.DataFiles > 0
+}
+
+// crossed returns the threshold crossed by the stats, as the name of its field, or
+// "" if there is none
+func (a AutoMerge) crossed(stats Stats) string {
+	switch {
+	case a.DeadRatio > 0 && stats.DeadRatio > a.DeadRatio:
+		return "dead_ratio"
+	case a.DeadSize > 0 && stats.DeadSize > a.DeadSize:
+		return "dead_size"
+	case a.DataFiles > 0 && stats.DataFiles > a.DataFiles:
+		return "data_files"
+	}
+	return ""
+}
+
+// SetAutoMerge changes when the store merges itself, the zero AutoMerge stops it. It
+// does nothing for a read-only store or a replica, which cannot be merged
+func (d *DiskStore) SetAutoMerge(a AutoMerge) {
+	d.mu.Lock()
+	stop, done := d.autoMergeStop, d.autoMergeDone
+	d.autoMergeStop, d.autoMergeDone = nil, nil
+	if a.enabled() && !d.options.ReadOnly && d.replica == nil {
+		if a.CheckInterval <= 0 {
+			a.CheckInterval = DefaultAutoMergeCheckInterval
+		}
+		if a.MinInterval <= 0 {
+			a.MinInterval = DefaultAutoMergeMinInterval
+		}
+		d.autoMergeStop, d.autoMergeDone = make(chan struct{}), make(chan struct{})
+		go d.runAutoMerge(a, d.autoMergeStop, d.autoMergeDone)
+	}
+	d.mu.Unlock()
+	// the old scheduler takes the lock to merge, so we wait for it only after we
+	// have released the lock
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// runAutoMerge checks the thresholds every CheckInterval and merges the store when
+// one is crossed, until stop is closed. It closes done when it returns
+func (d *DiskStore) runAutoMerge(a AutoMerge, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(a.CheckInterval)
+	defer ticker.Stop()
+	// lastAttempt is when the scheduler last tried to merge, so that a failing
+	// merge is not retried on every check
+	var lastAttempt time.Time
+	for {
+		select {
+		case <-ticker.C:
+			stats, err := d.Stats()
+			if err != nil {
+				d.options.Logger.Log(LevelError, "failed to check the auto merge", "dir", d.dirName, "err", err)
+				continue
+			}
+			threshold := a.crossed(stats)
+			if threshold == "" {
+				continue
+			}
+			now := d.now()
+			if now.Sub(stats.LastMerge) < a.MinInterval || now.Sub(lastAttempt) < a.MinInterval {
+				continue
+			}
+			lastAttempt = now
+			d.options.Logger.Log(LevelInfo, "auto merging store", "dir", d.dirName, "threshold", threshold,
+				"dead_ratio", stats.DeadRatio, "dead_size", stats.DeadSize, "data_files", stats.DataFiles)
+			if err := d.Merge(); err != nil {
+				d.options.Logger.Log(LevelError, "failed to auto merge", "dir", d.dirName, "err", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// stopAutoMerge stops the scheduler, if there is one, and waits for it
+func (d *DiskStore) stopAutoMerge() {
+	d.mu.Lock()
+	stop, done := d.autoMergeStop, d.autoMergeDone
+	d.autoMergeStop, d.autoMergeDone = nil, nil
+	d.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
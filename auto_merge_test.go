@@ -0,0 +1,95 @@
+package caskdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutoMerge_crossed(t *testing.T) {
+	stats := Stats{DataFiles: 4, DeadSize: 1000, DeadRatio: 0.5}
+	tests := []struct {
+		name      string
+		autoMerge AutoMerge
+		want      string
+	}{
+		{"none", AutoMerge{}, ""},
+		{"dead ratio", AutoMerge{DeadRatio: 0.4}, "dead_ratio"},
+		{"dead ratio below", AutoMerge{DeadRatio: 0.6}, ""},
+		{"dead size", AutoMerge{DeadSize: 999}, "dead_size"},
+		{"dead size below", AutoMerge{DeadSize: 1000}, ""},
+		{"data files", AutoMerge{DataFiles: 3}, "data_files"},
+		{"data files below", AutoMerge{DataFiles: 4}, ""},
+		{"any", AutoMerge{DeadRatio: 0.9, DeadSize: 5000, DataFiles: 2}, "data_files"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.autoMerge.crossed(stats); got != tt.want {
+				t.Errorf("crossed() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiskStore_SetAutoMerge(t *testing.T) {
+	store, err := Open("test.db", WithAutoMerge(AutoMerge{
+		DeadRatio:     0.5,
+		CheckInterval: time.Millisecond,
+		MinInterval:   time.Nanosecond,
+	}))
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	for i := 0; i < 10; i++ {
+		store.Set("name", "jojo")
+	}
+	var stats Stats
+	deadline := time.Now().Add(time.Second)
+	for {
+		stats, _ = store.Stats()
+		if !stats.LastMerge.IsZero() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the scheduler did not merge the store, Stats() = %+v", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if val, _ := store.Get("name"); val != "jojo" {
+		t.Errorf("Get() = %v, want %v", val, "jojo")
+	}
+
+	// a merge was just done, so the next one waits for the min interval
+	store.SetAutoMerge(AutoMerge{DeadRatio: 0.5, CheckInterval: time.Millisecond, MinInterval: time.Hour})
+	for i := 0; i < 10; i++ {
+		store.Set("name", "dio")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got, _ := store.Stats(); !got.LastMerge.Equal(stats.LastMerge) || got.DeadRatio <= 0.5 {
+		t.Errorf("Stats() = %+v, want no merge within the min interval", got)
+	}
+
+	// the zero AutoMerge stops the scheduler
+	store.SetAutoMerge(AutoMerge{})
+	if store.autoMergeStop != nil {
+		t.Errorf("the scheduler is still running")
+	}
+	store.SetAutoMerge(AutoMerge{DataFiles: 10, CheckInterval: time.Hour})
+	if err := store.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if store.autoMergeStop != nil {
+		t.Errorf("the scheduler is still running after Close")
+	}
+
+	// a read-only store cannot be merged, so it has no scheduler
+	store, err = Open("test.db", WithReadOnly(), WithAutoMerge(AutoMerge{DataFiles: 10}))
+	if err != nil {
+		t.Fatalf("failed to open the store read-only: %v", err)
+	}
+	if store.autoMergeStop != nil {
+		t.Errorf("the scheduler is running on a read-only store")
+	}
+	store.Close()
+}
@@ -0,0 +1,97 @@
+package caskdb
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// backup file has the streaming backup and restore of a store. A backup is an
+// archive of the live records of the store, taken from a snapshot, so the writes go on
+// while it is streamed. Unlike copying the data files, it has no overwritten or
+// deleted records, and it is a single stream which can be piped anywhere, like to S3
+// or to another host.
+//
+// The archive is in the format of a data file: every live key is written as a record
+// with its value, its timestamp and its expiry, in the key order, after the file
+// header. The values in the value logs are written inline, so the archive has
+// everything. Being a data file, it can be inspected with format.Reader, or `caskdb
+// dump`, and the archives of the older versions of the format are restored too.
+
+// ErrInvalidBackup is returned by Restore when the archive has a record which a
+// backup never has, like a tombstone
+var ErrInvalidBackup = errors.New("invalid backup")
+
+// Backup writes an archive of the live keys of the store to w. It is consistent as of
+// when it started, the writes made while it runs are not in it
+func (d *DiskStore) Backup(w io.Writer) error {
+	snap := d.Snapshot()
+	defer snap.Release()
+	buf := bufio.NewWriter(w)
+	if _, err := buf.Write(format.EncodeFileHeader(uint32(d.now().Unix()))); err != nil {
+		return err
+	}
+	writer := format.NewWriter(buf)
+	for _, key := range snap.Keys() {
+		value, err := snap.Get(key)
+		if err != nil {
+			return err
+		}
+		kEntry, _ := snap.keyDir.get(key)
+		if _, err := writer.WriteWithExpiry(kEntry.timestamp, kEntry.expiry, key, value); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// Restore creates a store in the path from the archive written by Backup. The path
+// must not exist, Restore returns ErrStoreExists otherwise. The options are used to
+// open the new store, so they can change its settings, like the value threshold. The
+// keys which expired since the backup are not restored. If Restore fails, the path
+// has a partial store, which should be removed
+func Restore(r io.Reader, path string, opts ...Option) error {
+	if isFileExists(path) {
+		return ErrStoreExists
+	}
+	// syncing every record would make the restore crawl, Close syncs them all at
+	// once at the end
+	opts = append(opts, WithSyncPolicy(SyncNever, 0))
+	d, err := Open(path, opts...)
+	if err != nil {
+		return err
+	}
+	if err := d.restore(r); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// restore writes the records of the archive to the store
+func (d *DiskStore) restore(r io.Reader) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := uint32(d.now().Unix())
+	reader := format.NewReader(r)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if record.Tombstone || record.ValuePointer {
+			return ErrInvalidBackup
+		}
+		if record.Expiry != 0 && record.Expiry <= now {
+			continue
+		}
+		if err := d.set(record.Key, record.Value, record.Timestamp, record.Expiry); err != nil {
+			return err
+		}
+	}
+}
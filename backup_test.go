@@ -0,0 +1,65 @@
+package caskdb
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+func TestDiskStore_Backup(t *testing.T) {
+	store, err := Open("test.db", WithValueThreshold(16))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer removeStore("test.db")
+	defer os.RemoveAll("restore.db")
+
+	stand := strings.Repeat("star platinum ", 4)
+	store.Set("name", "jojo")
+	store.Set("name", "jotaro")
+	store.Set("stand", stand)
+	store.Set("villain", "dio")
+	store.Delete("villain")
+	store.SetWithTTL("timestop", "5s", time.Hour)
+
+	var buf bytes.Buffer
+	if err := store.Backup(&buf); err != nil {
+		t.Fatalf("Backup() error = %v", err)
+	}
+	store.Close()
+	// a key which expired after the backup was taken is not restored
+	ts := uint32(time.Now().Unix())
+	format.NewWriter(&buf).WriteWithExpiry(ts-10, ts-1, "za warudo", "9s")
+	archive := buf.Bytes()
+
+	if err := Restore(bytes.NewReader(archive), "restore.db"); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	restored, err := NewDiskStore("restore.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	want := map[string]string{"name": "jotaro", "stand": stand, "timestop": "5s"}
+	for key, value := range want {
+		if got, err := restored.Get(key); err != nil || got != value {
+			t.Errorf("Get(%q) = %q, %v, want %q", key, got, err, value)
+		}
+	}
+	for _, key := range []string{"villain", "za warudo"} {
+		if _, err := restored.Get(key); err != ErrKeyNotFound {
+			t.Errorf("Get(%q) error = %v, want %v", key, err, ErrKeyNotFound)
+		}
+	}
+	if ttl, err := restored.TTL("timestop"); err != nil || ttl <= 0 {
+		t.Errorf("TTL() = %v, %v, want the expiry kept", ttl, err)
+	}
+	restored.Close()
+
+	if err := Restore(bytes.NewReader(archive), "restore.db"); err != ErrStoreExists {
+		t.Errorf("Restore() error = %v, want %v", err, ErrStoreExists)
+	}
+}
@@ -0,0 +1,143 @@
+package caskdb
+
+import (
+	"errors"
+	"time"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// ErrBatchTooLarge is returned by Commit when the records of the batch are larger than
+// format.MaxBatchSize in total
+var ErrBatchTooLarge = errors.New("batch too large")
+
+// Batch collects writes, which are applied together with DiskStore.Commit. The
+// writes of a batch are all or nothing: either all of them are applied, or, if the
+// write fails or the process crashes, none of them.
+//
+// A batch is also much faster for bulk loads, since all the records go to the disk
+// with a single write and a single fsync, instead of one each per record.
+//
+// Typical usage example:
+//
+//	batch := NewBatch()
+//	batch.Set("othello", "shakespeare")
+//	batch.Delete("hamlet")
+//	err := store.Commit(batch)
+type Batch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	key    string
+	value  string
+	delete bool
+	// timestamp is the timestamp of the write, zero for the time of the commit
+	timestamp uint32
+}
+
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Set adds a write of the key and value to the batch
+func (b *Batch) Set(key string, value string) {
+	b.ops = append(b.ops, batchOp{key: key, value: value})
+}
+
+// setAt adds a write of the key and value with the timestamp to the batch
+func (b *Batch) setAt(key string, value string, timestamp uint32) {
+	b.ops = append(b.ops, batchOp{key: key, value: value, timestamp: timestamp})
+}
+
+// Delete adds a delete of the key to the batch
+func (b *Batch) Delete(key string) {
+	b.ops = append(b.ops, batchOp{key: key, delete: true})
+}
+
+// Len returns the number of writes in the batch
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Commit applies the writes of the batch, in the order they were added to it. The
+// batch is left as it is, and can be committed again.
+//
+// All the records of the batch are encoded into a single batch record (check
+// format.BatchKeySize), which is written and synced to the disk in one go. The keyDir
+// is updated only after the write succeeds, so a failed Commit changes nothing. The
+// checksum of the batch record covers all the records in it, so after a crash in the
+// middle of the write, none of them are loaded at the startup
+func (d *DiskStore) Commit(b *Batch) error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	for _, op := range b.ops {
+		if err := checkReserved(op.key); err != nil {
+			return err
+		}
+		if err := d.checkSize(op.key, op.value); err != nil {
+			return err
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.commit(b)
+}
+
+// commit writes the batch, whose sizes are checked already. The store must be locked
+func (d *DiskStore) commit(b *Batch) error {
+	start := time.Now()
+	err := d.writeBatch(b)
+	d.metrics.ObserveOp(OpBatch, time.Since(start), err)
+	return err
+}
+
+// writeBatch is commit, without reporting it to the metrics
+func (d *DiskStore) writeBatch(b *Batch) error {
+	timestamp := uint32(time.Now().Unix())
+	var records []byte
+	sizes := make([]int, len(b.ops))
+	for i, op := range b.ops {
+		var data []byte
+		if op.delete {
+			_, data = format.EncodeTombstone(timestamp, op.key)
+			data = d.encrypt(data)
+			sizes[i] = len(data)
+		} else {
+			var err error
+			sizes[i], data, err = d.encodeKV(op.timestampOr(timestamp), 0, op.key, op.value)
+			if err != nil {
+				return err
+			}
+		}
+		records = append(records, data...)
+	}
+	if uint64(len(records)) > format.MaxBatchSize {
+		return ErrBatchTooLarge
+	}
+	size, data := format.EncodeBatch(timestamp, records)
+	if err := d.write(data); err != nil {
+		return err
+	}
+	// the records start after the header of the batch record
+	position := d.writePosition + format.HeaderSize
+	for i, op := range b.ops {
+		if op.delete {
+			d.removeEntry(op.key, timestamp)
+		} else {
+			d.putEntry(op.key, op.value, NewKeyEntry(op.timestampOr(timestamp), 0, d.activeFileID, d.activeVersion, uint64(position), uint64(sizes[i])))
+		}
+		position += sizes[i]
+	}
+	d.writePosition += size
+	return nil
+}
+
+// timestampOr returns the timestamp of the write, or else the timestamp of the commit
+func (op batchOp) timestampOr(timestamp uint32) uint32 {
+	if op.timestamp != 0 {
+		return op.timestamp
+	}
+	return timestamp
+}
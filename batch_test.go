@@ -0,0 +1,92 @@
+package caskdb
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+func TestDiskStore_Commit(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.CreateTextIndex()
+	store.Set("hamlet", "shakespeare")
+
+	batch := NewBatch()
+	batch.Set("othello", "shakespeare")
+	batch.Set("dune", "frank herbert")
+	batch.Delete("hamlet")
+	batch.Set("dune", "herbert")
+	if err := store.Commit(batch); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if err := store.Commit(NewBatch()); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	tests := map[string]string{"hamlet": "", "othello": "shakespeare", "dune": "herbert"}
+	for key, val := range tests {
+		if got, _ := store.Get(key); got != val {
+			t.Errorf("Get(%v) = %v, want %v", key, got, val)
+		}
+	}
+	if keys, _ := store.Search("shakespeare", 0); len(keys) != 1 || keys[0] != "othello" {
+		t.Errorf("Search() = %v, want [othello]", keys)
+	}
+	store.Close()
+
+	// the batch is loaded from the data file too, not only from the hint file
+	os.Remove(hintFileName("test.db"))
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	for key, val := range tests {
+		if got, _ := store.Get(key); got != val {
+			t.Errorf("Get(%v) = %v, want %v", key, got, val)
+		}
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if got, _ := store.Get("dune"); got != "herbert" {
+		t.Errorf("Get() after Merge() = %v, want %v", got, "herbert")
+	}
+	store.Close()
+}
+
+func TestDiskStore_CommitTorn(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("hamlet", "shakespeare")
+	batch := NewBatch()
+	for i := 0; i < 10; i++ {
+		batch.Set(fmt.Sprintf("key-%d", i), "value")
+	}
+	batch.Delete("hamlet")
+	store.Commit(batch)
+	store.closeFiles()
+
+	// cut the batch short, as if we crashed in the middle of writing it. The
+	// records in it which made it to the disk must not be loaded
+	fileName := dataFileName("test.db", 1)
+	info, _ := os.Stat(fileName)
+	if err := os.Truncate(fileName, info.Size()-format.HeaderSize); err != nil {
+		t.Fatalf("failed to truncate data file: %v", err)
+	}
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if keys := store.Keys(); len(keys) != 1 || keys[0] != "hamlet" {
+		t.Errorf("Keys() = %v, want [hamlet]", keys)
+	}
+	store.Close()
+}
@@ -0,0 +1,76 @@
+package caskdb
+
+import (
+	"math/bits"
+	"time"
+)
+
+// bitmap file provides bit level operations over the values, similar to Redis'
+// SETBIT, GETBIT and BITCOUNT. A bitmap is stored as a plain value, where the bit at
+// offset 0 is the most significant bit of the first byte, offset 7 is the least
+// significant bit of the first byte, offset 8 is the most significant bit of the
+// second byte and so on.
+//
+// Setting a bit beyond the current length grows the value with zero bytes. Every
+// SetBit is a read-modify-write of the whole value, so bitmaps work best when they
+// are small (feature flags, presence of a few thousand ids etc.)
+
+// SetBit sets or clears the bit at offset in the value stored at key, and returns
+// the bit's previous value
+func (d *DiskStore) SetBit(key string, offset uint32, value bool) (bool, error) {
+	if err := checkReserved(key); err != nil {
+		return false, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	current, err := d.getOrEmpty(key)
+	if err != nil {
+		return false, err
+	}
+	bitmap := []byte(current)
+	index := int(offset / 8)
+	if index >= len(bitmap) {
+		bitmap = append(bitmap, make([]byte, index-len(bitmap)+1)...)
+	}
+	mask := byte(1 << (7 - offset%8))
+	previous := bitmap[index]&mask != 0
+	if previous == value {
+		return previous, nil
+	}
+	bitmap[index] ^= mask
+	if err := d.set(key, string(bitmap), uint32(time.Now().Unix()), d.liveExpiry(key)); err != nil {
+		return false, err
+	}
+	return previous, nil
+}
+
+// GetBit returns the bit at offset in the value stored at key. Offsets past the
+// end of the value, and the missing keys read as zero
+func (d *DiskStore) GetBit(key string, offset uint32) (bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	bitmap, err := d.getOrEmpty(key)
+	if err != nil {
+		return false, err
+	}
+	index := int(offset / 8)
+	if index >= len(bitmap) {
+		return false, nil
+	}
+	return bitmap[index]&byte(1<<(7-offset%8)) != 0, nil
+}
+
+// BitCount returns the number of set bits in the value stored at key
+func (d *DiskStore) BitCount(key string) (int, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	bitmap, err := d.getOrEmpty(key)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := 0; i < len(bitmap); i++ {
+		count += bits.OnesCount8(bitmap[i])
+	}
+	return count, nil
+}
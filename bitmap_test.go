@@ -0,0 +1,65 @@
+package caskdb
+
+import "testing"
+
+func TestDiskStore_SetBit(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	if prev, err := store.SetBit("flags", 7, true); err != nil || prev {
+		t.Errorf("SetBit() = %v, want %v", prev, false)
+	}
+	if prev, err := store.SetBit("flags", 7, true); err != nil || !prev {
+		t.Errorf("SetBit() = %v, want %v", prev, true)
+	}
+	store.SetBit("flags", 100, true)
+	val, err := store.Get("flags")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if len(val) != 13 {
+		t.Errorf("len(Get()) = %v, want %v", len(val), 13)
+	}
+	if val[0] != 0x01 {
+		t.Errorf("Get()[0] = %#x, want %#x", val[0], 0x01)
+	}
+	store.SetBit("flags", 7, false)
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	tests := map[uint32]bool{0: false, 7: false, 100: true, 101: false, 5000: false}
+	for offset, want := range tests {
+		if got, _ := store.GetBit("flags", offset); got != want {
+			t.Errorf("GetBit(%v) = %v, want %v", offset, got, want)
+		}
+	}
+	if got, err := store.GetBit("missing", 0); err != nil || got {
+		t.Errorf("GetBit() = %v, want %v", got, false)
+	}
+	store.Close()
+}
+
+func TestDiskStore_BitCount(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	for _, offset := range []uint32{0, 3, 8, 63, 64, 1000} {
+		store.SetBit("presence", offset, true)
+	}
+	if count, _ := store.BitCount("presence"); count != 6 {
+		t.Errorf("BitCount() = %v, want %v", count, 6)
+	}
+	if count, err := store.BitCount("missing"); err != nil || count != 0 {
+		t.Errorf("BitCount() = %v, want %v", count, 0)
+	}
+	store.Close()
+}
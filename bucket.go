@@ -0,0 +1,172 @@
+package caskdb
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// bucket file has the buckets, the namespaces of keys within one store. Apps put
+// unrelated data in one store, and a bucket keeps the keys of each apart, without
+// every app having to prefix its keys by hand, and lets all of them be dropped in one
+// go.
+//
+// The keys of a bucket are stored like any other key, prefixed with the name of the
+// bucket between two zero bytes:
+//
+//	\x00books\x00othello
+//
+// So the keys of the store which start with the prefix of a bucket are reserved for
+// the buckets, and the writes of the store return ErrReservedKey for them. Else
+// deleting the key "\x00books\x00" would delete the whole bucket.
+// The keys of the buckets are in Keys, Len and Fold of the store, with their prefix.
+//
+// DeleteBucket does not write a tombstone for every key of the bucket. It writes a
+// single tombstone for the prefix itself, which is never a key, since the keys of a
+// bucket are not empty. When the tombstone is loaded, at the startup or by a replica,
+// it removes all the keys of the bucket written before it. The records stay on the
+// disk until Merge, which copies only the live keys, as for any other deleted key.
+
+// ErrInvalidBucket is returned when using a bucket whose name is empty or has a zero
+// byte
+var ErrInvalidBucket = errors.New("invalid bucket name")
+
+// ErrReservedKey is returned when writing a key which starts with the prefix of a
+// bucket, outside of the bucket
+var ErrReservedKey = errors.New("key is reserved for the buckets")
+
+// Bucket is a namespace of keys within a store, returned by DiskStore.Bucket. The
+// keys of a bucket are apart from the keys of the store and of the other buckets, the
+// same key can be in each of them with a different value. A bucket is safe for
+// concurrent use, like the store
+type Bucket struct {
+	store  *DiskStore
+	name   string
+	prefix string
+}
+
+// Bucket returns the bucket with the name. A bucket does not have to be created, it
+// exists as long as it has keys. If the name is not valid, the methods of the bucket
+// return ErrInvalidBucket
+func (d *DiskStore) Bucket(name string) *Bucket {
+	return &Bucket{store: d, name: name, prefix: bucketPrefix(name)}
+}
+
+// DeleteBucket deletes all the keys of the bucket. Deleting a bucket without keys is
+// a no-op. The space taken by the keys is reclaimed by the next Merge
+func (d *DiskStore) DeleteBucket(name string) error {
+	if !validBucketName(name) {
+		return ErrInvalidBucket
+	}
+	prefix := bucketPrefix(name)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.bucketKeys(prefix)) == 0 {
+		return nil
+	}
+	timestamp := uint32(time.Now().Unix())
+	_, data := format.EncodeTombstone(timestamp, prefix)
+	data = d.encrypt(data)
+	if err := d.write(data); err != nil {
+		return err
+	}
+	d.removeEntry(prefix, timestamp)
+	d.writePosition += len(data)
+	return nil
+}
+
+// Name returns the name of the bucket
+func (b *Bucket) Name() string {
+	return b.name
+}
+
+// Get returns the value of the key in the bucket, like DiskStore.Get
+func (b *Bucket) Get(key string) (string, error) {
+	if !validBucketName(b.name) {
+		return "", ErrInvalidBucket
+	}
+	return b.store.Get(b.prefix + key)
+}
+
+// Set stores the key and value in the bucket, like DiskStore.Set
+func (b *Bucket) Set(key string, value string) error {
+	if !validBucketName(b.name) {
+		return ErrInvalidBucket
+	}
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	// the key has the reserved prefix, so we skip the checks of DiskStore.Set
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	return b.store.set(b.prefix+key, value, uint32(time.Now().Unix()), 0)
+}
+
+// Delete removes the key from the bucket, like DiskStore.Delete
+func (b *Bucket) Delete(key string) error {
+	if !validBucketName(b.name) {
+		return ErrInvalidBucket
+	}
+	if len(key) == 0 {
+		return nil
+	}
+	b.store.mu.Lock()
+	defer b.store.mu.Unlock()
+	start := time.Now()
+	err := b.store.delete(b.prefix + key)
+	b.store.metrics.ObserveOp(OpDelete, time.Since(start), err)
+	return err
+}
+
+// Scan calls fn for every key of the bucket starting with the prefix and its value,
+// in the key order, like DiskStore.Scan. The keys are passed without the prefix of
+// the bucket. It needs the ordered index of the store, else it returns
+// ErrIndexNotFound
+func (b *Bucket) Scan(prefix string, fn func(key string, value string) error) error {
+	if !validBucketName(b.name) {
+		return ErrInvalidBucket
+	}
+	return b.store.Scan(b.prefix+prefix, func(key string, value string) error {
+		return fn(key[len(b.prefix):], value)
+	})
+}
+
+// bucketPrefix returns the prefix of the keys of the bucket with the name, which is
+// the key of its tombstone too
+func bucketPrefix(name string) string {
+	return "\x00" + name + "\x00"
+}
+
+// validBucketName tells whether the name can be the name of a bucket
+func validBucketName(name string) bool {
+	return name != "" && !strings.Contains(name, "\x00")
+}
+
+// checkReserved returns ErrReservedKey if the key starts with the prefix of a bucket.
+// The other keys starting with a zero byte are fine, they are never in a bucket
+func checkReserved(key string) error {
+	if len(key) > 0 && key[0] == 0 && strings.IndexByte(key[1:], 0) > 0 {
+		return ErrReservedKey
+	}
+	return nil
+}
+
+// isBucketTombstone tells whether the key of a tombstone is the prefix of a bucket,
+// that is, the tombstone deletes the bucket
+func isBucketTombstone(key string) bool {
+	return len(key) > 2 && key[0] == 0 && key[len(key)-1] == 0 && validBucketName(key[1:len(key)-1])
+}
+
+// bucketKeys returns the keys in the keyDir with the prefix of a bucket
+func (d *DiskStore) bucketKeys(prefix string) []string {
+	var keys []string
+	d.keyDir.each(func(key string, _ KeyEntry) error {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys
+}
@@ -0,0 +1,216 @@
+package caskdb
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiskStore_Bucket(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	if err := store.CreateOrderedIndex(); err != nil {
+		t.Fatalf("CreateOrderedIndex() error = %v", err)
+	}
+
+	books, plays := store.Bucket("books"), store.Bucket("plays")
+	store.Set("othello", "store")
+	books.Set("othello", "books")
+	books.Set("hamlet", "books")
+	plays.Set("othello", "plays")
+	for _, tt := range []struct {
+		get  func(key string) (string, error)
+		want string
+	}{
+		{store.Get, "store"},
+		{books.Get, "books"},
+		{plays.Get, "plays"},
+	} {
+		if val, err := tt.get("othello"); err != nil || val != tt.want {
+			t.Errorf("Get() = %v, %v, want %v", val, err, tt.want)
+		}
+	}
+	if _, err := plays.Get("hamlet"); err != ErrKeyNotFound {
+		t.Errorf("Get() of a key of another bucket error = %v, want %v", err, ErrKeyNotFound)
+	}
+
+	got := make(map[string]string)
+	err = books.Scan("", func(key string, value string) error {
+		got[key] = value
+		return nil
+	})
+	if want := map[string]string{"hamlet": "books", "othello": "books"}; err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan() = %v, %v, want %v", got, err, want)
+	}
+
+	if err := books.Delete("othello"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := books.Get("othello"); err != ErrKeyNotFound {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if val, _ := plays.Get("othello"); val != "plays" {
+		t.Errorf("Get() of the other bucket = %v, want %v", val, "plays")
+	}
+
+	if err := books.Set("", "books"); err != ErrEmptyKey {
+		t.Errorf("Set() of an empty key error = %v, want %v", err, ErrEmptyKey)
+	}
+	for _, name := range []string{"", "bo\x00oks"} {
+		if err := store.Bucket(name).Set("othello", "books"); err != ErrInvalidBucket {
+			t.Errorf("Set() in bucket %q error = %v, want %v", name, err, ErrInvalidBucket)
+		}
+		if err := store.DeleteBucket(name); err != ErrInvalidBucket {
+			t.Errorf("DeleteBucket(%q) error = %v, want %v", name, err, ErrInvalidBucket)
+		}
+	}
+	store.Close()
+}
+
+func TestDiskStore_DeleteBucket(t *testing.T) {
+	// the small files put the keys and the tombstone of the bucket in different
+	// files
+	store, err := Open("test.db", WithMaxFileSize(100))
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	books, plays := store.Bucket("books"), store.Bucket("plays")
+	for _, key := range []string{"othello", "hamlet", "macbeth"} {
+		books.Set(key, "shakespeare")
+		plays.Set(key, "shakespeare")
+	}
+	if err := store.DeleteBucket("books"); err != nil {
+		t.Fatalf("DeleteBucket() error = %v", err)
+	}
+	books.Set("emma", "austen")
+	check := func(when string) {
+		t.Helper()
+		if _, err := books.Get("othello"); err != ErrKeyNotFound {
+			t.Errorf("%s: Get() of a deleted key error = %v, want %v", when, err, ErrKeyNotFound)
+		}
+		if val, _ := books.Get("emma"); val != "austen" {
+			t.Errorf("%s: Get() of a key set after DeleteBucket() = %v, want %v", when, val, "austen")
+		}
+		if val, _ := plays.Get("othello"); val != "shakespeare" {
+			t.Errorf("%s: Get() of the other bucket = %v, want %v", when, val, "shakespeare")
+		}
+		if n := store.Len(); n != 4 {
+			t.Errorf("%s: Len() = %v, want %v", when, n, 4)
+		}
+	}
+	check("after DeleteBucket()")
+	if err := store.DeleteBucket("empty"); err != nil {
+		t.Errorf("DeleteBucket() of an empty bucket error = %v", err)
+	}
+	store.Close()
+
+	// without the hint file, the tombstone of the bucket is read from the data
+	// files
+	os.Remove(hintFileName("test.db"))
+	store, err = Open("test.db", WithMaxFileSize(100))
+	if err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	books, plays = store.Bucket("books"), store.Bucket("plays")
+	check("after reopening")
+
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	check("after Merge()")
+	store.Close()
+
+	os.Remove(hintFileName("test.db"))
+	store, err = Open("test.db", WithMaxFileSize(100))
+	if err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	books, plays = store.Bucket("books"), store.Bucket("plays")
+	check("after reopening the merged store")
+	store.Close()
+}
+
+func TestDiskStore_ReservedKey(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	books := store.Bucket("books")
+	books.Set("othello", "shakespeare")
+
+	// the key of the tombstone of the bucket must not delete the bucket
+	if err := store.Delete("\x00books\x00"); err != ErrReservedKey {
+		t.Errorf("Delete() error = %v, want %v", err, ErrReservedKey)
+	}
+	if err := store.Set("\x00books\x00hamlet", "shakespeare"); err != ErrReservedKey {
+		t.Errorf("Set() error = %v, want %v", err, ErrReservedKey)
+	}
+	batch := NewBatch()
+	batch.Delete("\x00books\x00")
+	if err := store.Commit(batch); err != ErrReservedKey {
+		t.Errorf("Commit() error = %v, want %v", err, ErrReservedKey)
+	}
+	if err := store.Update(func(tx *Tx) error {
+		return tx.Delete("\x00books\x00")
+	}); err != ErrReservedKey {
+		t.Errorf("Update() error = %v, want %v", err, ErrReservedKey)
+	}
+	if val, _ := books.Get("othello"); val != "shakespeare" {
+		t.Errorf("Get() = %v, want %v", val, "shakespeare")
+	}
+	if _, err := books.Get("hamlet"); err != ErrKeyNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+
+	// every write of the store rejects the keys of the buckets
+	writes := map[string]func(key string) error{
+		"Set":        func(key string) error { return store.Set(key, "x") },
+		"SetBytes":   func(key string) error { return store.SetBytes([]byte(key), []byte("x")) },
+		"SetWithTTL": func(key string) error { return store.SetWithTTL(key, "x", time.Minute) },
+		"SetIfNewer": func(key string) error { _, err := store.SetIfNewer(key, "x", time.Now()); return err },
+		"SetMulti":   func(key string) error { return store.SetMulti(map[string]string{key: "x"}) },
+		"Delete":     func(key string) error { return store.Delete(key) },
+		"CompareAndSwap": func(key string) error {
+			_, err := store.CompareAndSwap(key, "shakespeare", "x")
+			return err
+		},
+		"Increment":    func(key string) error { _, err := store.Increment(key, 1); return err },
+		"SetBit":       func(key string) error { _, err := store.SetBit(key, 1, true); return err },
+		"IncrCounter":  func(key string) error { _, err := store.IncrCounter(key, "a", 1); return err },
+		"MergeCounter": func(key string) error { _, err := store.MergeCounter(key, ""); return err },
+		"PFAdd":        func(key string) error { _, err := store.PFAdd(key, "x"); return err },
+		"PFMerge":      func(key string) error { return store.PFMerge(key) },
+		"Commit": func(key string) error {
+			batch := NewBatch()
+			batch.Set(key, "x")
+			return store.Commit(batch)
+		},
+		"Tx.Set": func(key string) error {
+			return store.Update(func(tx *Tx) error { return tx.Set(key, "x") })
+		},
+	}
+	for name, write := range writes {
+		if err := write("\x00books\x00othello"); err != ErrReservedKey {
+			t.Errorf("%s() error = %v, want %v", name, err, ErrReservedKey)
+		}
+	}
+	if val, _ := books.Get("othello"); val != "shakespeare" {
+		t.Errorf("Get() after the writes = %q, want %v", val, "shakespeare")
+	}
+
+	// the other keys starting with a zero byte are not in a bucket
+	if err := store.Set("\x00books", "austen"); err != nil {
+		t.Errorf("Set() error = %v", err)
+	}
+	if err := books.Delete("othello"); err != nil {
+		t.Errorf("Bucket.Delete() error = %v", err)
+	}
+	store.Close()
+}
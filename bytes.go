@@ -0,0 +1,35 @@
+package caskdb
+
+import "time"
+
+// GetBytes is like Get, but for binary keys and values. The returned slice is owned
+// by the caller, and is not copied from a string like the value of Get would be
+func (d *DiskStore) GetBytes(key []byte) ([]byte, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	start := time.Now()
+	if d.closed {
+		d.metrics.ObserveOp(OpGet, time.Since(start), ErrClosed)
+		return nil, ErrClosed
+	}
+	kEntry, ok := d.keyDir.get(string(key))
+	if !ok || kEntry.expired(uint32(d.now().Unix())) {
+		d.metrics.ObserveOp(OpGet, time.Since(start), ErrKeyNotFound)
+		return nil, ErrKeyNotFound
+	}
+	value, err := d.readBytes(kEntry)
+	d.metrics.ObserveOp(OpGet, time.Since(start), err)
+	return value, err
+}
+
+// SetBytes is like Set, but for binary keys and values, like protobuf messages or
+// images. The key and the value are copied, so the caller may reuse them after
+// SetBytes returns
+func (d *DiskStore) SetBytes(key []byte, value []byte) error {
+	if err := checkReserved(string(key)); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.set(string(key), string(value), uint32(time.Now().Unix()), 0)
+}
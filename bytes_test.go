@@ -0,0 +1,43 @@
+package caskdb
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDiskStore_Bytes(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	key := []byte{0x00, 0xff, 'k'}
+	value := []byte{0x89, 'P', 'N', 'G', 0x00, 0x0d, 0x0a, 0xff}
+	if err := store.SetBytes(key, value); err != nil {
+		t.Fatalf("SetBytes() error = %v", err)
+	}
+	// the store must not keep the slices of the caller
+	want := append([]byte(nil), value...)
+	value[1] = 'x'
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	got, err := store.GetBytes(key)
+	if err != nil {
+		t.Fatalf("GetBytes() error = %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("GetBytes() = %v, want %v", got, want)
+	}
+	if val, _ := store.Get(string(key)); val != string(want) {
+		t.Errorf("Get() = %q, want %q", val, want)
+	}
+	if _, err := store.GetBytes([]byte("missing")); err != ErrKeyNotFound {
+		t.Errorf("GetBytes() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	store.Close()
+}
@@ -0,0 +1,205 @@
+package caskdb
+
+import (
+	"sync"
+	"time"
+)
+
+// WritePolicy decides when the writes made to a CachedStore reach the backing store
+type WritePolicy int
+
+const (
+	// WriteThrough writes to the local and the backing store on every Set
+	WriteThrough WritePolicy = iota
+	// WriteBack writes only to the local store and keeps track of the dirty keys.
+	// They are written to the backing store on Flush or Close
+	WriteBack
+)
+
+// CachedStore fronts a slower backing Store (a remote server, an object storage tier
+// etc.) with a faster local Store, typically a DiskStore. Reads are served from the
+// local store, and on a miss the value is fetched from the backing store and
+// populated locally (read-through). Writes follow the WritePolicy.
+//
+// The local store cannot tell us when a key was populated, so CachedStore keeps its
+// own record of the cached keys and when they were populated. Entries older than the
+// TTL are fetched again from the backing store. A zero TTL never invalidates. The keys
+// missing in the backing store are not cached, every Get for them goes to the
+// backing store and returns ErrKeyNotFound.
+//
+// The record of cached keys lives in memory only, so after a restart every key is
+// fetched again from the backing store on its first read. CachedStore is safe for
+// concurrent use, the calls to the stores are made with it locked.
+//
+// Typical usage example:
+//
+//	local, _ := NewDiskStore("cache.db")
+//	store := NewCachedStore(local, remote, WriteThrough, time.Minute)
+//	store.Set("othello", "shakespeare")
+//	author, _ := store.Get("othello")
+type CachedStore struct {
+	// mu guards cachedAt and dirty, and keeps the local and the backing store in
+	// step with them
+	mu      sync.Mutex
+	local   Store
+	backing Store
+	policy  WritePolicy
+	ttl     time.Duration
+	// cachedAt keeps the time at which the key was populated in the local store
+	cachedAt map[string]time.Time
+	// dirty keeps the keys which are written to the local store but not yet to
+	// the backing store. It is used only with WriteBack policy
+	dirty map[string]struct{}
+	// now returns the current time, tests replace it to move the clock
+	now func() time.Time
+}
+
+func NewCachedStore(local Store, backing Store, policy WritePolicy, ttl time.Duration) *CachedStore {
+	return &CachedStore{
+		local:    local,
+		backing:  backing,
+		policy:   policy,
+		ttl:      ttl,
+		cachedAt: make(map[string]time.Time),
+		dirty:    make(map[string]struct{}),
+		now:      time.Now,
+	}
+}
+
+func (c *CachedStore) Get(key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the dirty keys are never invalidated, the local store has the only copy
+	// of the latest value
+	if _, ok := c.dirty[key]; ok {
+		return c.local.Get(key)
+	}
+	if at, ok := c.cachedAt[key]; ok && (c.ttl == 0 || c.now().Sub(at) < c.ttl) {
+		return c.local.Get(key)
+	}
+	value, err := c.backing.Get(key)
+	if err != nil {
+		// the key might have been deleted from the backing store, in which
+		// case our copy is stale
+		if err == ErrKeyNotFound {
+			delete(c.cachedAt, key)
+		}
+		return "", err
+	}
+	if err := c.local.Set(key, value); err != nil {
+		return "", err
+	}
+	c.cachedAt[key] = c.now()
+	return value, nil
+}
+
+func (c *CachedStore) Set(key string, value string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.local.Set(key, value); err != nil {
+		return err
+	}
+	c.cachedAt[key] = c.now()
+	if c.policy == WriteBack {
+		c.dirty[key] = struct{}{}
+		return nil
+	}
+	return c.backing.Set(key, value)
+}
+
+// Delete deletes the key from the local store, and from the backing store following
+// the WritePolicy. With WriteBack, the key is deleted from the backing store on Flush
+// or Close
+func (c *CachedStore) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.local.Delete(key); err != nil {
+		return err
+	}
+	if c.policy == WriteBack {
+		// a dirty key missing in the local store was deleted, check flushKey
+		c.dirty[key] = struct{}{}
+		return nil
+	}
+	delete(c.cachedAt, key)
+	return c.backing.Delete(key)
+}
+
+// Fold calls fn for every key and its value, in the key order. The keys are read from
+// the backing store, since the local store has only the cached ones, so the dirty
+// keys are flushed first. The store is not locked while fn runs, so fn may read and
+// write the store
+func (c *CachedStore) Fold(fn func(key string, value string) error) error {
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	return c.backing.Fold(fn)
+}
+
+// Invalidate drops the key from the cache, so that the next Get fetches it from the
+// backing store. A dirty key is flushed to the backing store first, so that the
+// write is not lost
+func (c *CachedStore) Invalidate(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.dirty[key]; ok {
+		if err := c.flushKey(key); err != nil {
+			return err
+		}
+	}
+	delete(c.cachedAt, key)
+	return nil
+}
+
+// Flush writes all the dirty keys to the backing store. It is a no-op with
+// WriteThrough policy. If a write fails, the keys not yet written stay dirty
+func (c *CachedStore) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flush()
+}
+
+func (c *CachedStore) flush() error {
+	for key := range c.dirty {
+		if err := c.flushKey(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *CachedStore) flushKey(key string) error {
+	value, err := c.local.Get(key)
+	if err == ErrKeyNotFound {
+		// the local store has the only copy of a dirty key, so the key was deleted
+		if err := c.backing.Delete(key); err != nil {
+			return err
+		}
+		delete(c.dirty, key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.backing.Set(key, value); err != nil {
+		return err
+	}
+	delete(c.dirty, key)
+	return nil
+}
+
+// Close flushes the dirty keys and closes both the stores. The stores are closed even
+// if the flush fails, and the first error is returned
+func (c *CachedStore) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	flushErr := c.flush()
+	localErr := c.local.Close()
+	backingErr := c.backing.Close()
+	for _, err := range []error{flushErr, localErr, backingErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
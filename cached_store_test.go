@@ -0,0 +1,152 @@
+package caskdb
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCachedStore_ReadThrough(t *testing.T) {
+	local, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	backing := NewMemoryStore()
+	backing.Set("othello", "shakespeare")
+
+	store := NewCachedStore(local, backing, WriteThrough, 0)
+	if val, _ := store.Get("othello"); val != "shakespeare" {
+		t.Errorf("Get() = %v, want %v", val, "shakespeare")
+	}
+	if val, _ := local.Get("othello"); val != "shakespeare" {
+		t.Errorf("local Get() = %v, want %v", val, "shakespeare")
+	}
+	// the missing keys are not cached
+	if _, err := store.Get("missing"); err != ErrKeyNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if _, err := local.Get("missing"); err != ErrKeyNotFound {
+		t.Errorf("local Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	store.Close()
+}
+
+func TestCachedStore_WriteThrough(t *testing.T) {
+	local, backing := NewMemoryStore(), NewMemoryStore()
+	store := NewCachedStore(local, backing, WriteThrough, 0)
+	store.Set("name", "jojo")
+	if val, _ := backing.Get("name"); val != "jojo" {
+		t.Errorf("backing Get() = %v, want %v", val, "jojo")
+	}
+}
+
+func TestCachedStore_WriteBack(t *testing.T) {
+	local, backing := NewMemoryStore(), NewMemoryStore()
+	store := NewCachedStore(local, backing, WriteBack, time.Nanosecond)
+	store.Set("name", "jojo")
+	if _, err := backing.Get("name"); err != ErrKeyNotFound {
+		t.Errorf("backing Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	// dirty keys must not be invalidated by the TTL
+	if val, _ := store.Get("name"); val != "jojo" {
+		t.Errorf("Get() = %v, want %v", val, "jojo")
+	}
+	store.Flush()
+	if val, _ := backing.Get("name"); val != "jojo" {
+		t.Errorf("backing Get() = %v, want %v", val, "jojo")
+	}
+}
+
+func TestCachedStore_TTL(t *testing.T) {
+	local, backing := NewMemoryStore(), NewMemoryStore()
+	store := NewCachedStore(local, backing, WriteThrough, time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	backing.Set("name", "jojo")
+	store.Get("name")
+	backing.Set("name", "dio")
+	if val, _ := store.Get("name"); val != "jojo" {
+		t.Errorf("Get() = %v, want %v", val, "jojo")
+	}
+	now = now.Add(time.Minute)
+	if val, _ := store.Get("name"); val != "dio" {
+		t.Errorf("Get() = %v, want %v", val, "dio")
+	}
+	backing.Set("name", "jotaro")
+	store.Invalidate("name")
+	if val, _ := store.Get("name"); val != "jotaro" {
+		t.Errorf("Get() = %v, want %v", val, "jotaro")
+	}
+}
+
+func TestCachedStore_Delete(t *testing.T) {
+	for _, policy := range []WritePolicy{WriteThrough, WriteBack} {
+		local, backing := NewMemoryStore(), NewMemoryStore()
+		store := NewCachedStore(local, backing, policy, 0)
+		store.Set("othello", "shakespeare")
+		store.Set("dune", "frank herbert")
+		store.Flush()
+		if err := store.Delete("othello"); err != nil {
+			t.Fatalf("Delete() error = %v", err)
+		}
+		if _, err := store.Get("othello"); err != ErrKeyNotFound {
+			t.Errorf("policy %v: Get() error = %v, want %v", policy, err, ErrKeyNotFound)
+		}
+		// Fold flushes the delete to the backing store
+		var keys []string
+		if err := store.Fold(func(key string, value string) error {
+			keys = append(keys, key)
+			return nil
+		}); err != nil {
+			t.Fatalf("Fold() error = %v", err)
+		}
+		if len(keys) != 1 || keys[0] != "dune" {
+			t.Errorf("policy %v: Fold() keys = %v, want %v", policy, keys, []string{"dune"})
+		}
+		if _, err := backing.Get("othello"); err != ErrKeyNotFound {
+			t.Errorf("policy %v: backing Get() error = %v, want %v", policy, err, ErrKeyNotFound)
+		}
+	}
+}
+
+func TestCachedStore_Concurrent(t *testing.T) {
+	local, backing := NewMemoryStore(), NewMemoryStore()
+	store := NewCachedStore(local, backing, WriteBack, time.Nanosecond)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				store.Set(key, key)
+				if val, _ := store.Get(key); val != key {
+					t.Errorf("Get(%v) = %v, want %v", key, val, key)
+				}
+				if i%5 == 0 {
+					store.Delete(key)
+				}
+				if i%10 == 0 {
+					store.Flush()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	n := 0
+	backing.Fold(func(key string, value string) error {
+		n++
+		return nil
+	})
+	if n != 4*400 {
+		t.Errorf("backing keys = %v, want %v", n, 4*400)
+	}
+}
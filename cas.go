@@ -0,0 +1,75 @@
+package caskdb
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrNotInteger is returned by Increment when the value is not a decimal integer
+	ErrNotInteger = errors.New("value is not an integer")
+	// ErrOverflow is returned by Increment when the result does not fit in an int64
+	ErrOverflow = errors.New("increment would overflow")
+)
+
+// CompareAndSwap sets the key to new only if its current value is old, and tells
+// whether it did. A missing key has an empty value, so an old of "" creates the key.
+// The compare and the write are done under the write lock, so no other write can
+// come in between. This is the building block of optimistic concurrency: read the
+// value, compute the new one, and retry if CompareAndSwap says the value changed.
+//
+// Like the other read-modify-write operations, CompareAndSwap keeps the expiry of
+// the key
+func (d *DiskStore) CompareAndSwap(key string, old string, new string) (bool, error) {
+	if err := checkReserved(key); err != nil {
+		return false, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	current, err := d.getOrEmpty(key)
+	if err != nil {
+		return false, err
+	}
+	if current != old {
+		return false, nil
+	}
+	if err := d.set(key, new, uint32(time.Now().Unix()), d.liveExpiry(key)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Increment adds delta to the integer stored at key, and returns the new value. The
+// value is stored as a decimal string, and a missing key is zero. It returns
+// ErrNotInteger if the value is not an integer.
+//
+// Unlike IncrCounter, this is a plain counter: when two stores are synced, the
+// last writer wins, and the increments of the other are lost
+func (d *DiskStore) Increment(key string, delta int64) (int64, error) {
+	if err := checkReserved(key); err != nil {
+		return 0, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	current, err := d.getOrEmpty(key)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if current != "" {
+		n, err = strconv.ParseInt(current, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	n += delta
+	if err := d.set(key, strconv.FormatInt(n, 10), uint32(time.Now().Unix()), d.liveExpiry(key)); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,89 @@
+package caskdb
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiskStore_CompareAndSwap(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	tests := []struct {
+		old     string
+		new     string
+		swapped bool
+		want    string
+	}{
+		{"v1", "v2", false, ""},
+		{"", "v1", true, "v1"},
+		{"", "v2", false, "v1"},
+		{"v1", "v2", true, "v2"},
+	}
+	for _, tt := range tests {
+		if swapped, _ := store.CompareAndSwap("name", tt.old, tt.new); swapped != tt.swapped {
+			t.Errorf("CompareAndSwap(%v, %v) = %v, want %v", tt.old, tt.new, swapped, tt.swapped)
+		}
+		if val, _ := store.Get("name"); val != tt.want {
+			t.Errorf("Get() = %v, want %v", val, tt.want)
+		}
+	}
+
+	store.SetWithTTL("session", "a", time.Hour)
+	store.CompareAndSwap("session", "a", "b")
+	if ttl, _ := store.TTL("session"); ttl == NoExpiry {
+		t.Errorf("CompareAndSwap() dropped the expiry of the key")
+	}
+	store.Close()
+}
+
+func TestDiskStore_Increment(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	if n, err := store.Increment("hits", 5); err != nil || n != 5 {
+		t.Errorf("Increment() = %v, %v, want %v", n, err, 5)
+	}
+	if n, _ := store.Increment("hits", -7); n != -2 {
+		t.Errorf("Increment() = %v, want %v", n, -2)
+	}
+	if val, _ := store.Get("hits"); val != "-2" {
+		t.Errorf("Get() = %v, want %v", val, "-2")
+	}
+	store.Set("name", "jojo")
+	if _, err := store.Increment("name", 1); err != ErrNotInteger {
+		t.Errorf("Increment() error = %v, want %v", err, ErrNotInteger)
+	}
+	store.Set("big", "9223372036854775806")
+	if _, err := store.Increment("big", 2); err != ErrOverflow {
+		t.Errorf("Increment() error = %v, want %v", err, ErrOverflow)
+	}
+	if n, _ := store.Increment("big", 1); n != math.MaxInt64 {
+		t.Errorf("Increment() = %v, want %v", n, int64(math.MaxInt64))
+	}
+
+	// the increments must not get lost when done concurrently
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				store.Increment("concurrent", 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if val, _ := store.Get("concurrent"); val != "100" {
+		t.Errorf("Get() = %v, want %v", val, "100")
+	}
+	store.Close()
+}
@@ -0,0 +1,85 @@
+package caskserver
+
+// match tells whether the key matches the glob style pattern of the KEYS command.
+// Like redis, a star matches any sequence of characters, even an empty one, and a
+// question mark any single character. [abc] matches one of the characters, [a-c] is
+// a range and [^a] negates it. A backslash escapes the character after it
+func match(pattern string, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// collapse the runs of stars, then try every possible rest of the key
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(key); i++ {
+				if match(pattern, key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+		case '[':
+			if len(key) == 0 {
+				return false
+			}
+			end, ok := matchClass(pattern, key[0])
+			if !ok {
+				return false
+			}
+			pattern, key = pattern[end:], key[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+		}
+		pattern, key = pattern[1:], key[1:]
+	}
+	return len(key) == 0
+}
+
+// matchClass matches c against the character class at the start of the pattern. It
+// returns where the class ends in the pattern, and whether c is in it. A class which
+// is not closed runs till the end of the pattern
+func matchClass(pattern string, c byte) (int, bool) {
+	i := 1
+	negate := i < len(pattern) && pattern[i] == '^'
+	if negate {
+		i++
+	}
+	matched := false
+	for i < len(pattern) && pattern[i] != ']' {
+		if pattern[i] == '\\' && i+1 < len(pattern) {
+			i++
+		}
+		lo, hi := pattern[i], pattern[i]
+		if i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']' {
+			hi = pattern[i+2]
+			i += 2
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+		i++
+	}
+	if i < len(pattern) {
+		// skip the closing ]
+		i++
+	}
+	return i, matched != negate
+}
@@ -0,0 +1,125 @@
+package caskserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// errProtocol is returned when the client does not speak RESP
+var errProtocol = errors.New("protocol error")
+
+// maxBulkSize is the largest bulk string we accept, the same as the default of redis
+const maxBulkSize = 512 << 20
+
+// maxLineSize is the longest line we read, with its line ending, the same limit as
+// redis has for the inline commands. The lines are only headers or inline commands,
+// the bulk strings are read by their size
+const maxLineSize = 64 << 10
+
+// maxArgs is the most arguments of a command we accept, the same limit as redis
+const maxArgs = 1024 * 1024
+
+// readCommand reads the next command of the client, and returns its arguments. The
+// clients send the commands as arrays of bulk strings, like:
+//
+//	*2\r\n$3\r\nGET\r\n$4\r\nname\r\n
+//
+// We also accept the inline commands, which are the arguments separated by spaces on
+// a line, so that one can type the commands in telnet
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 0 || n > maxArgs {
+		return nil, errProtocol
+	}
+	// the arguments are not allocated up front from the count, which the client
+	// sent, but as they arrive
+	var args []string
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, errProtocol
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 || size > maxBulkSize {
+			return nil, errProtocol
+		}
+		// the bulk string is followed by \r\n
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		if string(data[size:]) != "\r\n" {
+			return nil, errProtocol
+		}
+		args = append(args, string(data[:size]))
+	}
+	return args, nil
+}
+
+// readLine reads a line ending in \r\n, or just \n for the inline commands, and
+// returns it without the line ending. A line longer than maxLineSize is a protocol
+// error, else a client could make us buffer a line without end
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLineSize {
+			return "", errProtocol
+		}
+		line = append(line, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			if err == io.EOF && len(line) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+	}
+}
+
+// writeSimple writes a simple string, like +OK
+func writeSimple(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "+%s\r\n", s)
+}
+
+// writeError writes an error, the message starts with the error kind, like ERR
+func writeError(w *bufio.Writer, msg string) {
+	fmt.Fprintf(w, "-%s\r\n", msg)
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	fmt.Fprintf(w, ":%d\r\n", n)
+}
+
+func writeBulk(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+// writeNull writes the null bulk string, which is the reply for a missing key
+func writeNull(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
+
+func writeArray(w *bufio.Writer, items []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(items))
+	for _, item := range items {
+		writeBulk(w, item)
+	}
+}
@@ -0,0 +1,329 @@
+// Package caskserver serves a caskdb store over the Redis wire protocol (RESP), so
+// that redis-cli and the redis client libraries can talk to it. It supports the
+// commands GET, SET, DEL, EXISTS, KEYS and TTL, along with PING, ECHO and QUIT.
+//
+// Typical usage example:
+//
+//	store, _ := caskdb.NewDiskStore("books.db")
+//	server := caskserver.NewServer(store)
+//	server.ListenAndServe(":6380")
+package caskserver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/avinassh/go-caskdb"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close
+var ErrServerClosed = errors.New("caskserver: server closed")
+
+// Server serves a DiskStore to the clients speaking RESP
+type Server struct {
+	store *caskdb.DiskStore
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// NewServer returns a server for the store. The server does not own the store, the
+// caller closes the store after closing the server
+func NewServer(store *caskdb.DiskStore) *Server {
+	return &Server{
+		store:     store,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address and serves the clients connecting to it
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts the connections on the listener and serves each of them on its own
+// goroutine. It returns when the listener fails, or ErrServerClosed after Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listeners and closes the connections, and waits for the commands
+// being run to finish
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var closeErr error
+	for l := range s.listeners {
+		if err := l.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return closeErr
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err == errProtocol {
+			writeError(w, "ERR Protocol error")
+			w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		quit := s.run(w, args)
+		// the clients may send many commands without waiting for the replies,
+		// we reply to all of them with one write
+		if r.Buffered() == 0 || quit {
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// run runs the command and writes its reply. It returns true if the client asked
+// to close the connection
+func (s *Server) run(w *bufio.Writer, args []string) bool {
+	name := strings.ToLower(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs > 0 && len(args) > cmd.maxArgs) {
+		writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+	if name == "quit" {
+		writeSimple(w, "OK")
+		return true
+	}
+	if err := cmd.run(s.store, w, args[1:]); err != nil {
+		writeError(w, "ERR "+err.Error())
+	}
+	return false
+}
+
+// command is a command the server supports. The counts of the arguments include the
+// name of the command, and a maxArgs of zero means no limit
+type command struct {
+	minArgs int
+	maxArgs int
+	run     func(store *caskdb.DiskStore, w *bufio.Writer, args []string) error
+}
+
+var commands = map[string]command{
+	"ping":    {1, 2, ping},
+	"echo":    {2, 2, echo},
+	"quit":    {1, 1, nil},
+	"command": {1, 0, commandInfo},
+	"get":     {2, 2, get},
+	"set":     {3, 5, set},
+	"del":     {2, 0, del},
+	"exists":  {2, 0, exists},
+	"keys":    {2, 2, keys},
+	"ttl":     {2, 2, ttl},
+}
+
+func ping(store *caskdb.DiskStore, w *bufio.Writer, args []string) error {
+	if len(args) == 1 {
+		writeBulk(w, args[0])
+		return nil
+	}
+	writeSimple(w, "PONG")
+	return nil
+}
+
+func echo(store *caskdb.DiskStore, w *bufio.Writer, args []string) error {
+	writeBulk(w, args[0])
+	return nil
+}
+
+// commandInfo replies to COMMAND, which redis-cli sends when it connects, with no
+// commands. The clients fall back to their own list of commands then
+func commandInfo(store *caskdb.DiskStore, w *bufio.Writer, args []string) error {
+	writeArray(w, nil)
+	return nil
+}
+
+func get(store *caskdb.DiskStore, w *bufio.Writer, args []string) error {
+	value, err := store.Get(args[0])
+	if err == caskdb.ErrKeyNotFound {
+		writeNull(w)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	writeBulk(w, value)
+	return nil
+}
+
+// set handles SET key value [EX seconds | PX milliseconds]
+func set(store *caskdb.DiskStore, w *bufio.Writer, args []string) error {
+	key, value := args[0], args[1]
+	if len(args) == 2 {
+		if err := store.Set(key, value); err != nil {
+			return err
+		}
+		writeSimple(w, "OK")
+		return nil
+	}
+	if len(args) != 4 {
+		writeError(w, "ERR syntax error")
+		return nil
+	}
+	n, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil {
+		writeError(w, "ERR value is not an integer or out of range")
+		return nil
+	}
+	var ttl time.Duration
+	switch strings.ToLower(args[2]) {
+	case "ex":
+		ttl = time.Duration(n) * time.Second
+	case "px":
+		ttl = time.Duration(n) * time.Millisecond
+	default:
+		writeError(w, "ERR syntax error")
+		return nil
+	}
+	if ttl <= 0 {
+		writeError(w, "ERR invalid expire time in 'set' command")
+		return nil
+	}
+	if err := store.SetWithTTL(key, value, ttl); err != nil {
+		return err
+	}
+	writeSimple(w, "OK")
+	return nil
+}
+
+// del deletes the keys, and replies with how many of them existed
+func del(store *caskdb.DiskStore, w *bufio.Writer, args []string) error {
+	deleted := 0
+	for _, key := range args {
+		if !store.Has(key) {
+			continue
+		}
+		if err := store.Delete(key); err != nil {
+			return err
+		}
+		deleted++
+	}
+	writeInt(w, int64(deleted))
+	return nil
+}
+
+// exists replies with how many of the keys exist, a key given twice is counted twice
+func exists(store *caskdb.DiskStore, w *bufio.Writer, args []string) error {
+	n := 0
+	for _, key := range args {
+		if store.Has(key) {
+			n++
+		}
+	}
+	writeInt(w, int64(n))
+	return nil
+}
+
+func keys(store *caskdb.DiskStore, w *bufio.Writer, args []string) error {
+	var matched []string
+	for _, key := range store.Keys() {
+		if match(args[0], key) {
+			matched = append(matched, key)
+		}
+	}
+	writeArray(w, matched)
+	return nil
+}
+
+// ttl replies with the seconds left for the key to expire, -1 if it never expires and
+// -2 if it does not exist
+func ttl(store *caskdb.DiskStore, w *bufio.Writer, args []string) error {
+	left, err := store.TTL(args[0])
+	if err == caskdb.ErrKeyNotFound {
+		writeInt(w, -2)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if left == caskdb.NoExpiry {
+		writeInt(w, -1)
+		return nil
+	}
+	// the expiry is rounded up to a whole second when it is stored, we round down
+	// here so that a key set with EX n has a TTL of n right after
+	writeInt(w, int64(left/time.Second))
+	return nil
+}
@@ -0,0 +1,187 @@
+package caskserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/avinassh/go-caskdb"
+)
+
+// startServer starts a server for a new store on a random port, and returns a
+// connection to it
+func startServer(t *testing.T) (*Server, *caskdb.DiskStore, net.Conn) {
+	store, err := caskdb.NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := NewServer(store)
+	go server.Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	return server, store, conn
+}
+
+// send sends the command as an array of bulk strings, and returns the raw reply
+func send(t *testing.T, conn net.Conn, r *bufio.Reader, args ...string) string {
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := conn.Write([]byte(cmd)); err != nil {
+		t.Fatalf("failed to send %v: %v", args, err)
+	}
+	return readReply(t, r)
+}
+
+// readReply reads one reply, with its nested replies for an array
+func readReply(t *testing.T, r *bufio.Reader) string {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read reply: %v", err)
+	}
+	reply := line
+	var n int
+	switch line[0] {
+	case '$':
+		fmt.Sscanf(line, "$%d", &n)
+		if n >= 0 {
+			data := make([]byte, n+2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				t.Fatalf("failed to read reply: %v", err)
+			}
+			reply += string(data)
+		}
+	case '*':
+		fmt.Sscanf(line, "*%d", &n)
+		for i := 0; i < n; i++ {
+			reply += readReply(t, r)
+		}
+	}
+	return reply
+}
+
+func TestServer_Commands(t *testing.T) {
+	server, store, conn := startServer(t)
+	defer os.RemoveAll("test.db")
+	r := bufio.NewReader(conn)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"PING"}, "+PONG\r\n"},
+		{[]string{"GET", "othello"}, "$-1\r\n"},
+		{[]string{"SET", "othello", "shakespeare"}, "+OK\r\n"},
+		{[]string{"get", "othello"}, "$11\r\nshakespeare\r\n"},
+		{[]string{"SET", "dune", "herbert", "EX", "100"}, "+OK\r\n"},
+		{[]string{"TTL", "dune"}, ":100\r\n"},
+		{[]string{"TTL", "othello"}, ":-1\r\n"},
+		{[]string{"TTL", "missing"}, ":-2\r\n"},
+		{[]string{"SET", "dune", "herbert", "EX", "0"}, "-ERR invalid expire time in 'set' command\r\n"},
+		{[]string{"SET", "hamlet", "shakespeare"}, "+OK\r\n"},
+		{[]string{"KEYS", "*"}, "*3\r\n$4\r\ndune\r\n$6\r\nhamlet\r\n$7\r\nothello\r\n"},
+		{[]string{"KEYS", "[hd]*"}, "*2\r\n$4\r\ndune\r\n$6\r\nhamlet\r\n"},
+		{[]string{"EXISTS", "othello", "missing", "othello"}, ":2\r\n"},
+		{[]string{"DEL", "othello", "missing"}, ":1\r\n"},
+		{[]string{"EXISTS", "othello"}, ":0\r\n"},
+		{[]string{"GET"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{[]string{"FLUSHALL"}, "-ERR unknown command 'FLUSHALL'\r\n"},
+		{[]string{"QUIT"}, "+OK\r\n"},
+	}
+	for _, tt := range tests {
+		if got := send(t, conn, r, tt.args...); got != tt.want {
+			t.Errorf("%v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+	conn.Close()
+	server.Close()
+	store.Close()
+}
+
+func TestServer_InlineAndPipelining(t *testing.T) {
+	server, store, conn := startServer(t)
+	defer os.RemoveAll("test.db")
+	r := bufio.NewReader(conn)
+
+	conn.Write([]byte("SET name jojo\r\nGET name\r\n*1\r\n$4\r\nPING\r\n"))
+	for _, want := range []string{"+OK\r\n", "$4\r\njojo\r\n", "+PONG\r\n"} {
+		if got := readReply(t, r); got != want {
+			t.Errorf("reply = %q, want %q", got, want)
+		}
+	}
+	conn.Close()
+	if err := server.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	store.Close()
+}
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr error
+	}{
+		{"array", "*2\r\n$3\r\nGET\r\n$4\r\nname\r\n", []string{"GET", "name"}, nil},
+		{"inline", "GET name\r\n", []string{"GET", "name"}, nil},
+		{"negative count", "*-1\r\n", nil, errProtocol},
+		// a huge count must not be allocated up front, it would crash the server
+		{"huge count", "*4611686018427387904\r\n", nil, errProtocol},
+		{"too many arguments", fmt.Sprintf("*%d\r\n", maxArgs+1), nil, errProtocol},
+		{"count larger than the arguments", "*1000\r\n$4\r\nPING\r\n", nil, io.EOF},
+		{"long line", "GET " + strings.Repeat("x", 60<<10) + "\r\n", []string{"GET", strings.Repeat("x", 60<<10)}, nil},
+		// a line without end must not be buffered whole
+		{"line too long", strings.Repeat("x", maxLineSize+1), nil, errProtocol},
+		{"header too long", "*1\r\n$" + strings.Repeat("0", maxLineSize) + "4\r\nPING\r\n", nil, errProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readCommand(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != tt.wantErr {
+				t.Fatalf("readCommand() error = %v, want %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("readCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "hamlet", true},
+		{"h*t", "hamlet", true},
+		{"h*t", "hamlets", false},
+		{"h?mlet", "hamlet", true},
+		{"h?mlet", "hmlet", false},
+		{"[hd]*", "dune", true},
+		{"[^hd]*", "dune", false},
+		{"[a-c]at", "bat", true},
+		{"[a-c]at", "rat", false},
+		{"books/*", "books/dune", true},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+	}
+	for _, tt := range tests {
+		if got := match(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
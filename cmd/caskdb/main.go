@@ -0,0 +1,268 @@
+// Command caskdb inspects and administers a caskdb store.
+//
+// Usage:
+//
+//	caskdb [-dir path] <command> [arguments]
+//
+// The commands are:
+//
+//	dump              print all the records in the data files, in the order they
+//	                  were written
+//	get <key>         print the value of the key
+//	set <key> <value> set the key to the value
+//	del <key>         delete the key
+//	stats             print the Stats of the store
+//	merge             merge the data files, dropping the stale records
+//	verify            check the checksums of all the records
+//	repair            cut the data files off at their first corrupt record
+//	merge-stores <dst> <src>...
+//	                  merge the stores at src into a new store at dst, keeping the
+//	                  latest value of every key. The -dir flag is not used
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/avinassh/go-caskdb"
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// errUsage is returned when the command is given the wrong arguments
+var errUsage = errors.New("usage")
+
+func main() {
+	dir := flag.String("dir", "caskdb", "data directory of the store")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	err := run(*dir, flag.Arg(0), flag.Args()[1:], os.Stdout)
+	if err == errUsage {
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "caskdb: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: caskdb [-dir path] <command> [arguments]
+
+commands:
+  dump              print all the records in the data files
+  get <key>         print the value of the key
+  set <key> <value> set the key to the value
+  del <key>         delete the key
+  stats             print the stats of the store
+  merge             merge the data files
+  verify            check the checksums of all the records
+  repair            cut the data files off at their first corrupt record
+  merge-stores <dst> <src>...
+                    merge the stores at src into a new store at dst
+`)
+}
+
+// run runs the command with its arguments on the store in dir, and writes the output
+// to w
+func run(dir string, command string, args []string, w io.Writer) error {
+	// the commands which only read the store open it in read-only mode, so that
+	// they don't change it, and don't create it if it does not exist
+	readOnly := caskdb.WithReadOnly()
+	switch command {
+	case "dump":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return scan(dir, w, true)
+	case "verify":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return scan(dir, w, false)
+	case "repair":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return caskdb.Repair(dir, caskdb.WithLogger(caskdb.NewLogger(w, caskdb.LevelWarn)))
+	case "get":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return withStore(dir, func(store *caskdb.DiskStore) error {
+			value, err := store.Get(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, value)
+			return nil
+		}, readOnly)
+	case "set":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return withStore(dir, func(store *caskdb.DiskStore) error {
+			return store.Set(args[0], args[1])
+		})
+	case "del":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return withStore(dir, func(store *caskdb.DiskStore) error {
+			return store.Delete(args[0])
+		})
+	case "stats":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return withStore(dir, func(store *caskdb.DiskStore) error {
+			stats, err := store.Stats()
+			if err != nil {
+				return err
+			}
+			printStats(w, stats)
+			return nil
+		}, readOnly)
+	case "merge":
+		if len(args) != 0 {
+			return errUsage
+		}
+		return withStore(dir, func(store *caskdb.DiskStore) error {
+			return store.Merge()
+		})
+	case "merge-stores":
+		if len(args) < 2 {
+			return errUsage
+		}
+		return caskdb.MergeStores(args[0], args[1:]...)
+	}
+	return errUsage
+}
+
+// withStore opens the store, calls fn with it, and closes it
+func withStore(dir string, fn func(store *caskdb.DiskStore) error, opts ...caskdb.Option) error {
+	store, err := caskdb.Open(dir, opts...)
+	if err != nil {
+		return err
+	}
+	if err := fn(store); err != nil {
+		store.Close()
+		return err
+	}
+	return store.Close()
+}
+
+// scan reads all the records of the data files, verifying their checksums. If print
+// is true, it prints every record. It reports every file which is corrupt, and
+// returns an error if there is any
+func scan(dir string, w io.Writer, print bool) error {
+	fileNames, err := caskdb.DataFiles(dir)
+	if err != nil {
+		return err
+	}
+	corrupt := 0
+	for _, fileName := range fileNames {
+		records, err := scanFile(fileName, w, print)
+		if err != nil {
+			fmt.Fprintf(w, "%s: %v\n", fileName, err)
+			corrupt++
+			continue
+		}
+		if !print {
+			fmt.Fprintf(w, "%s: %d records ok\n", fileName, records)
+		}
+	}
+	if corrupt > 0 {
+		return fmt.Errorf("%d of %d data files are corrupt, run repair to fix them", corrupt, len(fileNames))
+	}
+	return nil
+}
+
+// scanFile reads all the records of the data file, and returns how many there are
+func scanFile(fileName string, w io.Writer, print bool) (int, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	reader := format.NewReader(file)
+	header, err := reader.Header()
+	if err != nil {
+		return 0, err
+	}
+	records := 0
+	end := int64(header.Size())
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, fmt.Errorf("offset %d: %w", end, err)
+		}
+		records++
+		end = record.Offset + int64(record.Size)
+		if print {
+			printRecord(w, filepath.Base(fileName), record)
+		}
+	}
+}
+
+func printRecord(w io.Writer, fileName string, record format.Record) {
+	fmt.Fprintf(w, "%s offset=%d time=%s ", fileName, record.Offset, formatTime(record.Timestamp))
+	if record.Tombstone && !record.Encrypted {
+		fmt.Fprintf(w, "key=%q deleted\n", record.Key)
+		return
+	}
+	switch {
+	case record.Encrypted:
+		fmt.Fprintf(w, "<%d encrypted bytes>", len(record.Value))
+	case record.ValuePointer:
+		pointer, _ := format.DecodeValuePointer([]byte(record.Value))
+		fmt.Fprintf(w, "key=%q value=<%d bytes in value log %d at offset %d>", record.Key, pointer.Size, pointer.FileID, pointer.Offset)
+	case record.Compressed:
+		value, err := format.Decompress([]byte(record.Value))
+		if err != nil {
+			fmt.Fprintf(w, "key=%q value=<%d compressed bytes: %v>", record.Key, len(record.Value), err)
+		} else {
+			fmt.Fprintf(w, "key=%q value=%q", record.Key, value)
+		}
+	default:
+		fmt.Fprintf(w, "key=%q value=%q", record.Key, record.Value)
+	}
+	if record.Compressed {
+		fmt.Fprint(w, " compressed")
+	}
+	if record.Expiry != 0 {
+		fmt.Fprintf(w, " expiry=%s", formatTime(record.Expiry))
+	}
+	fmt.Fprintln(w)
+}
+
+func formatTime(ts uint32) string {
+	return time.Unix(int64(ts), 0).UTC().Format(time.RFC3339)
+}
+
+func printStats(w io.Writer, stats caskdb.Stats) {
+	lastMerge := "never"
+	if !stats.LastMerge.IsZero() {
+		lastMerge = stats.LastMerge.Format(time.RFC3339)
+	}
+	fmt.Fprintf(w, "keys:            %d\n", stats.Keys)
+	fmt.Fprintf(w, "records scanned: %d\n", stats.RecordsScanned)
+	fmt.Fprintf(w, "data files:      %d\n", stats.DataFiles)
+	fmt.Fprintf(w, "data size:       %d\n", stats.DataSize)
+	fmt.Fprintf(w, "live size:       %d\n", stats.LiveSize)
+	fmt.Fprintf(w, "dead ratio:      %.2f\n", stats.DeadRatio)
+	fmt.Fprintf(w, "last merge:      %s\n", lastMerge)
+	fmt.Fprintf(w, "keydir size:     %d\n", stats.KeyDirSize)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/avinassh/go-caskdb"
+)
+
+func TestRun(t *testing.T) {
+	defer os.RemoveAll("test.db")
+	var out bytes.Buffer
+	for _, args := range [][]string{{"set", "othello", "shakespeare"}, {"set", "dune", "herbert"}, {"del", "dune"}} {
+		if err := run("test.db", args[0], args[1:], &out); err != nil {
+			t.Fatalf("run(%v) error = %v", args, err)
+		}
+	}
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"get", "othello"}, "shakespeare\n"},
+		{[]string{"dump"}, `key="othello" value="shakespeare"`},
+		{[]string{"dump"}, `key="dune" deleted`},
+		{[]string{"verify"}, "3 records ok"},
+		{[]string{"stats"}, "keys:            1\n"},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		if err := run("test.db", tt.args[0], tt.args[1:], &out); err != nil {
+			t.Fatalf("run(%v) error = %v", tt.args, err)
+		}
+		if !strings.Contains(out.String(), tt.want) {
+			t.Errorf("run(%v) = %q, want it to have %q", tt.args, out.String(), tt.want)
+		}
+	}
+	if err := run("test.db", "get", []string{"dune"}, &out); err != caskdb.ErrKeyNotFound {
+		t.Errorf("run(get dune) error = %v, want %v", err, caskdb.ErrKeyNotFound)
+	}
+	if err := run("test.db", "get", nil, &out); err != errUsage {
+		t.Errorf("run(get) error = %v, want %v", err, errUsage)
+	}
+
+	// corrupt the last record, verify reports it and repair drops it
+	fileNames, _ := caskdb.DataFiles("test.db")
+	data, _ := os.ReadFile(fileNames[0])
+	data[len(data)-1] ^= 0x01
+	os.WriteFile(fileNames[0], data, 0666)
+	if err := run("test.db", "verify", nil, &out); err == nil {
+		t.Errorf("run(verify) of a corrupt store, want an error")
+	}
+	if err := run("test.db", "repair", nil, &out); err != nil {
+		t.Fatalf("run(repair) error = %v", err)
+	}
+	if err := run("test.db", "verify", nil, &out); err != nil {
+		t.Errorf("run(verify) after repair error = %v", err)
+	}
+}
+
+func TestRun_MergeStores(t *testing.T) {
+	defer os.RemoveAll("test.db")
+	defer os.RemoveAll("othello.db")
+	defer os.RemoveAll("dune.db")
+	var out bytes.Buffer
+	if err := run("othello.db", "set", []string{"othello", "shakespeare"}, &out); err != nil {
+		t.Fatalf("run(set) error = %v", err)
+	}
+	if err := run("dune.db", "set", []string{"dune", "herbert"}, &out); err != nil {
+		t.Fatalf("run(set) error = %v", err)
+	}
+	if err := run("", "merge-stores", []string{"test.db", "othello.db", "dune.db"}, &out); err != nil {
+		t.Fatalf("run(merge-stores) error = %v", err)
+	}
+	for key, want := range map[string]string{"othello": "shakespeare\n", "dune": "herbert\n"} {
+		out.Reset()
+		if err := run("test.db", "get", []string{key}, &out); err != nil || out.String() != want {
+			t.Errorf("run(get %v) = %q, %v, want %q", key, out.String(), err, want)
+		}
+	}
+	if err := run("", "merge-stores", []string{"test.db"}, &out); err != errUsage {
+		t.Errorf("run(merge-stores test.db) error = %v, want %v", err, errUsage)
+	}
+}
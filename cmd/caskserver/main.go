@@ -0,0 +1,73 @@
+// Command caskserver serves a caskdb store over the Redis wire protocol.
+//
+// Usage:
+//
+//	caskserver -addr :6380 -dir books.db
+//
+// Then talk to it with redis-cli -p 6380.
+//
+// With -replicate :7380, it serves the replicas too, and with -replica-of
+// primary:7380, it is a replica of the primary, serving the reads only.
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/avinassh/go-caskdb"
+	"github.com/avinassh/go-caskdb/caskserver"
+)
+
+func main() {
+	addr := flag.String("addr", ":6380", "address to listen on")
+	dir := flag.String("dir", "caskdb", "data directory of the store")
+	replicate := flag.String("replicate", "", "address to serve the replicas on")
+	replicaOf := flag.String("replica-of", "", "address of the primary to follow")
+	flag.Parse()
+
+	// a server logs what it does, the store logs only the warnings by default
+	logger := caskdb.WithLogger(caskdb.NewLogger(os.Stderr, caskdb.LevelInfo))
+	var store *caskdb.DiskStore
+	var err error
+	if *replicaOf != "" {
+		store, err = caskdb.OpenReplica(*dir, *replicaOf, logger)
+	} else {
+		store, err = caskdb.Open(*dir, logger)
+	}
+	if err != nil {
+		log.Fatalf("failed to open the store: %v", err)
+	}
+	server := caskserver.NewServer(store)
+	var primary *caskdb.Primary
+	if *replicate != "" {
+		if primary, err = caskdb.NewPrimary(store); err != nil {
+			log.Fatalf("failed to serve the replicas: %v", err)
+		}
+		go func() {
+			if err := primary.ListenAndServe(*replicate); err != caskdb.ErrPrimaryClosed {
+				log.Printf("failed to serve the replicas: %v", err)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		server.Close()
+	}()
+
+	log.Printf("serving %s on %s", *dir, *addr)
+	if err := server.ListenAndServe(*addr); err != caskserver.ErrServerClosed {
+		log.Printf("failed to serve: %v", err)
+	}
+	if primary != nil {
+		primary.Close()
+	}
+	if err := store.Close(); err != nil {
+		log.Fatalf("failed to close the store: %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package caskdb
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"math"
+	"unsafe"
+)
+
+// compact keydir file has the compact table of the keyDir shards, for the stores with
+// too many keys to keep in Go maps. A map[string]KeyEntry takes about a hundred bytes
+// per key on top of the key itself: the slot of the key in the buckets of the map,
+// the room the map keeps free to grow, and the key string, which is an allocation of
+// its own, rounded up to the size class of the allocator. Worse, every key is a
+// pointer, which the garbage collector scans on every cycle.
+//
+// A compactTable keeps all the keys and their entries in pages of bytes, the arena,
+// one record after the other:
+//
+//	┌──────────────────┬─────┬───────────────────────────────────────────────────┐
+//	│ key_size(varint) │ key │ timestamp | expiry | file_id | version | position │
+//	│                  │     │ | total_size, all varints                          │
+//	└──────────────────┴─────┴───────────────────────────────────────────────────┘
+//
+// and finds them with an open addressing hash table of slots, each the place of a
+// record in the arena. A key is looked up by probing the slots from its hash on,
+// comparing the key with the ones in the arena, until it is found or an empty slot is
+// reached. Neither the slots nor the arena have pointers, so the garbage collector
+// skips them, and a key takes its own size and about 25 bytes: in a keyDir of a
+// million keys of 17 bytes, 42 bytes a key, against 141 in maps.
+//
+// The price is speed: a lookup reads the key from the arena to compare it and
+// decodes the varints of its entry, and every key returned by each is a new string,
+// copied out of the arena. A write of an existing key updates its entry in place if
+// the new one is no larger, else it is written anew, and the old record is left
+// behind in the arena like the one of a removed key. The arena is compacted once
+// these take half of it.
+//
+// The slots can address compactMaxPages pages, 4GiB of records. The keys which don't
+// fit once a table has as many go to a map of its own, the overflow, which is slow
+// and large but does not run out.
+
+const (
+	// compactEmpty is a slot which never had a key, and compactRemoved is a slot
+	// whose key was removed. The probes stop at the former and go past the latter.
+	// The other slots have the index of the page of the record in their high 16
+	// bits, and the offset of the record in the page in their low 16 bits, plus one
+	compactEmpty   = 0
+	compactRemoved = math.MaxUint32
+	// compactMinSlots is the number of slots of an empty table, a power of two
+	compactMinSlots = 8
+	// compactPageSize is the size of the pages of the arena, as much as the 16 bits
+	// of an offset in a slot can address. A record larger than that has a page of
+	// its own, at offset zero
+	compactPageSize = 1 << 16
+	// compactMaxPages is the number of pages a table can have, which caps the arena
+	// of a shard at 4GiB of records
+	compactMaxPages = 1<<16 - 1
+	// compactMaxEntrySize is the largest size of the varints of an entry
+	compactMaxEntrySize = 4*binary.MaxVarintLen32 + 2*binary.MaxVarintLen64
+)
+
+// compactTable is the keyDirTable of an arena of records and a hash table of slots
+type compactTable struct {
+	slots []uint32
+	// pages are the arena. A record is never split across pages. Unlike a single
+	// slice, which doubles as it grows, the pages waste at most the end of each
+	// page, a record's worth
+	pages [][]byte
+	// count is the number of keys, used is the number of slots which are not
+	// empty, the removed ones included, size is the size of all the records in the
+	// pages, and garbage is the size of the records of the removed keys and of the
+	// replaced entries
+	count   int
+	used    int
+	size    int
+	garbage int
+	// overflow has the keys which did not fit in the arena, once it had
+	// compactMaxPages pages. It is nil until then
+	overflow mapTable
+	// seed is the seed of the hash of the keys, random so that one who picks the
+	// keys can't make their probes long
+	seed maphash.Seed
+}
+
+// newCompactTable returns an empty compactTable, sized for about size keys
+func newCompactTable(size int) *compactTable {
+	n := compactMinSlots
+	for n*3 < size*4 {
+		n *= 2
+	}
+	return &compactTable{slots: make([]uint32, n), seed: maphash.MakeSeed()}
+}
+
+// hash returns the seeded hash of the key, the low bits of which pick the slot
+func (t *compactTable) hash(key string) uint64 {
+	return maphash.String(t.seed, key)
+}
+
+// record returns the key of the record in the slot, and the rest of the page from
+// the entry of the key on
+func (t *compactTable) record(slot uint32) ([]byte, []byte) {
+	page := t.pages[(slot-1)>>16][(slot-1)&0xffff:]
+	keySize, n := binary.Uvarint(page)
+	end := n + int(keySize)
+	return page[n:end], page[end:]
+}
+
+// alloc takes size bytes at the end of the arena for a new record, and returns them
+// with the slot of the record. It returns false if the arena is full: the last page
+// has no room for the record, and there are compactMaxPages pages already
+func (t *compactTable) alloc(size int) (uint32, []byte, bool) {
+	last := len(t.pages) - 1
+	if last < 0 || len(t.pages[last])+size > cap(t.pages[last]) {
+		if len(t.pages) >= compactMaxPages {
+			return 0, nil, false
+		}
+		pageSize := compactPageSize
+		if size > pageSize {
+			pageSize = size
+		}
+		t.pages = append(t.pages, make([]byte, 0, pageSize))
+		last++
+	}
+	offset := len(t.pages[last])
+	t.pages[last] = t.pages[last][:offset+size]
+	t.size += size
+	return uint32(last)<<16 | uint32(offset) + 1, t.pages[last][offset : offset+size], true
+}
+
+// find returns the index of the slot of the key, and true if the key is in it. If
+// the key is not in the table, the slot is where it goes: the first removed slot
+// probed, or else the empty slot which ended the probes
+func (t *compactTable) find(key string) (int, bool) {
+	mask := uint64(len(t.slots) - 1)
+	free := -1
+	for i := t.hash(key) & mask; ; i = (i + 1) & mask {
+		switch slot := t.slots[i]; slot {
+		case compactEmpty:
+			if free < 0 {
+				free = int(i)
+			}
+			return free, false
+		case compactRemoved:
+			if free < 0 {
+				free = int(i)
+			}
+		default:
+			// the compiler does not copy the bytes to compare them to a string
+			if k, _ := t.record(slot); string(k) == key {
+				return int(i), true
+			}
+		}
+	}
+}
+
+func (t *compactTable) get(key string) (KeyEntry, bool) {
+	i, ok := t.find(key)
+	if !ok {
+		return t.overflow.get(key)
+	}
+	_, entry := t.record(t.slots[i])
+	kEntry, _ := decodeCompactEntry(entry)
+	return kEntry, true
+}
+
+func (t *compactTable) put(key string, kEntry KeyEntry) (KeyEntry, bool) {
+	if previous, ok := t.overflow.get(key); ok {
+		t.overflow.put(key, kEntry)
+		return previous, true
+	}
+	var buf [compactMaxEntrySize]byte
+	encoded := appendCompactEntry(buf[:0], kEntry)
+	i, ok := t.find(key)
+	if ok {
+		_, entry := t.record(t.slots[i])
+		previous, n := decodeCompactEntry(entry)
+		// the varints of the entry are padded to the size of the old ones, so that
+		// the record keeps its size
+		if padCompactEntry(entry[:n], encoded) {
+			return previous, true
+		}
+		t.garbage += t.recordSize(key, n)
+		slot, ok := t.appendRecord(key, encoded)
+		if ok {
+			t.slots[i] = slot
+		} else {
+			t.slots[i] = compactRemoved
+			t.count--
+			t.putOverflow(key, kEntry)
+		}
+		t.collect()
+		return previous, true
+	}
+	// the table is kept at most 3/4 full, so that the probes stay short and always
+	// reach an empty slot
+	if t.slots[i] == compactEmpty && (t.used+1)*4 > len(t.slots)*3 {
+		n := len(t.slots)
+		// if most of the slots taken are removed ones, dropping them makes enough
+		// room
+		if (t.count+1)*2 > n {
+			n *= 2
+		}
+		t.rebuild(n)
+		i, _ = t.find(key)
+	}
+	slot, ok := t.appendRecord(key, encoded)
+	if !ok {
+		t.putOverflow(key, kEntry)
+		return KeyEntry{}, false
+	}
+	if t.slots[i] == compactEmpty {
+		t.used++
+	}
+	t.slots[i] = slot
+	t.count++
+	return KeyEntry{}, false
+}
+
+// putOverflow puts the key, which is in the arena no more, in the overflow
+func (t *compactTable) putOverflow(key string, kEntry KeyEntry) {
+	if t.overflow == nil {
+		t.overflow = make(mapTable)
+	}
+	t.overflow.put(key, kEntry)
+}
+
+func (t *compactTable) remove(key string) (KeyEntry, bool) {
+	i, ok := t.find(key)
+	if !ok {
+		return t.overflow.remove(key)
+	}
+	_, entry := t.record(t.slots[i])
+	previous, n := decodeCompactEntry(entry)
+	t.slots[i] = compactRemoved
+	t.count--
+	t.garbage += t.recordSize(key, n)
+	t.collect()
+	return previous, true
+}
+
+// recordSize returns the size of the record of the key, with an entry of n bytes
+func (t *compactTable) recordSize(key string, n int) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], uint64(len(key))) + len(key) + n
+}
+
+// appendRecord writes a record of the key and its encoded entry to the arena, and
+// returns its slot. It returns false if the arena is full
+func (t *compactTable) appendRecord(key string, entry []byte) (uint32, bool) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], uint64(len(key)))
+	slot, record, ok := t.alloc(n + len(key) + len(entry))
+	if !ok {
+		return 0, false
+	}
+	copy(record, buf[:n])
+	copy(record[n:], key)
+	copy(record[n+len(key):], entry)
+	return slot, true
+}
+
+// collect compacts the arena if the garbage takes more than half of it
+func (t *compactTable) collect() {
+	if t.garbage > t.size/2 {
+		t.rebuild(len(t.slots))
+	}
+}
+
+// rebuild moves the keys to a new table of n slots and a new arena, leaving out the
+// removed ones. The keys in the overflow stay there
+func (t *compactTable) rebuild(n int) {
+	old := *t
+	*t = compactTable{slots: make([]uint32, n), overflow: old.overflow, seed: old.seed}
+	mask := uint64(n - 1)
+	for _, slot := range old.slots {
+		if slot == compactEmpty || slot == compactRemoved {
+			continue
+		}
+		key, entry := old.record(slot)
+		kEntry, size := decodeCompactEntry(entry)
+		// the records are packed into the pages in another order, which may waste
+		// more of them, so a full arena may not take all of them back
+		newSlot, ok := t.appendRecord(string(key), entry[:size])
+		if !ok {
+			t.putOverflow(string(key), kEntry)
+			continue
+		}
+		t.count++
+		t.used++
+		for i := t.hash(string(key)) & mask; ; i = (i + 1) & mask {
+			if t.slots[i] == compactEmpty {
+				t.slots[i] = newSlot
+				break
+			}
+		}
+	}
+}
+
+func (t *compactTable) len() int {
+	return t.count + len(t.overflow)
+}
+
+func (t *compactTable) each(fn func(key string, kEntry KeyEntry) error) error {
+	for _, slot := range t.slots {
+		if slot == compactEmpty || slot == compactRemoved {
+			continue
+		}
+		key, entry := t.record(slot)
+		kEntry, _ := decodeCompactEntry(entry)
+		if err := fn(string(key), kEntry); err != nil {
+			return err
+		}
+	}
+	return t.overflow.each(fn)
+}
+
+func (t *compactTable) clone() keyDirTable {
+	c := *t
+	if t.overflow != nil {
+		c.overflow = t.overflow.clone().(mapTable)
+	}
+	c.slots = append([]uint32(nil), t.slots...)
+	c.pages = make([][]byte, len(t.pages))
+	for i, page := range t.pages {
+		c.pages[i] = append(make([]byte, 0, cap(page)), page...)
+	}
+	return &c
+}
+
+func (t *compactTable) memory() int64 {
+	n := int64(cap(t.slots))*4 + int64(cap(t.pages))*int64(unsafe.Sizeof([]byte(nil)))
+	for _, page := range t.pages {
+		n += int64(cap(page))
+	}
+	return n + t.overflow.memory()
+}
+
+// appendCompactEntry appends the varints of the KeyEntry to b
+func appendCompactEntry(b []byte, kEntry KeyEntry) []byte {
+	b = binary.AppendUvarint(b, uint64(kEntry.timestamp))
+	b = binary.AppendUvarint(b, uint64(kEntry.expiry))
+	b = binary.AppendUvarint(b, uint64(kEntry.fileID))
+	b = binary.AppendUvarint(b, uint64(kEntry.version))
+	b = binary.AppendUvarint(b, kEntry.position)
+	return binary.AppendUvarint(b, kEntry.totalSize)
+}
+
+// decodeCompactEntry decodes the KeyEntry at the start of b, and returns it with its
+// size
+func decodeCompactEntry(b []byte) (KeyEntry, int) {
+	var fields [6]uint64
+	n := 0
+	for i := range fields {
+		v, size := binary.Uvarint(b[n:])
+		fields[i] = v
+		n += size
+	}
+	return NewKeyEntry(uint32(fields[0]), uint32(fields[1]), uint32(fields[2]), uint32(fields[3]), fields[4], fields[5]), n
+}
+
+// padCompactEntry writes the encoded entry over the old one in b, if it fits. The
+// extra bytes are taken up by padding the last varint with zero groups of seven
+// bits, which decode to the same value, up to the nine bytes any value decodes from
+func padCompactEntry(b []byte, encoded []byte) bool {
+	last := len(encoded) - 1
+	for last > 0 && encoded[last-1]&0x80 != 0 {
+		last--
+	}
+	if len(encoded) > len(b) || len(b)-last > 9 {
+		return false
+	}
+	copy(b, encoded)
+	if len(b) == len(encoded) {
+		return true
+	}
+	b[len(encoded)-1] |= 0x80
+	for i := len(encoded); i < len(b)-1; i++ {
+		b[i] = 0x80
+	}
+	b[len(b)-1] = 0
+	return true
+}
@@ -0,0 +1,192 @@
+package caskdb
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCompactTable(t *testing.T) {
+	// the compact table must agree with a map on a random mix of puts and removes,
+	// which grow it, and rebuild it to drop the removed keys. The entries vary in
+	// size, so that some are padded in place and some written anew, and a few keys
+	// are too large for a page
+	table := newCompactTable(0)
+	want := make(mapTable)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("key-%d", r.Intn(2000))
+		if r.Intn(100) == 0 {
+			key += strings.Repeat("x", compactPageSize)
+		}
+		if r.Intn(3) == 0 {
+			got, gotOK := table.remove(key)
+			previous, ok := want.remove(key)
+			if got != previous || gotOK != ok {
+				t.Fatalf("remove(%q) = %+v, %v, want %+v, %v", key, got, gotOK, previous, ok)
+			}
+			continue
+		}
+		kEntry := NewKeyEntry(uint32(i), uint32(r.Intn(2)), uint32(r.Intn(10)), 2, uint64(r.Int63n(1<<40))>>r.Intn(40), uint64(r.Intn(1000)))
+		got, gotOK := table.put(key, kEntry)
+		previous, ok := want.put(key, kEntry)
+		if got != previous || gotOK != ok {
+			t.Fatalf("put(%q) = %+v, %v, want %+v, %v", key, got, gotOK, previous, ok)
+		}
+	}
+	if table.len() != len(want) {
+		t.Errorf("len() = %v, want %v", table.len(), len(want))
+	}
+	n := 0
+	table.each(func(key string, kEntry KeyEntry) error {
+		n++
+		if kEntry != want[key] {
+			t.Errorf("each() passed %q with %+v, want %+v", key, kEntry, want[key])
+		}
+		return nil
+	})
+	if n != len(want) {
+		t.Errorf("each() walked %v keys, want %v", n, len(want))
+	}
+	for key, kEntry := range want {
+		if got, ok := table.get(key); !ok || got != kEntry {
+			t.Errorf("get(%q) = %+v, %v, want %+v", key, got, ok, kEntry)
+		}
+	}
+	if table.garbage*2 > table.size {
+		t.Errorf("garbage = %v of an arena of %v, want at most half", table.garbage, table.size)
+	}
+	if table.used*4 > len(table.slots)*3 {
+		t.Errorf("used = %v of %v slots, want at most 3/4", table.used, len(table.slots))
+	}
+}
+
+func TestCompactTable_full(t *testing.T) {
+	// once the arena has all the pages the slots can address, the keys go to the
+	// overflow, which must agree with a map too
+	table := newCompactTable(0)
+	want := make(mapTable)
+	check := func(key string, got, previous KeyEntry, gotOK, ok bool) {
+		t.Helper()
+		if got != previous || gotOK != ok {
+			t.Fatalf("%q = %+v, %v, want %+v, %v", key, got, gotOK, previous, ok)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		key, kEntry := fmt.Sprintf("key-%d", i), NewKeyEntry(uint32(i), 0, 1, 2, 0, 10)
+		got, gotOK := table.put(key, kEntry)
+		previous, ok := want.put(key, kEntry)
+		check(key, got, previous, gotOK, ok)
+	}
+	table.pages = append(table.pages, make([][]byte, compactMaxPages-len(table.pages))...)
+	for i := 0; i < 20; i++ {
+		// the large positions don't fit in place of the old entries
+		key, kEntry := fmt.Sprintf("key-%d", i), NewKeyEntry(uint32(i), 0, 1, 2, 1<<40, 10)
+		got, gotOK := table.put(key, kEntry)
+		previous, ok := want.put(key, kEntry)
+		check(key, got, previous, gotOK, ok)
+	}
+	if len(table.overflow) == 0 {
+		t.Errorf("the overflow is empty, want the keys which did not fit")
+	}
+	for i := 0; i < 20; i += 3 {
+		key := fmt.Sprintf("key-%d", i)
+		got, gotOK := table.remove(key)
+		previous, ok := want.remove(key)
+		check(key, got, previous, gotOK, ok)
+	}
+	c := table.clone()
+	if c.len() != len(want) {
+		t.Errorf("len() = %v, want %v", c.len(), len(want))
+	}
+	n := 0
+	c.each(func(key string, kEntry KeyEntry) error {
+		n++
+		if got, ok := table.get(key); !ok || got != want[key] || kEntry != want[key] {
+			t.Errorf("get(%q) = %+v, %v, each() passed %+v, want %+v", key, got, ok, kEntry, want[key])
+		}
+		return nil
+	})
+	if n != len(want) {
+		t.Errorf("each() walked %v keys, want %v", n, len(want))
+	}
+}
+
+func TestCompactTable_memory(t *testing.T) {
+	// the heap taken by the keys of a table, measured rather than estimated, since
+	// the estimate of a map leaves out most of what the runtime allocates for it
+	heap := func(fill func(key string, kEntry KeyEntry)) uint64 {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		for i := 0; i < 100000; i++ {
+			fill(fmt.Sprintf("user:%08d", i), NewKeyEntry(uint32(i), 0, 1, 2, uint64(i), 100))
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		return after.HeapAlloc - before.HeapAlloc
+	}
+	table, m := newCompactTable(0), make(mapTable)
+	compact := heap(func(key string, kEntry KeyEntry) { table.put(key, kEntry) })
+	maps := heap(func(key string, kEntry KeyEntry) { m.put(key, kEntry) })
+	if compact*2 > maps {
+		t.Errorf("a compact table takes %v bytes, want less than half of the %v of a map", compact, maps)
+	}
+	if got := uint64(table.memory()); got < compact*9/10 || got > compact*11/10 {
+		t.Errorf("memory() = %v, want about the %v measured", got, compact)
+	}
+	runtime.KeepAlive(table)
+	runtime.KeepAlive(m)
+}
+
+func TestDiskStore_CompactKeyDir(t *testing.T) {
+	store, err := Open("test.db", WithCompactKeyDir(), WithMaxFileSize(1000))
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	for i := 0; i < 100; i++ {
+		store.Set(fmt.Sprintf("key-%d", i), fmt.Sprintf("value-%d", i))
+	}
+	for i := 0; i < 100; i += 2 {
+		store.Delete(fmt.Sprintf("key-%d", i))
+	}
+	check := func(when string) {
+		t.Helper()
+		if n := store.Len(); n != 50 {
+			t.Errorf("%s: Len() = %v, want %v", when, n, 50)
+		}
+		for i := 0; i < 100; i++ {
+			val, err := store.Get(fmt.Sprintf("key-%d", i))
+			if i%2 == 0 && err != ErrKeyNotFound {
+				t.Errorf("%s: Get() of a deleted key error = %v, want %v", when, err, ErrKeyNotFound)
+			}
+			if want := fmt.Sprintf("value-%d", i); i%2 == 1 && val != want {
+				t.Errorf("%s: Get() = %v, want %v", when, val, want)
+			}
+		}
+	}
+	check("after the writes")
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	check("after Merge()")
+	store.Close()
+
+	// with the hint file, and without it
+	for i := 0; i < 2; i++ {
+		store, err = Open("test.db", WithCompactKeyDir())
+		if err != nil {
+			t.Fatalf("failed to open the store: %v", err)
+		}
+		if _, ok := store.keyDir.shards[0].table.(*compactTable); !ok {
+			t.Errorf("the keyDir has %T tables, want compact ones", store.keyDir.shards[0].table)
+		}
+		check("after reopening")
+		store.Close()
+		os.Remove(hintFileName("test.db"))
+	}
+}
@@ -0,0 +1,252 @@
+package caskdb
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// composite index file provides indexes over several JSON fields together. Unlike
+// the single field indexes, the entries are kept sorted, which lets us answer
+// queries like `status = active AND created_at > 1700000000` from the index alone:
+//
+//	CreateCompositeIndex("status_created", "$.status", "$.created_at")
+//
+//	┌──────────┬────────────┬────────┐
+//	│ status   │ created_at │ key    │
+//	├──────────┼────────────┼────────┤
+//	│ active   │ 1690000000 │ user:7 │
+//	│ active   │ 1710000000 │ user:2 │ <- status = active AND created_at > 1700000000
+//	│ active   │ 1720000000 │ user:9 │ <-
+//	│ disabled │ 1680000000 │ user:1 │
+//	└──────────┴────────────┴────────┘
+//
+// A query fixes the values of the leading fields, and can bound the field right
+// after them with a range. Since the entries are sorted by the fields in their
+// declared order, the matching entries are next to each other, and we find the
+// first one with a binary search.
+//
+// Field values which look like numbers are ordered numerically and before all the
+// other values, the rest are ordered byte-wise. Values missing any of the fields are
+// not indexed.
+
+var ErrInvalidQuery = errors.New("invalid composite index query")
+
+// Bound is one end of the range over a field of a composite index
+type Bound struct {
+	Value     string
+	Exclusive bool
+}
+
+// CompositeQuery selects the entries of a composite index. Equal has the values for
+// the leading fields of the index, in order. Lower and Upper optionally bound the
+// next field, nil means unbounded
+type CompositeQuery struct {
+	Equal []string
+	Lower *Bound
+	Upper *Bound
+}
+
+type compositeEntry struct {
+	fields []string
+	key    string
+}
+
+// compositeIndex keeps the entries of an index over several JSON fields, sorted by
+// the field values and then the key
+type compositeIndex struct {
+	paths   [][]string
+	entries []compositeEntry
+	// values maps the key to its indexed field values. On Set, we use it to find
+	// and remove the entry of the previous value
+	values map[string][]string
+}
+
+// compareIndexValues orders the numbers numerically before the other values, which
+// are ordered byte-wise
+func compareIndexValues(a string, b string) int {
+	fa, errA := strconv.ParseFloat(a, 64)
+	fb, errB := strconv.ParseFloat(b, 64)
+	switch {
+	case errA == nil && errB == nil && fa < fb:
+		return -1
+	case errA == nil && errB == nil && fa > fb:
+		return 1
+	case errA == nil && errB != nil:
+		return -1
+	case errA != nil && errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareEntries(a compositeEntry, b compositeEntry) int {
+	for i := range a.fields {
+		if c := compareIndexValues(a.fields[i], b.fields[i]); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.key, b.key)
+}
+
+func newCompositeIndex(paths [][]string) *compositeIndex {
+	return &compositeIndex{paths: paths, values: make(map[string][]string)}
+}
+
+func (idx *compositeIndex) equal(other *compositeIndex) bool {
+	if len(idx.entries) != len(other.entries) || !reflect.DeepEqual(idx.values, other.values) {
+		return false
+	}
+	for i := range idx.entries {
+		if !reflect.DeepEqual(idx.entries[i], other.entries[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *compositeIndex) search(entry compositeEntry) int {
+	return sort.Search(len(idx.entries), func(i int) bool {
+		return compareEntries(idx.entries[i], entry) >= 0
+	})
+}
+
+func (idx *compositeIndex) update(key string, value string) {
+	if old, ok := idx.values[key]; ok {
+		i := idx.search(compositeEntry{old, key})
+		idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+		delete(idx.values, key)
+	}
+	fields := make([]string, len(idx.paths))
+	for i, path := range idx.paths {
+		field, ok := extractJSONField(value, path)
+		if !ok {
+			return
+		}
+		fields[i] = field
+	}
+	entry := compositeEntry{fields, key}
+	i := idx.search(entry)
+	idx.entries = append(idx.entries, compositeEntry{})
+	copy(idx.entries[i+1:], idx.entries[i:])
+	idx.entries[i] = entry
+	idx.values[key] = fields
+}
+
+// matches tells whether the entry's leading fields are equal to the query, and the
+// field after them is within the bounds. It also returns whether any of the
+// following entries can match, which is not the case once we are past the equal
+// values or the upper bound
+func (q CompositeQuery) matches(entry compositeEntry) (bool, bool) {
+	for i, value := range q.Equal {
+		if compareIndexValues(entry.fields[i], value) != 0 {
+			return false, false
+		}
+	}
+	if len(q.Equal) == len(entry.fields) {
+		return true, true
+	}
+	field := entry.fields[len(q.Equal)]
+	if q.Upper != nil {
+		c := compareIndexValues(field, q.Upper.Value)
+		if c > 0 || (c == 0 && q.Upper.Exclusive) {
+			return false, false
+		}
+	}
+	if q.Lower != nil {
+		c := compareIndexValues(field, q.Lower.Value)
+		if c < 0 || (c == 0 && q.Lower.Exclusive) {
+			return false, true
+		}
+	}
+	return true, true
+}
+
+// CreateCompositeIndex declares an index named name over the JSON fields at paths,
+// and builds it from the existing data. It reads every value from the disk, so it
+// takes time accordingly
+func (d *DiskStore) CreateCompositeIndex(name string, paths ...string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.compositeIndexes[name]; ok {
+		return ErrIndexExists
+	}
+	if len(paths) == 0 {
+		return ErrInvalidIndexPath
+	}
+	parsed := make([][]string, 0, len(paths))
+	for _, path := range paths {
+		fields, err := parseIndexPath(path)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, fields)
+	}
+	idx := newCompositeIndex(parsed)
+	err := d.keyDir.each(func(key string, kEntry KeyEntry) error {
+		value, err := d.read(kEntry)
+		if err != nil {
+			return err
+		}
+		idx.update(key, value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.compositeIndexes[name] = idx
+	return nil
+}
+
+// DropCompositeIndex removes the composite index named name
+func (d *DiskStore) DropCompositeIndex(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.compositeIndexes[name]; !ok {
+		return ErrIndexNotFound
+	}
+	delete(d.compositeIndexes, name)
+	return nil
+}
+
+// QueryCompositeIndex returns the keys of the entries matching the query, ordered
+// by the field values of the index
+func (d *DiskStore) QueryCompositeIndex(name string, query CompositeQuery) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	idx, ok := d.compositeIndexes[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	if len(query.Equal) > len(idx.paths) ||
+		(len(query.Equal) == len(idx.paths) && (query.Lower != nil || query.Upper != nil)) {
+		return nil, ErrInvalidQuery
+	}
+	// find the first entry which can match: the one with the leading fields equal
+	// to the query, and the next field not below the lower bound
+	start := sort.Search(len(idx.entries), func(i int) bool {
+		fields := idx.entries[i].fields
+		for j, value := range query.Equal {
+			if c := compareIndexValues(fields[j], value); c != 0 {
+				return c > 0
+			}
+		}
+		if query.Lower == nil || len(query.Equal) == len(fields) {
+			return true
+		}
+		return compareIndexValues(fields[len(query.Equal)], query.Lower.Value) >= 0
+	})
+	keys := []string{}
+	for _, entry := range idx.entries[start:] {
+		match, more := query.matches(entry)
+		if !more {
+			break
+		}
+		if match {
+			keys = append(keys, entry.key)
+		}
+	}
+	return d.liveKeys(keys), nil
+}
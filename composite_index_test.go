@@ -0,0 +1,104 @@
+package caskdb
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func Test_compareIndexValues(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"9", "10", -1},
+		{"1.5", "1.25", 1},
+		{"100", "abc", -1},
+		{"abc", "-5", 1},
+		{"active", "disabled", -1},
+		{"jojo", "jojo", 0},
+	}
+	for _, tt := range tests {
+		if got := compareIndexValues(tt.a, tt.b); got != tt.want {
+			t.Errorf("compareIndexValues(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestDiskStore_QueryCompositeIndex(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	users := map[string]string{
+		"user:1": `{"status": "disabled", "created_at": 80}`,
+		"user:2": `{"status": "active", "created_at": 110}`,
+		"user:7": `{"status": "active", "created_at": 90}`,
+		"user:9": `{"status": "active", "created_at": 120}`,
+		"user:5": `{"status": "active"}`,
+	}
+	for key, value := range users {
+		store.Set(key, value)
+	}
+	if err := store.CreateCompositeIndex("status_created", "$.status", "$.created_at"); err != nil {
+		t.Fatalf("CreateCompositeIndex() error = %v", err)
+	}
+	store.Set("user:3", `{"status": "active", "created_at": 100}`)
+	store.Set("user:9", `{"status": "disabled", "created_at": 120}`)
+
+	tests := []struct {
+		query CompositeQuery
+		keys  []string
+	}{
+		{CompositeQuery{Equal: []string{"active"}}, []string{"user:7", "user:3", "user:2"}},
+		{CompositeQuery{Equal: []string{"active"}, Lower: &Bound{"100", true}}, []string{"user:2"}},
+		{CompositeQuery{Equal: []string{"active"}, Lower: &Bound{"100", false}}, []string{"user:3", "user:2"}},
+		{CompositeQuery{Equal: []string{"active"}, Upper: &Bound{"100", true}}, []string{"user:7"}},
+		{CompositeQuery{Equal: []string{"disabled", "120"}}, []string{"user:9"}},
+		{CompositeQuery{Lower: &Bound{"b", false}}, []string{"user:1", "user:9"}},
+		{CompositeQuery{Equal: []string{"pending"}}, []string{}},
+	}
+	for _, tt := range tests {
+		keys, err := store.QueryCompositeIndex("status_created", tt.query)
+		if err != nil {
+			t.Fatalf("QueryCompositeIndex() error = %v", err)
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("QueryCompositeIndex(%v) = %v, want %v", tt.query, keys, tt.keys)
+		}
+	}
+	invalid := CompositeQuery{Equal: []string{"active", "100"}, Lower: &Bound{"1", false}}
+	if _, err := store.QueryCompositeIndex("status_created", invalid); err != ErrInvalidQuery {
+		t.Errorf("QueryCompositeIndex() error = %v, want %v", err, ErrInvalidQuery)
+	}
+	if err := store.DropCompositeIndex("status_created"); err != nil {
+		t.Errorf("DropCompositeIndex() error = %v", err)
+	}
+	if _, err := store.QueryCompositeIndex("status_created", CompositeQuery{}); err != ErrIndexNotFound {
+		t.Errorf("QueryCompositeIndex() error = %v, want %v", err, ErrIndexNotFound)
+	}
+	store.Close()
+}
+
+func TestDiskStore_CompositeIndexOrder(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	if err := store.CreateCompositeIndex("score", "$.score"); err != nil {
+		t.Fatalf("CreateCompositeIndex() error = %v", err)
+	}
+	for i := 0; i < 50; i++ {
+		store.Set(fmt.Sprintf("player:%d", i), fmt.Sprintf(`{"score": %d}`, (i*37)%50))
+	}
+	keys, _ := store.QueryCompositeIndex("score", CompositeQuery{Lower: &Bound{"45", false}})
+	want := []string{"player:35", "player:8", "player:31", "player:4", "player:27"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("QueryCompositeIndex() = %v, want %v", keys, want)
+	}
+	store.Close()
+}
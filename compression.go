@@ -0,0 +1,28 @@
+package caskdb
+
+import "github.com/avinassh/go-caskdb/format"
+
+// compression file has the compression of the values. With a compression threshold
+// set, the values larger than it are compressed with DEFLATE before they are written,
+// and decompressed when they are read, so the callers never see the compressed bytes.
+// The record header has a flag telling whether its value is compressed, check
+// format.CompressedFlag, so a store can have both kinds of records. Changing the
+// threshold of a store, or turning it off, does not touch the records written already.
+//
+// The compression happens before the value threshold is checked, so a value which
+// compresses well may stay in the data file instead of moving out to a value log.
+// Some values, like images or values compressed already, do not get smaller, and are
+// written as they are.
+
+// compressValue compresses the value if it is larger than the compression threshold,
+// and tells whether it did
+func (d *DiskStore) compressValue(value string) (string, bool) {
+	if d.options.CompressionThreshold <= 0 || len(value) <= d.options.CompressionThreshold {
+		return value, false
+	}
+	compressed := format.Compress([]byte(value))
+	if len(compressed) >= len(value) {
+		return value, false
+	}
+	return string(compressed), true
+}
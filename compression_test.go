@@ -0,0 +1,63 @@
+package caskdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiskStore_Compression(t *testing.T) {
+	store, err := Open("test.db", WithCompression(32), WithValueThreshold(52))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer removeStore("test.db")
+
+	tests := map[string]string{
+		// below the threshold, stored as it is
+		"short": "frank herbert",
+		// compressed, and small enough to stay in the data file
+		"json": strings.Repeat(`{"title": "dune", "author": "frank herbert"}`, 4),
+		// compressed, and still large enough to move out to the value log
+		"text": strings.Repeat("the spice must flow, ", 200) + strings.Repeat("x", 100),
+		// does not get any smaller, stored as it is
+		"random": "q8Zr1LmX0vB7nYk2PwT5sJd9HcA4gEuF6oRiNtV3yWbMlKxCzQe",
+	}
+	for key, value := range tests {
+		if err := store.Set(key, value); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if !isFileExists(valueLogFileName("test.db", 1)) {
+		t.Errorf("the compressed value above the value threshold is not in the value log")
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	var raw int
+	for _, value := range tests {
+		raw += len(value)
+	}
+	if stats.DataSize >= int64(raw) {
+		t.Errorf("DataSize = %d, want less than the %d bytes of the values", stats.DataSize, raw)
+	}
+	store.Close()
+
+	// the values are read back the same without the option, after a restart
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	for key, value := range tests {
+		if got, err := store.Get(key); err != nil || got != value {
+			t.Errorf("Get(%q) = %q, %v, want %q", key, got, err, value)
+		}
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if got, _ := store.Get("json"); got != tests["json"] {
+		t.Errorf("Get() after Merge() = %q, want %q", got, tests["json"])
+	}
+	store.Close()
+}
@@ -0,0 +1,184 @@
+package caskdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"time"
+)
+
+// counter file provides a PN-Counter, a counter CRDT (Conflict-free Replicated Data
+// Type). When two stores are written independently and then synced, plain values
+// follow last-writer-wins: if both stores incremented a counter, one of the
+// increments is lost. A PN-Counter does not have this problem.
+//
+// Every store (a node) keeps its own pair of totals inside the counter: P, the sum of
+// the increments and N, the sum of the decrements done by that node. A node only
+// ever updates its own pair, and the totals only ever grow. The value of the counter
+// is the sum of all P minus the sum of all N. To merge two copies of a counter, we
+// take the larger of the totals for every node. Since the totals never shrink, the
+// larger one has seen all the updates of the smaller one, and the merge gives the
+// same result irrespective of the order or the number of times it is done.
+//
+// Read more about it here: https://en.wikipedia.org/wiki/Conflict-free_replicated_data_type
+//
+// A counter is stored as a plain value:
+//
+//	┌───────────┬───────────┬───────────────┬──────┬───────┬───────┬─────┐
+//	│ magic(4B) │ count(4B) │ node_size(4B) │ node │ P(8B) │ N(8B) │ ... │
+//	└───────────┴───────────┴───────────────┴──────┴───────┴───────┴─────┘
+//
+// The last four fields repeat for every node. The nodes are sorted by their ID, so
+// that equal counters encode to the same bytes.
+
+// counterMagic is stored at the start of every counter, so that we don't mistake
+// some other value for a counter and corrupt it
+const counterMagic = "PNCT"
+
+var ErrInvalidCounter = errors.New("value is not a valid counter")
+
+// counterTotals keeps the increments (P) and decrements (N) done by a node
+type counterTotals struct {
+	p uint64
+	n uint64
+}
+
+func encodeCounter(nodes map[string]counterTotals) string {
+	ids := make([]string, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	data := make([]byte, 0, len(counterMagic)+4+len(ids)*24)
+	data = append(data, counterMagic...)
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(ids)))
+	for _, id := range ids {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(id)))
+		data = append(data, id...)
+		data = binary.LittleEndian.AppendUint64(data, nodes[id].p)
+		data = binary.LittleEndian.AppendUint64(data, nodes[id].n)
+	}
+	return string(data)
+}
+
+func decodeCounter(value string) (map[string]counterTotals, error) {
+	nodes := make(map[string]counterTotals)
+	if value == "" {
+		return nodes, nil
+	}
+	data := []byte(value)
+	if len(data) < len(counterMagic)+4 || string(data[:len(counterMagic)]) != counterMagic {
+		return nil, ErrInvalidCounter
+	}
+	data = data[len(counterMagic):]
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	for i := uint32(0); i < count; i++ {
+		if len(data) < 4 {
+			return nil, ErrInvalidCounter
+		}
+		size := int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		if len(data) < size+16 {
+			return nil, ErrInvalidCounter
+		}
+		id := string(data[:size])
+		nodes[id] = counterTotals{
+			p: binary.LittleEndian.Uint64(data[size:]),
+			n: binary.LittleEndian.Uint64(data[size+8:]),
+		}
+		data = data[size+16:]
+	}
+	if len(data) != 0 {
+		return nil, ErrInvalidCounter
+	}
+	return nodes, nil
+}
+
+func counterValue(nodes map[string]counterTotals) int64 {
+	var value int64
+	for _, totals := range nodes {
+		value += int64(totals.p) - int64(totals.n)
+	}
+	return value
+}
+
+// loadCounter returns the per node totals of the counter stored at key. A missing
+// key is a counter with no nodes
+func (d *DiskStore) loadCounter(key string) (map[string]counterTotals, error) {
+	value, err := d.getOrEmpty(key)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCounter(value)
+}
+
+// IncrCounter adds delta to the counter stored at key on behalf of the node, and
+// returns the new value of the counter. A negative delta decrements the counter.
+// Every store writing to the counter must use its own, stable node ID
+func (d *DiskStore) IncrCounter(key string, node string, delta int64) (int64, error) {
+	if err := checkReserved(key); err != nil {
+		return 0, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	nodes, err := d.loadCounter(key)
+	if err != nil {
+		return 0, err
+	}
+	totals := nodes[node]
+	if delta >= 0 {
+		totals.p += uint64(delta)
+	} else {
+		totals.n += uint64(-delta)
+	}
+	nodes[node] = totals
+	if err := d.set(key, encodeCounter(nodes), uint32(time.Now().Unix()), d.liveExpiry(key)); err != nil {
+		return 0, err
+	}
+	return counterValue(nodes), nil
+}
+
+// Counter returns the value of the counter stored at key. A missing key is a
+// counter with value zero
+func (d *DiskStore) Counter(key string) (int64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	nodes, err := d.loadCounter(key)
+	if err != nil {
+		return 0, err
+	}
+	return counterValue(nodes), nil
+}
+
+// MergeCounter merges a copy of the counter, as stored by another store (the raw
+// value from its Get), into the counter stored at key, and returns the merged value
+func (d *DiskStore) MergeCounter(key string, remote string) (int64, error) {
+	if err := checkReserved(key); err != nil {
+		return 0, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	nodes, err := d.loadCounter(key)
+	if err != nil {
+		return 0, err
+	}
+	remoteNodes, err := decodeCounter(remote)
+	if err != nil {
+		return 0, err
+	}
+	for node, remoteTotals := range remoteNodes {
+		totals := nodes[node]
+		if remoteTotals.p > totals.p {
+			totals.p = remoteTotals.p
+		}
+		if remoteTotals.n > totals.n {
+			totals.n = remoteTotals.n
+		}
+		nodes[node] = totals
+	}
+	if err := d.set(key, encodeCounter(nodes), uint32(time.Now().Unix()), d.liveExpiry(key)); err != nil {
+		return 0, err
+	}
+	return counterValue(nodes), nil
+}
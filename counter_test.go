@@ -0,0 +1,89 @@
+package caskdb
+
+import "testing"
+
+func TestDiskStore_IncrCounter(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	tests := []struct {
+		node  string
+		delta int64
+		want  int64
+	}{
+		{"a", 5, 5},
+		{"b", 3, 8},
+		{"a", -2, 6},
+		{"b", -10, -4},
+	}
+	for _, tt := range tests {
+		got, err := store.IncrCounter("visits", tt.node, tt.delta)
+		if err != nil {
+			t.Fatalf("IncrCounter() error = %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("IncrCounter() = %v, want %v", got, tt.want)
+		}
+	}
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if got, _ := store.Counter("visits"); got != -4 {
+		t.Errorf("Counter() = %v, want %v", got, -4)
+	}
+	if got, _ := store.Counter("missing"); got != 0 {
+		t.Errorf("Counter() = %v, want %v", got, 0)
+	}
+	store.Set("name", "jojo")
+	if _, err := store.IncrCounter("name", "a", 1); err != ErrInvalidCounter {
+		t.Errorf("IncrCounter() error = %v, want %v", err, ErrInvalidCounter)
+	}
+	store.Close()
+}
+
+func TestDiskStore_MergeCounter(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	other, err := NewDiskStore("other.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("other.db")
+
+	// both stores start from the same counter and increment it concurrently
+	store.IncrCounter("visits", "a", 10)
+	initial, _ := store.Get("visits")
+	other.MergeCounter("visits", initial)
+	store.IncrCounter("visits", "a", 5)
+	other.IncrCounter("visits", "b", 7)
+	other.IncrCounter("visits", "b", -2)
+
+	local, _ := store.Get("visits")
+	remote, _ := other.Get("visits")
+	if got, _ := store.MergeCounter("visits", remote); got != 20 {
+		t.Errorf("MergeCounter() = %v, want %v", got, 20)
+	}
+	if got, _ := other.MergeCounter("visits", local); got != 20 {
+		t.Errorf("MergeCounter() = %v, want %v", got, 20)
+	}
+	// merging is idempotent
+	remote, _ = other.Get("visits")
+	if got, _ := store.MergeCounter("visits", remote); got != 20 {
+		t.Errorf("MergeCounter() = %v, want %v", got, 20)
+	}
+	local, _ = store.Get("visits")
+	if remote, _ = other.Get("visits"); local != remote {
+		t.Errorf("merged counters encode differently")
+	}
+	store.Close()
+	other.Close()
+}
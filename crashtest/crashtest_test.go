@@ -0,0 +1,91 @@
+package crashtest
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/avinassh/go-caskdb"
+)
+
+// open opens the store in the storage, with small data files so that the writes
+// rotate them
+func open(t *testing.T, storage *Storage, policy caskdb.SyncPolicy) *caskdb.DiskStore {
+	t.Helper()
+	store, err := caskdb.Open("test.db", caskdb.WithStorage(storage), caskdb.WithSyncPolicy(policy, 0),
+		caskdb.WithMaxFileSize(400), caskdb.WithLogger(caskdb.NewLogger(io.Discard, caskdb.LevelError)))
+	if err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	return store
+}
+
+// writeRandom makes n random writes with the recorder, and syncs or merges the store
+// now and then. The errors are recorded, and are expected with the faults
+func writeRandom(recorder *Recorder, r *rand.Rand, n int) {
+	key := func() string { return fmt.Sprintf("key-%d", r.Intn(8)) }
+	for i := 0; i < n; i++ {
+		switch r.Intn(10) {
+		case 0, 1:
+			recorder.Delete(key())
+		case 2, 3:
+			recorder.Commit(Write{Key: key(), Value: fmt.Sprint(i)}, Write{Key: key(), Delete: true}, Write{Key: key(), Value: fmt.Sprint(i)})
+		case 4:
+			recorder.Sync()
+		case 5:
+			if r.Intn(4) == 0 {
+				recorder.Merge()
+			}
+		default:
+			recorder.Set(key(), fmt.Sprint(i))
+		}
+	}
+}
+
+func TestDiskStore_crash(t *testing.T) {
+	policies := map[string]caskdb.SyncPolicy{"always": caskdb.SyncAlways, "never": caskdb.SyncNever}
+	for name, policy := range policies {
+		t.Run(name, func(t *testing.T) {
+			storage := New(caskdb.NewMemoryStorage())
+			store := open(t, storage, policy)
+			recorder := NewRecorder(store, policy)
+			r := rand.New(rand.NewSource(1))
+			for i := 0; i < 50; i++ {
+				writeRandom(recorder, r, 20)
+				if err := storage.Crash(r); err != nil {
+					t.Fatalf("Crash() error = %v", err)
+				}
+				store = open(t, storage, policy)
+				if err := recorder.Check(store); err != nil {
+					t.Fatalf("after crash %d: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestDiskStore_faults(t *testing.T) {
+	// every write and every fsync of a run fails in turn, a partial write or a failed
+	// fsync is followed by more writes, and a crash
+	for _, op := range []Op{OpWrite, OpSync} {
+		for after := 0; after < 200; after++ {
+			t.Run(fmt.Sprintf("op=%d/after=%d", op, after), func(t *testing.T) {
+				storage := New(caskdb.NewMemoryStorage())
+				store := open(t, storage, caskdb.SyncAlways)
+				recorder := NewRecorder(store, caskdb.SyncAlways)
+				r := rand.New(rand.NewSource(int64(after)))
+				storage.Inject(Fault{Op: op, After: after, Written: r.Intn(40)})
+				writeRandom(recorder, r, 150)
+				if err := storage.Crash(r); err != nil {
+					t.Fatalf("Crash() error = %v", err)
+				}
+				store = open(t, storage, caskdb.SyncAlways)
+				if err := recorder.Check(store); err != nil {
+					t.Fatal(err)
+				}
+				store.Close()
+			})
+		}
+	}
+}
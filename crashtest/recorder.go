@@ -0,0 +1,167 @@
+package crashtest
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/avinassh/go-caskdb"
+)
+
+// Write is a write of a key in a batch of Recorder.Commit, a set of its value, or a
+// delete if Delete is set
+type Write struct {
+	Key    string
+	Value  string
+	Delete bool
+}
+
+// Recorder makes the writes to a store, and keeps their history, so that it can check
+// what the store has after a crash.
+//
+// The writes which failed must never show up. The writes which succeeded must all show
+// up if they were synced, by the sync policy or by Sync or Merge, while the ones not
+// synced yet may be lost, but only from the last one back, since the data files are
+// append only: the store must have a prefix of the successful writes
+type Recorder struct {
+	store  *caskdb.DiskStore
+	policy caskdb.SyncPolicy
+	// base is what the store had when the history started
+	base map[string]string
+	// history has the writes made since, and durable is the number of the writes at
+	// the start of it which are synced
+	history []recordedWrite
+	durable int
+}
+
+// recordedWrite is a write, or a batch of them, made by the Recorder
+type recordedWrite struct {
+	writes []Write
+	failed bool
+}
+
+// NewRecorder returns a Recorder of the writes to the store, which syncs them with the
+// policy. The store must be empty
+func NewRecorder(store *caskdb.DiskStore, policy caskdb.SyncPolicy) *Recorder {
+	return &Recorder{store: store, policy: policy, base: make(map[string]string)}
+}
+
+// record adds the writes to the history, with the error they failed with
+func (r *Recorder) record(err error, writes ...Write) error {
+	r.history = append(r.history, recordedWrite{writes: writes, failed: err != nil})
+	if err == nil && r.policy == caskdb.SyncAlways {
+		r.durable = len(r.history)
+	}
+	return err
+}
+
+// Set sets the key to the value in the store
+func (r *Recorder) Set(key string, value string) error {
+	return r.record(r.store.Set(key, value), Write{Key: key, Value: value})
+}
+
+// Delete deletes the key from the store
+func (r *Recorder) Delete(key string) error {
+	return r.record(r.store.Delete(key), Write{Key: key, Delete: true})
+}
+
+// Commit makes the writes in a single batch, which must be all or nothing
+func (r *Recorder) Commit(writes ...Write) error {
+	batch := caskdb.NewBatch()
+	for _, w := range writes {
+		if w.Delete {
+			batch.Delete(w.Key)
+		} else {
+			batch.Set(w.Key, w.Value)
+		}
+	}
+	return r.record(r.store.Commit(batch), writes...)
+}
+
+// Sync syncs the store, after which all the successful writes must survive a crash
+func (r *Recorder) Sync() error {
+	if err := r.store.Sync(); err != nil {
+		return err
+	}
+	r.durable = len(r.history)
+	return nil
+}
+
+// Merge merges the store, which syncs it too
+func (r *Recorder) Merge() error {
+	if err := r.store.Merge(); err != nil {
+		return err
+	}
+	r.durable = len(r.history)
+	return nil
+}
+
+// Check checks that the store, reopened after a crash, has a prefix of the successful
+// writes, at least as long as the synced ones, and none of the failed writes. If it
+// does, the Recorder carries on with the store, from what it has
+func (r *Recorder) Check(store *caskdb.DiskStore) error {
+	got := make(map[string]string)
+	if err := store.Fold(func(key string, value string) error {
+		got[key] = value
+		return nil
+	}); err != nil {
+		return fmt.Errorf("crashtest: reading the store: %w", err)
+	}
+	want := make(map[string]string, len(r.base))
+	for key, value := range r.base {
+		want[key] = value
+	}
+	for i := 0; ; i++ {
+		if i >= r.durable && equal(got, want) {
+			r.store, r.base, r.history, r.durable = store, got, nil, 0
+			return nil
+		}
+		if i == len(r.history) {
+			break
+		}
+		if !r.history[i].failed {
+			apply(want, r.history[i].writes)
+		}
+	}
+	return fmt.Errorf("crashtest: the store has %s, which is no prefix of the %d writes from %d synced on, want %s",
+		describe(got), len(r.history), r.durable, describe(want))
+}
+
+// apply applies the writes to the keys
+func apply(keys map[string]string, writes []Write) {
+	for _, w := range writes {
+		if w.Delete {
+			delete(keys, w.Key)
+		} else {
+			keys[w.Key] = w.Value
+		}
+	}
+}
+
+func equal(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, value := range a {
+		if other, ok := b[key]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+// describe returns the keys and the values, sorted by key
+func describe(keys map[string]string) string {
+	names := make([]string, 0, len(keys))
+	for key := range keys {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	s := "{"
+	for i, key := range names {
+		if i > 0 {
+			s += " "
+		}
+		s += key + "=" + keys[key]
+	}
+	return s + "}"
+}
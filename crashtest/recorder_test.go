@@ -0,0 +1,36 @@
+package crashtest
+
+import (
+	"testing"
+
+	"github.com/avinassh/go-caskdb"
+)
+
+func TestRecorder_Check(t *testing.T) {
+	storage := New(caskdb.NewMemoryStorage())
+	store, err := caskdb.Open("test.db", caskdb.WithStorage(storage), caskdb.WithSyncPolicy(caskdb.SyncNever, 0))
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	recorder := NewRecorder(store, caskdb.SyncNever)
+	recorder.Set("othello", "shakespeare")
+	recorder.Sync()
+	recorder.Commit(Write{Key: "dune", Value: "frank herbert"}, Write{Key: "othello", Delete: true})
+	recorder.Set("hamlet", "shakespeare")
+
+	// any prefix from the synced write on is consistent
+	if err := recorder.Check(store); err != nil {
+		t.Errorf("Check() error = %v", err)
+	}
+	// the store carries on from there, with all of it synced
+	store.Delete("dune")
+	if err := recorder.Check(store); err == nil {
+		t.Errorf("Check() of a store without a synced write error = %v, want an error", err)
+	}
+	store.Set("dune", "frank herbert")
+	store.Set("hamlet", "dostoevsky")
+	if err := recorder.Check(store); err == nil {
+		t.Errorf("Check() of a store with a write not made error = %v, want an error", err)
+	}
+	store.Close()
+}
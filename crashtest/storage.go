@@ -0,0 +1,334 @@
+// Package crashtest is a harness for testing the crash consistency of a
+// caskdb.DiskStore, for caskdb itself and for the applications built on it.
+//
+// Storage wraps the caskdb.Storage of a store, and fails its writes and fsyncs on
+// demand, or cuts the power: every file loses an arbitrary part of what was written
+// to it since its last fsync, and the store which had them open can do nothing more.
+// Recorder keeps the history of the writes made to the store, with their results, and
+// checks that the store reopened after a crash has a consistent prefix of them: all the
+// writes which were synced, and none of the failed ones.
+//
+// Typical usage example:
+//
+//	storage := crashtest.New(caskdb.NewMemoryStorage())
+//	store, _ := caskdb.Open("books.db", caskdb.WithStorage(storage))
+//	recorder := crashtest.NewRecorder(store, caskdb.SyncAlways)
+//	storage.Inject(crashtest.Fault{Op: crashtest.OpSync, After: 3})
+//	for i := 0; i < 10; i++ {
+//		recorder.Set(fmt.Sprint(i), "value")
+//	}
+//	storage.Crash(rand.New(rand.NewSource(1)))
+//	store, _ = caskdb.Open("books.db", caskdb.WithStorage(storage))
+//	err := recorder.Check(store)
+package crashtest
+
+import (
+	"errors"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/avinassh/go-caskdb"
+)
+
+var (
+	// ErrInjected is the error of the faults injected without one
+	ErrInjected = errors.New("crashtest: injected fault")
+	// ErrCrashed is returned by the files opened before a crash
+	ErrCrashed = errors.New("crashtest: storage crashed")
+)
+
+// Op is an operation of the files which a Fault fails
+type Op int
+
+const (
+	// OpWrite is a write to a file
+	OpWrite Op = iota
+	// OpSync is an fsync of a file
+	OpSync
+)
+
+// Fault is a failure injected into an operation of the Storage
+type Fault struct {
+	// Op is the operation which fails
+	Op Op
+	// After is the number of the operations which succeed before the one which fails
+	After int
+	// Err is the error the operation fails with, ErrInjected if it is nil
+	Err error
+	// Written is the number of bytes of a failed write which reach the file anyway,
+	// at most all of them. A partial write leaves a torn record behind
+	Written int
+}
+
+// Storage is a caskdb.Storage which fails the operations of its files on demand, and
+// simulates power cuts. The files are kept in another caskdb.Storage.
+//
+// A power cut only loses the data written to the files which was not synced. The
+// creation, the removal and the renaming of the files are taken to be on the disk
+// right away, as if the store synced its directory after each of them
+type Storage struct {
+	base caskdb.Storage
+
+	mu sync.Mutex
+	// files has the state of every file written through the Storage, by its name
+	files map[string]*fileState
+	// open are the files opened since the last crash
+	open map[*file]bool
+	// counts has the number of operations of each kind since the Storage was made,
+	// and faults are the faults yet to be injected
+	counts map[Op]int
+	faults []Fault
+}
+
+// fileState is what the Storage knows about a file: its size, and how much of it is
+// synced
+type fileState struct {
+	size   int64
+	synced int64
+}
+
+// New returns a Storage over the files of base
+func New(base caskdb.Storage) *Storage {
+	return &Storage{
+		base:   base,
+		files:  make(map[string]*fileState),
+		open:   make(map[*file]bool),
+		counts: make(map[Op]int),
+	}
+}
+
+// Inject makes an operation fail. The operation is counted from now on, and the
+// fault is injected only once
+func (s *Storage) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f.After += s.counts[f.Op]
+	if f.Err == nil {
+		f.Err = ErrInjected
+	}
+	s.faults = append(s.faults, f)
+}
+
+// Count returns the number of the operations of the kind made since the Storage was
+// made, so that a test can inject a fault at each of them in turn
+func (s *Storage) Count(op Op) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[op]
+}
+
+// fault counts an operation of the kind, and returns the fault to inject into it, if
+// there is one. The Storage must be locked
+func (s *Storage) fault(op Op) (Fault, bool) {
+	n := s.counts[op]
+	s.counts[op]++
+	for i, f := range s.faults {
+		if f.Op == op && f.After == n {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			return f, true
+		}
+	}
+	return Fault{}, false
+}
+
+// Crash cuts the power. Every file is truncated to a random size between the size
+// synced and its current size, picked with r, and the files opened so far fail all
+// their operations with ErrCrashed, so that the store which had them open cannot
+// change anything anymore. The faults not injected yet are dropped
+func (s *Storage) Crash(r *rand.Rand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for f := range s.open {
+		f.crashed = true
+		f.base.Close()
+	}
+	s.open = make(map[*file]bool)
+	s.faults = nil
+	for name, state := range s.files {
+		size := state.synced
+		if state.size > state.synced {
+			size += r.Int63n(state.size - state.synced + 1)
+		}
+		if size < state.size {
+			if err := s.base.Truncate(name, size); err != nil {
+				return err
+			}
+		}
+		state.size, state.synced = size, size
+	}
+	return nil
+}
+
+// state returns the state of the file, which is made from its size if the Storage did
+// not know the file yet. The Storage must be locked
+func (s *Storage) state(name string, base caskdb.File) (*fileState, error) {
+	if state, ok := s.files[name]; ok {
+		return state, nil
+	}
+	size, err := base.Size()
+	if err != nil {
+		return nil, err
+	}
+	state := &fileState{size: size, synced: size}
+	s.files[name] = state
+	return state, nil
+}
+
+func (s *Storage) OpenFile(name string, flag int, perm fs.FileMode) (caskdb.File, error) {
+	base, err := s.base.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = filepath.Clean(name)
+	state, err := s.state(name, base)
+	if err != nil {
+		base.Close()
+		return nil, err
+	}
+	if flag&os.O_TRUNC != 0 {
+		state.size, state.synced = 0, 0
+	}
+	f := &file{storage: s, base: base, state: state}
+	s.open[f] = true
+	return f, nil
+}
+
+func (s *Storage) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.base.Remove(name); err != nil {
+		return err
+	}
+	delete(s.files, filepath.Clean(name))
+	return nil
+}
+
+func (s *Storage) Rename(oldName string, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.base.Rename(oldName, newName); err != nil {
+		return err
+	}
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	if state, ok := s.files[oldName]; ok {
+		delete(s.files, oldName)
+		s.files[newName] = state
+	} else {
+		delete(s.files, newName)
+	}
+	return nil
+}
+
+func (s *Storage) Truncate(name string, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.base.Truncate(name, size); err != nil {
+		return err
+	}
+	if state, ok := s.files[filepath.Clean(name)]; ok && size < state.size {
+		state.size = size
+		if size < state.synced {
+			state.synced = size
+		}
+	}
+	return nil
+}
+
+func (s *Storage) ReadDir(name string) ([]string, error) {
+	return s.base.ReadDir(name)
+}
+
+func (s *Storage) MkdirAll(name string) error {
+	return s.base.MkdirAll(name)
+}
+
+func (s *Storage) Lock(f caskdb.File, exclusive bool) error {
+	if f, ok := f.(*file); ok {
+		return s.base.Lock(f.base, exclusive)
+	}
+	return s.base.Lock(f, exclusive)
+}
+
+// file is a caskdb.File of Storage
+type file struct {
+	storage *Storage
+	base    caskdb.File
+	state   *fileState
+	// crashed is set once the Storage crashes, the file is closed then
+	crashed bool
+}
+
+func (f *file) ReadAt(p []byte, off int64) (int, error) {
+	f.storage.mu.Lock()
+	crashed := f.crashed
+	f.storage.mu.Unlock()
+	if crashed {
+		return 0, ErrCrashed
+	}
+	return f.base.ReadAt(p, off)
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	f.storage.mu.Lock()
+	defer f.storage.mu.Unlock()
+	if f.crashed {
+		return 0, ErrCrashed
+	}
+	fault, ok := f.storage.fault(OpWrite)
+	if ok {
+		if fault.Written > len(p) {
+			fault.Written = len(p)
+		}
+		p = p[:fault.Written]
+	}
+	n, err := f.base.Write(p)
+	f.state.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	if ok {
+		return n, fault.Err
+	}
+	return n, nil
+}
+
+func (f *file) Sync() error {
+	f.storage.mu.Lock()
+	defer f.storage.mu.Unlock()
+	if f.crashed {
+		return ErrCrashed
+	}
+	if fault, ok := f.storage.fault(OpSync); ok {
+		return fault.Err
+	}
+	if err := f.base.Sync(); err != nil {
+		return err
+	}
+	f.state.synced = f.state.size
+	return nil
+}
+
+func (f *file) Close() error {
+	f.storage.mu.Lock()
+	defer f.storage.mu.Unlock()
+	if f.crashed {
+		return nil
+	}
+	delete(f.storage.open, f)
+	return f.base.Close()
+}
+
+func (f *file) Size() (int64, error) {
+	f.storage.mu.Lock()
+	crashed := f.crashed
+	f.storage.mu.Unlock()
+	if crashed {
+		return 0, ErrCrashed
+	}
+	return f.base.Size()
+}
@@ -0,0 +1,60 @@
+package crashtest
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/avinassh/go-caskdb"
+)
+
+func TestStorage(t *testing.T) {
+	storage := New(caskdb.NewMemoryStorage())
+	storage.MkdirAll("test.db")
+	file, err := storage.OpenFile("test.db/1.data", os.O_APPEND|os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	file.Write([]byte("synced"))
+	if err := file.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+
+	// the second write fails after writing 3 of its bytes, and the next fsync fails
+	storage.Inject(Fault{Op: OpWrite, After: 1, Written: 3})
+	storage.Inject(Fault{Op: OpSync, After: 0})
+	if _, err := file.Write([]byte("-lost")); err != nil {
+		t.Errorf("Write() error = %v", err)
+	}
+	if n, err := file.Write([]byte("-torn")); n != 3 || err != ErrInjected {
+		t.Errorf("Write() = %v, %v, want %v, %v", n, err, 3, ErrInjected)
+	}
+	if err := file.Sync(); err != ErrInjected {
+		t.Errorf("Sync() error = %v, want %v", err, ErrInjected)
+	}
+	if n := storage.Count(OpWrite); n != 3 {
+		t.Errorf("Count(OpWrite) = %v, want %v", n, 3)
+	}
+
+	// the crash keeps what was synced, and maybe some of the rest
+	if err := storage.Crash(rand.New(rand.NewSource(1))); err != nil {
+		t.Fatalf("Crash() error = %v", err)
+	}
+	if _, err := file.Write([]byte("after")); err != ErrCrashed {
+		t.Errorf("Write() after Crash() error = %v, want %v", err, ErrCrashed)
+	}
+	file, err = storage.OpenFile("test.db/1.data", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() after Crash() error = %v", err)
+	}
+	size, _ := file.Size()
+	if size < int64(len("synced")) || size > int64(len("synced-lost-to")) {
+		t.Errorf("Size() after Crash() = %v, want between %v and %v", size, len("synced"), len("synced-lost-to"))
+	}
+	data := make([]byte, size)
+	file.ReadAt(data, 0)
+	if want := "synced-lost-to"[:size]; string(data) != want {
+		t.Errorf("ReadAt() after Crash() = %q, want %q", data, want)
+	}
+	file.Close()
+}
@@ -1,25 +1,24 @@
 package caskdb
 
 import (
+	"crypto/cipher"
 	"errors"
-	"fmt"
-	"io"
 	"io/fs"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/avinassh/go-caskdb/format"
 )
 
-// defaultWhence helps us with `file.Seek` method to move our cursor to certain byte offset for read
-// or write operations. The method takes two parameters file.Seek(offset, whence).
-// The offset says the byte offset and whence says the direction:
-//
-// whence 0 - beginning of the file
-// whence 1 - current cursor position
-// whence 2 - end of the file
-//
-// read more about it here:
-// https://pkg.go.dev/os#File.Seek
-const defaultWhence = 0
+// ErrChecksumMismatch is returned when a record read from the disk does not match its
+// checksum, that is, the record is corrupt
+var ErrChecksumMismatch = format.ErrChecksumMismatch
+
+// ErrClosed is returned when using a store after it was closed
+var ErrClosed = errors.New("store is closed")
 
 // DiskStore is a Log-Structured Hash Table as described in the BitCask paper. We
 // keep appending the data to a file, like a log. DiskStorage maintains an in-memory
@@ -45,31 +44,138 @@ const defaultWhence = 0
 //     time too
 //   - Deleted keys need to be purged from the file to reduce the file size
 //
+// The data lives in a directory of numbered data files. We append to the newest one,
+// the active file, and once it grows past the maximum file size, we start a new
+// one. The older files are never written to again, only read from, and every
+// KeyEntry knows which file has its record. Check segment.go for more details
+//
 // Read the paper for more details: https://riak.com/assets/bitcask-intro.pdf
 //
 // DiskStore provides two simple operations to get and set key value pairs. Both key
 // and value need to be of string type, and all the data is persisted to disk.
 // During startup, DiskStorage loads all the existing KV pair metadata, and it will
-// throw an error if the file is invalid or corrupt.
+// throw an error if a file is invalid or corrupt.
 //
-// Note that if the database file is large, the initialisation will take time
+// Note that if the database is large, the initialisation will take time
 // accordingly. The initialisation is also a blocking operation; till it is completed,
 // we cannot use the database.
 //
+// DiskStore is safe for concurrent use by multiple goroutines. Reads (Get, the index
+// queries etc.) run in parallel with each other, while writes (Set, Delete, Merge
+// etc.) run one at a time and wait for the reads in progress to finish. A read
+// always sees either all of a write or none of it. The read-modify-write operations
+// like SetBit and IncrCounter are atomic too. Has, TTL and Metadata read only the
+// keyDir, which has locks of its own, so they don't wait for the writes at all.
+//
+// The guarantees are within a single process only, two processes must not open the
+// same data directory.
+//
 // Typical usage example:
 //
 //		store, _ := NewDiskStore("books.db")
 //	   	store.Set("othello", "shakespeare")
-//	   	author := store.Get("othello")
+//	   	author, err := store.Get("othello")
 type DiskStore struct {
-	// file object pointing the file_name
-	file *os.File
-	// current cursor position in the file where the data can be written
+	// mu guards all the fields below. Get and the other reads take it shared,
+	// Set, Delete and the other writes take it exclusively
+	mu sync.RWMutex
+	// dirName is the path of the data directory
+	dirName string
+	// files are the open data files, keyed by their ID. Only the active file is
+	// written to, the rest are read only
+	files map[uint32]File
+	// activeFileID is the ID of the data file we are appending to, and
+	// activeVersion is the version of the format of it. The store writes only to a
+	// file of format.Version, but a replica copies the files of the primary as they
+	// are, in whichever version they are
+	activeFileID  uint32
+	activeVersion uint32
+	// current cursor position in the active file where the data can be written
 	writePosition int
+	// maxFileSize is the size after which we rotate to a new active file
+	maxFileSize int
 	// keyDir is a map of key and KeyEntry being the value. KeyEntry contains the position
 	// of the byte offset in the file where the value exists. key_dir map acts as in-memory
-	// index to fetch the values quickly from the disk
-	keyDir map[string]KeyEntry
+	// index to fetch the values quickly from the disk. It is sharded, check keydir.go
+	keyDir *keyDir
+	// indexes are the secondary indexes declared on the JSON fields of the values,
+	// keyed by the field path. Check index.go for more details
+	indexes map[string]*jsonIndex
+	// textIndex is the full-text index over the values, nil unless created. Check
+	// text_index.go for more details
+	textIndex *textIndex
+	// compositeIndexes are the sorted indexes over several JSON fields, keyed by
+	// their name. Check composite_index.go for more details
+	compositeIndexes map[string]*compositeIndex
+	// timeIndex is the index of the keys by their last write time, nil unless
+	// created. Check time_index.go for more details
+	timeIndex *timeIndex
+	// valueLogs are the open value log files, keyed by their ID. activeValueLog is
+	// the ID of the one we are appending to, zero if none, and valueLogPosition is
+	// where the next value goes in it. Check value_log.go for more details
+	valueLogs        map[uint32]File
+	activeValueLog   uint32
+	valueLogPosition int64
+	// pins counts the snapshots having each file, and retired has the files which
+	// the store does not use any more, but are pinned. Check snapshot.go
+	pins    map[File]int
+	retired map[File]bool
+	// mmaps are the memory mapped data files, check mmap_read.go
+	mmaps map[uint32][]byte
+	// aead encrypts and decrypts the records, nil if the store has no encryption
+	// key. Check encryption.go
+	aead cipher.AEAD
+	// orderedIndex is the index of the keys in their sorted order, nil unless
+	// created. Check ordered_index.go for more details
+	orderedIndex *orderedIndex
+	// watchers are the running watches, check watch.go
+	watchers map[*watcher]bool
+	// replica follows the primary, nil unless the store was opened by OpenReplica.
+	// Check replica.go
+	replica *replica
+	// id is the ID of the store for the replication, zero until it is loaded.
+	// Check storeID
+	id uint64
+	// appended is closed after the next write to the data files, to wake up the
+	// replicas waiting for it, and appendMu guards it. Check replication.go
+	appendMu sync.Mutex
+	appended chan struct{}
+	// syncPolicy decides when the writes are synced to the disk, and dirty tells
+	// whether the active file has writes which are not synced yet. Check sync.go
+	// for more details
+	syncPolicy SyncPolicy
+	dirty      bool
+	// syncStop stops the background flusher of SyncInterval, and syncDone is
+	// closed once it has stopped. Both are nil if there is no flusher
+	syncStop chan struct{}
+	syncDone chan struct{}
+	// autoMergeStop stops the auto merge scheduler, and autoMergeDone is closed once
+	// it has stopped. Both are nil if there is no scheduler. Check auto_merge.go
+	autoMergeStop chan struct{}
+	autoMergeDone chan struct{}
+	// janitorStop stops the janitor, and janitorDone is closed once it has stopped.
+	// Both are nil if there is no janitor. expiredRemoved is the number of expired
+	// keys it removed. Check janitor.go
+	janitorStop    chan struct{}
+	janitorDone    chan struct{}
+	expiredRemoved int
+	// recordsScanned is the number of records read from the data files at the
+	// startup, and lastMerge is when Merge last completed. Check stats.go
+	recordsScanned int
+	lastMerge      time.Time
+	// lockFile holds the lock of the data directory, so that no other process opens
+	// the store while we have it open. Check lock.go for more details
+	lockFile File
+	// closed is set by Close, after which the store has no files to read or write
+	closed bool
+	// options are the settings the store was opened with. The max file size and the
+	// sync policy are kept in their own fields, since they can be changed later
+	options Options
+	// metrics is where the store reports its operations. Check metrics.go
+	metrics Metrics
+	// now returns the current time to check the expiry of the keys, tests replace
+	// it to move the clock
+	now func() time.Time
 }
 
 func isFileExists(fileName string) bool {
@@ -80,130 +186,756 @@ func isFileExists(fileName string) bool {
 	return false
 }
 
-func NewDiskStore(fileName string) (*DiskStore, error) {
-	ds := &DiskStore{keyDir: make(map[string]KeyEntry)}
-	// if the file exists already, then we will load the key_dir
-	if isFileExists(fileName) {
-		ds.initKeyDir(fileName)
+// NewDiskStore opens the store in the data directory with the default options,
+// creating it if it does not exist
+func NewDiskStore(dirName string) (*DiskStore, error) {
+	return Open(dirName)
+}
+
+// Open opens the store in the data directory, creating it if it does not exist. The
+// options change the defaults given by DefaultOptions
+//
+// Typical usage example:
+//
+//	store, _ := Open("books.db", WithSyncPolicy(SyncInterval, time.Second))
+func Open(dirName string, opts ...Option) (*DiskStore, error) {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
 	}
-	// we open the file in following modes:
-	//	os.O_APPEND - says that the writes are append only.
-	// 	os.O_RDWR - says we can read and write to the file
-	// 	os.O_CREATE - creates the file if it does not exist
-	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_RDWR|os.O_CREATE, 0666)
+	aead, err := newCipher(options.EncryptionKey)
 	if err != nil {
 		return nil, err
 	}
-	ds.file = file
+	ds := &DiskStore{
+		dirName:          dirName,
+		files:            make(map[uint32]File),
+		valueLogs:        make(map[uint32]File),
+		pins:             make(map[File]int),
+		retired:          make(map[File]bool),
+		mmaps:            make(map[uint32][]byte),
+		maxFileSize:      options.MaxFileSize,
+		keyDir:           newKeyDir(0, options.CompactKeyDir),
+		indexes:          make(map[string]*jsonIndex),
+		compositeIndexes: make(map[string]*compositeIndex),
+		aead:             aead,
+		options:          options,
+		metrics:          options.Metrics,
+		now:              time.Now,
+	}
+	if ds.metrics == nil {
+		ds.metrics = nopMetrics{}
+	}
+	if err := migrateSingleFile(options.Storage, dirName, options.ReadOnly); err != nil {
+		return nil, err
+	}
+	// a read-only store must not create anything, listing the data files fails
+	// if the directory does not exist
+	if !options.ReadOnly {
+		if err := options.Storage.MkdirAll(dirName); err != nil {
+			return nil, err
+		}
+	}
+	if err := ds.lock(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	if err := ds.load(); err != nil {
+		ds.closeFiles()
+		return nil, err
+	}
+	options.Logger.Log(LevelInfo, "opened store", "dir", dirName, "keys", ds.keyDir.len(),
+		"files", len(ds.files), "records_scanned", ds.recordsScanned, "took", time.Since(start))
+	ds.SetSyncPolicy(options.SyncPolicy, options.SyncInterval)
+	ds.SetAutoMerge(options.AutoMerge)
+	ds.SetJanitor(options.Janitor)
 	return ds, nil
 }
 
-func (d *DiskStore) Get(key string) string {
+// load loads the keyDir from the hint file and the data files, and opens the data
+// files
+func (d *DiskStore) load() error {
+	fileIDs, err := listDataFiles(d.options.Storage, d.dirName)
+	if err != nil {
+		return err
+	}
+	// if the data files exist already, then we will load the key_dir. If there is
+	// a hint file, we load most of the key_dir from it, and read only the records
+	// written after it from the data files
+	startID, startPosition, _ := d.loadHintFile(fileIDs)
+	var scanIDs []uint32
+	for _, fileID := range fileIDs {
+		if fileID >= startID {
+			scanIDs = append(scanIDs, fileID)
+		}
+	}
+	if err := d.scanDataFiles(scanIDs, startID, startPosition); err != nil {
+		return err
+	}
+	if len(fileIDs) == 0 {
+		d.activeFileID = 1
+	}
+	if !d.options.ReadOnly {
+		fileIDs = append(fileIDs, d.activeFileID)
+	}
+	for _, fileID := range fileIDs {
+		if _, ok := d.files[fileID]; ok {
+			continue
+		}
+		file, err := d.openDataFile(fileID)
+		if err != nil {
+			return err
+		}
+		d.files[fileID] = file
+		// the active file was empty, and now has the file header
+		if fileID == d.activeFileID && d.writePosition == 0 && !d.options.ReadOnly {
+			d.writePosition = format.FileHeaderSize
+			d.activeVersion = format.Version
+		}
+		// a read-only store never writes to the active file either
+		if fileID != d.activeFileID || d.options.ReadOnly {
+			d.mapDataFile(fileID)
+		}
+	}
+	return d.openValueLogs()
+}
+
+// scanDataFiles builds the keyDir from the data files, on top of what was loaded from
+// the hint file. The files are read from the start, except the start file, which is
+// read from the start position.
+//
+// Reading the files takes most of the startup time of a large store, so they are
+// scanned in parallel, by as many workers as there are CPUs. Every worker scans a
+// file on its own, and the scans are applied to the keyDir in the order the files
+// were written, so that a later record of a key replaces the earlier ones. The
+// workers stay at most as many files ahead of the file being applied as there are
+// workers, so the memory taken by the scans waiting to be applied stays bounded
+func (d *DiskStore) scanDataFiles(fileIDs []uint32, startID uint32, startPosition int) error {
+	workers := runtime.GOMAXPROCS(0)
+	scans := make([]chan *fileScan, len(fileIDs))
+	errs := make([]error, len(fileIDs))
+	for i := range scans {
+		scans[i] = make(chan *fileScan, 1)
+	}
+	// slots limits the scans running or waiting to be applied, and stop tells the
+	// dispatcher to stop starting scans, once we fail
+	slots := make(chan struct{}, workers)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i, fileID := range fileIDs {
+			select {
+			case slots <- struct{}{}:
+			case <-stop:
+				return
+			}
+			position := 0
+			if fileID == startID {
+				position = startPosition
+			}
+			go func(i int, fileID uint32, position int) {
+				scan, err := d.scanDataFile(fileID, position)
+				errs[i] = err
+				scans[i] <- scan
+			}(i, fileID, position)
+		}
+	}()
+	for i, fileID := range fileIDs {
+		scan := <-scans[i]
+		<-slots
+		if errs[i] != nil {
+			return errs[i]
+		}
+		for _, prefix := range scan.buckets {
+			for _, key := range d.bucketKeys(prefix) {
+				d.keyDir.remove(key)
+			}
+		}
+		for key, entry := range scan.entries {
+			if entry.deleted {
+				d.keyDir.remove(key)
+			} else {
+				d.keyDir.put(key, entry.kEntry)
+			}
+		}
+		d.recordsScanned += scan.records
+		d.activeFileID = fileID
+		d.activeVersion = scan.version
+		d.writePosition = scan.end
+		// only the last file can end with a partially written record, the earlier
+		// ones were synced before the next one was started. An incomplete record in
+		// one of them is corruption, like a flipped bit in its size, and cutting it
+		// off would lose the records after it for good, so it is left to Repair
+		if i < len(fileIDs)-1 && scan.size > scan.end {
+			d.options.Logger.Log(LevelError, "found incomplete record in a sealed data file", "dir", d.dirName,
+				"file", fileID, "offset", scan.end, "bytes", scan.size-scan.end)
+			return ErrChecksumMismatch
+		}
+		if i == len(fileIDs)-1 {
+			if err := d.truncateTail(fileID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SetMaxFileSize sets the size after which the active data file is rotated. A record
+// is never split across files, so a file may end up larger than this by up to one
+// record. The default is DefaultMaxFileSize
+func (d *DiskStore) SetMaxFileSize(size int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.maxFileSize = size
+}
+
+// activeFile returns the data file we are appending to
+func (d *DiskStore) activeFile() File {
+	return d.files[d.activeFileID]
+}
+
+func (d *DiskStore) Get(key string) (string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	start := time.Now()
+	value, err := d.get(key)
+	d.metrics.ObserveOp(OpGet, time.Since(start), err)
+	return value, err
+}
+
+func (d *DiskStore) get(key string) (string, error) {
 	// Get retrieves the value from the disk and returns. If the key does not
-	// exist then it returns an empty string
+	// exist then it returns ErrKeyNotFound
 	//
 	// How get works?
 	//	1. Check if there is any KeyEntry record for the key in keyDir
-	//	2. Return an empty string if key doesn't exist
+	//	2. Return ErrKeyNotFound if key doesn't exist, or is expired
 	//	3. If it exists, then read KeyEntry.totalSize bytes starting from the
-	//     KeyEntry.position from the disk
-	//	4. Decode the bytes into valid KV pair and return the value
+	//     KeyEntry.position from the data file KeyEntry.fileID
+	//	4. Verify the checksum of the bytes
+	//	5. Decode the bytes into valid KV pair and return the value
 	//
-	kEntry, ok := d.keyDir[key]
-	if !ok {
-		return ""
-	}
-	// move the current pointer to the right offset
-	// TODO: handle errors
-	d.file.Seek(int64(kEntry.position), defaultWhence)
-	data := make([]byte, kEntry.totalSize)
-	// TODO: handle errors
-	_, err := io.ReadFull(d.file, data)
+	if d.closed {
+		return "", ErrClosed
+	}
+	kEntry, ok := d.keyDir.get(key)
+	if !ok || kEntry.expired(uint32(d.now().Unix())) {
+		return "", ErrKeyNotFound
+	}
+	return d.read(kEntry)
+}
+
+// read reads the value of the record at kEntry from the disk
+func (d *DiskStore) read(kEntry KeyEntry) (string, error) {
+	value, err := d.readBytes(kEntry)
 	if err != nil {
-		panic("read error")
+		return "", err
+	}
+	return string(value), nil
+}
+
+// readBytes is like read, but returns the value as bytes. The value is a slice of
+// the record read from the disk, so unlike read, it is not copied
+func (d *DiskStore) readBytes(kEntry KeyEntry) ([]byte, error) {
+	return d.readBytesInto(kEntry, make([]byte, kEntry.totalSize))
+}
+
+// readRecordValue reads the value of the record from the given files, decrypting it
+// with the aead if it is encrypted. The snapshots read from their own files with it
+func readRecordValue(aead cipher.AEAD, files map[uint32]File, valueLogs map[uint32]File, kEntry KeyEntry) ([]byte, error) {
+	return readRecordValueInto(aead, files, valueLogs, kEntry, make([]byte, kEntry.totalSize))
+}
+
+// readRecordValueInto is like readRecordValue, but reads the record into data, which
+// must be as long as the record. The returned value is a slice of data, unless the
+// value had to be decrypted, decompressed or read from a value log
+func readRecordValueInto(aead cipher.AEAD, files map[uint32]File, valueLogs map[uint32]File, kEntry KeyEntry, data []byte) ([]byte, error) {
+	// we read the record with a positional read (pread on unix), which does not
+	// move the file cursor. Compared to Seek followed by Read, it is a single
+	// syscall per Get and reads don't depend on where the previous one left the cursor
+	//
+	// read more about it here:
+	// https://pkg.go.dev/os#File.ReadAt
+	if _, err := files[kEntry.fileID].ReadAt(data, int64(kEntry.position)); err != nil {
+		return nil, err
 	}
-	_, _, value := decodeKV(data)
-	return value
+	return decodeRecordValue(aead, valueLogs, kEntry.version, data)
 }
 
-func (d *DiskStore) Set(key string, value string) {
+// decodeRecordValue verifies the record read from a data file of the version of the
+// format, and returns its value
+func decodeRecordValue(aead cipher.AEAD, valueLogs map[uint32]File, version uint32, data []byte) ([]byte, error) {
+	// the checksum tells us if the record got corrupted on the disk, we must
+	// not return garbage as if it were the value
+	if err := format.VerifyChecksum(data); err != nil {
+		return nil, err
+	}
+	headerSize := format.HeaderSizeOf(version)
+	_, _, keySize, _ := format.DecodeHeader(version, data)
+	encrypted := format.IsEncrypted(keySize)
+	data, err := decryptRecord(aead, version, data)
+	if err != nil {
+		return nil, err
+	}
+	_, _, keySize, valueSize := format.DecodeHeader(version, data)
+	value := data[headerSize+int(keySize):]
+	if format.IsValuePointer(valueSize) {
+		if value, err = readValue(valueLogs, value); err != nil {
+			return nil, err
+		}
+		// the value of an encrypted record is encrypted in the value log too,
+		// bound to the key
+		if encrypted {
+			key := data[headerSize : headerSize+int(keySize)]
+			if value, err = format.Open(aead, value, key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if format.IsCompressed(valueSize) {
+		return format.Decompress(value)
+	}
+	return value, nil
+}
+
+// getOrEmpty is like get, but returns an empty value for a missing key. The data
+// types built on top of the values, like the bitmaps and the counters, treat a
+// missing key as an empty value
+func (d *DiskStore) getOrEmpty(key string) (string, error) {
+	value, err := d.get(key)
+	if err == ErrKeyNotFound {
+		return "", nil
+	}
+	return value, err
+}
+
+// Has tells whether the key exists. It is answered from the keyDir alone, without
+// reading the value from the disk
+func (d *DiskStore) Has(key string) bool {
+	return d.isLive(key)
+}
+
+func (d *DiskStore) Set(key string, value string) error {
 	// Set stores the key and value on the disk
 	//
 	// The steps to save a KV to disk is simple:
 	// 1. Encode the KV into bytes
 	// 2. Write the bytes to disk by appending to the file
 	// 3. Update KeyDir with the KeyEntry of this key
-	timestamp := uint32(time.Now().Unix())
-	size, data := encodeKV(timestamp, key, value)
-	d.write(data)
-	d.keyDir[key] = NewKeyEntry(timestamp, uint32(d.writePosition), uint32(size))
+	// 4. Update the secondary indexes, if any
+	if err := checkReserved(key); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.set(key, value, uint32(time.Now().Unix()), 0)
+}
+
+// SetIfNewer stores the key and value only if ts is later than the timestamp of the
+// key's current value, and tells whether it did. The value is stored with ts as its
+// timestamp. Timestamps have a precision of seconds, so a write within the same second
+// as the current value is not applied.
+//
+// This makes the writes order independent: when several writers, say replicas or a
+// backfill job, write the same key, the value with the latest timestamp wins no matter
+// in which order the writes arrive
+func (d *DiskStore) SetIfNewer(key string, value string, ts time.Time) (bool, error) {
+	if err := checkReserved(key); err != nil {
+		return false, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	timestamp := uint32(ts.Unix())
+	if kEntry, ok := d.keyDir.get(key); ok && !kEntry.expired(uint32(d.now().Unix())) && timestamp <= kEntry.timestamp {
+		return false, nil
+	}
+	if err := d.set(key, value, timestamp, 0); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// set writes the key and value with the timestamp, expiring at expiry. An expiry of
+// zero never expires
+func (d *DiskStore) set(key string, value string, timestamp uint32, expiry uint32) error {
+	start := time.Now()
+	err := d.writeKV(key, value, timestamp, expiry)
+	d.metrics.ObserveOp(OpSet, time.Since(start), err)
+	return err
+}
+
+// writeKV is set, without reporting it to the metrics
+func (d *DiskStore) writeKV(key string, value string, timestamp uint32, expiry uint32) error {
+	if err := d.checkSize(key, value); err != nil {
+		return err
+	}
+	size, data, err := d.encodeKV(timestamp, expiry, key, value)
+	if err != nil {
+		return err
+	}
+	if err := d.write(data); err != nil {
+		return err
+	}
+	d.putEntry(key, value, NewKeyEntry(timestamp, expiry, d.activeFileID, d.activeVersion, uint64(d.writePosition), uint64(size)))
 	// update last write position, so that next record can be written from this point
 	d.writePosition += size
+	return nil
 }
 
-func (d *DiskStore) Close() bool {
-	// before we close the file, we need to safely write the contents in the buffers
+// putEntry points the key to its new record in the keyDir, and updates the secondary
+// indexes with the value
+func (d *DiskStore) putEntry(key string, value string, kEntry KeyEntry) {
+	previous, exists := d.keyDir.put(key, kEntry)
+	d.updateIndexes(key, value)
+	if d.timeIndex != nil {
+		d.timeIndex.update(key, previous, exists, kEntry.timestamp)
+	}
+	if d.orderedIndex != nil {
+		if exists {
+			d.orderedIndex.rekey(key)
+		} else {
+			d.orderedIndex.insert(key)
+		}
+	}
+	d.notify(Event{Type: EventSet, Key: key, Value: value, Timestamp: time.Unix(int64(kEntry.timestamp), 0)})
+}
+
+// removeEntry removes the key from the keyDir and the secondary indexes, for the
+// tombstone written at timestamp. The tombstone of a bucket removes all the keys of
+// the bucket, check bucket.go
+func (d *DiskStore) removeEntry(key string, timestamp uint32) {
+	if isBucketTombstone(key) {
+		for _, bucketKey := range d.bucketKeys(key) {
+			d.removeEntry(bucketKey, timestamp)
+		}
+		return
+	}
+	previous, ok := d.keyDir.remove(key)
+	if !ok {
+		return
+	}
+	d.removeFromIndexes(key, previous)
+	d.notify(Event{Type: EventDelete, Key: key, Timestamp: time.Unix(int64(timestamp), 0)})
+}
+
+func (d *DiskStore) Delete(key string) error {
+	// Delete removes the key from the store. Deleting a key which does not exist
+	// is a no-op
+	//
+	// We cannot remove the key's records from the file, since it is append only.
+	// Instead, we append a tombstone record which says that the key is deleted:
+	// 1. Encode the tombstone for the key into bytes
+	// 2. Write the bytes to disk by appending to the file
+	// 3. Remove the key from KeyDir and the secondary indexes
+	//
+	// When we load the file at the startup, the tombstone removes the key from
+	// the keyDir, so that the deleted key does not come back. The key's records
+	// and the tombstone keep taking space on the disk though
+	if err := checkReserved(key); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	start := time.Now()
+	err := d.delete(key)
+	d.metrics.ObserveOp(OpDelete, time.Since(start), err)
+	return err
+}
+
+// delete writes the tombstone of the key, if the key exists
+func (d *DiskStore) delete(key string) error {
+	if d.closed {
+		return ErrClosed
+	}
+	if _, ok := d.keyDir.get(key); !ok {
+		return nil
+	}
+	timestamp := uint32(time.Now().Unix())
+	_, data := format.EncodeTombstone(timestamp, key)
+	data = d.encrypt(data)
+	if err := d.write(data); err != nil {
+		return err
+	}
+	d.removeEntry(key, timestamp)
+	d.writePosition += len(data)
+	return nil
+}
+
+func (d *DiskStore) Close() error {
+	// before we close the files, we need to safely write the contents in the buffers
 	// to the disk. Check documentation of DiskStore.write() to understand
-	// following the operations
-	// TODO: handle errors
-	d.file.Sync()
-	if err := d.file.Close(); err != nil {
-		// TODO: log the error
-		return false
+	// following the operations. The files other than the active one were synced
+	// when we rotated away from them
+	d.stopReplica()
+	d.stopAutoMerge()
+	d.stopJanitor()
+	d.stopFlusher()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	d.closed = true
+	d.stopWatchers()
+	if d.options.ReadOnly {
+		return d.closeFiles()
+	}
+	syncErr := d.syncValueLog()
+	if syncErr == nil {
+		syncErr = d.syncFile(d.activeFile())
+	}
+	// the hint file makes the next startup faster, but the data files have
+	// everything we need without it. We don't write it if the data files may
+	// not be on the disk
+	if syncErr == nil {
+		if err := d.writeHintFile(); err != nil {
+			d.options.Logger.Log(LevelError, "failed to write the hint file", "dir", d.dirName, "err", err)
+		}
+	}
+	// the files are closed even if the sync failed, so that we don't leak them
+	closeErr := d.closeFiles()
+	if syncErr != nil {
+		return syncErr
+	}
+	return closeErr
+}
+
+// closeFiles closes all the data files and releases the lock of the data directory,
+// and returns the first error
+func (d *DiskStore) closeFiles() error {
+	var closeErr error
+	if d.lockFile != nil {
+		closeErr = d.lockFile.Close()
+		d.lockFile = nil
+	}
+	for fileID, file := range d.files {
+		d.unmapDataFile(fileID)
+		if err := file.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+		delete(d.files, fileID)
+	}
+	for fileID, file := range d.valueLogs {
+		if err := file.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+		delete(d.valueLogs, fileID)
 	}
-	return true
+	// the snapshots cannot be read once the store is closed
+	for file := range d.retired {
+		file.Close()
+		delete(d.retired, file)
+	}
+	return closeErr
 }
 
-func (d *DiskStore) write(data []byte) {
+func (d *DiskStore) write(data []byte) error {
 	// saving stuff to a file reliably is hard!
 	// if you would like to explore and learn more, then
 	// start from here: https://danluu.com/file-consistency/
 	// and read this too: https://lwn.net/Articles/457667/
-	if _, err := d.file.Write(data); err != nil {
-		panic(err)
+	//
+	// if the record does not fit in the active file, we start a new one. An
+	// empty file takes the record no matter its size, else a record larger than
+	// maxFileSize could never be written. The records are always in the current
+	// version of the format, so we start a new file too if the active one was
+	// written by an older version
+	if d.closed {
+		return ErrClosed
+	}
+	if d.options.ReadOnly || d.replica != nil {
+		return ErrReadOnly
+	}
+	if d.activeVersion != format.Version || (d.writePosition > format.FileHeaderSize && d.writePosition+len(data) > d.maxFileSize) {
+		if err := d.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := d.activeFile().Write(data); err != nil {
+		return d.undoWrite(err)
 	}
 	// calling fsync after every write is important, this assures that our writes
-	// are actually persisted to the disk
-	if err := d.file.Sync(); err != nil {
-		panic(err)
+	// are actually persisted to the disk. Unless the sync policy says otherwise,
+	// then we just remember to sync later
+	if d.syncPolicy != SyncAlways {
+		d.dirty = true
+		d.signalAppend()
+		return nil
 	}
+	if err := d.syncFile(d.activeFile()); err != nil {
+		return d.undoWrite(err)
+	}
+	d.signalAppend()
+	return nil
+}
+
+// undoWrite cuts the active file back to the write position after a failed write, and
+// returns the error of the write. Else a partially written record would be left in the
+// middle of the file, followed by the next records, and the file would fail its
+// checksums at the next startup. A record whose fsync failed is cut off too, since it
+// may or may not be on the disk, while the caller is told it was not written
+func (d *DiskStore) undoWrite(err error) error {
+	if truncateErr := d.options.Storage.Truncate(dataFileName(d.dirName, d.activeFileID), int64(d.writePosition)); truncateErr != nil {
+		d.options.Logger.Log(LevelError, "failed to cut off a failed write", "dir", d.dirName, "file", d.activeFileID, "err", truncateErr)
+	}
+	return err
+}
+
+// fileScan is what scanDataFile found in a data file
+type fileScan struct {
+	// entries has the last record of every key in the file. A key whose last
+	// record is a tombstone, or is expired, has deleted set
+	entries map[string]scanEntry
+	// buckets has the prefixes of the buckets deleted in the file. Their keys in
+	// the earlier files are removed too, check bucket.go
+	buckets []string
+	// end is the position after the last complete record of the file, size is the
+	// size of the file, and version is the version of the format of the file
+	end     int
+	size    int
+	version uint32
+	// records is the number of records read
+	records int
 }
 
-func (d *DiskStore) initKeyDir(existingFile string) {
-	// we will initialise the keyDir by reading the contents of the file, record by
-	// record. As we read each record, we will also update our keyDir with the
-	// corresponding KeyEntry
+// scanEntry is the last record of a key in a data file
+type scanEntry struct {
+	kEntry  KeyEntry
+	deleted bool
+}
+
+// scanDataFile reads the records of the data file with the ID, from the position on.
+// It does not touch the store, so the data files can be scanned in parallel
+func (d *DiskStore) scanDataFile(fileID uint32, position int) (*fileScan, error) {
+	// we read the contents of the data file with the ID, record by record, and
+	// remember the last record of every key we see
+	//
+	// instead of reading the header, key and value with separate read calls for every
+	// record, we memory map the whole file and decode the records straight from the
+	// mapping. The kernel takes care of reading the file sequentially, and we
+	// avoid three syscalls per record
 	//
-	// NOTE: this method is a blocking one, if the DB size is yuge then it will take
-	// a lot of time to startup
-	file, _ := os.Open(existingFile)
+	// every record's checksum is verified, and we return ErrChecksumMismatch if
+	// the file is corrupt
+	//
+	// If the keyDir was loaded from a hint file, the position is at the end of the
+	// records covered by it in its file, and we continue reading from there
+	//
+	// the errors opening or mapping the file are returned, an empty scan would have
+	// the file truncated as if all of it were a partially written record
+	scan := &fileScan{entries: make(map[string]scanEntry), end: position}
+	file, err := d.options.Storage.OpenFile(dataFileName(d.dirName, fileID), os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
 	defer file.Close()
-	for {
-		header := make([]byte, headerSize)
-		_, err := io.ReadFull(file, header)
-		if err == io.EOF {
+	data, release, err := mapFile(file)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	scan.size = len(data)
+	header, err := format.DecodeFileHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	scan.version = header.Version
+	if scan.end == 0 {
+		scan.end = header.Size()
+	}
+	now := uint32(d.now().Unix())
+	end, err := walkRecords(scan.version, data, scan.end, func(position int, record []byte) error {
+		return d.scanRecord(scan, fileID, position, record, now)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// a partially written record at the end of the file is left out, and
+	// truncateTail cuts it off
+	scan.end = end
+	return scan, nil
+}
+
+// walkRecords calls fn for every complete record in data, which is in the version of
+// the format, starting at position, with the position of the record and the record.
+// It returns the position after the last complete record, which is the end of data
+// unless the last record is partial. Every record's checksum is verified, and
+// ErrChecksumMismatch is returned for a corrupt one
+func walkRecords(version uint32, data []byte, position int, fn func(position int, record []byte) error) (int, error) {
+	headerSize := format.HeaderSizeOf(version)
+	for position+headerSize <= len(data) {
+		_, _, keySize, valueSize := format.DecodeHeader(version, data[position:position+headerSize])
+		totalSize := format.RecordSize(version, keySize, valueSize)
+		if position+totalSize > len(data) {
 			break
 		}
-		// TODO: handle errors
-		if err != nil {
-			break
+		record := data[position : position+totalSize]
+		if err := format.VerifyChecksum(record); err != nil {
+			return position, err
 		}
-		timestamp, keySize, valueSize := decodeHeader(header)
-		key := make([]byte, keySize)
-		value := make([]byte, valueSize)
-		_, err = io.ReadFull(file, key)
-		// TODO: handle errors
-		if err != nil {
-			break
+		if !format.IsBatch(keySize) {
+			if err := fn(position, record); err != nil {
+				return position, err
+			}
+			position += totalSize
+			continue
 		}
-		_, err = io.ReadFull(file, value)
-		// TODO: handle errors
-		if err != nil {
-			break
+		// the checksum of a batch covers all the records in it, so we load
+		// either all of them or, if the batch was not written completely, none
+		for offset := headerSize; offset < totalSize; {
+			if offset+headerSize > totalSize {
+				return position, ErrChecksumMismatch
+			}
+			_, _, keySize, valueSize := format.DecodeHeader(version, record[offset:offset+headerSize])
+			size := format.RecordSize(version, keySize, valueSize)
+			if format.IsBatch(keySize) || offset+size > totalSize {
+				return position, ErrChecksumMismatch
+			}
+			if err := fn(position+offset, record[offset:offset+size]); err != nil {
+				return position, err
+			}
+			offset += size
 		}
-		totalSize := headerSize + keySize + valueSize
-		d.keyDir[string(key)] = NewKeyEntry(timestamp, uint32(d.writePosition), totalSize)
-		d.writePosition += int(totalSize)
-		fmt.Printf("loaded key=%s, value=%s\n", key, value)
+		position += totalSize
+	}
+	return position, nil
+}
+
+// scanRecord adds the record found at the position in the data file to the scan
+func (d *DiskStore) scanRecord(scan *fileScan, fileID uint32, position int, record []byte, now uint32) error {
+	scan.records++
+	totalSize := len(record)
+	record, err := decryptRecord(d.aead, scan.version, record)
+	if err != nil {
+		return err
+	}
+	headerSize := format.HeaderSizeOf(scan.version)
+	timestamp, expiry, keySize, valueSize := format.DecodeHeader(scan.version, record)
+	key := string(record[headerSize : headerSize+int(keySize)])
+	if format.IsTombstone(valueSize) {
+		if isBucketTombstone(key) {
+			// the keys of the bucket seen so far in the file are removed here,
+			// and the ones in the earlier files when the scan is applied
+			for bucketKey := range scan.entries {
+				if strings.HasPrefix(bucketKey, key) {
+					delete(scan.entries, bucketKey)
+				}
+			}
+			scan.buckets = append(scan.buckets, key)
+			d.options.Logger.Log(LevelDebug, "loaded bucket tombstone", "bucket", key[1:len(key)-1], "file", fileID)
+			return nil
+		}
+		scan.entries[key] = scanEntry{deleted: true}
+		d.options.Logger.Log(LevelDebug, "loaded tombstone", "key", key, "file", fileID)
+		return nil
+	}
+	kEntry := NewKeyEntry(timestamp, expiry, fileID, scan.version, uint64(position), uint64(totalSize))
+	// an expired record is as good as deleted
+	if kEntry.expired(now) {
+		scan.entries[key] = scanEntry{deleted: true}
+		return nil
 	}
+	scan.entries[key] = scanEntry{kEntry: kEntry}
+	d.options.Logger.Log(LevelDebug, "loaded key", "key", key, "file", fileID)
+	return nil
 }
@@ -1,8 +1,12 @@
 package caskdb
 
 import (
+	"errors"
+	"fmt"
 	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestDiskStore_Get(t *testing.T) {
@@ -10,9 +14,15 @@ func TestDiskStore_Get(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create disk store: %v", err)
 	}
-	defer os.Remove("test.db")
-	store.Set("name", "jojo")
-	if val := store.Get("name"); val != "jojo" {
+	defer removeStore("test.db")
+	if err := store.Set("name", "jojo"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	val, err := store.Get("name")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if val != "jojo" {
 		t.Errorf("Get() = %v, want %v", val, "jojo")
 	}
 }
@@ -22,9 +32,9 @@ func TestDiskStore_GetInvalid(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create disk store: %v", err)
 	}
-	defer os.Remove("test.db")
-	if val := store.Get("some key"); val != "" {
-		t.Errorf("Get() = %v, want %v", val, "")
+	defer removeStore("test.db")
+	if val, err := store.Get("some key"); err != ErrKeyNotFound || val != "" {
+		t.Errorf("Get() = %v, %v, want %v, %v", val, err, "", ErrKeyNotFound)
 	}
 }
 
@@ -33,7 +43,7 @@ func TestDiskStore_SetWithPersistence(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create disk store: %v", err)
 	}
-	defer os.Remove("test.db")
+	defer removeStore("test.db")
 
 	tests := map[string]string{
 		"crime and punishment": "dostoevsky",
@@ -46,8 +56,8 @@ func TestDiskStore_SetWithPersistence(t *testing.T) {
 	}
 	for key, val := range tests {
 		store.Set(key, val)
-		if store.Get(key) != val {
-			t.Errorf("Get() = %v, want %v", store.Get(key), val)
+		if got, _ := store.Get(key); got != val {
+			t.Errorf("Get() = %v, want %v", got, val)
 		}
 	}
 	store.Close()
@@ -56,8 +66,8 @@ func TestDiskStore_SetWithPersistence(t *testing.T) {
 		t.Fatalf("failed to create disk store: %v", err)
 	}
 	for key, val := range tests {
-		if store.Get(key) != val {
-			t.Errorf("Get() = %v, want %v", store.Get(key), val)
+		if got, _ := store.Get(key); got != val {
+			t.Errorf("Get() = %v, want %v", got, val)
 		}
 	}
 	store.Close()
@@ -68,7 +78,7 @@ func TestDiskStore_Delete(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to create disk store: %v", err)
 	}
-	defer os.Remove("test.db")
+	defer removeStore("test.db")
 
 	tests := map[string]string{
 		"crime and punishment": "dostoevsky",
@@ -93,12 +103,323 @@ func TestDiskStore_Delete(t *testing.T) {
 		t.Fatalf("failed to create disk store: %v", err)
 	}
 	for key := range tests {
-		if store.Get(key) != "" {
-			t.Errorf("Get() = %v, want '' (empty)", store.Get(key))
+		if val, err := store.Get(key); err != nil || val != "" {
+			t.Errorf("Get() = %v, %v, want '' (empty)", val, err)
+		}
+	}
+	if val, _ := store.Get("end"); val != "yes" {
+		t.Errorf("Get() = %v, want %v", val, "yes")
+	}
+	store.Close()
+}
+
+func TestDiskStore_Close(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("othello", "shakespeare")
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := store.Close(); !errors.Is(err, ErrClosed) {
+		t.Errorf("second Close() error = %v, want %v", err, ErrClosed)
+	}
+	if _, err := store.Get("othello"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Get() error = %v, want %v", err, ErrClosed)
+	}
+	if _, err := store.GetBytes([]byte("othello")); !errors.Is(err, ErrClosed) {
+		t.Errorf("GetBytes() error = %v, want %v", err, ErrClosed)
+	}
+	if err := store.Set("hamlet", "shakespeare"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Set() error = %v, want %v", err, ErrClosed)
+	}
+	if err := store.Delete("othello"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Delete() error = %v, want %v", err, ErrClosed)
+	}
+	if err := store.Delete("missing"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Delete() of a missing key error = %v, want %v", err, ErrClosed)
+	}
+	batch := NewBatch()
+	batch.Set("dune", "frank herbert")
+	if err := store.Commit(batch); !errors.Is(err, ErrClosed) {
+		t.Errorf("Commit() error = %v, want %v", err, ErrClosed)
+	}
+	if err := store.Sync(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Sync() error = %v, want %v", err, ErrClosed)
+	}
+	if err := store.Merge(); !errors.Is(err, ErrClosed) {
+		t.Errorf("Merge() error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestDiskStore_InitKeyDirEmptyFile(t *testing.T) {
+	if err := os.MkdirAll("test.db", 0777); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	file, err := os.Create(dataFileName("test.db", 1))
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	file.Close()
+	defer removeStore("test.db")
+
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	store.Set("name", "jojo")
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if val, _ := store.Get("name"); val != "jojo" {
+		t.Errorf("Get() = %v, want %v", val, "jojo")
+	}
+	store.Close()
+}
+
+func TestDiskStore_SetIfNewer(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	now := time.Now()
+	tests := []struct {
+		value   string
+		ts      time.Time
+		applied bool
+		want    string
+	}{
+		{"v1", now, true, "v1"},
+		{"v0", now.Add(-time.Hour), false, "v1"},
+		{"v1-again", now, false, "v1"},
+		{"v2", now.Add(time.Hour), true, "v2"},
+	}
+	for _, tt := range tests {
+		if applied, _ := store.SetIfNewer("name", tt.value, tt.ts); applied != tt.applied {
+			t.Errorf("SetIfNewer(%v) = %v, want %v", tt.value, applied, tt.applied)
+		}
+		if val, _ := store.Get("name"); val != tt.want {
+			t.Errorf("Get() = %v, want %v", val, tt.want)
+		}
+	}
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if applied, _ := store.SetIfNewer("name", "v1", now.Add(time.Minute)); applied {
+		t.Errorf("SetIfNewer() = %v, want %v", applied, false)
+	}
+	if val, _ := store.Get("name"); val != "v2" {
+		t.Errorf("Get() = %v, want %v", val, "v2")
+	}
+	store.Close()
+}
+
+func TestDiskStore_DeleteWithPersistence(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("hamlet", "shakespeare")
+	store.Set("othello", "shakespeare")
+	store.Set("dune", "frank herbert")
+	if err := store.Delete("othello"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := store.Delete("dune"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := store.Delete("missing"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	store.Set("dune", "herbert")
+	if val, _ := store.Get("othello"); val != "" {
+		t.Errorf("Get() = %v, want '' (empty)", val)
+	}
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	tests := map[string]string{"hamlet": "shakespeare", "othello": "", "dune": "herbert"}
+	for key, val := range tests {
+		if got, _ := store.Get(key); got != val {
+			t.Errorf("Get(%v) = %v, want %v", key, got, val)
+		}
+	}
+	if _, ok := store.keyDir.get("othello"); ok {
+		t.Errorf("deleted key othello is in the keyDir")
+	}
+	store.Close()
+}
+
+// removeStore removes the data directory of a store
+func removeStore(dirName string) {
+	os.RemoveAll(dirName)
+}
+
+// storeSize returns the total size of the data files of a store
+func storeSize(dirName string) int64 {
+	fileIDs, _ := listDataFiles(OSStorage{}, dirName)
+	var size int64
+	for _, fileID := range fileIDs {
+		if info, err := os.Stat(dataFileName(dirName, fileID)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+func TestDiskStore_ChecksumMismatch(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("hamlet", "shakespeare")
+	store.Set("othello", "shakespeare")
+
+	// flip a bit of the last value on the disk
+	data, err := os.ReadFile(dataFileName("test.db", 1))
+	if err != nil {
+		t.Fatalf("failed to read data file: %v", err)
+	}
+	data[len(data)-1] ^= 0x01
+	if err := os.WriteFile(dataFileName("test.db", 1), data, 0666); err != nil {
+		t.Fatalf("failed to write data file: %v", err)
+	}
+	if _, err := store.Get("othello"); err != ErrChecksumMismatch {
+		t.Errorf("Get() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if val, _ := store.Get("hamlet"); val != "shakespeare" {
+		t.Errorf("Get() = %v, want %v", val, "shakespeare")
+	}
+	store.closeFiles()
+
+	if _, err := NewDiskStore("test.db"); err != ErrChecksumMismatch {
+		t.Errorf("NewDiskStore() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+}
+
+func TestDiskStore_Concurrent(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.SetMaxFileSize(1024)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				store.Set(key, key)
+				if i%5 == 0 {
+					store.Delete(key)
+				}
+				store.IncrCounter("counter", fmt.Sprint(w), 1)
+			}
+		}(w)
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := fmt.Sprintf("key-%d-%d", w, i)
+				if val, _ := store.Get(key); val != "" && val != key {
+					t.Errorf("Get(%v) = %v, want %v", key, val, key)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if val, _ := store.Counter("counter"); val != 200 {
+		t.Errorf("Counter() = %v, want %v", val, 200)
+	}
+	if store.keyDir.len() != 4*40+1 {
+		t.Errorf("len(keyDir) = %v, want %v", store.keyDir.len(), 4*40+1)
+	}
+	store.Close()
+}
+
+func TestDiskStore_Has(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("hamlet", "shakespeare")
+	store.Set("othello", "")
+	store.Delete("hamlet")
+	tests := map[string]bool{"hamlet": false, "othello": true, "missing": false}
+	for key, want := range tests {
+		if got := store.Has(key); got != want {
+			t.Errorf("Has(%v) = %v, want %v", key, got, want)
+		}
+	}
+	store.Close()
+}
+
+func TestDiskStore_ScanDataFiles(t *testing.T) {
+	store, err := Open("test.db", WithMaxFileSize(128))
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	// the keys are updated and deleted across the files, so the scans must be
+	// applied in the order of the files
+	want := make(map[string]string)
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("key%02d", i)
+			if (i+round)%4 == 0 {
+				store.Delete(key)
+				delete(want, key)
+				continue
+			}
+			value := fmt.Sprintf("value%d", round)
+			store.Set(key, value)
+			want[key] = value
+		}
+	}
+	if len(store.files) < 10 {
+		t.Fatalf("the store has %d data files, want a lot of them", len(store.files))
+	}
+	activeFileID, writePosition := store.activeFileID, store.writePosition
+	store.Close()
+	os.Remove(hintFileName("test.db"))
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if store.keyDir.len() != len(want) {
+		t.Errorf("len(keyDir) = %v, want %v", store.keyDir.len(), len(want))
+	}
+	for key, value := range want {
+		if got, err := store.Get(key); err != nil || got != value {
+			t.Errorf("Get(%q) = %q, %v, want %q", key, got, err, value)
 		}
 	}
-	if store.Get("end") != "yes" {
-		t.Errorf("Get() = %v, want %v", store.Get("end"), "yes")
+	if store.activeFileID != activeFileID || store.writePosition != writePosition {
+		t.Errorf("the active file is %d at %d, want %d at %d", store.activeFileID, store.writePosition, activeFileID, writePosition)
 	}
 	store.Close()
 }
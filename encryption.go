@@ -0,0 +1,79 @@
+package caskdb
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// encryption file has the encryption at rest. With an encryption key, every record is
+// encrypted with AES-GCM before it is written, and decrypted when it is read, so the
+// data files are of no use to someone who gets the disk but not the key. Check
+// format.EncryptedFlag for the format of the encrypted records.
+//
+// The keys and the values are encrypted, and the values in the value logs and the
+// hint file too. The timestamps, the expiries and the sizes of the records are not,
+// but they are authenticated, so they cannot be changed without the key. Every record
+// gets a random nonce, so the same value written twice does not look the same.
+//
+// The encryption key is not stored anywhere, opening the store without it returns
+// ErrEncrypted, and with a different one, format.ErrDecryptionFailed. The records
+// written before the store got a key stay as they are, and can still be read. A
+// backup has the data decrypted, so it should be encrypted on its way out if it
+// matters.
+
+var (
+	// ErrInvalidEncryptionKey is returned by Open when the encryption key is not 256
+	// bits long
+	ErrInvalidEncryptionKey = errors.New("encryption key must be 32 bytes")
+	// ErrEncrypted is returned when reading an encrypted record without the
+	// encryption key
+	ErrEncrypted = errors.New("store is encrypted, the encryption key is needed")
+)
+
+// newCipher returns the AES-GCM cipher for the encryption key, or nil if there is no
+// key
+func newCipher(key []byte) (cipher.AEAD, error) {
+	if key == nil {
+		return nil, nil
+	}
+	if len(key) != 32 {
+		return nil, ErrInvalidEncryptionKey
+	}
+	// the key is the right size, so NewCipher cannot fail
+	block, _ := aes.NewCipher(key)
+	return cipher.NewGCM(block)
+}
+
+// encrypt encrypts the encoded record, if the store has an encryption key
+func (d *DiskStore) encrypt(data []byte) []byte {
+	if d.aead == nil {
+		return data
+	}
+	return format.EncryptRecord(d.aead, data)
+}
+
+// sealValue encrypts the value of the key which goes to the value log, if the store
+// has an encryption key. The key is authenticated with it, so that the value cannot be
+// swapped with the value of another key
+func (d *DiskStore) sealValue(key string, value []byte) []byte {
+	if d.aead == nil {
+		return value
+	}
+	return format.Seal(d.aead, value, []byte(key))
+}
+
+// decryptRecord decrypts the record in the version of the format, if it is encrypted.
+// The aead is nil if the store has no encryption key
+func decryptRecord(aead cipher.AEAD, version uint32, data []byte) ([]byte, error) {
+	_, _, keySize, _ := format.DecodeHeader(version, data)
+	if !format.IsEncrypted(keySize) {
+		return data, nil
+	}
+	if aead == nil {
+		return nil, ErrEncrypted
+	}
+	return format.DecryptRecord(aead, version, data)
+}
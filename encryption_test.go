@@ -0,0 +1,83 @@
+package caskdb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+func TestOpen_Encryption(t *testing.T) {
+	if _, err := Open("test.db", WithEncryptionKey([]byte("short"))); err != ErrInvalidEncryptionKey {
+		t.Errorf("Open() error = %v, want %v", err, ErrInvalidEncryptionKey)
+	}
+	key := bytes.Repeat([]byte{7}, 32)
+	store, err := Open("test.db", WithEncryptionKey(key), WithValueThreshold(32), WithCompression(16))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer removeStore("test.db")
+
+	plot := strings.Repeat("the spice must flow ", 10)
+	store.Set("othello", "shakespeare")
+	store.Set("dune", plot)
+	store.Set("emma", "austen")
+	store.Delete("emma")
+	b := NewBatch()
+	b.Set("hamlet", "shakespeare")
+	b.Delete("othello")
+	if err := store.Commit(b); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	check := func() {
+		t.Helper()
+		if val, err := store.Get("dune"); err != nil || val != plot {
+			t.Errorf("Get() = %q, %v, want %q", val, err, plot)
+		}
+		if val, err := store.Get("hamlet"); err != nil || val != "shakespeare" {
+			t.Errorf("Get() = %q, %v, want %q", val, err, "shakespeare")
+		}
+		for _, key := range []string{"othello", "emma"} {
+			if _, err := store.Get(key); err != ErrKeyNotFound {
+				t.Errorf("Get(%q) error = %v, want %v", key, err, ErrKeyNotFound)
+			}
+		}
+	}
+	check()
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	check()
+	store.Close()
+
+	// nothing on the disk has the keys or the values in the clear
+	files, _ := filepath.Glob(filepath.Join("test.db", "*"))
+	for _, file := range files {
+		data, _ := os.ReadFile(file)
+		for _, secret := range []string{"hamlet", "shakespeare", "spice"} {
+			if bytes.Contains(data, []byte(secret)) {
+				t.Errorf("%s has %q in the clear", file, secret)
+			}
+		}
+	}
+
+	// with the hint file, and without it
+	for i := 0; i < 2; i++ {
+		store, err = Open("test.db", WithEncryptionKey(key))
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		check()
+		store.Close()
+		os.Remove(hintFileName("test.db"))
+	}
+	if _, err := Open("test.db"); err != ErrEncrypted {
+		t.Errorf("Open() without the key error = %v, want %v", err, ErrEncrypted)
+	}
+	if _, err := Open("test.db", WithEncryptionKey(bytes.Repeat([]byte{8}, 32))); err != format.ErrDecryptionFailed {
+		t.Errorf("Open() with another key error = %v, want %v", err, format.ErrDecryptionFailed)
+	}
+}
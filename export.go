@@ -0,0 +1,235 @@
+package caskdb
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// export file has the export and the import of the keys in the text formats, so that
+// the data can be moved in and out of caskdb with the standard tools, and reviewed or
+// diffed as text. Unlike a backup, the export has only the keys, the values and the
+// timestamps: the expiries are left out, so the imported keys never expire.
+//
+// In JSON Lines, every key is a line with a JSON object:
+//
+//	{"key":"othello","value":"shakespeare","timestamp":"2022-09-11T10:00:00Z"}
+//
+// In CSV, the first row is the header with the column names, and every key is a row:
+//
+//	key,value,timestamp
+//	othello,shakespeare,2022-09-11T10:00:00Z
+//
+// The timestamps are in RFC 3339, in UTC. The import takes the timestamp as optional,
+// a key without one is written with the current time. JSON strings are UTF-8, so the
+// values which are not valid UTF-8 don't survive the JSON Lines, CSV keeps them as
+// they are.
+
+// Format is the text format of Export and Import
+type Format int
+
+const (
+	// FormatJSONL is JSON Lines, a JSON object per line
+	FormatJSONL Format = iota
+	// FormatCSV is comma separated values, with a header row
+	FormatCSV
+)
+
+var (
+	// ErrUnknownFormat is returned by Export and Import for a Format they don't know
+	ErrUnknownFormat = errors.New("unknown format")
+	// ErrInvalidImport is returned by Import when the input is not in the format
+	ErrInvalidImport = errors.New("invalid import")
+)
+
+// importBatchSize is the number of keys Import writes at once, before it lets the
+// other reads and writes in
+const importBatchSize = 1000
+
+// exportRecord is a key of the export
+type exportRecord struct {
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Timestamp string `json:"timestamp,omitempty"`
+	// time is the parsed Timestamp, the zero time if there is none
+	time time.Time
+}
+
+// Export writes all the live keys of the store to w in the format, in the key order.
+// It is consistent as of when it started, like Backup
+func (d *DiskStore) Export(w io.Writer, f Format) error {
+	if f != FormatJSONL && f != FormatCSV {
+		return ErrUnknownFormat
+	}
+	snap := d.Snapshot()
+	defer snap.Release()
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	writer := csv.NewWriter(buf)
+	if f == FormatCSV {
+		writer.Write([]string{"key", "value", "timestamp"})
+	}
+	for _, key := range snap.Keys() {
+		value, err := snap.Get(key)
+		if err != nil {
+			return err
+		}
+		kEntry, _ := snap.keyDir.get(key)
+		record := exportRecord{
+			Key:       key,
+			Value:     value,
+			Timestamp: time.Unix(int64(kEntry.timestamp), 0).UTC().Format(time.RFC3339),
+		}
+		if f == FormatJSONL {
+			err = encoder.Encode(record)
+		} else {
+			err = writer.Write([]string{record.Key, record.Value, record.Timestamp})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+// Import writes the keys read from r in the format to the store, with their
+// timestamps. A key which exists already is overwritten. The keys are written in
+// batches of importBatchSize, so Import does not hold up the store for long. It
+// stops at the first invalid record, say an empty key or a value too large, and
+// returns ErrInvalidImport with its line. The batches before the one having it are
+// imported, and the records of that batch are not
+func (d *DiskStore) Import(r io.Reader, f Format) error {
+	var next func() (exportRecord, int, error)
+	switch f {
+	case FormatJSONL:
+		next = jsonlReader(r)
+	case FormatCSV:
+		var err error
+		if next, err = csvReader(r); err != nil {
+			return err
+		}
+	default:
+		return ErrUnknownFormat
+	}
+	for {
+		records := make([]exportRecord, 0, importBatchSize)
+		for len(records) < importBatchSize {
+			record, line, err := next()
+			if err == io.EOF {
+				break
+			}
+			if err == nil && record.Timestamp != "" {
+				record.time, err = time.Parse(time.RFC3339, record.Timestamp)
+			}
+			// the batch is checked as a whole before any of it is written
+			if err == nil {
+				err = d.checkSize(record.Key, record.Value)
+			}
+			if err != nil {
+				return fmt.Errorf("%w: line %d: %v", ErrInvalidImport, line, err)
+			}
+			records = append(records, record)
+		}
+		if len(records) == 0 {
+			return nil
+		}
+		if err := d.importRecords(records); err != nil {
+			return err
+		}
+	}
+}
+
+// importRecords writes the checked records to the store in a single batch, so that
+// either all of them are imported or none, with a single fsync
+func (d *DiskStore) importRecords(records []exportRecord) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	batch := NewBatch()
+	for _, record := range records {
+		timestamp := record.time
+		if timestamp.IsZero() {
+			timestamp = now
+		}
+		batch.setAt(record.Key, record.Value, uint32(timestamp.Unix()))
+	}
+	return d.commit(batch)
+}
+
+// jsonlReader returns a function which reads the next record of the JSON Lines, and
+// returns its line
+func jsonlReader(r io.Reader) func() (exportRecord, int, error) {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	line := 0
+	return func() (exportRecord, int, error) {
+		var record struct {
+			Key       *string `json:"key"`
+			Value     string  `json:"value"`
+			Timestamp string  `json:"timestamp"`
+		}
+		line++
+		if err := decoder.Decode(&record); err != nil {
+			return exportRecord{}, line, err
+		}
+		if record.Key == nil {
+			return exportRecord{}, line, errors.New("no key")
+		}
+		return exportRecord{Key: *record.Key, Value: record.Value, Timestamp: record.Timestamp}, line, nil
+	}
+}
+
+// csvReader reads the header row of the CSV, and returns a function which reads the
+// next record, and returns its line. The columns are found by their names in the
+// header, in any order
+func csvReader(r io.Reader) (func() (exportRecord, int, error), error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return func() (exportRecord, int, error) { return exportRecord{}, 0, io.EOF }, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidImport, err)
+	}
+	columns := map[string]int{"key": -1, "value": -1, "timestamp": -1}
+	for i, name := range header {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidImport, name)
+		}
+		columns[name] = i
+	}
+	if columns["key"] < 0 {
+		return nil, fmt.Errorf("%w: no key column", ErrInvalidImport)
+	}
+	return func() (exportRecord, int, error) {
+		row, err := reader.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return exportRecord{}, parseErr.StartLine, parseErr.Err
+		}
+		if err != nil {
+			return exportRecord{}, 0, err
+		}
+		line, _ := reader.FieldPos(0)
+		if len(row) != len(header) {
+			return exportRecord{}, line, fmt.Errorf("%d columns, want %d", len(row), len(header))
+		}
+		field := func(name string) string {
+			if columns[name] < 0 {
+				return ""
+			}
+			return row[columns[name]]
+		}
+		return exportRecord{Key: field("key"), Value: field("value"), Timestamp: field("timestamp")}, line, nil
+	}, nil
+}
@@ -0,0 +1,106 @@
+package caskdb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskStore_Export(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	ts := time.Date(2022, 9, 11, 10, 0, 0, 0, time.UTC)
+	store.SetIfNewer("othello", "shakespeare", ts)
+	store.SetIfNewer("dune", "frank \"herbert\",\nauthor", ts.Add(time.Hour))
+	store.Set("deleted", "value")
+	store.Delete("deleted")
+
+	tests := map[Format]string{
+		FormatJSONL: `{"key":"dune","value":"frank \"herbert\",\nauthor","timestamp":"2022-09-11T11:00:00Z"}
+{"key":"othello","value":"shakespeare","timestamp":"2022-09-11T10:00:00Z"}
+`,
+		FormatCSV: `key,value,timestamp
+dune,"frank ""herbert"",
+author",2022-09-11T11:00:00Z
+othello,shakespeare,2022-09-11T10:00:00Z
+`,
+	}
+	for f, want := range tests {
+		var buf bytes.Buffer
+		if err := store.Export(&buf, f); err != nil {
+			t.Fatalf("Export() error = %v", err)
+		}
+		if buf.String() != want {
+			t.Errorf("Export(%v) = %v, want %v", f, buf.String(), want)
+		}
+
+		// the export imports back with the same values and timestamps
+		imported, err := NewDiskStore("imported.db")
+		if err != nil {
+			t.Fatalf("failed to create disk store: %v", err)
+		}
+		if err := imported.Import(&buf, f); err != nil {
+			t.Fatalf("Import() error = %v", err)
+		}
+		if got, want := storeContents(imported), storeContents(store); got != want {
+			t.Errorf("Import(%v) = %v, want %v", f, got, want)
+		}
+		if meta, _ := imported.Metadata("othello"); !meta.Timestamp.Equal(ts) {
+			t.Errorf("Import(%v) timestamp = %v, want %v", f, meta.Timestamp, ts)
+		}
+		imported.Close()
+		removeStore("imported.db")
+	}
+	if err := store.Export(&bytes.Buffer{}, Format(10)); err != ErrUnknownFormat {
+		t.Errorf("Export() error = %v, want %v", err, ErrUnknownFormat)
+	}
+	store.Close()
+}
+
+func TestDiskStore_Import(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	// the columns can be in any order, and the timestamp is optional
+	csv := "value,key\nshakespeare,othello\nherbert,dune\n"
+	if err := store.Import(strings.NewReader(csv), FormatCSV); err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if got, _ := store.GetMulti([]string{"othello", "dune"}); got["othello"] != "shakespeare" || got["dune"] != "herbert" {
+		t.Errorf("Import() = %v", got)
+	}
+	invalid := map[Format]string{
+		FormatJSONL: `{"key":"hamlet","value":"shakespeare"}` + "\n" + `{"value":"no key"}`,
+		FormatCSV:   "key,author\nhamlet,shakespeare\n",
+	}
+	for f, input := range invalid {
+		if err := store.Import(strings.NewReader(input), f); !errors.Is(err, ErrInvalidImport) {
+			t.Errorf("Import(%v) error = %v, want %v", f, err, ErrInvalidImport)
+		}
+	}
+	bad := `{"key":"hamlet","value":"shakespeare","timestamp":"yesterday"}`
+	if err := store.Import(strings.NewReader(bad), FormatJSONL); !errors.Is(err, ErrInvalidImport) {
+		t.Errorf("Import() error = %v, want %v", err, ErrInvalidImport)
+	}
+	if store.Has("hamlet") {
+		t.Errorf("Import() wrote hamlet from an invalid input")
+	}
+	// a record the store rejects, later in the batch, leaves out the whole batch
+	empty := `{"key":"hamlet","value":"shakespeare"}` + "\n" + `{"key":"","value":"nobody"}`
+	err = store.Import(strings.NewReader(empty), FormatJSONL)
+	if !errors.Is(err, ErrInvalidImport) || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Import() error = %v, want %v at line 2", err, ErrInvalidImport)
+	}
+	if store.Has("hamlet") {
+		t.Errorf("Import() wrote hamlet from an invalid batch")
+	}
+	store.Close()
+}
@@ -0,0 +1,63 @@
+package caskdb
+
+import "sort"
+
+// Keys returns all the keys in the store, in sorted order. The expired keys are left
+// out
+func (d *DiskStore) Keys() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	now := uint32(d.now().Unix())
+	keys := make([]string, 0, d.keyDir.len())
+	d.keyDir.each(func(key string, kEntry KeyEntry) error {
+		if !kEntry.expired(now) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// Len returns the number of keys in the store. The expired keys are not counted
+func (d *DiskStore) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	now := uint32(d.now().Unix())
+	n := 0
+	d.keyDir.each(func(_ string, kEntry KeyEntry) error {
+		if !kEntry.expired(now) {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+// Fold calls fn for every key in the store and its value, in the key order, like the
+// fold of the BitCask paper. It stops at the first error, from reading a value or
+// returned by fn, and returns it.
+//
+// Fold walks the keys which exist when it is called. The store is not locked while
+// fn runs, so fn may read and write the store. A key deleted before Fold reaches it
+// is skipped, and a key updated before Fold reaches it is passed with its new value.
+func (d *DiskStore) Fold(fn func(key string, value string) error) error {
+	return d.foldKeys(d.Keys(), fn)
+}
+
+// foldKeys calls fn for every one of the keys which still exists and its value
+func (d *DiskStore) foldKeys(keys []string, fn func(key string, value string) error) error {
+	for _, key := range keys {
+		value, err := d.Get(key)
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package caskdb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiskStore_Keys(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	if keys := store.Keys(); len(keys) != 0 {
+		t.Errorf("Keys() = %v, want []", keys)
+	}
+	store.Set("othello", "shakespeare")
+	store.Set("dune", "frank herbert")
+	store.Set("hamlet", "shakespeare")
+	store.Delete("othello")
+	if keys, want := store.Keys(), []string{"dune", "hamlet"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys() = %v, want %v", keys, want)
+	}
+	store.Close()
+}
+
+func TestDiskStore_Fold(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("othello", "shakespeare")
+	store.Set("dune", "frank herbert")
+	store.Set("hamlet", "shakespeare")
+
+	// fn may write to the store, the deleted keys are skipped
+	var visited []string
+	err = store.Fold(func(key string, value string) error {
+		visited = append(visited, key+"="+value)
+		if key == "dune" {
+			store.Delete("hamlet")
+			store.Set("othello", "verdi")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Fold() error = %v", err)
+	}
+	if want := []string{"dune=frank herbert", "othello=verdi"}; !reflect.DeepEqual(visited, want) {
+		t.Errorf("Fold() visited %v, want %v", visited, want)
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = store.Fold(func(key string, value string) error {
+		count++
+		return stop
+	})
+	if err != stop || count != 1 {
+		t.Errorf("Fold() = %v after %v calls, want %v after 1", err, count, stop)
+	}
+	store.Close()
+}
+
+func TestDiskStore_Len(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	if n := store.Len(); n != 0 {
+		t.Errorf("Len() = %v, want %v", n, 0)
+	}
+	store.Set("othello", "shakespeare")
+	store.Set("dune", "frank herbert")
+	store.Set("dune", "herbert")
+	store.SetWithTTL("hamlet", "shakespeare", time.Minute)
+	store.Delete("othello")
+	if n := store.Len(); n != 2 {
+		t.Errorf("Len() = %v, want %v", n, 2)
+	}
+	now = now.Add(2 * time.Minute)
+	if n := store.Len(); n != 1 {
+		t.Errorf("Len() after expiry = %v, want %v", n, 1)
+	}
+	store.Close()
+}
@@ -0,0 +1,58 @@
+package format
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+)
+
+// CompressedFlag is set in the value_size field of a record whose value is compressed
+// with DEFLATE (compress/flate). It is the bit below ValuePointerFlag, and the two
+// combine: a value moved out to a value log can be compressed too, then the value in
+// the value log is the compressed one. The rest of the value_size field is the size
+// of the compressed value, or of the pointer:
+//
+//	┌─────┬───────────┬────────┬──────────┬───────────────────────┬─────┬──────────────────┐
+//	│ crc │ timestamp │ expiry │ key_size │ value_size(flag|size) │ key │ compressed value │
+//	└─────┴───────────┴────────┴──────────┴───────────────────────┴─────┴──────────────────┘
+//
+// DEFLATE is in the standard library, and text and JSON values shrink by a half or
+// more with it. Like with ValuePointerFlag, a tombstone has all the bits set, and is
+// not compressed.
+const CompressedFlag = 1 << 62
+
+// valueSizeFlags are the bits of value_size which are flags, not the size
+const valueSizeFlags = ValuePointerFlag | CompressedFlag
+
+// IsCompressed tells whether the value size read from a header marks a record with a
+// compressed value
+func IsCompressed(valueSize uint64) bool {
+	return valueSize != TombstoneSize && valueSize&CompressedFlag != 0
+}
+
+// MarkCompressed sets CompressedFlag in the header of the encoded record, whose value
+// was compressed with Compress, and updates its checksum
+func MarkCompressed(data []byte) {
+	valueSize := binary.LittleEndian.Uint64(data[16:24])
+	binary.LittleEndian.PutUint64(data[16:24], valueSize|CompressedFlag)
+	putChecksum(data)
+}
+
+// Compress compresses the value with DEFLATE
+func Compress(value []byte) []byte {
+	var buf bytes.Buffer
+	// NewWriter fails only for an invalid level, and writing to a bytes.Buffer
+	// does not fail
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	w.Write(value)
+	w.Close()
+	return buf.Bytes()
+}
+
+// Decompress decompresses the value compressed with Compress
+func Decompress(value []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(value))
+	defer r.Close()
+	return io.ReadAll(r)
+}
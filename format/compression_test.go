@@ -0,0 +1,45 @@
+package format
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMarkCompressed(t *testing.T) {
+	value := []byte(strings.Repeat(`{"title": "dune", "author": "frank herbert"}`, 10))
+	compressed := Compress(value)
+	if len(compressed) >= len(value) {
+		t.Errorf("Compress() = %d bytes, want less than %d", len(compressed), len(value))
+	}
+	size, data := EncodeKVWithExpiry(1000, 2000, "dune", string(compressed))
+	MarkCompressed(data)
+	if err := VerifyChecksum(data); err != nil {
+		t.Fatalf("VerifyChecksum() error = %v", err)
+	}
+	_, _, keySize, valueSize := DecodeHeader(Version, data)
+	if !IsCompressed(valueSize) || IsValuePointer(valueSize) || RecordSize(Version, keySize, valueSize) != size {
+		t.Errorf("the header does not mark a compressed value of size %v", size)
+	}
+	got, err := Decompress(data[HeaderSize+keySize:])
+	if err != nil {
+		t.Fatalf("Decompress() error = %v", err)
+	}
+	if !bytes.Equal(got, value) {
+		t.Errorf("Decompress() = %q, want %q", got, value)
+	}
+
+	// a value pointer can be compressed too
+	size, data = EncodeValuePointer(1000, 0, "dune", NewValuePointer(1, 0, compressed))
+	MarkCompressed(data)
+	_, _, keySize, valueSize = DecodeHeader(Version, data)
+	if !IsCompressed(valueSize) || !IsValuePointer(valueSize) || RecordSize(Version, keySize, valueSize) != size {
+		t.Errorf("the header does not mark a compressed value pointer of size %v", size)
+	}
+	if IsCompressed(TombstoneSize) {
+		t.Errorf("IsCompressed(TombstoneSize) = true, want false")
+	}
+	if _, err := Decompress([]byte("not deflate")); err == nil {
+		t.Errorf("Decompress() of garbage, want an error")
+	}
+}
@@ -0,0 +1,97 @@
+package format
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+)
+
+// EncryptedFlag is set in the key_size field of a record whose key and value are
+// encrypted with AES-GCM. The header stays in the clear, since it is needed to find
+// the records in the file, but it is authenticated along with the rest of the record,
+// so it cannot be changed without failing the decryption. The key and the value are
+// encrypted together, with a random nonce for every record, which is stored before
+// them, and the GCM tag after them:
+//
+//	┌─────┬───────────┬────────┬─────────────────────┬────────────┬────────────┬─────────────────────────┬──────────┐
+//	│ crc │ timestamp │ expiry │ key_size(flag|size) │ value_size │ nonce(12B) │ encrypted key and value │ tag(16B) │
+//	└─────┴───────────┴────────┴─────────────────────┴────────────┴────────────┴─────────────────────────┴──────────┘
+//
+// The sizes in the header are the sizes of the key and the value, as in a record
+// which is not encrypted, and the record is EncryptionOverhead bytes larger. The crc
+// covers the encrypted record, so the corrupt records are found without the
+// encryption key. Taking the top bit of key_size limits the keys to 2GB. A batch
+// record has all the bits set, and is not encrypted itself, but the records in it are.
+const EncryptedFlag = 0x80000000
+
+// NonceSize is the size of the nonce of AES-GCM
+const NonceSize = 12
+
+// EncryptionOverhead is how much larger encrypting makes a record, or a value: the
+// nonce and the GCM tag
+const EncryptionOverhead = NonceSize + 16
+
+// ErrDecryptionFailed is returned when a record cannot be decrypted, because the
+// encryption key is not the one it was encrypted with, or the record was tampered with
+var ErrDecryptionFailed = errors.New("failed to decrypt, wrong encryption key or tampered data")
+
+// IsEncrypted tells whether the key size read from a header marks an encrypted record
+func IsEncrypted(keySize uint32) bool {
+	return keySize != BatchKeySize && keySize&EncryptedFlag != 0
+}
+
+// EncryptRecord encrypts the encoded record with the AES-GCM cipher, and returns the
+// encrypted record
+func EncryptRecord(aead cipher.AEAD, data []byte) []byte {
+	header := make([]byte, HeaderSize, len(data)+EncryptionOverhead)
+	copy(header, data[:HeaderSize])
+	keySize := binary.LittleEndian.Uint32(header[12:16])
+	binary.LittleEndian.PutUint32(header[12:16], keySize|EncryptedFlag)
+	encrypted := append(header, Seal(aead, data[HeaderSize:], header[4:HeaderSize])...)
+	putChecksum(encrypted)
+	return encrypted
+}
+
+// DecryptRecord decrypts the record in the version of the format encrypted with
+// EncryptRecord, and returns the record as it was before encrypting it
+func DecryptRecord(aead cipher.AEAD, version uint32, data []byte) ([]byte, error) {
+	headerSize := HeaderSizeOf(version)
+	plain, err := Open(aead, data[headerSize:], data[4:headerSize])
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, headerSize, headerSize+len(plain))
+	copy(header, data[:headerSize])
+	keySize := binary.LittleEndian.Uint32(header[12:16])
+	binary.LittleEndian.PutUint32(header[12:16], keySize&^EncryptedFlag)
+	decrypted := append(header, plain...)
+	putChecksum(decrypted)
+	return decrypted, nil
+}
+
+// Seal encrypts and authenticates the data with the AES-GCM cipher, and authenticates
+// the additional data along with it. The nonce is random, and is returned before the
+// encrypted data
+func Seal(aead cipher.AEAD, data []byte, additionalData []byte) []byte {
+	nonce := make([]byte, NonceSize, NonceSize+len(data)+aead.Overhead())
+	// the nonce must never repeat for a key, 96 random bits make it unlikely
+	// enough for far more records than a store has
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return aead.Seal(nonce, nonce, data, additionalData)
+}
+
+// Open decrypts the data sealed with Seal, and returns ErrDecryptionFailed if it, or
+// the additional data, is not what was sealed
+func Open(aead cipher.AEAD, sealed []byte, additionalData []byte) ([]byte, error) {
+	if len(sealed) < NonceSize {
+		return nil, ErrDecryptionFailed
+	}
+	data, err := aead.Open(nil, sealed[:NonceSize], sealed[NonceSize:], additionalData)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return data, nil
+}
@@ -0,0 +1,86 @@
+package format
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+func newTestCipher(t *testing.T, key byte) cipher.AEAD {
+	block, err := aes.NewCipher(bytes.Repeat([]byte{key}, 32))
+	if err != nil {
+		t.Fatalf("NewCipher() error = %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatalf("NewGCM() error = %v", err)
+	}
+	return aead
+}
+
+func TestEncryptRecord(t *testing.T) {
+	aead := newTestCipher(t, 1)
+	size, data := EncodeKVWithExpiry(1000, 2000, "dune", "frank herbert")
+	encrypted := EncryptRecord(aead, data)
+	if len(encrypted) != size+EncryptionOverhead {
+		t.Errorf("EncryptRecord() = %d bytes, want %d", len(encrypted), size+EncryptionOverhead)
+	}
+	if err := VerifyChecksum(encrypted); err != nil {
+		t.Fatalf("VerifyChecksum() error = %v", err)
+	}
+	if bytes.Contains(encrypted, []byte("dune")) || bytes.Contains(encrypted, []byte("frank")) {
+		t.Errorf("EncryptRecord() has the key or the value in the clear")
+	}
+	_, _, keySize, valueSize := DecodeHeader(Version, encrypted)
+	if !IsEncrypted(keySize) || RecordSize(Version, keySize, valueSize) != len(encrypted) {
+		t.Errorf("the header does not mark an encrypted record of size %v", len(encrypted))
+	}
+	record, err := NewReader(bytes.NewReader(stream(encrypted))).Next()
+	if err != nil || !record.Encrypted || record.Key != "" {
+		t.Errorf("Next() = %+v, %v, want an encrypted record", record, err)
+	}
+
+	decrypted, err := DecryptRecord(aead, Version, encrypted)
+	if err != nil {
+		t.Fatalf("DecryptRecord() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Errorf("DecryptRecord() = %v, want %v", decrypted, data)
+	}
+	if _, err := DecryptRecord(newTestCipher(t, 2), Version, encrypted); err != ErrDecryptionFailed {
+		t.Errorf("DecryptRecord() with another key error = %v, want %v", err, ErrDecryptionFailed)
+	}
+	// the header is authenticated too
+	encrypted[8]++
+	if _, err := DecryptRecord(aead, Version, encrypted); err != ErrDecryptionFailed {
+		t.Errorf("DecryptRecord() of a changed header error = %v, want %v", err, ErrDecryptionFailed)
+	}
+
+	_, tombstone := EncodeTombstone(1000, "dune")
+	encrypted = EncryptRecord(aead, tombstone)
+	_, _, keySize, valueSize = DecodeHeader(Version, encrypted)
+	if !IsTombstone(valueSize) || RecordSize(Version, keySize, valueSize) != len(encrypted) {
+		t.Errorf("the header does not mark an encrypted tombstone of size %v", len(encrypted))
+	}
+	if IsEncrypted(BatchKeySize) {
+		t.Errorf("IsEncrypted(BatchKeySize) = true, want false")
+	}
+}
+
+func TestSeal(t *testing.T) {
+	aead := newTestCipher(t, 1)
+	sealed := Seal(aead, []byte("frank herbert"), []byte("dune"))
+	if again := Seal(aead, []byte("frank herbert"), []byte("dune")); bytes.Equal(sealed, again) {
+		t.Errorf("Seal() of the same data twice, want different nonces")
+	}
+	if data, err := Open(aead, sealed, []byte("dune")); err != nil || string(data) != "frank herbert" {
+		t.Errorf("Open() = %q, %v, want %q", data, err, "frank herbert")
+	}
+	if _, err := Open(aead, sealed, []byte("emma")); err != ErrDecryptionFailed {
+		t.Errorf("Open() with other additional data error = %v, want %v", err, ErrDecryptionFailed)
+	}
+	if _, err := Open(aead, []byte("short"), nil); err != ErrDecryptionFailed {
+		t.Errorf("Open() error = %v, want %v", err, ErrDecryptionFailed)
+	}
+}
@@ -0,0 +1,91 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// A data file starts with a file header, which tells that the file is a caskdb data
+// file, and which version of the format its records are in:
+//
+//	┌───────────────┬────────────┬────────────────┬─────────┐
+//	│ magic("CASK") │ version(4) │ created_at(4B) │ crc(4B) │
+//	└───────────────┴────────────┴────────────────┴─────────┘
+//
+// created_at is when the file was created, in unix epoch seconds, and crc is the
+// CRC32 checksum of the fields before it. The records follow the header.
+//
+// The version lets the format change without breaking the old stores: a new version
+// of caskdb can tell the old files from the new ones and read both, and an old version
+// refuses to open the files newer than what it knows, instead of reading garbage.
+//
+// The files written before the header was added do not have it, and start with their
+// first record right away. They are version 0. A record starts with its crc, which
+// could be "CASK" by chance, but the crc of the header would have to match too.
+
+// FileHeaderSize is the size of the file header
+const FileHeaderSize = 16
+
+// Version is the version of the format written by this package. The versions are:
+//
+//	0 - the files without a header
+//	1 - the files with the header, the records are the same as in version 0
+//	2 - value_size in the header of the records is 8 bytes instead of 4, along with
+//	    the size in the value pointers, so the values can be larger than 4GB
+const Version = 2
+
+// fileMagic are the bytes a data file starts with
+var fileMagic = []byte("CASK")
+
+// ErrUnsupportedVersion is returned for the files in a newer version of the format
+// than this package knows
+var ErrUnsupportedVersion = errors.New("unsupported format version")
+
+// FileHeader is the header of a data file
+type FileHeader struct {
+	// Version is the version of the format of the file, 0 if the file has no header
+	Version uint32
+	// CreatedAt is when the file was created, in unix epoch seconds
+	CreatedAt uint32
+}
+
+// EncodeFileHeader encodes the header of a data file created at createdAt, in the
+// current Version
+func EncodeFileHeader(createdAt uint32) []byte {
+	data := make([]byte, 0, FileHeaderSize)
+	data = append(data, fileMagic...)
+	data = binary.LittleEndian.AppendUint32(data, Version)
+	data = binary.LittleEndian.AppendUint32(data, createdAt)
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+}
+
+// DecodeFileHeader decodes the header from the start of a data file. A file which
+// does not start with a header, or is too short to have one, is of version 0. It
+// returns ErrUnsupportedVersion if the version is newer than Version
+func DecodeFileHeader(data []byte) (FileHeader, error) {
+	if len(data) < FileHeaderSize || !bytes.Equal(data[:4], fileMagic) {
+		return FileHeader{}, nil
+	}
+	if crc32.ChecksumIEEE(data[:12]) != binary.LittleEndian.Uint32(data[12:16]) {
+		return FileHeader{}, nil
+	}
+	header := FileHeader{
+		Version:   binary.LittleEndian.Uint32(data[4:8]),
+		CreatedAt: binary.LittleEndian.Uint32(data[8:12]),
+	}
+	if header.Version > Version {
+		return FileHeader{}, fmt.Errorf("%w %d, the latest known is %d", ErrUnsupportedVersion, header.Version, Version)
+	}
+	return header, nil
+}
+
+// Size returns the size of the header in the file, zero if it has none
+func (h FileHeader) Size() int {
+	if h.Version == 0 {
+		return 0
+	}
+	return FileHeaderSize
+}
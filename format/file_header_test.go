@@ -0,0 +1,71 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"testing"
+)
+
+func TestDecodeFileHeader(t *testing.T) {
+	data := EncodeFileHeader(1000)
+	if len(data) != FileHeaderSize {
+		t.Fatalf("EncodeFileHeader() = %d bytes, want %d", len(data), FileHeaderSize)
+	}
+	header, err := DecodeFileHeader(data)
+	if err != nil {
+		t.Fatalf("DecodeFileHeader() error = %v", err)
+	}
+	if header.Version != Version || header.CreatedAt != 1000 || header.Size() != FileHeaderSize {
+		t.Errorf("DecodeFileHeader() = %+v, want version %v created at 1000", header, Version)
+	}
+
+	// the files without a header are version 0, and start with a record
+	_, record := EncodeKV(1000, "hello", "world")
+	corrupt := append([]byte(nil), data...)
+	corrupt[9] ^= 0xFF
+	for _, legacy := range [][]byte{record, nil, data[:FileHeaderSize-1], corrupt} {
+		header, err := DecodeFileHeader(legacy)
+		if err != nil || header.Version != 0 || header.Size() != 0 {
+			t.Errorf("DecodeFileHeader(%x) = %+v, %v, want version 0", legacy, header, err)
+		}
+	}
+
+	newer := append([]byte(nil), data[:12]...)
+	binary.LittleEndian.PutUint32(newer[4:8], Version+1)
+	newer = binary.LittleEndian.AppendUint32(newer, crc32.ChecksumIEEE(newer))
+	if _, err := DecodeFileHeader(newer); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("DecodeFileHeader() error = %v, want %v", err, ErrUnsupportedVersion)
+	}
+}
+
+func TestReader_Header(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(EncodeFileHeader(1000))
+	writer := NewWriter(&buf)
+	if _, err := writer.Write(10, "hello", "world"); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	reader := NewReader(bytes.NewReader(buf.Bytes()))
+	record, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	if record.Key != "hello" || record.Offset != FileHeaderSize {
+		t.Errorf("Next() = %+v, want hello at offset %v", record, FileHeaderSize)
+	}
+	if header, _ := reader.Header(); header.Version != Version || header.CreatedAt != 1000 {
+		t.Errorf("Header() = %+v, want version %v created at 1000", header, Version)
+	}
+
+	// the records of a file without a header start right away
+	reader = NewReader(bytes.NewReader(encodeKVV1(10, 0, "hello", "world")))
+	if header, err := reader.Header(); err != nil || header.Version != 0 {
+		t.Errorf("Header() = %+v, %v, want version 0", header, err)
+	}
+	if record, err := reader.Next(); err != nil || record.Offset != 0 {
+		t.Errorf("Next() = %+v, %v, want a record at offset 0", record, err)
+	}
+}
@@ -0,0 +1,292 @@
+// Package format provides encode/decode functions for serialisation and
+// deserialisation of the records in a caskdb data file, along with a streaming Reader
+// and Writer over them. External tools (inspectors, converters, fuzzers etc.) can use
+// it to parse caskdb files without re-implementing the format.
+//
+// format methods are generic and does not have any disk or memory specific code.
+//
+// The disk storage deals with bytes; you cannot just store a string or object without
+// converting it to bytes. The programming languages provide abstractions where you
+// don't have to think about all this when storing things in memory (i.e. RAM).
+// Consider the following example where you are storing stuff in a hash table:
+//
+//	books = {}
+//	books["hamlet"] = "shakespeare"
+//	books["anna karenina"] = "tolstoy"
+//
+// In the above, the language deals with all the complexities:
+//
+//   - allocating space on the RAM so that it can store data of `books`
+//   - whenever you add data to `books`, convert that to bytes and keep it in the memory
+//   - whenever the size of `books` increases, move that to somewhere in the RAM so that
+//     we can add new items
+//
+// Unfortunately, when it comes to disks, we have to do all this by ourselves, write
+// code which can allocate space, convert objects to/from bytes and many other operations.
+//
+// This package has two functions which help us with serialisation of data.
+//
+//	EncodeKV - takes the key value pair and encodes them into bytes
+//	DecodeKV - takes a bunch of bytes and decodes them into key value pairs
+//
+// **workshop note**
+//
+// For the workshop, the functions will have the following signature:
+//
+//	func encodeKV(timestamp uint32, key string, value string) (int, []byte)
+//	func decodeKV(data []byte) (uint32, string, string)
+package format
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+)
+
+// HeaderSize specifies the total header size. Our key value pair, when stored on disk
+// looks like this:
+//
+//	┌─────┬───────────┬────────┬──────────┬────────────┬─────┬───────┐
+//	│ crc │ timestamp │ expiry │ key_size │ value_size │ key │ value │
+//	└─────┴───────────┴────────┴──────────┴────────────┴─────┴───────┘
+//
+// This is analogous to a typical database's row (or a record). The total length of
+// the row is variable, depending on the contents of the key and value.
+//
+// The first five fields form the header:
+//
+//	┌─────────┬───────────────┬────────────┬──────────────┬────────────────┐
+//	│ crc(4B) │ timestamp(4B) │ expiry(4B) │ key_size(4B) │ value_size(8B) │
+//	└─────────┴───────────────┴────────────┴──────────────┴────────────────┘
+//
+// The first four fields store unsigned integers of size 4 bytes, and value_size one
+// of 8 bytes, giving our header a fixed length of 24 bytes. Timestamp field stores
+// the time the record we inserted in unix epoch seconds. Expiry field stores the
+// time, in unix epoch seconds, after which the record is expired and treated as
+// deleted, or zero if the record never expires. Key size and value size fields store
+// the length of bytes occupied by the key and value. The maximum integer stored by 4
+// bytes is 4,294,967,295 (2 ** 32 - 1), roughly ~4.2GB, which bounds the keys (check
+// MaxKeySize), while 8 bytes leave no practical limit to the values.
+//
+// The files of the versions 0 and 1 of the format have a value_size of 4 bytes, and a
+// header of HeaderSizeV1 bytes, which limits the values to ~4.2GB. They are still
+// read, but the new records are always written in the current version. Check
+// file_header.go for the versions.
+//
+// The crc field stores the CRC32 checksum of the rest of the record: the other header
+// fields, the key and the value. Disks and file systems can corrupt the data silently,
+// a bit flipped here and there, or a write which did not complete. Whenever we read a
+// record, we compute its checksum again and compare it with the stored one, so we
+// never return corrupt data as if it were valid.
+const HeaderSize = 24
+
+// HeaderSizeV1 is the header size of the records of the versions 0 and 1 of the
+// format, whose value_size is 4 bytes
+const HeaderSizeV1 = 20
+
+// HeaderSizeOf returns the header size of the records in the version of the format
+func HeaderSizeOf(version uint32) int {
+	if version < 2 {
+		return HeaderSizeV1
+	}
+	return HeaderSize
+}
+
+var ErrChecksumMismatch = errors.New("record checksum mismatch")
+
+// TombstoneSize is stored in the value_size field of a tombstone record. A tombstone
+// marks the deletion of a key: since the file is append only, we cannot remove the
+// key's records, instead we append a record saying that the key is deleted. The
+// tombstone has no value, only the key:
+//
+//	┌─────┬───────────┬───────────┬──────────┬──────────────────────────┬─────┐
+//	│ crc │ timestamp │ expiry(0) │ key_size │ value_size(all bits set) │ key │
+//	└─────┴───────────┴───────────┴──────────┴──────────────────────────┴─────┘
+//
+// A real value can never be this large, since it would have the flag bits set too
+// (check ValuePointerFlag and CompressedFlag), so this does not take away any valid
+// value size. In the versions 0 and 1, value_size is 0xFFFFFFFF.
+const TombstoneSize = math.MaxUint64
+
+// the value_size of a tombstone and its flags in the versions 0 and 1 of the
+// format, where it is 4 bytes
+const (
+	v1TombstoneSize    = 0xFFFFFFFF
+	v1ValuePointerFlag = 0x80000000
+	v1CompressedFlag   = 0x40000000
+	v1ValueSizeFlags   = v1ValuePointerFlag | v1CompressedFlag
+)
+
+// BatchKeySize is stored in the key_size field of a batch record. A batch record
+// groups several records which are written together, so that after a crash either
+// all of them are on the disk or none. It has no key, and its value is the records
+// of the batch, encoded one after another as usual:
+//
+//	┌─────┬───────────┬───────────┬──────────────────────┬────────────┬──────────┬──────────┬─────┐
+//	│ crc │ timestamp │ expiry(0) │ key_size(0xFFFFFFFF) │ value_size │ record 1 │ record 2 │ ... │
+//	└─────┴───────────┴───────────┴──────────────────────┴────────────┴──────────┴──────────┴─────┘
+//
+// The crc of the batch record covers all the records in it, so a batch which was not
+// written completely fails the checksum as a whole. The records in the batch have
+// their own crc too, and can be read on their own, like any other record.
+const BatchKeySize = 0xFFFFFFFF
+
+// MaxKeySize is the largest key a record can have. The top bit of key_size is
+// EncryptedFlag, which leaves 31 bits for the size, so the keys are limited to 2GB
+const MaxKeySize = EncryptedFlag - 1
+
+// MaxValueSize is the largest value a record can have. The top two bits of
+// value_size are ValuePointerFlag and CompressedFlag, which leave 62 bits for the
+// size. A larger value_size would be taken for the flags, or for a tombstone
+const MaxValueSize = CompressedFlag - 1
+
+// MaxBatchSize is the largest total size of the records in a batch, which the batch
+// record has in its value_size like a value
+const MaxBatchSize = MaxValueSize
+
+// widenValueSize returns the value_size of the versions 0 and 1 of the format, which is
+// 4 bytes, as the value_size of the current version, with the same flags
+func widenValueSize(valueSize uint32) uint64 {
+	if valueSize == v1TombstoneSize {
+		return TombstoneSize
+	}
+	return uint64(valueSize&^v1ValueSizeFlags) | uint64(valueSize&v1ValueSizeFlags)<<32
+}
+
+// EncodeHeader encodes the header fields into HeaderSize bytes. The crc field is left
+// empty, it is filled once the whole record is encoded
+func EncodeHeader(timestamp uint32, expiry uint32, keySize uint32, valueSize uint64) []byte {
+	header := make([]byte, HeaderSize)
+	binary.LittleEndian.PutUint32(header[4:8], timestamp)
+	binary.LittleEndian.PutUint32(header[8:12], expiry)
+	binary.LittleEndian.PutUint32(header[12:16], keySize)
+	binary.LittleEndian.PutUint64(header[16:24], valueSize)
+	return header
+}
+
+// DecodeHeader decodes the header fields of a record in the version of the format,
+// from its first HeaderSizeOf(version) bytes: the timestamp, the expiry, the key size
+// and the value size. The value size of the older versions is returned as it would be
+// in the current one, so the same functions tell its flags
+func DecodeHeader(version uint32, header []byte) (uint32, uint32, uint32, uint64) {
+	timestamp := binary.LittleEndian.Uint32(header[4:8])
+	expiry := binary.LittleEndian.Uint32(header[8:12])
+	keySize := binary.LittleEndian.Uint32(header[12:16])
+	if version < 2 {
+		return timestamp, expiry, keySize, widenValueSize(binary.LittleEndian.Uint32(header[16:20]))
+	}
+	return timestamp, expiry, keySize, binary.LittleEndian.Uint64(header[16:24])
+}
+
+// putChecksum computes the checksum of the encoded record and stores it in the crc
+// field
+func putChecksum(data []byte) {
+	binary.LittleEndian.PutUint32(data[0:4], crc32.ChecksumIEEE(data[4:]))
+}
+
+// VerifyChecksum checks the crc field of the encoded record against the rest of it,
+// and returns ErrChecksumMismatch if they don't match
+func VerifyChecksum(data []byte) error {
+	if len(data) < 4 {
+		return ErrChecksumMismatch
+	}
+	if binary.LittleEndian.Uint32(data[0:4]) != crc32.ChecksumIEEE(data[4:]) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// EncodeKV encodes the record into bytes, and returns the size of the record with them.
+// The record never expires
+func EncodeKV(timestamp uint32, key string, value string) (int, []byte) {
+	return EncodeKVWithExpiry(timestamp, 0, key, value)
+}
+
+// EncodeKVWithExpiry is like EncodeKV, but the record expires at expiry, in unix epoch
+// seconds. An expiry of zero never expires
+func EncodeKVWithExpiry(timestamp uint32, expiry uint32, key string, value string) (int, []byte) {
+	header := EncodeHeader(timestamp, expiry, uint32(len(key)), uint64(len(value)))
+	data := append(append(header, key...), value...)
+	putChecksum(data)
+	return len(data), data
+}
+
+// EncodeTombstone encodes a tombstone record for the key into bytes, and returns the
+// size of the record with them
+func EncodeTombstone(timestamp uint32, key string) (int, []byte) {
+	data := append(EncodeHeader(timestamp, 0, uint32(len(key)), TombstoneSize), key...)
+	putChecksum(data)
+	return len(data), data
+}
+
+// EncodeBatch encodes a batch record with the records, which are encoded with EncodeKV
+// and EncodeTombstone and appended one after another, and returns the size of the
+// batch record with them
+func EncodeBatch(timestamp uint32, records []byte) (int, []byte) {
+	data := append(EncodeHeader(timestamp, 0, BatchKeySize, uint64(len(records))), records...)
+	putChecksum(data)
+	return len(data), data
+}
+
+// IsTombstone tells whether the value size read from a header marks a tombstone
+func IsTombstone(valueSize uint64) bool {
+	return valueSize == TombstoneSize
+}
+
+// IsBatch tells whether the key size read from a header marks a batch record
+func IsBatch(keySize uint32) bool {
+	return keySize == BatchKeySize
+}
+
+// RecordSize returns the total size of the record in the version of the format, header
+// included, from the key and value sizes read from its header
+func RecordSize(version uint32, keySize uint32, valueSize uint64) int {
+	if IsBatch(keySize) {
+		return HeaderSizeOf(version) + int(valueSize&^valueSizeFlags)
+	}
+	size := HeaderSizeOf(version) + int(keySize&^EncryptedFlag)
+	if IsEncrypted(keySize) {
+		size += EncryptionOverhead
+	}
+	if IsTombstone(valueSize) {
+		return size
+	}
+	return size + int(valueSize&^valueSizeFlags)
+}
+
+// DecodeKV decodes the record from the bytes returned by EncodeKV, in the current
+// version of the format. It returns ErrChecksumMismatch if the record is corrupt. For
+// a record with a value pointer, the value is the encoded pointer, and for a
+// compressed record, the compressed value. An encrypted record must be decrypted with
+// DecryptRecord first
+func DecodeKV(data []byte) (uint32, string, string, error) {
+	if len(data) < HeaderSize {
+		return 0, "", "", ErrChecksumMismatch
+	}
+	if err := VerifyChecksum(data); err != nil {
+		return 0, "", "", err
+	}
+	timestamp, _, keySize, valueSize := DecodeHeader(Version, data[0:HeaderSize])
+	// the checksum covers the bytes we were given, which may still be fewer than
+	// the header says
+	if uint64(keySize) > uint64(len(data)) || RecordSize(Version, keySize, valueSize) > len(data) {
+		return 0, "", "", ErrChecksumMismatch
+	}
+	key := string(data[HeaderSize : HeaderSize+keySize])
+	value := string(data[HeaderSize+keySize : RecordSize(Version, keySize, valueSize)])
+	return timestamp, key, value, nil
+}
+
+// UpgradeRecord returns the record in the version of the format as a record of the
+// current version, with the same fields. The record must not be a batch or encrypted,
+// an encrypted record is decrypted with DecryptRecord first
+func UpgradeRecord(version uint32, data []byte) []byte {
+	if version >= Version {
+		return data
+	}
+	timestamp, expiry, keySize, valueSize := DecodeHeader(version, data)
+	upgraded := append(EncodeHeader(timestamp, expiry, keySize, valueSize), data[HeaderSizeV1:]...)
+	putChecksum(upgraded)
+	return upgraded
+}
@@ -0,0 +1,228 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func TestEncodeHeader(t *testing.T) {
+	tests := []struct {
+		timestamp uint32
+		expiry    uint32
+		keySize   uint32
+		valueSize uint64
+	}{
+		{10, 10, 10, 10},
+		{0, 0, 0, 0},
+		{10000, 20000, 10000, 10000},
+		{10, 0, 10, 1 << 40},
+	}
+	for _, tt := range tests {
+		data := EncodeHeader(tt.timestamp, tt.expiry, tt.keySize, tt.valueSize)
+		timestamp, expiry, keySize, valueSize := DecodeHeader(Version, data)
+		if timestamp != tt.timestamp {
+			t.Errorf("EncodeHeader() timestamp = %v, want %v", timestamp, tt.timestamp)
+		}
+		if expiry != tt.expiry {
+			t.Errorf("EncodeHeader() expiry = %v, want %v", expiry, tt.expiry)
+		}
+		if keySize != tt.keySize {
+			t.Errorf("EncodeHeader() keySize = %v, want %v", keySize, tt.keySize)
+		}
+		if valueSize != tt.valueSize {
+			t.Errorf("EncodeHeader() valueSize = %v, want %v", valueSize, tt.valueSize)
+		}
+	}
+}
+
+func TestEncodeKV(t *testing.T) {
+	tests := []struct {
+		timestamp uint32
+		key       string
+		value     string
+		size      int
+	}{
+		{10, "hello", "world", HeaderSize + 10},
+		{0, "", "", HeaderSize},
+		{100, "🔑", "", HeaderSize + 4},
+	}
+	for _, tt := range tests {
+		size, data := EncodeKV(tt.timestamp, tt.key, tt.value)
+		timestamp, key, value, err := DecodeKV(data)
+		if err != nil {
+			t.Fatalf("DecodeKV() error = %v", err)
+		}
+		if timestamp != tt.timestamp {
+			t.Errorf("EncodeKV() timestamp = %v, want %v", timestamp, tt.timestamp)
+		}
+		if key != tt.key {
+			t.Errorf("EncodeKV() key = %v, want %v", key, tt.key)
+		}
+		if value != tt.value {
+			t.Errorf("EncodeKV() value = %v, want %v", value, tt.value)
+		}
+		if size != tt.size {
+			t.Errorf("EncodeKV() size = %v, want %v", size, tt.size)
+		}
+	}
+}
+
+func TestEncodeKVWithExpiry(t *testing.T) {
+	size, data := EncodeKVWithExpiry(10, 70, "hello", "world")
+	if size != HeaderSize+10 || len(data) != size {
+		t.Errorf("EncodeKVWithExpiry() size = %v, want %v", size, HeaderSize+10)
+	}
+	if _, expiry, _, _ := DecodeHeader(Version, data); expiry != 70 {
+		t.Errorf("EncodeKVWithExpiry() expiry = %v, want %v", expiry, 70)
+	}
+	if _, key, value, err := DecodeKV(data); err != nil || key != "hello" || value != "world" {
+		t.Errorf("DecodeKV() = %v, %v, %v, want hello, world, <nil>", key, value, err)
+	}
+	if _, data := EncodeKV(10, "hello", "world"); data[8] != 0 {
+		t.Errorf("EncodeKV() expiry = %v, want %v", data[8], 0)
+	}
+}
+
+func TestDecodeKVCorrupt(t *testing.T) {
+	_, data := EncodeKV(10, "hello", "world")
+	for i := range data {
+		corrupt := append([]byte{}, data...)
+		corrupt[i] ^= 0x01
+		if _, _, _, err := DecodeKV(corrupt); err != ErrChecksumMismatch {
+			t.Errorf("DecodeKV() with byte %v flipped error = %v, want %v", i, err, ErrChecksumMismatch)
+		}
+	}
+}
+
+func TestDecodeKVShort(t *testing.T) {
+	_, data := EncodeKV(10, "hello", "world")
+	// a record cut short, with the checksum of what is left
+	short := append([]byte{}, data[:len(data)-2]...)
+	binary.LittleEndian.PutUint32(short[0:4], crc32.ChecksumIEEE(short[4:]))
+	for _, data := range [][]byte{nil, {1, 2}, data[:HeaderSize-1], short} {
+		if _, _, _, err := DecodeKV(data); err != ErrChecksumMismatch {
+			t.Errorf("DecodeKV() of %v bytes error = %v, want %v", len(data), err, ErrChecksumMismatch)
+		}
+	}
+	if err := VerifyChecksum([]byte{1, 2}); err != ErrChecksumMismatch {
+		t.Errorf("VerifyChecksum() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+}
+
+func TestEncodeTombstone(t *testing.T) {
+	size, data := EncodeTombstone(10, "hello")
+	if size != HeaderSize+5 || len(data) != size {
+		t.Errorf("EncodeTombstone() size = %v, want %v", size, HeaderSize+5)
+	}
+	timestamp, _, keySize, valueSize := DecodeHeader(Version, data)
+	if timestamp != 10 {
+		t.Errorf("EncodeTombstone() timestamp = %v, want %v", timestamp, 10)
+	}
+	if !IsTombstone(valueSize) {
+		t.Errorf("IsTombstone() = %v, want %v", false, true)
+	}
+	if got := RecordSize(Version, keySize, valueSize); got != size {
+		t.Errorf("RecordSize(Version, ) = %v, want %v", got, size)
+	}
+	if string(data[HeaderSize:]) != "hello" {
+		t.Errorf("EncodeTombstone() key = %v, want %v", string(data[HeaderSize:]), "hello")
+	}
+}
+
+func TestEncodeBatch(t *testing.T) {
+	_, set := EncodeKV(10, "hello", "world")
+	_, tombstone := EncodeTombstone(10, "bye")
+	records := append(append([]byte{}, set...), tombstone...)
+	size, data := EncodeBatch(10, records)
+	if size != HeaderSize+len(records) || len(data) != size {
+		t.Errorf("EncodeBatch() size = %v, want %v", size, HeaderSize+len(records))
+	}
+	if err := VerifyChecksum(data); err != nil {
+		t.Errorf("VerifyChecksum() error = %v", err)
+	}
+	_, _, keySize, valueSize := DecodeHeader(Version, data)
+	if !IsBatch(keySize) {
+		t.Errorf("IsBatch() = %v, want %v", false, true)
+	}
+	if got := RecordSize(Version, keySize, valueSize); got != size {
+		t.Errorf("RecordSize(Version, ) = %v, want %v", got, size)
+	}
+	// the records in the batch can be read on their own
+	if _, key, value, err := DecodeKV(data[HeaderSize : HeaderSize+len(set)]); err != nil || key != "hello" || value != "world" {
+		t.Errorf("DecodeKV() = %v, %v, %v, want hello, world, <nil>", key, value, err)
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	// the largest sizes must not be taken for the flags or the special sizes
+	if IsEncrypted(MaxKeySize) || IsBatch(MaxKeySize) {
+		t.Errorf("MaxKeySize %x is taken for a flag", MaxKeySize)
+	}
+	if IsTombstone(MaxValueSize) || IsValuePointer(MaxValueSize) || IsCompressed(MaxValueSize) {
+		t.Errorf("MaxValueSize %x is taken for a flag", MaxValueSize)
+	}
+	if got := RecordSize(Version, MaxKeySize, MaxValueSize); got != HeaderSize+MaxKeySize+MaxValueSize {
+		t.Errorf("RecordSize() = %v, want %v", got, HeaderSize+MaxKeySize+MaxValueSize)
+	}
+	if got := RecordSize(Version, BatchKeySize, MaxBatchSize); got != HeaderSize+MaxBatchSize {
+		t.Errorf("RecordSize() of a batch = %v, want %v", got, HeaderSize+MaxBatchSize)
+	}
+}
+
+func TestDecodeHeaderV1(t *testing.T) {
+	// the versions 0 and 1 have a 4 byte value_size, with the flags in its top bits
+	header := func(keySize uint32, valueSize uint32) []byte {
+		data := make([]byte, HeaderSizeV1)
+		binary.LittleEndian.PutUint32(data[4:8], 10)
+		binary.LittleEndian.PutUint32(data[8:12], 70)
+		binary.LittleEndian.PutUint32(data[12:16], keySize)
+		binary.LittleEndian.PutUint32(data[16:20], valueSize)
+		return data
+	}
+	tests := []struct {
+		keySize   uint32
+		valueSize uint32
+		want      uint64
+		size      int
+	}{
+		{5, 5, 5, HeaderSizeV1 + 10},
+		{5, 0xFFFFFFFF, TombstoneSize, HeaderSizeV1 + 5},
+		{5, 0x80000000 | v1ValuePointerSize, ValuePointerFlag | v1ValuePointerSize, HeaderSizeV1 + 5 + v1ValuePointerSize},
+		{5, 0xC0000000 | 7, ValuePointerFlag | CompressedFlag | 7, HeaderSizeV1 + 12},
+		{BatchKeySize, 40, 40, HeaderSizeV1 + 40},
+	}
+	for _, version := range []uint32{0, 1} {
+		for _, tt := range tests {
+			timestamp, expiry, keySize, valueSize := DecodeHeader(version, header(tt.keySize, tt.valueSize))
+			if timestamp != 10 || expiry != 70 || keySize != tt.keySize || valueSize != tt.want {
+				t.Errorf("DecodeHeader(%v) = %v, %v, %v, %x, want 10, 70, %v, %x", version, timestamp, expiry, keySize, valueSize, tt.keySize, tt.want)
+			}
+			if got := RecordSize(version, keySize, valueSize); got != tt.size {
+				t.Errorf("RecordSize(%v) = %v, want %v", version, got, tt.size)
+			}
+		}
+	}
+}
+
+// encodeKVV1 encodes the record in the version 1 of the format, with a 4 byte
+// value_size
+func encodeKVV1(timestamp uint32, expiry uint32, key string, value string) []byte {
+	data := EncodeHeader(timestamp, expiry, uint32(len(key)), 0)[:HeaderSizeV1]
+	binary.LittleEndian.PutUint32(data[16:20], uint32(len(value)))
+	data = append(append(data, key...), value...)
+	putChecksum(data)
+	return data
+}
+
+func TestUpgradeRecord(t *testing.T) {
+	upgraded := UpgradeRecord(1, encodeKVV1(10, 70, "hello", "world"))
+	_, want := EncodeKVWithExpiry(10, 70, "hello", "world")
+	if !bytes.Equal(upgraded, want) {
+		t.Errorf("UpgradeRecord() = %x, want %x", upgraded, want)
+	}
+	if got := UpgradeRecord(Version, want); !bytes.Equal(got, want) {
+		t.Errorf("UpgradeRecord() of the current version = %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,127 @@
+package format
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// hint file format
+//
+// At startup, we build the keyDir by reading all the data files. For a large
+// database, this takes a lot of time, most of it spent reading the values which we
+// don't even need. The BitCask paper solves this with hint files: alongside the data
+// files, we keep a file with only the keyDir entries, which is much smaller and faster
+// to load.
+//
+// The hint file starts with the magic "HINT", the ID and the size of the active data
+// file at the time it was written, followed by an entry for every live key, and ends
+// with a CRC32 checksum of everything before it:
+//
+//	┌───────────────┬──────────────┬────────────────┬─────────┬─────────┬─────┬──────────┐
+//	│ magic("HINT") │ file_id (4B) │ data_size (8B) │ entry 1 │ entry 2 │ ... │ crc (4B) │
+//	└───────────────┴──────────────┴────────────────┴─────────┴─────────┴─────┴──────────┘
+//
+// Each entry is the keyDir entry of a key, followed by the key. The version is the
+// version of the format of the data file, which the record is in:
+//
+//	┌───────────────┬────────────┬──────────────┬─────────────┬─────────────┬──────────────┬────────────────┬─────┐
+//	│ timestamp(4B) │ expiry(4B) │ key_size(4B) │ file_id(4B) │ version(4B) │ position(8B) │ total_size(8B) │ key │
+//	└───────────────┴────────────┴──────────────┴─────────────┴─────────────┴──────────────┴────────────────┴─────┘
+//
+// The hint files written before the version 2 of the format had 4 byte positions and
+// sizes, and no magic. They are taken as invalid, and the keyDir is built from the data
+// files once, as if there were no hint file.
+//
+// The data files may have grown after the hint file was written, the records after
+// data_size in the file file_id, and the ones in the files after it, are not in the
+// hint file and have to be read from the data files. The checksum catches a hint
+// file which was not written completely.
+
+// hintMagic are the bytes a hint file starts with
+const hintMagic = "HINT"
+
+// hintPrefixSize is the size of the fields before the entries of a hint file
+const hintPrefixSize = 4 + 4 + 8
+
+// hintHeaderSize is the size of the fixed fields of a hint entry
+const hintHeaderSize = 36
+
+var ErrInvalidHint = errors.New("invalid hint file")
+
+// HintEntry is the location of the current record of a key in the data files
+type HintEntry struct {
+	Key       string
+	Timestamp uint32
+	// Expiry is the expiry of the record, zero if it never expires
+	Expiry uint32
+	// FileID is the ID of the data file which has the record, and Version is the
+	// version of the format of the file
+	FileID  uint32
+	Version uint32
+	// Position is the byte offset of the record in the data file
+	Position uint64
+	// Size is the total size of the record, header included
+	Size uint64
+}
+
+// EncodeHint encodes the hint file for the data files up to dataSize bytes of the
+// data file fileID
+func EncodeHint(fileID uint32, dataSize uint64, entries []HintEntry) []byte {
+	size := hintPrefixSize + 4
+	for _, entry := range entries {
+		size += hintHeaderSize + len(entry.Key)
+	}
+	data := make([]byte, 0, size)
+	data = append(data, hintMagic...)
+	data = binary.LittleEndian.AppendUint32(data, fileID)
+	data = binary.LittleEndian.AppendUint64(data, dataSize)
+	for _, entry := range entries {
+		data = binary.LittleEndian.AppendUint32(data, entry.Timestamp)
+		data = binary.LittleEndian.AppendUint32(data, entry.Expiry)
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(entry.Key)))
+		data = binary.LittleEndian.AppendUint32(data, entry.FileID)
+		data = binary.LittleEndian.AppendUint32(data, entry.Version)
+		data = binary.LittleEndian.AppendUint64(data, entry.Position)
+		data = binary.LittleEndian.AppendUint64(data, entry.Size)
+		data = append(data, entry.Key...)
+	}
+	return binary.LittleEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+}
+
+// DecodeHint decodes the hint file, and returns the ID and size of the data file it
+// covers along with the entries. It returns ErrInvalidHint if the hint file is
+// corrupt or incomplete
+func DecodeHint(data []byte) (uint32, uint64, []HintEntry, error) {
+	if len(data) < hintPrefixSize+4 || string(data[:4]) != hintMagic {
+		return 0, 0, nil, ErrInvalidHint
+	}
+	body, checksum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != checksum {
+		return 0, 0, nil, ErrInvalidHint
+	}
+	fileID := binary.LittleEndian.Uint32(body[4:8])
+	dataSize := binary.LittleEndian.Uint64(body[8:16])
+	body = body[hintPrefixSize:]
+	var entries []HintEntry
+	for len(body) > 0 {
+		if len(body) < hintHeaderSize {
+			return 0, 0, nil, ErrInvalidHint
+		}
+		keySize := int(binary.LittleEndian.Uint32(body[8:12]))
+		if len(body) < hintHeaderSize+keySize {
+			return 0, 0, nil, ErrInvalidHint
+		}
+		entries = append(entries, HintEntry{
+			Key:       string(body[hintHeaderSize : hintHeaderSize+keySize]),
+			Timestamp: binary.LittleEndian.Uint32(body[0:4]),
+			Expiry:    binary.LittleEndian.Uint32(body[4:8]),
+			FileID:    binary.LittleEndian.Uint32(body[12:16]),
+			Version:   binary.LittleEndian.Uint32(body[16:20]),
+			Position:  binary.LittleEndian.Uint64(body[20:28]),
+			Size:      binary.LittleEndian.Uint64(body[28:36]),
+		})
+		body = body[hintHeaderSize+keySize:]
+	}
+	return fileID, dataSize, entries, nil
+}
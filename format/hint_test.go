@@ -0,0 +1,53 @@
+package format
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeHint(t *testing.T) {
+	entries := []HintEntry{
+		{"hello", 10, 0, 1, Version, 0, HeaderSize + 10},
+		{"", 0, 0, 1, Version, HeaderSize + 10, HeaderSize},
+		{"🔑", 100, 160, 2, 1, 0, HeaderSizeV1 + 4},
+		{"large", 100, 0, 3, Version, 1 << 33, 1 << 33},
+	}
+	fileID, dataSize, decoded, err := DecodeHint(EncodeHint(2, 1000, entries))
+	if err != nil {
+		t.Fatalf("DecodeHint() error = %v", err)
+	}
+	if fileID != 2 {
+		t.Errorf("DecodeHint() fileID = %v, want %v", fileID, 2)
+	}
+	if dataSize != 1000 {
+		t.Errorf("DecodeHint() dataSize = %v, want %v", dataSize, 1000)
+	}
+	if !reflect.DeepEqual(decoded, entries) {
+		t.Errorf("DecodeHint() entries = %v, want %v", decoded, entries)
+	}
+}
+
+func TestDecodeHintInvalid(t *testing.T) {
+	data := EncodeHint(1, 1000, []HintEntry{{"hello", 10, 0, 1, Version, 0, HeaderSize + 10}})
+	corrupt := append([]byte{}, data...)
+	corrupt[14] ^= 0xFF
+	// the hint files of the older versions have no magic
+	legacy := append([]byte{}, data[4:len(data)-4]...)
+	legacy = binary.LittleEndian.AppendUint32(legacy, crc32.ChecksumIEEE(legacy))
+	tests := [][]byte{
+		nil,
+		data[:len(data)-1],
+		corrupt,
+		legacy,
+	}
+	for _, tt := range tests {
+		if _, _, _, err := DecodeHint(tt); err != ErrInvalidHint {
+			t.Errorf("DecodeHint() error = %v, want %v", err, ErrInvalidHint)
+		}
+	}
+	if _, _, entries, err := DecodeHint(EncodeHint(1, 0, nil)); err != nil || len(entries) != 0 {
+		t.Errorf("DecodeHint() = %v, %v, want no entries", entries, err)
+	}
+}
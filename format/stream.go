@@ -0,0 +1,240 @@
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// maxPrealloc is the size of the largest record which Next allocates up front. The
+// size comes from the header, before the checksum is verified, so a larger record is
+// read in chunks. Else one flipped bit in the value_size of a corrupt file would make
+// us allocate terabytes, instead of running into the end of the file
+const maxPrealloc = 1 << 20
+
+// Record is a single key value pair read from a data file, along with where it was
+// found in the file
+type Record struct {
+	Timestamp uint32
+	// Expiry is the time in unix epoch seconds after which the record is expired,
+	// or zero if it never expires
+	Expiry uint32
+	Key    string
+	Value  string
+	// Tombstone tells whether the record marks the deletion of the key, the Value
+	// of a tombstone is always empty
+	Tombstone bool
+	// ValuePointer tells whether the value was moved out to a value log, the Value
+	// is the encoded pointer to it then. Check DecodeValuePointer
+	ValuePointer bool
+	// Compressed tells whether the value was compressed, the Value, or the value
+	// which the pointer points to, is compressed then. Check Decompress
+	Compressed bool
+	// Encrypted tells whether the record is encrypted. The Key is empty then, and
+	// the Value is the encrypted key and value. Check DecryptRecord
+	Encrypted bool
+	// Offset is the byte offset of the record in the file
+	Offset int64
+	// Size is the total size of the record, header included
+	Size int
+}
+
+// Reader reads the records from a caskdb data file, one after another. It buffers
+// the reads, so it reads the file in large chunks instead of per record. The records
+// of a batch are returned one by one, as if they were written on their own
+//
+// Typical usage example:
+//
+//	reader := format.NewReader(file)
+//	for {
+//		record, err := reader.Next()
+//		if err == io.EOF {
+//			break
+//		}
+//		...
+//	}
+type Reader struct {
+	r      *bufio.Reader
+	offset int64
+	// header is the file header, read before the first record
+	header     FileHeader
+	headerRead bool
+	// batch has the records of the batch being read which are not returned yet,
+	// and batchOffset is the offset of the first of them
+	batch       []byte
+	batchOffset int64
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next record. At the end of the file it returns io.EOF. If the file
+// ends within a record, say because of a partial write during a crash, it returns
+// io.ErrUnexpectedEOF, and if the record is corrupt, ErrChecksumMismatch
+func (r *Reader) Next() (Record, error) {
+	if len(r.batch) > 0 {
+		return r.nextInBatch()
+	}
+	if !r.headerRead {
+		if _, err := r.Header(); err != nil {
+			return Record{}, err
+		}
+	}
+	version := r.header.Version
+	headerSize := HeaderSizeOf(version)
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		return Record{}, err
+	}
+	_, _, keySize, valueSize := DecodeHeader(version, header)
+	data, err := r.readRecord(header, RecordSize(version, keySize, valueSize))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, err
+	}
+	if err := VerifyChecksum(data); err != nil {
+		return Record{}, err
+	}
+	offset := r.offset
+	r.offset += int64(len(data))
+	if IsBatch(keySize) {
+		r.batch, r.batchOffset = data[headerSize:], offset+int64(headerSize)
+		if len(r.batch) == 0 {
+			return r.Next()
+		}
+		return r.nextInBatch()
+	}
+	return decodeRecord(version, data, offset), nil
+}
+
+// readRecord reads the rest of the record of the size, whose header is read already,
+// and returns the whole record
+func (r *Reader) readRecord(header []byte, size int) ([]byte, error) {
+	if size <= maxPrealloc {
+		data := make([]byte, size)
+		copy(data, header)
+		if _, err := io.ReadFull(r.r, data[len(header):]); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	// the buffer grows only as the data arrives
+	buf := bytes.NewBuffer(make([]byte, 0, maxPrealloc))
+	buf.Write(header)
+	if _, err := io.CopyN(buf, r.r, int64(size-len(header))); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Header reads the file header, if it was not read yet, and returns it. The version
+// of a file without a header is 0. It returns ErrUnsupportedVersion for a file in a
+// newer format
+func (r *Reader) Header() (FileHeader, error) {
+	if r.headerRead {
+		return r.header, nil
+	}
+	// a file shorter than a header has no header, Peek returns what there is
+	data, _ := r.r.Peek(FileHeaderSize)
+	header, err := DecodeFileHeader(data)
+	if err != nil {
+		return FileHeader{}, err
+	}
+	r.r.Discard(header.Size())
+	r.offset += int64(header.Size())
+	r.header, r.headerRead = header, true
+	return header, nil
+}
+
+// nextInBatch returns the next record of the batch being read. The batch was
+// verified as a whole, but its records are verified on their own too
+func (r *Reader) nextInBatch() (Record, error) {
+	version := r.header.Version
+	if len(r.batch) < HeaderSizeOf(version) {
+		return Record{}, io.ErrUnexpectedEOF
+	}
+	_, _, keySize, valueSize := DecodeHeader(version, r.batch)
+	size := RecordSize(version, keySize, valueSize)
+	if IsBatch(keySize) || len(r.batch) < size {
+		return Record{}, io.ErrUnexpectedEOF
+	}
+	data := r.batch[:size]
+	if err := VerifyChecksum(data); err != nil {
+		return Record{}, err
+	}
+	record := decodeRecord(version, data, r.batchOffset)
+	r.batch, r.batchOffset = r.batch[size:], r.batchOffset+int64(size)
+	return record, nil
+}
+
+// decodeRecord decodes the verified record in the version of the format read at
+// offset
+func decodeRecord(version uint32, data []byte, offset int64) Record {
+	headerSize := HeaderSizeOf(version)
+	timestamp, expiry, keySize, valueSize := DecodeHeader(version, data)
+	if IsEncrypted(keySize) {
+		return Record{
+			Timestamp:    timestamp,
+			Expiry:       expiry,
+			Value:        string(data[headerSize:]),
+			Tombstone:    IsTombstone(valueSize),
+			ValuePointer: IsValuePointer(valueSize),
+			Compressed:   IsCompressed(valueSize),
+			Encrypted:    true,
+			Offset:       offset,
+			Size:         len(data),
+		}
+	}
+	return Record{
+		Timestamp:    timestamp,
+		Expiry:       expiry,
+		Key:          string(data[headerSize : headerSize+int(keySize)]),
+		Value:        string(data[headerSize+int(keySize):]),
+		Tombstone:    IsTombstone(valueSize),
+		ValuePointer: IsValuePointer(valueSize),
+		Compressed:   IsCompressed(valueSize),
+		Offset:       offset,
+		Size:         len(data),
+	}
+}
+
+// Writer appends records in the caskdb format to the underlying writer. The records
+// are in the current version of the format, so a new file must start with the file
+// header, written with EncodeFileHeader, for a Reader to read them
+type Writer struct {
+	w      io.Writer
+	offset int64
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write encodes the record and writes it, and returns the offset at which the record
+// starts, counted from the first record written by this Writer
+func (w *Writer) Write(timestamp uint32, key string, value string) (int64, error) {
+	return w.write(EncodeKV(timestamp, key, value))
+}
+
+// WriteWithExpiry is like Write, but the record expires at expiry
+func (w *Writer) WriteWithExpiry(timestamp uint32, expiry uint32, key string, value string) (int64, error) {
+	return w.write(EncodeKVWithExpiry(timestamp, expiry, key, value))
+}
+
+// WriteTombstone writes a tombstone record for the key, and returns the offset at
+// which the record starts
+func (w *Writer) WriteTombstone(timestamp uint32, key string) (int64, error) {
+	return w.write(EncodeTombstone(timestamp, key))
+}
+
+func (w *Writer) write(size int, data []byte) (int64, error) {
+	if _, err := w.w.Write(data); err != nil {
+		return 0, err
+	}
+	offset := w.offset
+	w.offset += int64(size)
+	return offset, nil
+}
@@ -0,0 +1,210 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+)
+
+// stream returns the records as a file of the current version of the format, after
+// the file header
+func stream(records ...[]byte) []byte {
+	data := EncodeFileHeader(1000)
+	for _, record := range records {
+		data = append(data, record...)
+	}
+	return data
+}
+
+func TestReader_Next(t *testing.T) {
+	tests := []struct {
+		timestamp uint32
+		key       string
+		value     string
+	}{
+		{10, "hello", "world"},
+		{0, "", ""},
+		{100, "🔑", "value"},
+	}
+	var buf bytes.Buffer
+	buf.Write(EncodeFileHeader(1000))
+	writer := NewWriter(&buf)
+	var offsets []int64
+	for _, tt := range tests {
+		offset, err := writer.Write(tt.timestamp, tt.key, tt.value)
+		if err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		offsets = append(offsets, offset)
+	}
+
+	reader := NewReader(bytes.NewReader(buf.Bytes()))
+	for i, tt := range tests {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if record.Timestamp != tt.timestamp || record.Key != tt.key || record.Value != tt.value {
+			t.Errorf("Next() = %v, want %v", record, tt)
+		}
+		// the offsets of the Writer are counted from its first record, after the
+		// file header
+		if record.Offset != FileHeaderSize+offsets[i] {
+			t.Errorf("Next() offset = %v, want %v", record.Offset, FileHeaderSize+offsets[i])
+		}
+		if record.Size != HeaderSize+len(tt.key)+len(tt.value) {
+			t.Errorf("Next() size = %v, want %v", record.Size, HeaderSize+len(tt.key)+len(tt.value))
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestReader_NextCorrupt(t *testing.T) {
+	_, data := EncodeKV(10, "hello", "world")
+	data[len(data)-1] ^= 0x01
+	reader := NewReader(bytes.NewReader(stream(data)))
+	if _, err := reader.Next(); err != ErrChecksumMismatch {
+		t.Errorf("Next() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+}
+
+func TestReader_NextTombstone(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(EncodeFileHeader(1000))
+	writer := NewWriter(&buf)
+	writer.Write(10, "hello", "world")
+	writer.WriteTombstone(11, "hello")
+
+	reader := NewReader(&buf)
+	if record, _ := reader.Next(); record.Tombstone {
+		t.Errorf("Next() tombstone = %v, want %v", record.Tombstone, false)
+	}
+	record, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	if !record.Tombstone || record.Key != "hello" || record.Value != "" {
+		t.Errorf("Next() = %v, want a tombstone for %v", record, "hello")
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestReader_NextTruncated(t *testing.T) {
+	_, record := EncodeKV(10, "hello", "world")
+	data := stream(record)
+	tests := []struct {
+		size int
+		err  error
+	}{
+		{0, io.EOF},
+		{FileHeaderSize, io.EOF},
+		{FileHeaderSize + HeaderSize - 1, io.ErrUnexpectedEOF},
+		{FileHeaderSize + HeaderSize, io.ErrUnexpectedEOF},
+		{len(data) - 1, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		reader := NewReader(bytes.NewReader(data[:tt.size]))
+		if _, err := reader.Next(); err != tt.err {
+			t.Errorf("Next() with %v bytes error = %v, want %v", tt.size, err, tt.err)
+		}
+	}
+}
+
+func TestWriter_WriteWithExpiry(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(EncodeFileHeader(1000))
+	if _, err := NewWriter(&buf).WriteWithExpiry(10, 70, "hello", "world"); err != nil {
+		t.Fatalf("WriteWithExpiry() error = %v", err)
+	}
+	record, err := NewReader(&buf).Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	if record.Expiry != 70 || record.Key != "hello" || record.Value != "world" {
+		t.Errorf("Next() = %v, want expiry 70", record)
+	}
+}
+
+func TestReader_NextBatch(t *testing.T) {
+	_, first := EncodeKV(10, "hello", "world")
+	_, second := EncodeTombstone(10, "bye")
+	_, batch := EncodeBatch(10, append(append([]byte{}, first...), second...))
+	_, last := EncodeKV(20, "after", "batch")
+	data := stream(batch, last)
+
+	reader := NewReader(bytes.NewReader(data))
+	want := []Record{
+		{Timestamp: 10, Key: "hello", Value: "world", Offset: FileHeaderSize + HeaderSize, Size: len(first)},
+		{Timestamp: 10, Key: "bye", Tombstone: true, Offset: int64(FileHeaderSize + HeaderSize + len(first)), Size: len(second)},
+		{Timestamp: 20, Key: "after", Value: "batch", Offset: int64(FileHeaderSize + len(batch)), Size: len(last)},
+	}
+	for _, tt := range want {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if record != tt {
+			t.Errorf("Next() = %+v, want %+v", record, tt)
+		}
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, want %v", err, io.EOF)
+	}
+
+	// a batch cut short is not read at all
+	reader = NewReader(bytes.NewReader(stream(batch[:len(batch)-1])))
+	if _, err := reader.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Next() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestReader_NextV1(t *testing.T) {
+	// the files of the version 1 have the file header, and the records with a 4 byte
+	// value_size
+	header := EncodeFileHeader(1000)[:12]
+	binary.LittleEndian.PutUint32(header[4:8], 1)
+	header = binary.LittleEndian.AppendUint32(header, crc32.ChecksumIEEE(header))
+	first := encodeKVV1(10, 0, "hello", "world")
+	second := encodeKVV1(20, 70, "dune", "frank herbert")
+	for _, data := range [][]byte{
+		append(append(header, first...), second...),
+		// the version 0 is the same without the file header
+		append(append([]byte{}, first...), second...),
+	} {
+		reader := NewReader(bytes.NewReader(data))
+		offset := int64(len(data) - len(first) - len(second))
+		want := []Record{
+			{Timestamp: 10, Key: "hello", Value: "world", Offset: offset, Size: len(first)},
+			{Timestamp: 20, Expiry: 70, Key: "dune", Value: "frank herbert", Offset: offset + int64(len(first)), Size: len(second)},
+		}
+		for _, tt := range want {
+			if record, err := reader.Next(); err != nil || record != tt {
+				t.Errorf("Next() = %+v, %v, want %+v", record, err, tt)
+			}
+		}
+		if _, err := reader.Next(); err != io.EOF {
+			t.Errorf("Next() error = %v, want %v", err, io.EOF)
+		}
+	}
+}
+
+func TestReader_NextCorruptSize(t *testing.T) {
+	// a flipped bit in the value_size claims a huge record, which must run into the
+	// end of the file instead of being allocated
+	_, record := EncodeKV(10, "hello", "world")
+	for _, bit := range []uint{20, 40, 61} {
+		data := stream(record)
+		size := binary.LittleEndian.Uint64(data[FileHeaderSize+16:])
+		binary.LittleEndian.PutUint64(data[FileHeaderSize+16:], size|1<<bit)
+		reader := NewReader(bytes.NewReader(data))
+		if _, err := reader.Next(); err != io.ErrUnexpectedEOF {
+			t.Errorf("Next() with bit %v of value_size set error = %v, want %v", bit, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
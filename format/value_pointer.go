@@ -0,0 +1,104 @@
+package format
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+)
+
+// ValuePointerFlag is set in the value_size field of a record whose value was moved
+// out to a value log, keeping large values out of the data files as in WiscKey. The
+// record has a ValuePointer in place of the value, and the rest of the value_size
+// field is the size of the pointer:
+//
+//	┌─────┬───────────┬────────┬──────────┬─────────────────────┬─────┬───────────────┐
+//	│ crc │ timestamp │ expiry │ key_size │ value_size(flag|24) │ key │ value pointer │
+//	└─────┴───────────┴────────┴──────────┴─────────────────────┴─────┴───────────────┘
+//
+// The pointer has the ID of the value log file, and the offset, size and CRC32
+// checksum of the value in it:
+//
+//	┌─────────────┬────────────┬──────────┬──────────────┐
+//	│ file_id(4B) │ offset(8B) │ size(8B) │ checksum(4B) │
+//	└─────────────┴────────────┴──────────┴──────────────┘
+//
+// The value log has just the values, one after another, since the pointer has
+// everything else. A tombstone has all the bits of value_size set, and is not a value
+// pointer. In the versions 0 and 1 of the format, the flag is the top bit of the 4
+// byte value_size, and the size in the pointer is 4 bytes too.
+//
+// Read more about WiscKey here: https://www.usenix.org/conference/fast16/technical-sessions/presentation/lu
+const ValuePointerFlag = 1 << 63
+
+// ValuePointerSize is the size of an encoded ValuePointer
+const ValuePointerSize = 24
+
+// v1ValuePointerSize is the size of a ValuePointer encoded by the versions 0 and 1
+// of the format
+const v1ValuePointerSize = 20
+
+var ErrInvalidValuePointer = errors.New("invalid value pointer")
+
+// ValuePointer locates a value in a value log file
+type ValuePointer struct {
+	FileID   uint32
+	Offset   uint64
+	Size     uint64
+	Checksum uint32
+}
+
+// NewValuePointer returns the pointer to the value, which is written at the offset in
+// the value log file with the ID
+func NewValuePointer(fileID uint32, offset uint64, value []byte) ValuePointer {
+	return ValuePointer{fileID, offset, uint64(len(value)), crc32.ChecksumIEEE(value)}
+}
+
+// Verify checks the value read from the value log against the checksum of the pointer,
+// and returns ErrChecksumMismatch if they don't match
+func (p ValuePointer) Verify(value []byte) error {
+	if uint64(len(value)) != p.Size || crc32.ChecksumIEEE(value) != p.Checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// IsValuePointer tells whether the value size read from a header marks a record with
+// a value pointer
+func IsValuePointer(valueSize uint64) bool {
+	return valueSize != TombstoneSize && valueSize&ValuePointerFlag != 0
+}
+
+// EncodeValuePointer encodes a record of the key with the pointer to its value into
+// bytes, and returns the size of the record with them
+func EncodeValuePointer(timestamp uint32, expiry uint32, key string, p ValuePointer) (int, []byte) {
+	header := EncodeHeader(timestamp, expiry, uint32(len(key)), ValuePointerFlag|ValuePointerSize)
+	data := append(header, key...)
+	data = binary.LittleEndian.AppendUint32(data, p.FileID)
+	data = binary.LittleEndian.AppendUint64(data, p.Offset)
+	data = binary.LittleEndian.AppendUint64(data, p.Size)
+	data = binary.LittleEndian.AppendUint32(data, p.Checksum)
+	putChecksum(data)
+	return len(data), data
+}
+
+// DecodeValuePointer decodes the value pointer from the value of a record with one.
+// The pointers of the older versions of the format are told apart by their size
+func DecodeValuePointer(value []byte) (ValuePointer, error) {
+	switch len(value) {
+	case ValuePointerSize:
+		return ValuePointer{
+			FileID:   binary.LittleEndian.Uint32(value[0:4]),
+			Offset:   binary.LittleEndian.Uint64(value[4:12]),
+			Size:     binary.LittleEndian.Uint64(value[12:20]),
+			Checksum: binary.LittleEndian.Uint32(value[20:24]),
+		}, nil
+	case v1ValuePointerSize:
+		return ValuePointer{
+			FileID:   binary.LittleEndian.Uint32(value[0:4]),
+			Offset:   binary.LittleEndian.Uint64(value[4:12]),
+			Size:     uint64(binary.LittleEndian.Uint32(value[12:16])),
+			Checksum: binary.LittleEndian.Uint32(value[16:20]),
+		}, nil
+	}
+	return ValuePointer{}, ErrInvalidValuePointer
+}
@@ -0,0 +1,38 @@
+package format
+
+import "testing"
+
+func TestEncodeValuePointer(t *testing.T) {
+	value := []byte("frank herbert")
+	pointer := NewValuePointer(3, 1<<40, value)
+	size, data := EncodeValuePointer(1000, 2000, "dune", pointer)
+	if size != HeaderSize+len("dune")+ValuePointerSize || size != len(data) {
+		t.Errorf("EncodeValuePointer() size = %v, want %v", size, HeaderSize+len("dune")+ValuePointerSize)
+	}
+	if err := VerifyChecksum(data); err != nil {
+		t.Fatalf("VerifyChecksum() error = %v", err)
+	}
+	_, _, keySize, valueSize := DecodeHeader(Version, data)
+	if !IsValuePointer(valueSize) || IsTombstone(valueSize) || RecordSize(Version, keySize, valueSize) != size {
+		t.Errorf("the header does not mark a value pointer of size %v", size)
+	}
+	decoded, err := DecodeValuePointer(data[HeaderSize+keySize:])
+	if err != nil {
+		t.Fatalf("DecodeValuePointer() error = %v", err)
+	}
+	if decoded != pointer {
+		t.Errorf("DecodeValuePointer() = %+v, want %+v", decoded, pointer)
+	}
+	if err := decoded.Verify(value); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err := decoded.Verify([]byte("frank herbers")); err != ErrChecksumMismatch {
+		t.Errorf("Verify() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if IsValuePointer(TombstoneSize) {
+		t.Errorf("IsValuePointer(TombstoneSize) = true, want false")
+	}
+	if _, err := DecodeValuePointer([]byte("short")); err != ErrInvalidValuePointer {
+		t.Errorf("DecodeValuePointer() error = %v, want %v", err, ErrInvalidValuePointer)
+	}
+}
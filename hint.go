@@ -0,0 +1,108 @@
+package caskdb
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// hintFileName returns the path of the hint file in the data directory. Check
+// format/hint.go for what the hint file is and its format
+func hintFileName(dirName string) string {
+	return filepath.Join(dirName, "keydir.hint")
+}
+
+// writeHintFile writes the keyDir to the hint file, check replaceFile
+func (d *DiskStore) writeHintFile() error {
+	entries := make([]format.HintEntry, 0, d.keyDir.len())
+	d.keyDir.each(func(key string, kEntry KeyEntry) error {
+		entries = append(entries, format.HintEntry{
+			Key:       key,
+			Timestamp: kEntry.timestamp,
+			Expiry:    kEntry.expiry,
+			FileID:    kEntry.fileID,
+			Version:   kEntry.version,
+			Position:  kEntry.position,
+			Size:      kEntry.totalSize,
+		})
+		return nil
+	})
+	data := format.EncodeHint(d.activeFileID, uint64(d.writePosition), entries)
+	// the hint file has all the keys, so it is encrypted as a whole
+	if d.aead != nil {
+		data = format.Seal(d.aead, data, nil)
+	}
+	return d.replaceFile(hintFileName(d.dirName), data)
+}
+
+// replaceFile writes the data to the file. It writes to a temporary file first and
+// renames it over the file, so a crash never leaves a partially written file in place
+func (d *DiskStore) replaceFile(fileName string, data []byte) error {
+	tmpName := fileName + ".tmp"
+	file, err := d.options.Storage.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, d.options.FileMode)
+	if err != nil {
+		return err
+	}
+	defer d.options.Storage.Remove(tmpName)
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := d.syncFile(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return d.options.Storage.Rename(tmpName, fileName)
+}
+
+// loadHintFile loads the keyDir from the hint file, and returns the ID of the data
+// file and the position in it where the data covered by the hint file ends.
+// scanDataFiles then reads only the records written after that. It returns false if
+// there is no usable hint file, in which case the keyDir is left empty and has to be
+// built from all the data files
+func (d *DiskStore) loadHintFile(fileIDs []uint32) (uint32, int, bool) {
+	data, err := readFile(d.options.Storage, hintFileName(d.dirName))
+	if err != nil {
+		return 0, 0, false
+	}
+	if d.aead != nil {
+		if data, err = format.Open(d.aead, data, nil); err != nil {
+			return 0, 0, false
+		}
+	}
+	hintFileID, dataSize, entries, err := format.DecodeHint(data)
+	if err != nil {
+		return 0, 0, false
+	}
+	// the data files cannot shrink or go away, except by a merge, which removes
+	// the hint file first. If a data file the hint refers to is missing, or is
+	// smaller than the hint says, it is not the one the hint was written for
+	exists := make(map[uint32]bool, len(fileIDs))
+	for _, fileID := range fileIDs {
+		exists[fileID] = true
+	}
+	size, err := fileSize(d.options.Storage, dataFileName(d.dirName, hintFileID))
+	if err != nil || !exists[hintFileID] || uint64(size) < dataSize {
+		return 0, 0, false
+	}
+	for _, entry := range entries {
+		if !exists[entry.FileID] {
+			return 0, 0, false
+		}
+	}
+	// the keyDir is empty still, it is sized for the keys up front, so that it does
+	// not grow again and again while we load them
+	d.keyDir = d.keyDir.empty(len(entries))
+	now := uint32(d.now().Unix())
+	for _, entry := range entries {
+		kEntry := NewKeyEntry(entry.Timestamp, entry.Expiry, entry.FileID, entry.Version, entry.Position, entry.Size)
+		if !kEntry.expired(now) {
+			d.keyDir.put(entry.Key, kEntry)
+		}
+	}
+	return hintFileID, int(dataSize), true
+}
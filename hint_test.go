@@ -0,0 +1,84 @@
+package caskdb
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDiskStore_HintFile(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("hamlet", "shakespeare")
+	store.Set("othello", "shakespeare")
+	store.Delete("othello")
+	store.Close()
+	if !isFileExists(hintFileName("test.db")) {
+		t.Fatalf("Close() did not write the hint file")
+	}
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	// these writes are not in the hint file, we don't close the store properly
+	// to simulate a crash
+	store.Set("dune", "frank herbert")
+	store.Delete("hamlet")
+	store.closeFiles()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	tests := map[string]string{"hamlet": "", "othello": "", "dune": "frank herbert"}
+	for key, val := range tests {
+		if got, _ := store.Get(key); got != val {
+			t.Errorf("Get(%v) = %v, want %v", key, got, val)
+		}
+	}
+	if store.keyDir.len() != 1 {
+		t.Errorf("len(keyDir) = %v, want %v", store.keyDir.len(), 1)
+	}
+	store.Close()
+}
+
+func TestDiskStore_HintFileInvalid(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("hamlet", "shakespeare")
+	store.Close()
+
+	// a corrupt hint file is ignored, and the keyDir is built from the data files
+	if err := os.WriteFile(hintFileName("test.db"), []byte("garbage"), 0666); err != nil {
+		t.Fatalf("failed to write hint file: %v", err)
+	}
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if val, _ := store.Get("hamlet"); val != "shakespeare" {
+		t.Errorf("Get() = %v, want %v", val, "shakespeare")
+	}
+	store.Close()
+
+	// a hint file covering more data than the data file has, is not for this
+	// data file
+	if err := os.Truncate(dataFileName("test.db", 1), 0); err != nil {
+		t.Fatalf("failed to truncate data file: %v", err)
+	}
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if val, _ := store.Get("hamlet"); val != "" {
+		t.Errorf("Get() = %v, want '' (empty)", val)
+	}
+	store.Close()
+}
@@ -0,0 +1,250 @@
+// Package httpapi exposes a caskdb store over HTTP. The handler can be mounted into
+// any net/http server, under a prefix with http.StripPrefix.
+//
+// The endpoints are:
+//
+//	GET    /keys/{key}   the value of the key, 404 if it does not exist
+//	PUT    /keys/{key}   sets the key to the request body, ?ttl=1h sets it with a TTL.
+//	                     A body larger than the max value size of the store is 413
+//	DELETE /keys/{key}   deletes the key
+//	GET    /keys         the keys as a JSON array, ?prefix= filters them
+//	POST   /bulk/get     takes a JSON array of keys, returns a JSON object of the
+//	                     ones which exist and their values
+//	POST   /bulk/set     takes a JSON object of keys and values, and sets all of them
+//	                     atomically
+//	POST   /bulk/delete  takes a JSON array of keys, and deletes all of them atomically
+//	GET    /stats        the Stats of the store as JSON
+//
+// The keys in the path are URL escaped, so a key may have slashes as %2F. A body of
+// the bulk endpoints larger than 32MB is 413.
+//
+// Typical usage example:
+//
+//	store, _ := caskdb.NewDiskStore("books.db")
+//	http.Handle("/db/", http.StripPrefix("/db", httpapi.NewHandler(store)))
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/avinassh/go-caskdb"
+)
+
+// keysPrefix is the path prefix of the single key endpoints
+const keysPrefix = "/keys/"
+
+// maxBulkSize is the largest body the bulk endpoints read, 32MB
+const maxBulkSize = 32 << 20
+
+// Handler serves a DiskStore over HTTP
+type Handler struct {
+	store *caskdb.DiskStore
+}
+
+// NewHandler returns a handler for the store. The handler does not own the store, the
+// caller closes the store after shutting the server down
+func NewHandler(store *caskdb.DiskStore) *Handler {
+	return &Handler{store: store}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// we route on the escaped path, else an escaped slash in a key would look
+	// like a separator
+	path := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(path, keysPrefix):
+		key, err := url.PathUnescape(strings.TrimPrefix(path, keysPrefix))
+		if err != nil || key == "" {
+			http.Error(w, "invalid key", http.StatusBadRequest)
+			return
+		}
+		h.serveKey(w, r, key)
+	case path == "/keys":
+		h.allow(w, r, http.MethodGet, h.keys)
+	case path == "/bulk/get":
+		h.allow(w, r, http.MethodPost, h.bulkGet)
+	case path == "/bulk/set":
+		h.allow(w, r, http.MethodPost, h.bulkSet)
+	case path == "/bulk/delete":
+		h.allow(w, r, http.MethodPost, h.bulkDelete)
+	case path == "/stats":
+		h.allow(w, r, http.MethodGet, h.stats)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// allow calls serve if the request has the method, else it replies with 405
+func (h *Handler) allow(w http.ResponseWriter, r *http.Request, method string, serve http.HandlerFunc) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	serve(w, r)
+}
+
+func (h *Handler) serveKey(w http.ResponseWriter, r *http.Request, key string) {
+	switch r.Method {
+	case http.MethodGet:
+		value, err := h.store.Get(key)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.WriteString(w, value)
+	case http.MethodPut:
+		// a body larger than the store takes is not read into memory only to be
+		// rejected by Set
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.store.MaxValueSize()))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, caskdb.ErrValueTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ttl := r.URL.Query().Get("ttl"); ttl != "" {
+			d, parseErr := time.ParseDuration(ttl)
+			if parseErr != nil {
+				http.Error(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+			err = h.store.SetWithTTL(key, string(value), d)
+		} else {
+			err = h.store.Set(key, string(value))
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := h.store.Delete(key); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) keys(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	keys := make([]string, 0)
+	for _, key := range h.store.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	writeJSON(w, keys)
+}
+
+func (h *Handler) bulkGet(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	if !decodeBulk(w, r, &keys, "the body must be a JSON array of keys") {
+		return
+	}
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, err := h.store.Get(key)
+		if err == caskdb.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		values[key] = value
+	}
+	writeJSON(w, values)
+}
+
+func (h *Handler) bulkSet(w http.ResponseWriter, r *http.Request) {
+	var values map[string]string
+	if !decodeBulk(w, r, &values, "the body must be a JSON object of keys and values") {
+		return
+	}
+	batch := caskdb.NewBatch()
+	for key, value := range values {
+		batch.Set(key, value)
+	}
+	if err := h.store.Commit(batch); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) bulkDelete(w http.ResponseWriter, r *http.Request) {
+	var keys []string
+	if !decodeBulk(w, r, &keys, "the body must be a JSON array of keys") {
+		return
+	}
+	batch := caskdb.NewBatch()
+	for _, key := range keys {
+		batch.Delete(key)
+	}
+	if err := h.store.Commit(batch); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.store.Stats()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, stats)
+}
+
+// decodeBulk decodes the JSON body of a bulk request into v. If it can't, it replies
+// with 413 for a body larger than maxBulkSize, else with 400 and the message
+func decodeBulk(w http.ResponseWriter, r *http.Request, v interface{}, message string) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBulkSize)).Decode(v)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return false
+	}
+	if err != nil {
+		http.Error(w, message, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeError replies with the status code matching the error of the store
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, caskdb.ErrKeyNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, caskdb.ErrKeyTooLarge), errors.Is(err, caskdb.ErrValueTooLarge), errors.Is(err, caskdb.ErrBatchTooLarge):
+		status = http.StatusRequestEntityTooLarge
+	case errors.Is(err, caskdb.ErrInvalidTTL), errors.Is(err, caskdb.ErrEmptyKey), errors.Is(err, caskdb.ErrReservedKey):
+		status = http.StatusBadRequest
+	case errors.Is(err, caskdb.ErrReadOnly):
+		status = http.StatusForbidden
+	}
+	http.Error(w, err.Error(), status)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,135 @@
+package httpapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/avinassh/go-caskdb"
+)
+
+func TestHandler(t *testing.T) {
+	store, err := caskdb.NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer os.RemoveAll("test.db")
+	handler := NewHandler(store)
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+		status int
+		want   string
+	}{
+		{http.MethodGet, "/keys/othello", "", http.StatusNotFound, "key not found\n"},
+		{http.MethodPut, "/keys/othello", "shakespeare", http.StatusNoContent, ""},
+		{http.MethodGet, "/keys/othello", "", http.StatusOK, "shakespeare"},
+		{http.MethodPut, "/keys/books%2Fdune?ttl=1h", "herbert", http.StatusNoContent, ""},
+		{http.MethodGet, "/keys/books%2Fdune", "", http.StatusOK, "herbert"},
+		{http.MethodPut, "/keys/dune?ttl=-1s", "herbert", http.StatusBadRequest, "ttl must be positive\n"},
+		{http.MethodGet, "/keys", "", http.StatusOK, "[\"books/dune\",\"othello\"]\n"},
+		{http.MethodGet, "/keys?prefix=books/", "", http.StatusOK, "[\"books/dune\"]\n"},
+		{http.MethodPost, "/bulk/set", `{"hamlet":"shakespeare","emma":"austen"}`, http.StatusNoContent, ""},
+		{http.MethodPost, "/bulk/get", `["hamlet","emma","missing"]`, http.StatusOK, "{\"emma\":\"austen\",\"hamlet\":\"shakespeare\"}\n"},
+		{http.MethodPost, "/bulk/delete", `["hamlet","emma"]`, http.StatusNoContent, ""},
+		{http.MethodPost, "/bulk/get", `["hamlet","emma"]`, http.StatusOK, "{}\n"},
+		{http.MethodPost, "/bulk/set", `not json`, http.StatusBadRequest, "the body must be a JSON object of keys and values\n"},
+		{http.MethodDelete, "/keys/othello", "", http.StatusNoContent, ""},
+		{http.MethodGet, "/keys/othello", "", http.StatusNotFound, "key not found\n"},
+		{http.MethodPost, "/keys/othello", "", http.StatusMethodNotAllowed, "Method Not Allowed\n"},
+		{http.MethodGet, "/bulk/get", "", http.StatusMethodNotAllowed, "Method Not Allowed\n"},
+		{http.MethodGet, "/missing", "", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		body, _ := io.ReadAll(rec.Body)
+		if rec.Code != tt.status || string(body) != tt.want {
+			t.Errorf("%v %v = %v %q, want %v %q", tt.method, tt.target, rec.Code, body, tt.status, tt.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"Keys":1`) {
+		t.Errorf("GET /stats = %v %q, want the stats with 1 key", rec.Code, rec.Body.String())
+	}
+	store.Close()
+}
+
+// countingReader counts the bytes read from it
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestHandler_ValueTooLarge(t *testing.T) {
+	store, err := caskdb.Open("test.db", caskdb.WithMaxValueSize(8))
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer os.RemoveAll("test.db")
+	defer store.Close()
+	handler := NewHandler(store)
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{"herbert", http.StatusNoContent},
+		{"12345678", http.StatusNoContent},
+		{"frank herbert", http.StatusRequestEntityTooLarge},
+		{strings.Repeat("x", 1<<20), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		body := &countingReader{r: strings.NewReader(tt.body)}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/keys/dune", body))
+		if rec.Code != tt.status {
+			t.Errorf("PUT of %v bytes = %v, want %v", len(tt.body), rec.Code, tt.status)
+		}
+		// the body is read up to the limit only
+		if body.n > 8+512 {
+			t.Errorf("PUT of %v bytes read %v bytes of the body", len(tt.body), body.n)
+		}
+	}
+}
+
+func TestHandler_BulkTooLarge(t *testing.T) {
+	store, err := caskdb.NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer os.RemoveAll("test.db")
+	defer store.Close()
+	handler := NewHandler(store)
+
+	for _, path := range []string{"/bulk/get", "/bulk/set", "/bulk/delete"} {
+		body := &countingReader{r: strings.NewReader(`["` + strings.Repeat("x", maxBulkSize) + `"]`)}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, body))
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("POST %v of %v bytes = %v, want %v", path, maxBulkSize+4, rec.Code, http.StatusRequestEntityTooLarge)
+		}
+		if body.n > maxBulkSize+512 {
+			t.Errorf("POST %v read %v bytes of the body", path, body.n)
+		}
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/keys/%00books%00dune", strings.NewReader("herbert")))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT of a reserved key = %v, want %v", rec.Code, http.StatusBadRequest)
+	}
+}
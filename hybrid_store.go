@@ -0,0 +1,197 @@
+package caskdb
+
+import (
+	"sync"
+	"time"
+)
+
+// HybridStore keeps all the data in a MemoryStore and persists it in the background,
+// similar to how Redis combines RDB snapshots with its append only file (AOF). Reads
+// and writes only touch the memory, so they are as fast as the MemoryStore. The
+// writes are collected and appended to a DiskStore log every flush interval, and the
+// log is periodically rewritten into a snapshot of just the live data, so that it
+// does not grow forever with the old values.
+//
+// This trades durability for latency: the writes made since the last flush are lost
+// if the process crashes. Use Flush to persist them explicitly. Since all the data
+// lives in memory, the data set has to fit in the RAM.
+//
+// On open, the whole log is loaded into memory. HybridStore is safe for concurrent
+// use, since the background flushes run alongside the caller's reads and writes.
+//
+// Typical usage example:
+//
+//	store, _ := NewHybridStore("books.db", time.Second, time.Hour)
+//	store.Set("othello", "shakespeare")
+//	author, _ := store.Get("othello")
+type HybridStore struct {
+	mu       sync.Mutex
+	fileName string
+	memory   *MemoryStore
+	log      *DiskStore
+	// pending keeps the latest value of every key written since the last flush, nil
+	// for the deleted keys
+	pending map[string]*string
+	// stop and done shut down the background goroutine, and closed is set by the
+	// first Close
+	stop   chan struct{}
+	done   chan struct{}
+	closed bool
+}
+
+// NewHybridStore opens the log at fileName and loads it into memory. Pending writes
+// are flushed to the log every flushInterval, and the log is rewritten into a snapshot
+// every snapshotInterval. A zero interval disables the corresponding background work
+func NewHybridStore(fileName string, flushInterval time.Duration, snapshotInterval time.Duration) (*HybridStore, error) {
+	log, err := NewDiskStore(fileName)
+	if err != nil {
+		return nil, err
+	}
+	h := &HybridStore{
+		fileName: fileName,
+		memory:   NewMemoryStore(),
+		log:      log,
+		pending:  make(map[string]*string),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	err = log.Fold(func(key string, value string) error {
+		return h.memory.Set(key, value)
+	})
+	if err != nil {
+		log.Close()
+		return nil, err
+	}
+	go h.run(flushInterval, snapshotInterval)
+	return h, nil
+}
+
+func (h *HybridStore) run(flushInterval time.Duration, snapshotInterval time.Duration) {
+	defer close(h.done)
+	// a nil channel blocks forever, which disables the disabled intervals
+	var flushes, snapshots <-chan time.Time
+	if flushInterval > 0 {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		flushes = ticker.C
+	}
+	if snapshotInterval > 0 {
+		ticker := time.NewTicker(snapshotInterval)
+		defer ticker.Stop()
+		snapshots = ticker.C
+	}
+	for {
+		select {
+		case <-flushes:
+			// TODO: log the error
+			h.Flush()
+		case <-snapshots:
+			// TODO: log the error
+			h.Snapshot()
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+func (h *HybridStore) Get(key string) (string, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.memory.Get(key)
+}
+
+// Set stores the key and value in memory. The key and the value are checked like
+// DiskStore.Set checks them, so that a write the log would reject is never pending
+func (h *HybridStore) Set(key string, value string) error {
+	if err := checkReserved(key); err != nil {
+		return err
+	}
+	if err := h.log.checkSize(key, value); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pending[key] = &value
+	return h.memory.Set(key, value)
+}
+
+func (h *HybridStore) Delete(key string) error {
+	if err := checkReserved(key); err != nil {
+		return err
+	}
+	if key == "" {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pending[key] = nil
+	return h.memory.Delete(key)
+}
+
+// Fold calls fn for every key and its value, in the key order, like MemoryStore.Fold.
+// The store is not locked while fn runs, so fn may read and write the store
+func (h *HybridStore) Fold(fn func(key string, value string) error) error {
+	return h.memory.Fold(fn)
+}
+
+// Flush appends the writes made since the last flush to the log, in a single batch.
+// If the batch fails, all the writes are kept for the next flush
+func (h *HybridStore) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.flush()
+}
+
+func (h *HybridStore) flush() error {
+	// a batch is synced once, not once per key, and is written all or nothing
+	batch := NewBatch()
+	for key, value := range h.pending {
+		if value == nil {
+			batch.Delete(key)
+		} else {
+			batch.Set(key, *value)
+		}
+	}
+	if err := h.log.Commit(batch); err != nil {
+		return err
+	}
+	h.pending = make(map[string]*string)
+	return nil
+}
+
+// Snapshot rewrites the log with only the current value of every key. The pending
+// writes are flushed first, so that the log has the same data as the memory, and
+// then the log is compacted with DiskStore.Merge
+func (h *HybridStore) Snapshot() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.flush(); err != nil {
+		return err
+	}
+	return h.log.Merge()
+}
+
+// Close flushes the pending writes and closes the log. The log is closed even if the
+// flush fails, and the first error is returned. Closing the store again returns
+// ErrClosed
+func (h *HybridStore) Close() error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return ErrClosed
+	}
+	h.closed = true
+	h.mu.Unlock()
+	// the background goroutine takes the lock to flush, so we wait for it only
+	// after we have released the lock
+	close(h.stop)
+	<-h.done
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	flushErr := h.flush()
+	closeErr := h.log.Close()
+	if flushErr != nil {
+		return flushErr
+	}
+	return closeErr
+}
@@ -0,0 +1,158 @@
+package caskdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHybridStore_SetWithPersistence(t *testing.T) {
+	store, err := NewHybridStore("test.db", 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create hybrid store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("name", "jojo")
+	if val, _ := store.Get("name"); val != "jojo" {
+		t.Errorf("Get() = %v, want %v", val, "jojo")
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	store, err = NewHybridStore("test.db", 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create hybrid store: %v", err)
+	}
+	if val, _ := store.Get("name"); val != "jojo" {
+		t.Errorf("Get() = %v, want %v", val, "jojo")
+	}
+	store.Close()
+}
+
+func TestHybridStore_Flush(t *testing.T) {
+	store, err := NewHybridStore("test.db", 10*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("failed to create hybrid store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("name", "jojo")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		store.mu.Lock()
+		val, _ := store.log.Get("name")
+		store.mu.Unlock()
+		if val == "jojo" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("background flush did not persist the write")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	store.Close()
+}
+
+func TestHybridStore_Snapshot(t *testing.T) {
+	store, err := NewHybridStore("test.db", 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create hybrid store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	for i := 0; i < 100; i++ {
+		store.Set("counter", fmt.Sprint(i))
+		store.Flush()
+	}
+	before := storeSize("test.db")
+	if err := store.Snapshot(); err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if after := storeSize("test.db"); after >= before {
+		t.Errorf("Snapshot() size = %v, want less than %v", after, before)
+	}
+	store.Set("name", "jojo")
+	store.Close()
+
+	store, err = NewHybridStore("test.db", 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create hybrid store: %v", err)
+	}
+	if val, _ := store.Get("counter"); val != "99" {
+		t.Errorf("Get() = %v, want %v", val, "99")
+	}
+	if val, _ := store.Get("name"); val != "jojo" {
+		t.Errorf("Get() = %v, want %v", val, "jojo")
+	}
+	store.Close()
+}
+
+func TestHybridStore_Delete(t *testing.T) {
+	store, err := NewHybridStore("test.db", 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create hybrid store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("othello", "shakespeare")
+	store.Set("dune", "frank herbert")
+	store.Flush()
+	if err := store.Delete("othello"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get("othello"); err != ErrKeyNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	// the delete is flushed to the log
+	store, err = NewHybridStore("test.db", 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create hybrid store: %v", err)
+	}
+	var keys []string
+	if err := store.Fold(func(key string, value string) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		t.Fatalf("Fold() error = %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "dune" {
+		t.Errorf("Fold() keys = %v, want %v", keys, []string{"dune"})
+	}
+	store.Close()
+}
+
+func TestHybridStore_Invalid(t *testing.T) {
+	store, err := NewHybridStore("test.db", 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create hybrid store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	// the writes the log would reject are rejected right away, and never block
+	// the flushes of the valid ones
+	if err := store.Set("", "empty"); err != ErrEmptyKey {
+		t.Errorf("Set() of an empty key error = %v, want %v", err, ErrEmptyKey)
+	}
+	if err := store.Set("\x00books\x00hamlet", "shakespeare"); err != ErrReservedKey {
+		t.Errorf("Set() of a reserved key error = %v, want %v", err, ErrReservedKey)
+	}
+	if err := store.Delete("\x00books\x00"); err != ErrReservedKey {
+		t.Errorf("Delete() of a reserved key error = %v, want %v", err, ErrReservedKey)
+	}
+	store.Set("othello", "shakespeare")
+	if err := store.Flush(); err != nil {
+		t.Errorf("Flush() error = %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if err := store.Close(); err != ErrClosed {
+		t.Errorf("second Close() error = %v, want %v", err, ErrClosed)
+	}
+}
@@ -0,0 +1,164 @@
+package caskdb
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// hyperloglog file provides approximate cardinality counting, similar to Redis'
+// PFADD, PFCOUNT and PFMERGE. A HyperLogLog sketch can count millions of unique
+// elements using a fixed, small amount of memory, at the cost of a small error in
+// the count.
+//
+// The idea is to hash every element and look at the number of leading zeros in the
+// hash. Seeing a hash with n leading zeros is as rare as seeing 2^n different
+// elements. To reduce the variance, the hash space is split into many buckets
+// (called registers), each register remembers the largest number of leading zeros
+// it has seen, and the final estimate is a harmonic mean over all of them.
+//
+// Read more about it here: http://algo.inria.fr/flajolet/Publications/FlFuGaMe07.pdf
+//
+// A sketch is stored as a plain value:
+//
+//	┌────────────┬──────────────────────────┐
+//	│ magic (4B) │ registers (4096 x 1B)    │
+//	└────────────┴──────────────────────────┘
+//
+// With 4096 registers the standard error of the count is 1.04/sqrt(4096), ~1.6%.
+
+// hllMagic is stored at the start of every sketch, so that we don't mistake
+// some other value for a sketch and corrupt it
+const hllMagic = "HYLL"
+
+// hllPrecision is the number of bits of the hash used to pick a register
+const hllPrecision = 12
+
+const hllRegisters = 1 << hllPrecision
+
+var ErrInvalidHyperLogLog = errors.New("value is not a valid HyperLogLog sketch")
+
+// hllHash hashes the element with FNV-1a and mixes the result with the finaliser
+// from MurmurHash3. FNV alone does not spread short inputs well enough over the
+// high bits, which we use to pick the register. The hash has to be stable across
+// processes and machines since the sketches are persisted
+func hllHash(element string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(element))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// loadHyperLogLog returns the registers of the sketch stored at key. A missing
+// key returns empty registers
+func (d *DiskStore) loadHyperLogLog(key string) ([]byte, error) {
+	value, err := d.getOrEmpty(key)
+	if err != nil {
+		return nil, err
+	}
+	if value == "" {
+		return make([]byte, hllRegisters), nil
+	}
+	if len(value) != len(hllMagic)+hllRegisters || value[:len(hllMagic)] != hllMagic {
+		return nil, ErrInvalidHyperLogLog
+	}
+	return []byte(value[len(hllMagic):]), nil
+}
+
+// PFAdd adds the elements to the sketch stored at key, creating it if the key does
+// not exist. It returns true if the estimated cardinality changed
+func (d *DiskStore) PFAdd(key string, elements ...string) (bool, error) {
+	if err := checkReserved(key); err != nil {
+		return false, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	registers, err := d.loadHyperLogLog(key)
+	if err != nil {
+		return false, err
+	}
+	// a new sketch is written even when there are no elements to add
+	changed := !d.isLive(key)
+	for _, element := range elements {
+		hash := hllHash(element)
+		index := hash >> (64 - hllPrecision)
+		// the sentinel bit caps the count of leading zeros, in case the
+		// remaining bits of the hash are all zero
+		rank := byte(bits.LeadingZeros64(hash<<hllPrecision|1<<(hllPrecision-1)) + 1)
+		if rank > registers[index] {
+			registers[index] = rank
+			changed = true
+		}
+	}
+	if changed {
+		if err := d.set(key, hllMagic+string(registers), uint32(time.Now().Unix()), d.liveExpiry(key)); err != nil {
+			return false, err
+		}
+	}
+	return changed, nil
+}
+
+// PFCount returns the approximate number of unique elements added to the sketches
+// stored at keys. With more than one key, it returns the cardinality of their union
+func (d *DiskStore) PFCount(keys ...string) (uint64, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	union, err := d.mergeHyperLogLogs(keys)
+	if err != nil {
+		return 0, err
+	}
+	sum := 0.0
+	zeros := 0
+	for _, rank := range union {
+		sum += 1 / float64(uint64(1)<<rank)
+		if rank == 0 {
+			zeros++
+		}
+	}
+	m := float64(hllRegisters)
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	// for small cardinalities most of the registers are still empty and the
+	// estimate is biased, linear counting does much better here
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5), nil
+}
+
+// PFMerge merges the sketches stored at sources into the sketch stored at dest. The
+// existing sketch at dest, if any, is part of the merge
+func (d *DiskStore) PFMerge(dest string, sources ...string) error {
+	if err := checkReserved(dest); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	union, err := d.mergeHyperLogLogs(append([]string{dest}, sources...))
+	if err != nil {
+		return err
+	}
+	return d.set(dest, hllMagic+string(union), uint32(time.Now().Unix()), d.liveExpiry(dest))
+}
+
+func (d *DiskStore) mergeHyperLogLogs(keys []string) ([]byte, error) {
+	union := make([]byte, hllRegisters)
+	for _, key := range keys {
+		registers, err := d.loadHyperLogLog(key)
+		if err != nil {
+			return nil, err
+		}
+		for i, rank := range registers {
+			if rank > union[i] {
+				union[i] = rank
+			}
+		}
+	}
+	return union, nil
+}
@@ -0,0 +1,82 @@
+package caskdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiskStore_PFCount(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	tests := []int{0, 1, 10, 1000, 20000}
+	for _, n := range tests {
+		key := fmt.Sprintf("visitors-%d", n)
+		elements := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			elements = append(elements, fmt.Sprintf("user-%d", i))
+		}
+		// adding everything twice must not change the count
+		store.PFAdd(key, elements...)
+		store.PFAdd(key, elements...)
+		count, err := store.PFCount(key)
+		if err != nil {
+			t.Fatalf("PFCount() error = %v", err)
+		}
+		if diff := float64(count) - float64(n); diff > 0.05*float64(n)+1 || diff < -0.05*float64(n)-1 {
+			t.Errorf("PFCount() = %v, want ~%v", count, n)
+		}
+	}
+	store.Close()
+}
+
+func TestDiskStore_PFMerge(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	for i := 0; i < 1000; i++ {
+		store.PFAdd("monday", fmt.Sprintf("user-%d", i))
+		store.PFAdd("tuesday", fmt.Sprintf("user-%d", i+500))
+	}
+	if err := store.PFMerge("week", "monday", "tuesday"); err != nil {
+		t.Fatalf("PFMerge() error = %v", err)
+	}
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	week, _ := store.PFCount("week")
+	union, _ := store.PFCount("monday", "tuesday")
+	if week != union {
+		t.Errorf("PFCount() = %v, want %v", week, union)
+	}
+	if week < 1425 || week > 1575 {
+		t.Errorf("PFCount() = %v, want ~%v", week, 1500)
+	}
+	store.Close()
+}
+
+func TestDiskStore_PFAddInvalid(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("name", "jojo")
+	if _, err := store.PFAdd("name", "dio"); err != ErrInvalidHyperLogLog {
+		t.Errorf("PFAdd() error = %v, want %v", err, ErrInvalidHyperLogLog)
+	}
+	if val, _ := store.Get("name"); val != "jojo" {
+		t.Errorf("Get() = %v, want %v", val, "jojo")
+	}
+	store.Close()
+}
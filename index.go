@@ -0,0 +1,292 @@
+package caskdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// index file provides secondary indexes over the fields of JSON values. Without
+// them, finding the keys whose value has a given field, say the user with some
+// email, requires reading every value from the disk, or the application has to
+// maintain reverse lookup keys by hand.
+//
+// An index is declared on a path into the JSON document, like `$.user.email`. Once
+// declared, every Set parses the value and updates the index. Values which are not
+// JSON objects, or which do not have the field, are simply not indexed. Field values
+// are indexed by their JSON text, except strings which are indexed unquoted:
+//
+//	{"user": {"email": "jojo@example.com", "age": 17}}
+//
+//	$.user.email -> jojo@example.com
+//	$.user.age   -> 17
+//
+// Indexes are kept in memory only, like the keyDir. They are not persisted, so the
+// application needs to declare them again after opening the store, which rebuilds
+// them from the data file.
+
+var (
+	ErrInvalidIndexPath = errors.New("invalid index path")
+	ErrIndexExists      = errors.New("index already exists")
+	ErrIndexNotFound    = errors.New("index not found")
+)
+
+// jsonIndex maps the values of a single JSON field to the keys having them
+type jsonIndex struct {
+	path []string
+	// entries maps the field value to the set of keys with that value
+	entries map[string]map[string]struct{}
+	// values maps the key to its indexed field value. On Set, we use it to
+	// remove the key from the entry of its previous value
+	values map[string]string
+}
+
+// parseIndexPath splits `$.user.email` (or `user.email`) into its fields
+func parseIndexPath(path string) ([]string, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, ErrInvalidIndexPath
+	}
+	fields := strings.Split(path, ".")
+	for _, field := range fields {
+		if field == "" {
+			return nil, ErrInvalidIndexPath
+		}
+	}
+	return fields, nil
+}
+
+// extractJSONField returns the value of the field at path in the JSON document
+func extractJSONField(value string, path []string) (string, bool) {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	// keep the numbers as they were written, so that 1.0 and 1 don't both
+	// become 1 and 100000000000000000001 doesn't lose precision
+	decoder.UseNumber()
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return "", false
+	}
+	for _, field := range path {
+		object, ok := doc.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if doc, ok = object[field]; !ok {
+			return "", false
+		}
+	}
+	switch field := doc.(type) {
+	case string:
+		return field, true
+	case nil, map[string]interface{}, []interface{}:
+		return "", false
+	default:
+		var buf bytes.Buffer
+		json.NewEncoder(&buf).Encode(field)
+		return strings.TrimSuffix(buf.String(), "\n"), true
+	}
+}
+
+func newJSONIndex(path []string) *jsonIndex {
+	return &jsonIndex{
+		path:    path,
+		entries: make(map[string]map[string]struct{}),
+		values:  make(map[string]string),
+	}
+}
+
+func (idx *jsonIndex) equal(other *jsonIndex) bool {
+	return reflect.DeepEqual(idx.entries, other.entries) && reflect.DeepEqual(idx.values, other.values)
+}
+
+func (idx *jsonIndex) update(key string, value string) {
+	if old, ok := idx.values[key]; ok {
+		delete(idx.entries[old], key)
+		if len(idx.entries[old]) == 0 {
+			delete(idx.entries, old)
+		}
+		delete(idx.values, key)
+	}
+	field, ok := extractJSONField(value, idx.path)
+	if !ok {
+		return
+	}
+	if idx.entries[field] == nil {
+		idx.entries[field] = make(map[string]struct{})
+	}
+	idx.entries[field][key] = struct{}{}
+	idx.values[key] = field
+}
+
+// CreateIndex declares an index on the JSON field at path and builds it from the
+// existing data. It reads every value from the disk, so it takes time accordingly
+func (d *DiskStore) CreateIndex(path string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fields, err := parseIndexPath(path)
+	if err != nil {
+		return err
+	}
+	name := strings.Join(fields, ".")
+	if _, ok := d.indexes[name]; ok {
+		return ErrIndexExists
+	}
+	idx := newJSONIndex(fields)
+	// the expired keys are indexed too, until a merge drops them. The queries
+	// leave them out
+	err = d.keyDir.each(func(key string, kEntry KeyEntry) error {
+		value, err := d.read(kEntry)
+		if err != nil {
+			return err
+		}
+		idx.update(key, value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.indexes[name] = idx
+	return nil
+}
+
+// DropIndex removes the index on the JSON field at path
+func (d *DiskStore) DropIndex(path string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fields, err := parseIndexPath(path)
+	if err != nil {
+		return err
+	}
+	name := strings.Join(fields, ".")
+	if _, ok := d.indexes[name]; !ok {
+		return ErrIndexNotFound
+	}
+	delete(d.indexes, name)
+	return nil
+}
+
+// QueryIndex returns the keys, in sorted order, whose JSON value has the field at
+// path equal to value
+func (d *DiskStore) QueryIndex(path string, value string) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	fields, err := parseIndexPath(path)
+	if err != nil {
+		return nil, err
+	}
+	idx, ok := d.indexes[strings.Join(fields, ".")]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+	keys := make([]string, 0, len(idx.entries[value]))
+	for key := range idx.entries[value] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return d.liveKeys(keys), nil
+}
+
+// RebuildIndexes rebuilds all the declared indexes from the data file, and replaces
+// the existing ones with them. This recovers the indexes if they have gone out of
+// sync with the data, say, after a bug. It returns the names of the indexes which
+// did not match their rebuilt version, in sorted order: the path of a JSON index,
+// the name of a composite index, and `text`, `time` and `ordered` for the full-text,
+// time and ordered indexes. It reads every value from the disk, so it takes time
+// accordingly. If a value cannot be read, the existing indexes are left as they are
+func (d *DiskStore) RebuildIndexes() ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	indexes := make(map[string]*jsonIndex, len(d.indexes))
+	for name, idx := range d.indexes {
+		indexes[name] = newJSONIndex(idx.path)
+	}
+	compositeIndexes := make(map[string]*compositeIndex, len(d.compositeIndexes))
+	for name, idx := range d.compositeIndexes {
+		compositeIndexes[name] = newCompositeIndex(idx.paths)
+	}
+	var text *textIndex
+	if d.textIndex != nil {
+		text = newTextIndex()
+	}
+	// all the indexes are rebuilt in a single pass, so that we read every
+	// value only once
+	if len(indexes) > 0 || len(compositeIndexes) > 0 || text != nil {
+		err := d.keyDir.each(func(key string, kEntry KeyEntry) error {
+			value, err := d.read(kEntry)
+			if err != nil {
+				return err
+			}
+			for _, idx := range indexes {
+				idx.update(key, value)
+			}
+			for _, idx := range compositeIndexes {
+				idx.update(key, value)
+			}
+			if text != nil {
+				text.update(key, value)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	mismatched := []string{}
+	for name, idx := range indexes {
+		if !idx.equal(d.indexes[name]) {
+			mismatched = append(mismatched, "$."+name)
+		}
+	}
+	for name, idx := range compositeIndexes {
+		if !idx.equal(d.compositeIndexes[name]) {
+			mismatched = append(mismatched, name)
+		}
+	}
+	if text != nil && !text.equal(d.textIndex) {
+		mismatched = append(mismatched, "text")
+	}
+	if d.timeIndex != nil {
+		rebuilt := newTimeIndex(d.keyDir)
+		if !rebuilt.equal(d.timeIndex) {
+			mismatched = append(mismatched, "time")
+		}
+		d.timeIndex = rebuilt
+	}
+	if d.orderedIndex != nil {
+		rebuilt := newOrderedIndex(d.keyDir)
+		if !rebuilt.equal(d.orderedIndex) {
+			mismatched = append(mismatched, "ordered")
+		}
+		d.orderedIndex = rebuilt
+	}
+	d.indexes, d.compositeIndexes, d.textIndex = indexes, compositeIndexes, text
+	sort.Strings(mismatched)
+	return mismatched, nil
+}
+
+func (d *DiskStore) updateIndexes(key string, value string) {
+	for _, idx := range d.indexes {
+		idx.update(key, value)
+	}
+	if d.textIndex != nil {
+		d.textIndex.update(key, value)
+	}
+	for _, idx := range d.compositeIndexes {
+		idx.update(key, value)
+	}
+}
+
+func (d *DiskStore) removeFromIndexes(key string, previous KeyEntry) {
+	// indexing an empty value removes the key, since it cannot be parsed as JSON
+	// and has no terms
+	d.updateIndexes(key, "")
+	if d.timeIndex != nil {
+		d.timeIndex.remove(key, previous)
+	}
+	if d.orderedIndex != nil {
+		d.orderedIndex.remove(key)
+	}
+}
@@ -0,0 +1,120 @@
+package caskdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_extractJSONField(t *testing.T) {
+	tests := []struct {
+		value string
+		path  []string
+		field string
+		ok    bool
+	}{
+		{`{"user": {"email": "jojo@example.com"}}`, []string{"user", "email"}, "jojo@example.com", true},
+		{`{"user": {"age": 17}}`, []string{"user", "age"}, "17", true},
+		{`{"price": 1.50}`, []string{"price"}, "1.50", true},
+		{`{"admin": true}`, []string{"admin"}, "true", true},
+		{`{"user": {"tags": ["a"]}}`, []string{"user", "tags"}, "", false},
+		{`{"user": null}`, []string{"user", "email"}, "", false},
+		{`{"user": "jojo"}`, []string{"user", "email"}, "", false},
+		{`not json`, []string{"user"}, "", false},
+	}
+	for _, tt := range tests {
+		field, ok := extractJSONField(tt.value, tt.path)
+		if field != tt.field || ok != tt.ok {
+			t.Errorf("extractJSONField(%v) = %v, %v, want %v, %v", tt.value, field, ok, tt.field, tt.ok)
+		}
+	}
+}
+
+func TestDiskStore_QueryIndex(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("user:1", `{"name": "jojo", "city": "naples"}`)
+	store.Set("user:2", `{"name": "dio", "city": "cairo"}`)
+	if err := store.CreateIndex("$.city"); err != nil {
+		t.Fatalf("CreateIndex() error = %v", err)
+	}
+	if err := store.CreateIndex("city"); err != ErrIndexExists {
+		t.Errorf("CreateIndex() error = %v, want %v", err, ErrIndexExists)
+	}
+	store.Set("user:3", `{"name": "giorno", "city": "naples"}`)
+	store.Set("user:1", `{"name": "jojo", "city": "cairo"}`)
+	store.Set("user:4", "plain value")
+
+	tests := map[string][]string{
+		"naples": {"user:3"},
+		"cairo":  {"user:1", "user:2"},
+		"tokyo":  {},
+	}
+	for city, want := range tests {
+		keys, err := store.QueryIndex("$.city", city)
+		if err != nil {
+			t.Fatalf("QueryIndex() error = %v", err)
+		}
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("QueryIndex(%v) = %v, want %v", city, keys, want)
+		}
+	}
+	store.Delete("user:3")
+	if keys, _ := store.QueryIndex("$.city", "naples"); len(keys) != 0 {
+		t.Errorf("QueryIndex() = %v, want []", keys)
+	}
+	if _, err := store.QueryIndex("$.name", "jojo"); err != ErrIndexNotFound {
+		t.Errorf("QueryIndex() error = %v, want %v", err, ErrIndexNotFound)
+	}
+	if err := store.DropIndex("$.city"); err != nil {
+		t.Errorf("DropIndex() error = %v", err)
+	}
+	if _, err := store.QueryIndex("$.city", "cairo"); err != ErrIndexNotFound {
+		t.Errorf("QueryIndex() error = %v, want %v", err, ErrIndexNotFound)
+	}
+	if err := store.CreateIndex("$."); err != ErrInvalidIndexPath {
+		t.Errorf("CreateIndex() error = %v, want %v", err, ErrInvalidIndexPath)
+	}
+	store.Close()
+}
+
+func TestDiskStore_RebuildIndexes(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("user:1", `{"name": "jojo", "city": "naples", "age": 17}`)
+	store.Set("user:2", `{"name": "dio", "city": "cairo", "age": 120}`)
+	store.CreateIndex("$.city")
+	store.CreateIndex("$.name")
+	store.CreateCompositeIndex("city_age", "$.city", "$.age")
+	store.CreateTextIndex()
+	store.CreateTimeIndex()
+	if mismatched, err := store.RebuildIndexes(); err != nil || len(mismatched) != 0 {
+		t.Errorf("RebuildIndexes() = %v, want []", mismatched)
+	}
+
+	// make the indexes go out of sync with the data
+	store.indexes["city"].update("user:1", `{"city": "tokyo"}`)
+	store.compositeIndexes["city_age"].update("user:3", `{"city": "rome", "age": 1}`)
+	store.timeIndex.entries = store.timeIndex.entries[1:]
+	want := []string{"$.city", "city_age", "time"}
+	if mismatched, _ := store.RebuildIndexes(); !reflect.DeepEqual(mismatched, want) {
+		t.Errorf("RebuildIndexes() = %v, want %v", mismatched, want)
+	}
+	if keys, _ := store.QueryIndex("$.city", "naples"); !reflect.DeepEqual(keys, []string{"user:1"}) {
+		t.Errorf("QueryIndex() = %v, want %v", keys, []string{"user:1"})
+	}
+	if keys, _ := store.QueryCompositeIndex("city_age", CompositeQuery{}); len(keys) != 2 {
+		t.Errorf("QueryCompositeIndex() = %v, want 2 keys", keys)
+	}
+	if mismatched, err := store.RebuildIndexes(); err != nil || len(mismatched) != 0 {
+		t.Errorf("RebuildIndexes() = %v, want []", mismatched)
+	}
+	store.Close()
+}
@@ -0,0 +1,127 @@
+package caskdb
+
+import "time"
+
+// janitor file has the background janitor which removes the expired keys. An expired
+// key is treated as missing as soon as it expires, but it stays in the keyDir until it
+// is overwritten or deleted, or Merge drops it, and it comes back into the keyDir on
+// every startup until its expiry is checked again. A store of short lived keys which
+// are never touched again fills its memory with them.
+//
+// Every Interval, the janitor walks one shard of the keyDir, and deletes the expired
+// keys in it with a tombstone each, all in one batch, so that they don't come back on
+// the next startup, and Merge reclaims their records. It moves on to the next shard
+// once a run removes fewer than MaxKeys keys from the shard, so the whole keyDir is
+// walked every keyDirShards runs or so, and a run holds the store lock for at most
+// MaxKeys tombstones.
+
+// DefaultJanitorMaxKeys is the most expired keys removed by a run of the janitor, when
+// no limit is given
+const DefaultJanitorMaxKeys = 1000
+
+// Janitor is how the store removes its expired keys in the background. The zero
+// Janitor never runs
+type Janitor struct {
+	// Interval is how often the janitor runs, it does not run if it is not positive
+	Interval time.Duration
+	// MaxKeys is the most expired keys removed by a run, DefaultJanitorMaxKeys if it
+	// is not positive
+	MaxKeys int
+}
+
+// SetJanitor changes how the store removes its expired keys, the zero Janitor stops
+// it. It does nothing for a read-only store or a replica, whose keys are removed by
+// the primary
+func (d *DiskStore) SetJanitor(j Janitor) {
+	d.mu.Lock()
+	stop, done := d.janitorStop, d.janitorDone
+	d.janitorStop, d.janitorDone = nil, nil
+	if j.Interval > 0 && !d.options.ReadOnly && d.replica == nil {
+		if j.MaxKeys <= 0 {
+			j.MaxKeys = DefaultJanitorMaxKeys
+		}
+		d.janitorStop, d.janitorDone = make(chan struct{}), make(chan struct{})
+		go d.runJanitor(j, d.janitorStop, d.janitorDone)
+	}
+	d.mu.Unlock()
+	// the old janitor takes the lock to remove the keys, so we wait for it only
+	// after we have released the lock
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// runJanitor removes the expired keys of a shard every Interval, until stop is closed.
+// It closes done when it returns
+func (d *DiskStore) runJanitor(j Janitor, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	shard := 0
+	for {
+		select {
+		case <-ticker.C:
+			n, err := d.removeExpired(shard, j.MaxKeys)
+			if err != nil {
+				d.options.Logger.Log(LevelError, "failed to remove the expired keys", "dir", d.dirName, "err", err)
+				continue
+			}
+			if n > 0 {
+				d.options.Logger.Log(LevelDebug, "removed expired keys", "dir", d.dirName, "shard", shard, "keys", n)
+			}
+			// a shard with more expired keys than a run removes is walked again
+			if n < j.MaxKeys {
+				shard = (shard + 1) % keyDirShards
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// removeExpired deletes up to max of the expired keys of the i-th shard of the keyDir,
+// and returns how many it deleted
+func (d *DiskStore) removeExpired(i int, max int) (int, error) {
+	// the shard is walked without the store lock, which the writes would wait for,
+	// so the keys are checked again once we have it
+	now := uint32(d.now().Unix())
+	var keys []string
+	d.keyDir.eachInShard(i, func(key string, kEntry KeyEntry) error {
+		if kEntry.expired(now) && len(keys) < max {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	batch := NewBatch()
+	for _, key := range keys {
+		if kEntry, ok := d.keyDir.get(key); ok && kEntry.expired(now) {
+			batch.Delete(key)
+		}
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+	if err := d.commit(batch); err != nil {
+		return 0, err
+	}
+	d.expiredRemoved += batch.Len()
+	return batch.Len(), nil
+}
+
+// stopJanitor stops the janitor, if there is one, and waits for it
+func (d *DiskStore) stopJanitor() {
+	d.mu.Lock()
+	stop, done := d.janitorStop, d.janitorDone
+	d.janitorStop, d.janitorDone = nil, nil
+	d.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,110 @@
+package caskdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDiskStore_removeExpired(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	for i := 0; i < 100; i++ {
+		store.SetWithTTL(fmt.Sprintf("session-%d", i), "jojo", time.Minute)
+		store.Set(fmt.Sprintf("name-%d", i), "jojo")
+	}
+	if n, _ := store.removeExpired(0, 1000); n != 0 {
+		t.Errorf("removeExpired() before the expiry = %v, want %v", n, 0)
+	}
+	store.now = func() time.Time { return time.Now().Add(time.Hour) }
+	if stats, _ := store.Stats(); stats.ExpiredKeys != 100 {
+		t.Errorf("Stats().ExpiredKeys = %v, want %v", stats.ExpiredKeys, 100)
+	}
+
+	// the max is kept, the shard has the rest of its keys removed by the next run
+	removed := 0
+	for i := 0; i < keyDirShards; i++ {
+		for {
+			n, err := store.removeExpired(i, 1)
+			if err != nil {
+				t.Fatalf("removeExpired() error = %v", err)
+			}
+			if n > 1 {
+				t.Fatalf("removeExpired() = %v, want at most %v", n, 1)
+			}
+			if n == 0 {
+				break
+			}
+			removed += n
+		}
+	}
+	if removed != 100 {
+		t.Errorf("removeExpired() removed %v keys, want %v", removed, 100)
+	}
+	stats, _ := store.Stats()
+	if stats.ExpiredKeys != 0 || stats.ExpiredRemoved != 100 || stats.Keys != 100 {
+		t.Errorf("Stats() = %+v, want 100 keys, and 100 expired keys removed", stats)
+	}
+	store.Close()
+
+	// the tombstones keep the keys removed, even with a clock on which they are not
+	// expired
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to open the store: %v", err)
+	}
+	defer store.Close()
+	if n := store.keyDir.len(); n != 100 {
+		t.Errorf("keyDir.len() after reopening = %v, want %v", n, 100)
+	}
+	if store.Has("session-7") {
+		t.Errorf("Has() of a removed key = %v, want %v", true, false)
+	}
+}
+
+func TestDiskStore_SetJanitor(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	for i := 0; i < 100; i++ {
+		store.SetWithTTL(fmt.Sprintf("session-%d", i), "jojo", time.Minute)
+	}
+	store.Set("name", "jojo")
+	// the clock is moved before the janitor starts, which reads it
+	store.now = func() time.Time { return time.Now().Add(time.Hour) }
+	store.SetJanitor(Janitor{Interval: time.Millisecond})
+
+	var stats Stats
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, _ = store.Stats()
+		if stats.ExpiredRemoved == 100 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the janitor did not remove the expired keys, Stats() = %+v", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if stats.ExpiredKeys != 0 || stats.Keys != 1 {
+		t.Errorf("Stats() = %+v, want 1 key and no expired ones", stats)
+	}
+
+	// the zero Janitor stops it
+	store.SetJanitor(Janitor{})
+	if store.janitorStop != nil {
+		t.Errorf("the janitor is still running")
+	}
+	store.SetJanitor(Janitor{Interval: time.Hour})
+	if err := store.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if store.janitorStop != nil {
+		t.Errorf("Close() did not stop the janitor")
+	}
+}
@@ -0,0 +1,255 @@
+package caskdb
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+// KeyEntry keeps the metadata about the KV, specially the position of
+// the byte offset in the file. Whenever we insert/update a key, we create a new
+// KeyEntry object and insert that into keyDir.
+type KeyEntry struct {
+	// Timestamp at which we wrote the KV pair to the disk. The value
+	// is current time in seconds since the epoch.
+	timestamp uint32
+	// Expiry is the time in seconds since the epoch after which the KV pair
+	// is expired, or zero if it never expires
+	expiry uint32
+	// The fileID is the ID of the data file which has the KV pair
+	fileID uint32
+	// The version is the version of the format of the data file, which tells
+	// how to decode the record
+	version uint32
+	// The position is the byte offset in the file where the data
+	// exists
+	position uint64
+	// Total size of bytes of the value. We use this value to know
+	// how many bytes we need to read from the file
+	totalSize uint64
+}
+
+func NewKeyEntry(timestamp uint32, expiry uint32, fileID uint32, version uint32, position uint64, totalSize uint64) KeyEntry {
+	return KeyEntry{timestamp, expiry, fileID, version, position, totalSize}
+}
+
+// expired tells whether the KV pair is expired at now, in seconds since the epoch
+func (k KeyEntry) expired(now uint32) bool {
+	return k.expiry != 0 && k.expiry <= now
+}
+
+// keyDirShards is the number of shards of the keyDir, a power of two
+const keyDirShards = 64
+
+// keyDir maps the keys to their KeyEntry. It is split into shards by the hash of the
+// key, every shard a table of its own with its own lock.
+//
+// A single map of tens of millions of keys is slow to grow: it allocates a new table
+// twice as large and moves all the keys to it, and the garbage collector has to scan
+// the whole table. The shards grow one at a time, each a fraction of the size. The
+// locks of the shards make the keyDir safe to read while it is being written, so
+// the reads of a single key, like Has and TTL, don't have to wait for the store lock,
+// which a write holds until its record is synced to the disk.
+//
+// The writes to the keyDir are still made with the store locked exclusively, and the
+// reads of more than one key with the store locked shared, so that they see either
+// all of a batch or none of it.
+//
+// The tables of the shards are Go maps, or, with the compact option, compactTables,
+// which take a fraction of the memory. Check compact_keydir.go
+//
+// The shard of a key is picked by a hash seeded at random. With a fixed hash, one who
+// picks the keys written to the store could put all of them in the same shard. The
+// hash is never persisted, so the seed needn't be either
+type keyDir struct {
+	shards  [keyDirShards]keyDirShard
+	compact bool
+	seed    maphash.Seed
+}
+
+type keyDirShard struct {
+	mu    sync.RWMutex
+	table keyDirTable
+}
+
+// keyDirTable is the table of the keys of a shard of the keyDir
+type keyDirTable interface {
+	get(key string) (KeyEntry, bool)
+	// put and remove return the previous KeyEntry of the key, if there was one
+	put(key string, kEntry KeyEntry) (KeyEntry, bool)
+	remove(key string) (KeyEntry, bool)
+	len() int
+	// each stops at the first error returned by fn, and returns it
+	each(fn func(key string, kEntry KeyEntry) error) error
+	clone() keyDirTable
+	// memory returns an estimate of the memory taken by the table, in bytes
+	memory() int64
+}
+
+// newKeyDir returns an empty keyDir, sized for about size keys. It is made of
+// compactTables if compact is set, else of maps
+func newKeyDir(size int, compact bool) *keyDir {
+	return newSeededKeyDir(size, compact, maphash.MakeSeed())
+}
+
+// newSeededKeyDir is newKeyDir with the seed of the hash of the shards
+func newSeededKeyDir(size int, compact bool, seed maphash.Seed) *keyDir {
+	kd := &keyDir{compact: compact, seed: seed}
+	for i := range kd.shards {
+		if compact {
+			kd.shards[i].table = newCompactTable(size / keyDirShards)
+		} else {
+			kd.shards[i].table = make(mapTable, size/keyDirShards)
+		}
+	}
+	return kd
+}
+
+// empty returns an empty keyDir like kd, sized for about size keys. It has the same
+// seed, so that its tables can replace the ones of kd
+func (kd *keyDir) empty(size int) *keyDir {
+	return newSeededKeyDir(size, kd.compact, kd.seed)
+}
+
+// shard returns the shard of the key, by the seeded hash of the key
+func (kd *keyDir) shard(key string) *keyDirShard {
+	return &kd.shards[maphash.String(kd.seed, key)&(keyDirShards-1)]
+}
+
+// get returns the KeyEntry of the key, and whether it is in the keyDir
+func (kd *keyDir) get(key string) (KeyEntry, bool) {
+	shard := kd.shard(key)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.table.get(key)
+}
+
+// put sets the KeyEntry of the key, and returns the previous one, if there was one
+func (kd *keyDir) put(key string, kEntry KeyEntry) (KeyEntry, bool) {
+	shard := kd.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.table.put(key, kEntry)
+}
+
+// remove removes the key, and returns its KeyEntry, if it was in the keyDir
+func (kd *keyDir) remove(key string) (KeyEntry, bool) {
+	shard := kd.shard(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	return shard.table.remove(key)
+}
+
+// len returns the number of keys, the expired ones included
+func (kd *keyDir) len() int {
+	n := 0
+	for i := range kd.shards {
+		kd.shards[i].mu.RLock()
+		n += kd.shards[i].table.len()
+		kd.shards[i].mu.RUnlock()
+	}
+	return n
+}
+
+// each calls fn for every key and its KeyEntry, in no particular order. It stops at
+// the first error returned by fn, and returns it. The shard being walked is locked,
+// so fn must not write to the keyDir
+func (kd *keyDir) each(fn func(key string, kEntry KeyEntry) error) error {
+	for i := range kd.shards {
+		if err := kd.eachInShard(i, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachInShard is like each, but only for the keys of the i-th shard
+func (kd *keyDir) eachInShard(i int, fn func(key string, kEntry KeyEntry) error) error {
+	shard := &kd.shards[i]
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.table.each(fn)
+}
+
+// memory returns an estimate of the memory taken by the keyDir, in bytes
+func (kd *keyDir) memory() int64 {
+	var n int64
+	for i := range kd.shards {
+		kd.shards[i].mu.RLock()
+		n += kd.shards[i].table.memory()
+		kd.shards[i].mu.RUnlock()
+	}
+	return n
+}
+
+// replace replaces the keys of the keyDir with the ones of other, which must not be
+// used afterwards and must have the same seed, check empty. The keyDir is replaced
+// shard by shard, in place, since its readers don't hold the store lock
+func (kd *keyDir) replace(other *keyDir) {
+	for i := range kd.shards {
+		shard := &kd.shards[i]
+		shard.mu.Lock()
+		shard.table = other.shards[i].table
+		shard.mu.Unlock()
+	}
+}
+
+// clone returns a copy of the keyDir
+func (kd *keyDir) clone() *keyDir {
+	c := &keyDir{compact: kd.compact, seed: kd.seed}
+	for i := range kd.shards {
+		shard := &kd.shards[i]
+		shard.mu.RLock()
+		c.shards[i].table = shard.table.clone()
+		shard.mu.RUnlock()
+	}
+	return c
+}
+
+// mapTable is the keyDirTable of a Go map
+type mapTable map[string]KeyEntry
+
+func (t mapTable) get(key string) (KeyEntry, bool) {
+	kEntry, ok := t[key]
+	return kEntry, ok
+}
+
+func (t mapTable) put(key string, kEntry KeyEntry) (KeyEntry, bool) {
+	previous, ok := t[key]
+	t[key] = kEntry
+	return previous, ok
+}
+
+func (t mapTable) remove(key string) (KeyEntry, bool) {
+	previous, ok := t[key]
+	delete(t, key)
+	return previous, ok
+}
+
+func (t mapTable) len() int {
+	return len(t)
+}
+
+func (t mapTable) each(fn func(key string, kEntry KeyEntry) error) error {
+	for key, kEntry := range t {
+		if err := fn(key, kEntry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t mapTable) clone() keyDirTable {
+	c := make(mapTable, len(t))
+	for key, kEntry := range t {
+		c[key] = kEntry
+	}
+	return c
+}
+
+func (t mapTable) memory() int64 {
+	var n int64
+	for key := range t {
+		n += int64(len(key)) + keyDirEntryOverhead
+	}
+	return n
+}
@@ -0,0 +1,101 @@
+package caskdb
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestKeyDir(t *testing.T) {
+	for _, compact := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compact=%v", compact), func(t *testing.T) {
+			kd := newKeyDir(0, compact)
+			for i := 0; i < 1000; i++ {
+				kd.put(fmt.Sprintf("key-%d", i), NewKeyEntry(uint32(i), 0, 1, 2, uint64(i), 10))
+			}
+			if n := kd.len(); n != 1000 {
+				t.Errorf("len() = %v, want %v", n, 1000)
+			}
+			// the keys are spread over the shards
+			for i := range kd.shards {
+				if kd.shards[i].table.len() == 0 {
+					t.Errorf("shard %d is empty", i)
+				}
+			}
+			if previous, ok := kd.put("key-7", NewKeyEntry(2000, 0, 1, 2, 0, 10)); !ok || previous.timestamp != 7 {
+				t.Errorf("put() = %+v, %v, want the previous entry", previous, ok)
+			}
+			if kEntry, ok := kd.get("key-7"); !ok || kEntry.timestamp != 2000 {
+				t.Errorf("get() = %+v, %v, want the new entry", kEntry, ok)
+			}
+			if _, ok := kd.remove("key-7"); !ok {
+				t.Errorf("remove() = %v, want %v", ok, true)
+			}
+			if _, ok := kd.get("key-7"); ok {
+				t.Errorf("get() of a removed key = %v, want %v", ok, false)
+			}
+			if _, ok := kd.remove("key-7"); ok {
+				t.Errorf("remove() of a removed key = %v, want %v", ok, false)
+			}
+
+			clone := kd.clone()
+			kd.put("key-7", NewKeyEntry(7, 0, 1, 2, 7, 10))
+			if _, ok := clone.get("key-7"); ok {
+				t.Errorf("the clone has a key put after it was taken")
+			}
+			var keys []string
+			clone.each(func(key string, _ KeyEntry) error {
+				keys = append(keys, key)
+				return nil
+			})
+			if len(keys) != 999 {
+				t.Errorf("each() walked %v keys, want %v", len(keys), 999)
+			}
+			sort.Strings(keys)
+			for i := 1; i < len(keys); i++ {
+				if keys[i] == keys[i-1] {
+					t.Errorf("each() walked %q twice", keys[i])
+				}
+			}
+
+			kd.replace(clone)
+			if n := kd.len(); n != 999 {
+				t.Errorf("len() after replace() = %v, want %v", n, 999)
+			}
+		})
+	}
+}
+
+func TestDiskStore_HasConcurrent(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("othello", "shakespeare")
+
+	// Has and TTL read the keyDir without the store lock, while the writes and a
+	// merge change it
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			store.Set(fmt.Sprintf("key-%d", i), "value")
+			if i%10 == 0 {
+				store.Merge()
+			}
+		}
+	}()
+	for i := 0; i < 200; i++ {
+		if !store.Has("othello") {
+			t.Fatalf("Has() = %v, want %v", false, true)
+		}
+		if _, err := store.TTL("othello"); err != nil {
+			t.Fatalf("TTL() error = %v", err)
+		}
+	}
+	wg.Wait()
+	store.Close()
+}
@@ -0,0 +1,45 @@
+package caskdb
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// ErrDatabaseLocked is returned when opening a store which is open in another process
+var ErrDatabaseLocked = errors.New("database is locked by another process")
+
+// lockFileName is the name of the lock file in the data directory
+const lockFileName = "LOCK"
+
+// lock takes the lock of the data directory. Two processes appending to the same data
+// files would corrupt them, so only one process may open the store for writes. Read
+// only stores take a shared lock, so that any number of them can be open at once, but
+// not along with a writer. The lock is advisory, it keeps out only the processes which
+// take it too, and it is released when the lock file is closed, even if the process
+// crashes
+func (d *DiskStore) lock() error {
+	fileName := filepath.Join(d.dirName, lockFileName)
+	var file File
+	var err error
+	if d.options.ReadOnly {
+		file, err = d.options.Storage.OpenFile(fileName, os.O_RDONLY, 0)
+		// the lock file is missing only if the store was never opened for
+		// writes, there is nothing to lock then
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+	} else {
+		file, err = d.options.Storage.OpenFile(fileName, os.O_CREATE|os.O_RDWR, d.options.FileMode)
+	}
+	if err != nil {
+		return err
+	}
+	if err := d.options.Storage.Lock(file, !d.options.ReadOnly); err != nil {
+		file.Close()
+		return err
+	}
+	d.lockFile = file
+	return nil
+}
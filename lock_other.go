@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+package caskdb
+
+import "os"
+
+// tryLock does nothing on the platforms without flock or LockFileEx, the store is not
+// protected from being opened by two processes there
+func tryLock(file *os.File, exclusive bool) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+package caskdb
+
+import "testing"
+
+func TestDiskStore_Lock(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	if _, err := NewDiskStore("test.db"); err != ErrDatabaseLocked {
+		t.Errorf("NewDiskStore() error = %v, want %v", err, ErrDatabaseLocked)
+	}
+	if _, err := Open("test.db", WithReadOnly()); err != ErrDatabaseLocked {
+		t.Errorf("Open() in read-only mode error = %v, want %v", err, ErrDatabaseLocked)
+	}
+	store.Close()
+
+	// any number of readers can have the store open, but not along with a writer
+	reader1, err := Open("test.db", WithReadOnly())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	reader2, err := Open("test.db", WithReadOnly())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if _, err := NewDiskStore("test.db"); err != ErrDatabaseLocked {
+		t.Errorf("NewDiskStore() error = %v, want %v", err, ErrDatabaseLocked)
+	}
+	reader1.Close()
+	reader2.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("NewDiskStore() after Close() error = %v", err)
+	}
+	store.Close()
+}
@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package caskdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// tryLock takes an flock on the file without waiting for it, and returns
+// ErrDatabaseLocked if another process holds it
+func tryLock(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	err := syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrDatabaseLocked
+	}
+	return os.NewSyscallError("flock", err)
+}
@@ -0,0 +1,36 @@
+//go:build windows
+
+package caskdb
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procLockFileEx = syscall.NewLazyDLL("kernel32.dll").NewProc("LockFileEx")
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	errorLockViolation      = syscall.Errno(33)
+)
+
+// tryLock locks the file with LockFileEx without waiting for it, and returns
+// ErrDatabaseLocked if another process holds the lock. The syscall package does not
+// have LockFileEx, so we load it from kernel32.dll
+func tryLock(file *os.File, exclusive bool) error {
+	flags := uint32(lockfileFailImmediately)
+	if exclusive {
+		flags |= lockfileExclusiveLock
+	}
+	var overlapped syscall.Overlapped
+	r, _, err := procLockFileEx.Call(file.Fd(), uintptr(flags), 0, 1, 0, uintptr(unsafe.Pointer(&overlapped)))
+	if r != 0 {
+		return nil
+	}
+	if err == errorLockViolation {
+		return ErrDatabaseLocked
+	}
+	return os.NewSyscallError("LockFileEx", err)
+}
@@ -0,0 +1,101 @@
+package caskdb
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the importance of a log message
+type Level int
+
+const (
+	// LevelDebug is for the details which help debugging, like every key loaded at
+	// the startup
+	LevelDebug Level = iota
+	// LevelInfo is for the events of the store, like opening it or a merge
+	LevelInfo
+	// LevelWarn is for what went wrong but was recovered from, like a partially
+	// written record cut off at the startup
+	LevelWarn
+	// LevelError is for the errors which could not be returned to the caller, like
+	// a failed background sync
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return "LEVEL(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Logger is where the store reports what it is doing, and the errors which it cannot
+// return to the caller. The messages are structured: args are key value pairs which
+// describe the event, like "file", 3. The store never logs the values.
+//
+// The Log method is shaped after log/slog, so a slog.Logger can be plugged in with
+// a one line adapter
+type Logger interface {
+	Log(level Level, msg string, args ...interface{})
+}
+
+// textLogger is the Logger returned by NewLogger
+type textLogger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+// NewLogger returns a Logger which writes the messages of the level and above to w,
+// one per line, like:
+//
+//	time=2022-09-12T10:00:00Z level=INFO msg="opened store" dir=books.db keys=3
+func NewLogger(w io.Writer, level Level) Logger {
+	return &textLogger{w: w, level: level}
+}
+
+func (l *textLogger) Log(level Level, msg string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("time=" + time.Now().UTC().Format(time.RFC3339))
+	b.WriteString(" level=" + level.String())
+	b.WriteString(" msg=" + quoteLogValue(msg))
+	for i := 0; i < len(args); i += 2 {
+		key := fmt.Sprint(args[i])
+		value := "!MISSING"
+		if i+1 < len(args) {
+			value = fmt.Sprint(args[i+1])
+		}
+		b.WriteString(" " + key + "=" + quoteLogValue(value))
+	}
+	b.WriteByte('\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, b.String())
+}
+
+// discardLogger is the Logger of WithLogger(nil), which drops all the messages
+type discardLogger struct{}
+
+func (discardLogger) Log(level Level, msg string, args ...interface{}) {}
+
+// quoteLogValue quotes the value if it would not be read back as one otherwise
+func quoteLogValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " =\"\t\r\n") {
+		return strconv.Quote(value)
+	}
+	return value
+}
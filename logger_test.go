@@ -0,0 +1,33 @@
+package caskdb
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(&buf, LevelInfo)
+	logger.Log(LevelDebug, "loaded key", "key", "name")
+	logger.Log(LevelInfo, "opened store", "dir", "books.db", "keys", 3)
+	logger.Log(LevelError, "failed to sync", "err", errors.New("disk full"), "odd")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("the logger wrote %q, want 2 lines", buf.String())
+	}
+	want := []string{
+		`level=INFO msg="opened store" dir=books.db keys=3`,
+		`level=ERROR msg="failed to sync" err="disk full" odd=!MISSING`,
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "time=") || !strings.HasSuffix(line, want[i]) {
+			t.Errorf("line %d = %q, want the time and %q", i, line, want[i])
+		}
+	}
+	if got := Level(7).String(); got != "LEVEL(7)" {
+		t.Errorf("String() = %q, want %q", got, "LEVEL(7)")
+	}
+}
@@ -0,0 +1,178 @@
+package caskdb
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// errReadOnlyFile is returned when writing to a file of MemoryStorage opened read only
+var errReadOnlyFile = errors.New("file is opened read only")
+
+// MemoryStorage keeps the files in the memory, they are gone once it is garbage
+// collected. It is handy for the tests, which then do not touch the disk. Not to be
+// confused with MemoryStore, which is a store, MemoryStorage is what a DiskStore
+// keeps its files in:
+//
+//	store, _ := Open("books.db", WithStorage(NewMemoryStorage()))
+//
+// Like on Unix, a removed file can still be read from the Files opened before. The
+// locks are not enforced, a MemoryStorage must not be shared by two open stores
+type MemoryStorage struct {
+	mu    sync.Mutex
+	files map[string]*memoryData
+	dirs  map[string]bool
+}
+
+// memoryData is the contents of a file of MemoryStorage
+type memoryData struct {
+	mu   sync.RWMutex
+	data []byte
+}
+
+// memoryFile is a File of MemoryStorage
+type memoryFile struct {
+	file     *memoryData
+	writable bool
+}
+
+// NewMemoryStorage returns an empty MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		files: make(map[string]*memoryData),
+		dirs:  make(map[string]bool),
+	}
+}
+
+func (m *MemoryStorage) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	file, ok := m.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if !m.dirs[filepath.Dir(name)] {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		file = &memoryData{}
+		m.files[name] = file
+	}
+	if flag&os.O_TRUNC != 0 {
+		file.mu.Lock()
+		file.data = nil
+		file.mu.Unlock()
+	}
+	return &memoryFile{file: file, writable: flag&(os.O_WRONLY|os.O_RDWR) != 0}, nil
+}
+
+func (m *MemoryStorage) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *MemoryStorage) Rename(oldName string, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldName, newName = filepath.Clean(oldName), filepath.Clean(newName)
+	file, ok := m.files[oldName]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldName)
+	m.files[newName] = file
+	return nil
+}
+
+func (m *MemoryStorage) Truncate(name string, size int64) error {
+	m.mu.Lock()
+	file, ok := m.files[filepath.Clean(name)]
+	m.mu.Unlock()
+	if !ok {
+		return &fs.PathError{Op: "truncate", Path: name, Err: fs.ErrNotExist}
+	}
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	if size < int64(len(file.data)) {
+		file.data = file.data[:size:size]
+	}
+	return nil
+}
+
+func (m *MemoryStorage) ReadDir(name string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = filepath.Clean(name)
+	if !m.dirs[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	var names []string
+	for fileName := range m.files {
+		if filepath.Dir(fileName) == name {
+			names = append(names, filepath.Base(fileName))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *MemoryStorage) MkdirAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name = filepath.Clean(name); !m.dirs[name]; name = filepath.Dir(name) {
+		m.dirs[name] = true
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Lock(file File, exclusive bool) error {
+	return nil
+}
+
+func (f *memoryFile) ReadAt(p []byte, off int64) (int, error) {
+	f.file.mu.RLock()
+	defer f.file.mu.RUnlock()
+	if off >= int64(len(f.file.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.file.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memoryFile) Write(p []byte) (int, error) {
+	if !f.writable {
+		return 0, errReadOnlyFile
+	}
+	f.file.mu.Lock()
+	defer f.file.mu.Unlock()
+	f.file.data = append(f.file.data, p...)
+	return len(p), nil
+}
+
+func (f *memoryFile) Sync() error {
+	return nil
+}
+
+func (f *memoryFile) Close() error {
+	return nil
+}
+
+func (f *memoryFile) Size() (int64, error) {
+	f.file.mu.RLock()
+	defer f.file.mu.RUnlock()
+	return int64(len(f.file.data)), nil
+}
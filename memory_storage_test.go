@@ -0,0 +1,97 @@
+package caskdb
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	if _, err := storage.OpenFile("db/a", os.O_CREATE|os.O_RDWR, 0666); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenFile() in a missing directory error = %v, want %v", err, fs.ErrNotExist)
+	}
+	storage.MkdirAll("db")
+	file, err := storage.OpenFile("db/a", os.O_APPEND|os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	file.Write([]byte("frank "))
+	file.Write([]byte("herbert"))
+	buf := make([]byte, 7)
+	if _, err := file.ReadAt(buf, 6); err != nil || string(buf) != "herbert" {
+		t.Errorf("ReadAt() = %q, %v, want %q", buf, err, "herbert")
+	}
+	if _, err := file.ReadAt(buf, 10); err != io.EOF {
+		t.Errorf("ReadAt() past the end error = %v, want %v", err, io.EOF)
+	}
+
+	reader, err := storage.OpenFile("db/a", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() error = %v", err)
+	}
+	if _, err := reader.Write([]byte("x")); err == nil {
+		t.Errorf("Write() to a read only file, want an error")
+	}
+	if err := storage.Rename("db/a", "db/b"); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if names, _ := storage.ReadDir("db"); strings.Join(names, ",") != "b" {
+		t.Errorf("ReadDir() = %v, want [b]", names)
+	}
+	// like on unix, the open files can still be read after they are removed
+	storage.Remove("db/b")
+	if size, _ := reader.Size(); size != 13 {
+		t.Errorf("Size() = %v, want %v", size, 13)
+	}
+	if _, err := storage.OpenFile("db/b", os.O_RDONLY, 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("OpenFile() of a removed file error = %v, want %v", err, fs.ErrNotExist)
+	}
+	if err := storage.Truncate("db/b", 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Truncate() of a removed file error = %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestOpen_MemoryStorage(t *testing.T) {
+	storage := NewMemoryStorage()
+	store, err := Open("test.db", WithStorage(storage), WithMaxFileSize(64), WithValueThreshold(32))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	plot := strings.Repeat("the spice must flow ", 4)
+	store.Set("othello", "shakespeare")
+	store.Set("dune", plot)
+	store.Set("emma", "austen")
+	store.Set("othello", "william shakespeare")
+	store.Delete("emma")
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	store.Set("hamlet", "shakespeare")
+	store.Close()
+	if isFileExists("test.db") {
+		t.Errorf("the store on a MemoryStorage created the data directory on the disk")
+	}
+
+	// with the hint file, and without it
+	for i := 0; i < 2; i++ {
+		store, err = Open("test.db", WithStorage(storage))
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		want := map[string]string{"othello": "william shakespeare", "dune": plot, "hamlet": "shakespeare"}
+		for key, value := range want {
+			if got, err := store.Get(key); err != nil || got != value {
+				t.Errorf("Get(%q) = %q, %v, want %q", key, got, err, value)
+			}
+		}
+		if _, err := store.Get("emma"); err != ErrKeyNotFound {
+			t.Errorf("Get() error = %v, want %v", err, ErrKeyNotFound)
+		}
+		store.Close()
+		storage.Remove(hintFileName("test.db"))
+	}
+}
@@ -1,21 +1,69 @@
 package caskdb
 
+import (
+	"sort"
+	"sync"
+)
+
+// MemoryStore is a Store which keeps the data in a map, and nothing on the disk. It is
+// a fast stand in for a DiskStore in the unit tests, and an ephemeral cache. It is
+// safe for concurrent use
 type MemoryStore struct {
+	mu   sync.RWMutex
 	data map[string]string
 }
 
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{make(map[string]string)}
+	return &MemoryStore{data: make(map[string]string)}
 }
 
-func (m *MemoryStore) Get(key string) string {
-	return m.data[key]
+func (m *MemoryStore) Get(key string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	value, ok := m.data[key]
+	if !ok {
+		return "", ErrKeyNotFound
+	}
+	return value, nil
 }
 
-func (m *MemoryStore) Set(key string, value string) {
+func (m *MemoryStore) Set(key string, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.data[key] = value
+	return nil
+}
+
+func (m *MemoryStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.data, key)
+	return nil
+}
+
+// Fold calls fn for every key in the store and its value, in the key order. Like
+// DiskStore.Fold, it walks the keys which exist when it is called, and the store is
+// not locked while fn runs, so fn may read and write the store
+func (m *MemoryStore) Fold(fn func(key string, value string) error) error {
+	m.mu.RLock()
+	keys := make([]string, 0, len(m.data))
+	for key := range m.data {
+		keys = append(keys, key)
+	}
+	m.mu.RUnlock()
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := m.Get(key)
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (m *MemoryStore) Close() bool {
-	return true
+func (m *MemoryStore) Close() error {
+	return nil
 }
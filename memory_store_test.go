@@ -1,25 +1,67 @@
 package caskdb
 
-import "testing"
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
 
 func TestMemoryStore_Get(t *testing.T) {
 	store := NewMemoryStore()
 	store.Set("name", "jojo")
-	if val := store.Get("name"); val != "jojo" {
+	if val, _ := store.Get("name"); val != "jojo" {
 		t.Errorf("Get() = %v, want %v", val, "jojo")
 	}
 }
 
 func TestMemoryStore_InvalidGet(t *testing.T) {
 	store := NewMemoryStore()
-	if val := store.Get("some rando key"); val != "" {
-		t.Errorf("Get() = %v, want %v", val, "")
+	if val, err := store.Get("some rando key"); err != ErrKeyNotFound || val != "" {
+		t.Errorf("Get() = %v, %v, want %v, %v", val, err, "", ErrKeyNotFound)
 	}
 }
 
 func TestMemoryStore_Close(t *testing.T) {
 	store := NewMemoryStore()
-	if !store.Close() {
-		t.Errorf("Close() failed")
+	if err := store.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+}
+
+func TestMemoryStore_Delete(t *testing.T) {
+	store := NewMemoryStore()
+	store.Set("name", "jojo")
+	if err := store.Delete("name"); err != nil {
+		t.Errorf("Delete() error = %v", err)
+	}
+	if _, err := store.Get("name"); err != ErrKeyNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if err := store.Delete("name"); err != nil {
+		t.Errorf("Delete() of a missing key error = %v", err)
+	}
+}
+
+func TestMemoryStore_Fold(t *testing.T) {
+	var store Store = NewMemoryStore()
+	store.Set("othello", "shakespeare")
+	store.Set("dune", "herbert")
+	store.Set("emma", "austen")
+	var got []string
+	err := store.Fold(func(key string, value string) error {
+		got = append(got, key+"="+value)
+		// fn may write to the store, the deleted keys are skipped
+		return store.Delete("emma")
+	})
+	if err != nil {
+		t.Fatalf("Fold() error = %v", err)
+	}
+	want := []string{"dune=herbert", "othello=shakespeare"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Fold() got %v, want %v", got, want)
+	}
+	stop := errors.New("stop")
+	if err := store.Fold(func(key string, value string) error { return stop }); err != stop {
+		t.Errorf("Fold() error = %v, want %v", err, stop)
 	}
 }
@@ -0,0 +1,298 @@
+package caskdb
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// Merge compacts the data files. Since the files are append only, every update leaves
+// the key's old record behind, and every delete leaves the key's records and a
+// tombstone. These dead records take up space forever, unless we rewrite the files
+// with only the live records. This is the merge process of the BitCask paper.
+//
+// Merge works in the following steps:
+//  1. Remove the hint file, since it points to the records in the old files
+//  2. Copy the current record of every key in keyDir to new data files, numbered
+//     after the active file. The records are copied as is, so they keep their
+//     timestamps
+//  3. Sync the new files to the disk
+//  4. Point the keyDir entries to the new locations of the records, and make the
+//     last new file the active one
+//  5. Remove the old data files, oldest first
+//  6. Write the hint file for the new data files
+//
+// The tombstones are not copied, since the records they delete are gone too. The
+// expired keys are not copied either, so they are gone for good.
+//
+// If we crash before all the old files are removed, the remaining old files are read
+// before the new ones at the startup, and the new ones have the final say. Since the
+// old files are removed oldest first, a tombstone is never gone while the records it
+// deletes are still around.
+//
+// Merge rewrites all the live data, so it takes time accordingly, and the store
+// cannot be used while it runs.
+func (d *DiskStore) Merge() error {
+	return d.MergeWith(MergeOptions{})
+}
+
+// MergeOptions are the callbacks of MergeWith, which change the data while it is
+// rewritten anyway. Either can be nil
+type MergeOptions struct {
+	// Keep is called for every live key with its Meta, and the key is dropped
+	// if it returns false, as if it were deleted
+	Keep func(key string, meta Meta) bool
+	// Transform is called for every key kept with its value, and returns the new
+	// value, and true if it is to replace the old one. The new value keeps the
+	// timestamp and the expiry of the old one
+	Transform func(key string, value string) (string, bool)
+}
+
+// MergeWith is like Merge, but it calls the callbacks of the options for the keys
+// being copied, to drop them or rewrite their values. This can scrub the data or
+// migrate it to a new schema without a separate pass over all of it. The callbacks
+// are called with the store locked, so they must not use the store. If the options
+// fail the merge, by a new value being too large, nothing is changed.
+//
+// The keys Keep drops get a tombstone in the new files. Else, if we crash before all
+// the old files are removed, their records in the old files would bring them back
+func (d *DiskStore) MergeWith(opts MergeOptions) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrClosed
+	}
+	if d.options.ReadOnly || d.replica != nil {
+		return ErrReadOnly
+	}
+	start := time.Now()
+	err := d.merge(opts)
+	d.metrics.ObserveMerge(time.Since(start), err)
+	return err
+}
+
+// merge is MergeWith, with the store locked
+func (d *DiskStore) merge(opts MergeOptions) error {
+	if err := d.syncValueLog(); err != nil {
+		return err
+	}
+	if err := d.syncFile(d.activeFile()); err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := d.options.Storage.Remove(hintFileName(d.dirName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	keyDir := d.keyDir.empty(d.keyDir.len())
+	files := make(map[uint32]File)
+	// abort removes the new files written so far, the old files and the keyDir
+	// are left untouched
+	abort := func(err error) error {
+		for fileID, file := range files {
+			file.Close()
+			d.options.Storage.Remove(dataFileName(d.dirName, fileID))
+		}
+		return err
+	}
+	fileID := d.activeFileID
+	var file File
+	var writer *bufio.Writer
+	position := 0
+	// next syncs the current new file, if any, and starts the next one
+	next := func() error {
+		if writer != nil {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			if err := d.syncFile(file); err != nil {
+				return err
+			}
+		}
+		newFile, err := d.openDataFile(fileID + 1)
+		if err != nil {
+			return err
+		}
+		fileID++
+		file = newFile
+		files[fileID] = file
+		writer = bufio.NewWriter(file)
+		// the new file has just the file header
+		position = format.FileHeaderSize
+		return nil
+	}
+	if err := next(); err != nil {
+		return abort(err)
+	}
+	// appendRecord writes the record to the new files, and returns its position
+	appendRecord := func(data []byte) (int, error) {
+		if position > format.FileHeaderSize && position+len(data) > d.maxFileSize {
+			if err := next(); err != nil {
+				return 0, err
+			}
+		}
+		if _, err := writer.Write(data); err != nil {
+			return 0, err
+		}
+		position += len(data)
+		return position - len(data), nil
+	}
+	now := uint32(d.now().Unix())
+	expired := make(map[string]KeyEntry)
+	// dropped has the keys Keep dropped, and transformed the new values of the keys
+	// Transform rewrote
+	dropped := make(map[string]KeyEntry)
+	transformed := make(map[string]string)
+	// liveValueLogs has the IDs of the value logs which the live records point to,
+	// the rest are removed at the end
+	liveValueLogs := make(map[uint32]bool)
+	err := d.keyDir.each(func(key string, kEntry KeyEntry) error {
+		if kEntry.expired(now) {
+			expired[key] = kEntry
+			return nil
+		}
+		if opts.Keep != nil && !opts.Keep(key, kEntry.meta(key)) {
+			dropped[key] = kEntry
+			_, data := format.EncodeTombstone(now, key)
+			_, err := appendRecord(d.encrypt(data))
+			return err
+		}
+		data, err := d.mergeRecord(key, kEntry, opts.Transform, transformed)
+		if err != nil {
+			return err
+		}
+		if _, _, _, valueSize := format.DecodeHeader(format.Version, data); format.IsValuePointer(valueSize) {
+			// the record is copied as it is, encrypted or not, but we need the
+			// pointer in it
+			plain, err := decryptRecord(d.aead, format.Version, data)
+			if err != nil {
+				return err
+			}
+			_, _, keySize, _ := format.DecodeHeader(format.Version, plain)
+			pointer, err := format.DecodeValuePointer(plain[format.HeaderSize+int(keySize):])
+			if err != nil {
+				return err
+			}
+			liveValueLogs[pointer.FileID] = true
+		}
+		recordPosition, err := appendRecord(data)
+		if err != nil {
+			return err
+		}
+		keyDir.put(key, NewKeyEntry(kEntry.timestamp, kEntry.expiry, fileID, format.Version, uint64(recordPosition), uint64(len(data))))
+		return nil
+	})
+	if err != nil {
+		return abort(err)
+	}
+	if err := writer.Flush(); err != nil {
+		return abort(err)
+	}
+	if err := d.syncFile(file); err != nil {
+		return abort(err)
+	}
+	oldFiles := d.files
+	d.files = files
+	d.keyDir.replace(keyDir)
+	d.activeFileID = fileID
+	d.activeVersion = format.Version
+	d.writePosition = position
+	for key, kEntry := range expired {
+		d.removeFromIndexes(key, kEntry)
+	}
+	for key, kEntry := range dropped {
+		d.removeFromIndexes(key, kEntry)
+		d.notify(Event{Type: EventDelete, Key: key, Timestamp: d.now()})
+	}
+	for key, value := range transformed {
+		kEntry, _ := d.keyDir.get(key)
+		d.updateIndexes(key, value)
+		d.notify(Event{Type: EventSet, Key: key, Value: value, Timestamp: time.Unix(int64(kEntry.timestamp), 0)})
+	}
+	oldIDs := make([]uint32, 0, len(oldFiles))
+	for oldID := range oldFiles {
+		oldIDs = append(oldIDs, oldID)
+	}
+	sort.Slice(oldIDs, func(i, j int) bool { return oldIDs[i] < oldIDs[j] })
+	// Windows does not allow removing a file which is open, so we close each old
+	// file before removing it. The files pinned by a snapshot stay open, check
+	// snapshot.go
+	for fileID := range d.files {
+		if fileID != d.activeFileID {
+			d.mapDataFile(fileID)
+		}
+	}
+	for _, oldID := range oldIDs {
+		d.unmapDataFile(oldID)
+		d.closeFile(oldFiles[oldID])
+		if err := d.options.Storage.Remove(dataFileName(d.dirName, oldID)); err != nil {
+			return err
+		}
+	}
+	if err := d.removeDeadValueLogs(liveValueLogs); err != nil {
+		return err
+	}
+	d.lastMerge = d.now()
+	// the replicas at the old files have to start over
+	d.signalAppend()
+	d.options.Logger.Log(LevelInfo, "merged store", "dir", d.dirName, "keys", d.keyDir.len(),
+		"files_before", len(oldFiles), "files_after", len(d.files), "took", time.Since(start))
+	return d.writeHintFile()
+}
+
+// mergeRecord returns the record of the key to copy to the new files. It is the
+// record as it is on the disk, upgraded to the current version of the format, unless
+// transform rewrites the value, in which case it is a new record with the new value,
+// which is added to transformed
+func (d *DiskStore) mergeRecord(key string, kEntry KeyEntry, transform func(key string, value string) (string, bool), transformed map[string]string) ([]byte, error) {
+	data := make([]byte, kEntry.totalSize)
+	if _, err := d.files[kEntry.fileID].ReadAt(data, int64(kEntry.position)); err != nil {
+		return nil, err
+	}
+	// we don't want to carry a corrupt record into the new files, where it
+	// would look as valid as the rest
+	if err := format.VerifyChecksum(data); err != nil {
+		return nil, err
+	}
+	if transform == nil {
+		return d.upgradeRecord(kEntry.version, data)
+	}
+	value, err := decodeRecordValue(d.aead, d.valueLogs, kEntry.version, data)
+	if err != nil {
+		return nil, err
+	}
+	newValue, ok := transform(key, string(value))
+	if !ok {
+		return d.upgradeRecord(kEntry.version, data)
+	}
+	if err := d.checkSize(key, newValue); err != nil {
+		return nil, err
+	}
+	_, data, err = d.encodeKV(kEntry.timestamp, kEntry.expiry, key, newValue)
+	if err != nil {
+		return nil, err
+	}
+	transformed[key] = newValue
+	return data, nil
+}
+
+// upgradeRecord returns the record read from a data file of the version of the format
+// as a record of the current version, which the new files are in. An encrypted record
+// is decrypted and encrypted again, since its header is authenticated along with it
+func (d *DiskStore) upgradeRecord(version uint32, data []byte) ([]byte, error) {
+	if version == format.Version {
+		return data, nil
+	}
+	_, _, keySize, _ := format.DecodeHeader(version, data)
+	if !format.IsEncrypted(keySize) {
+		return format.UpgradeRecord(version, data), nil
+	}
+	plain, err := decryptRecord(d.aead, version, data)
+	if err != nil {
+		return nil, err
+	}
+	return d.encrypt(format.UpgradeRecord(version, plain)), nil
+}
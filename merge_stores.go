@@ -0,0 +1,128 @@
+package caskdb
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrStoreExists = errors.New("destination store already exists")
+
+// KeyVersion is one of the values of a key, as found in one of the stores being
+// merged
+type KeyVersion struct {
+	Value     string
+	Timestamp time.Time
+	// ExpiresAt is the time at which the version expires, the zero time if it
+	// never expires
+	ExpiresAt time.Time
+	// Source is the file name of the store having this version
+	Source string
+}
+
+// Resolver picks the value to keep for a key found in more than one of the stores
+// being merged. The versions are in the order of the stores passed to MergeStores
+type Resolver func(key string, versions []KeyVersion) KeyVersion
+
+// LatestWins is the default Resolver, which keeps the version with the latest
+// timestamp. On a tie, the version from the store passed last wins
+func LatestWins(key string, versions []KeyVersion) KeyVersion {
+	latest := versions[0]
+	for _, version := range versions[1:] {
+		if !version.Timestamp.Before(latest.Timestamp) {
+			latest = version
+		}
+	}
+	return latest
+}
+
+// MergeStores combines the stores at srcs into a new store at dst, keeping only the
+// current value of every key, so the new store has no stale records. When a key
+// exists in more than one store, the version with the latest timestamp is kept. This
+// is useful to consolidate per-shard or per-day stores into one
+func MergeStores(dst string, srcs ...string) error {
+	return MergeStoresWith(dst, LatestWins, srcs...)
+}
+
+// MergeStoresWith is like MergeStores, but the resolver picks the version to keep
+// when a key exists in more than one store
+func MergeStoresWith(dst string, resolve Resolver, srcs ...string) error {
+	if isFileExists(dst) {
+		return ErrStoreExists
+	}
+	stores := make([]*DiskStore, 0, len(srcs))
+	defer func() {
+		for _, store := range stores {
+			store.Close()
+		}
+	}()
+	for _, src := range srcs {
+		// the sources are only read, read-only mode makes sure we don't change
+		// them, not even their hint files
+		store, err := Open(src, WithReadOnly())
+		if err != nil {
+			return err
+		}
+		stores = append(stores, store)
+	}
+	// first we find which stores have each key, the values are read only when we
+	// write them out, so that we don't hold all of them in memory
+	holders := make(map[string][]int)
+	// the expired keys are left out, as if they were deleted
+	for i, store := range stores {
+		now := uint32(store.now().Unix())
+		store.keyDir.each(func(key string, kEntry KeyEntry) error {
+			if !kEntry.expired(now) {
+				holders[key] = append(holders[key], i)
+			}
+			return nil
+		})
+	}
+	// syncing every record would make the merge crawl, Close syncs them all at once
+	// at the end
+	merged, err := Open(dst, WithSyncPolicy(SyncNever, 0))
+	if err != nil {
+		return err
+	}
+	if err := mergeInto(merged, resolve, holders, stores, srcs); err != nil {
+		merged.Close()
+		return err
+	}
+	return merged.Close()
+}
+
+// mergeInto writes the version to keep of every key in holders to the merged store
+func mergeInto(merged *DiskStore, resolve Resolver, holders map[string][]int, stores []*DiskStore, srcs []string) error {
+	for key, indexes := range holders {
+		if len(indexes) == 1 {
+			store := stores[indexes[0]]
+			value, err := store.Get(key)
+			if err != nil {
+				return err
+			}
+			kEntry, _ := store.keyDir.get(key)
+			if err := merged.set(key, value, kEntry.timestamp, kEntry.expiry); err != nil {
+				return err
+			}
+			continue
+		}
+		versions := make([]KeyVersion, 0, len(indexes))
+		for _, i := range indexes {
+			value, err := stores[i].Get(key)
+			if err != nil {
+				return err
+			}
+			kEntry, _ := stores[i].keyDir.get(key)
+			versions = append(versions, KeyVersion{
+				Value:     value,
+				Timestamp: time.Unix(int64(kEntry.timestamp), 0),
+				ExpiresAt: expiryTime(kEntry.expiry),
+				Source:    srcs[i],
+			})
+		}
+		version := resolve(key, versions)
+		if err := merged.set(key, version.Value, uint32(version.Timestamp.Unix()), expiryFromTime(version.ExpiresAt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
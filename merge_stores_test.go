@@ -0,0 +1,95 @@
+package caskdb
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+func TestMergeStores(t *testing.T) {
+	monday, err := NewDiskStore("monday.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("monday.db")
+	tuesday, err := NewDiskStore("tuesday.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("tuesday.db")
+	defer removeStore("week.db")
+
+	now := time.Now()
+	monday.Set("hamlet", "shakespeare")
+	monday.SetIfNewer("dune", "herbert", now.Add(time.Hour))
+	monday.Set("othello", "draft")
+	monday.Set("othello", "shakespeare")
+	tuesday.SetIfNewer("dune", "frank herbert", now)
+	tuesday.Set("war and peace", "tolstoy")
+	monday.Close()
+	tuesday.Close()
+
+	if err := MergeStores("week.db", "monday.db", "tuesday.db"); err != nil {
+		t.Fatalf("MergeStores() error = %v", err)
+	}
+	if err := MergeStores("week.db", "monday.db"); err != ErrStoreExists {
+		t.Errorf("MergeStores() error = %v, want %v", err, ErrStoreExists)
+	}
+	week, err := NewDiskStore("week.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	tests := map[string]string{
+		"hamlet":        "shakespeare",
+		"dune":          "herbert",
+		"othello":       "shakespeare",
+		"war and peace": "tolstoy",
+	}
+	for key, val := range tests {
+		if got, _ := week.Get(key); got != val {
+			t.Errorf("Get(%v) = %v, want %v", key, got, val)
+		}
+	}
+	size := format.FileHeaderSize
+	for key, val := range tests {
+		size += format.HeaderSize + len(key) + len(val)
+	}
+	if week.writePosition != size {
+		t.Errorf("merged store size = %v, want %v", week.writePosition, size)
+	}
+	week.Close()
+}
+
+func TestMergeStoresWith(t *testing.T) {
+	for _, name := range []string{"a.db", "b.db"} {
+		store, err := NewDiskStore(name)
+		if err != nil {
+			t.Fatalf("failed to create disk store: %v", err)
+		}
+		defer removeStore(name)
+		store.Set("tags", strings.TrimSuffix(name, ".db"))
+		store.Close()
+	}
+	defer removeStore("merged.db")
+
+	concat := func(key string, versions []KeyVersion) KeyVersion {
+		values := []string{}
+		for _, version := range versions {
+			values = append(values, version.Value)
+		}
+		return KeyVersion{Value: strings.Join(values, ","), Timestamp: versions[0].Timestamp}
+	}
+	if err := MergeStoresWith("merged.db", concat, "a.db", "b.db"); err != nil {
+		t.Fatalf("MergeStoresWith() error = %v", err)
+	}
+	merged, err := NewDiskStore("merged.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if got, _ := merged.Get("tags"); got != "a,b" {
+		t.Errorf("Get() = %v, want %v", got, "a,b")
+	}
+	merged.Close()
+}
@@ -0,0 +1,184 @@
+package caskdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+func TestDiskStore_Merge(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	for i := 0; i < 100; i++ {
+		store.Set("counter", fmt.Sprint(i))
+		store.Set(fmt.Sprintf("temp-%d", i), "value")
+		store.Delete(fmt.Sprintf("temp-%d", i))
+	}
+	store.Set("hamlet", "shakespeare")
+	before := storeSize("test.db")
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	want := int64(format.FileHeaderSize + 2*format.HeaderSize + len("counter99") + len("hamletshakespeare"))
+	if after := storeSize("test.db"); after != want {
+		t.Errorf("Merge() size = %v, want %v (was %v)", after, want, before)
+	}
+	if fileIDs, _ := listDataFiles(OSStorage{}, "test.db"); len(fileIDs) != 1 || fileIDs[0] != 2 {
+		t.Errorf("Merge() data files = %v, want [2]", fileIDs)
+	}
+
+	// the store keeps working after the merge
+	if val, _ := store.Get("counter"); val != "99" {
+		t.Errorf("Get() = %v, want %v", val, "99")
+	}
+	store.Set("dune", "frank herbert")
+	store.Delete("hamlet")
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	tests := map[string]string{"counter": "99", "dune": "frank herbert", "hamlet": "", "temp-5": ""}
+	for key, val := range tests {
+		if got, _ := store.Get(key); got != val {
+			t.Errorf("Get(%v) = %v, want %v", key, got, val)
+		}
+	}
+	store.Close()
+}
+
+func TestDiskStore_MergeWith(t *testing.T) {
+	store, err := Open("test.db", WithMaxValueSize(30))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.CreateIndex("$.author")
+	store.Set("book:hamlet", `{"author": "shakespeare"}`)
+	store.Set("book:dune", `{"author": "herbert"}`)
+	store.Set("tmp:1", "scratch")
+	store.Set("tmp:2", "scratch")
+	store.Set("user:1", "alice@example.com")
+	events, cancel := store.Watch("")
+	defer cancel()
+
+	// a new value too large fails the merge, and changes nothing
+	err = store.MergeWith(MergeOptions{
+		Transform: func(key string, value string) (string, bool) {
+			return value + value, true
+		},
+	})
+	if err != ErrValueTooLarge {
+		t.Errorf("MergeWith() error = %v, want %v", err, ErrValueTooLarge)
+	}
+	err = store.MergeWith(MergeOptions{
+		Keep: func(key string, meta Meta) bool {
+			return !strings.HasPrefix(key, "tmp:")
+		},
+		Transform: func(key string, value string) (string, bool) {
+			if key == "book:dune" {
+				return `{"author": "frank"}`, true
+			}
+			if strings.HasPrefix(key, "user:") {
+				return "redacted", true
+			}
+			return "", false
+		},
+	})
+	if err != nil {
+		t.Fatalf("MergeWith() error = %v", err)
+	}
+	want := map[string]string{
+		"book:hamlet": `{"author": "shakespeare"}`,
+		"book:dune":   `{"author": "frank"}`,
+		"user:1":      "redacted",
+	}
+	if got := storeContents(store); got != fmt.Sprint(want) {
+		t.Errorf("MergeWith() = %v, want %v", got, want)
+	}
+	// the indexes and the watches see the changes
+	if keys, _ := store.QueryIndex("$.author", "frank"); len(keys) != 1 || keys[0] != "book:dune" {
+		t.Errorf("QueryIndex() = %v, want [book:dune]", keys)
+	}
+	changes := make(map[string]EventType)
+	for i := 0; i < 4; i++ {
+		select {
+		case event := <-events:
+			changes[event.Key] = event.Type
+		case <-time.After(time.Second):
+			t.Fatalf("MergeWith() sent %v events, want 4", i)
+		}
+	}
+	wantChanges := map[string]EventType{"tmp:1": EventDelete, "tmp:2": EventDelete, "book:dune": EventSet, "user:1": EventSet}
+	if fmt.Sprint(changes) != fmt.Sprint(wantChanges) {
+		t.Errorf("MergeWith() events = %v, want %v", changes, wantChanges)
+	}
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if got := storeContents(store); got != fmt.Sprint(want) {
+		t.Errorf("after reopen = %v, want %v", got, want)
+	}
+	store.Close()
+}
+
+// keepOldFilesStorage fails the removal of the data files, as if we crashed after a
+// merge wrote its new files, but before it removed the old ones
+type keepOldFilesStorage struct {
+	OSStorage
+}
+
+func (s keepOldFilesStorage) Remove(name string) error {
+	if strings.HasSuffix(name, dataFileExt) {
+		return errors.New("crashed")
+	}
+	return s.OSStorage.Remove(name)
+}
+
+func TestDiskStore_MergeWithCrash(t *testing.T) {
+	store, err := Open("test.db", WithStorage(keepOldFilesStorage{}))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("book:hamlet", "shakespeare")
+	store.Set("tmp:1", "scratch")
+	err = store.MergeWith(MergeOptions{
+		Keep: func(key string, meta Meta) bool {
+			return !strings.HasPrefix(key, "tmp:")
+		},
+	})
+	if err == nil {
+		t.Fatalf("MergeWith() error = nil, want the crash")
+	}
+	store.Close()
+	if fileIDs, _ := listDataFiles(OSStorage{}, "test.db"); len(fileIDs) != 2 {
+		t.Fatalf("data files = %v, want the old and the new one", fileIDs)
+	}
+
+	// without the hint file, the old file is read before the new one, whose
+	// tombstone keeps the dropped key gone
+	os.Remove(hintFileName("test.db"))
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	want := map[string]string{"book:hamlet": "shakespeare"}
+	if got := storeContents(store); got != fmt.Sprint(want) {
+		t.Errorf("after the crash = %v, want %v", got, want)
+	}
+	store.Close()
+}
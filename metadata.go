@@ -0,0 +1,50 @@
+package caskdb
+
+import (
+	"time"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// Meta is the metadata of a key, which the keyDir has for every key: when the key was
+// written, and where its record is on the disk
+type Meta struct {
+	// Timestamp is when the current value of the key was written, with a precision
+	// of seconds
+	Timestamp time.Time
+	// Expiry is when the key expires, the zero time if it never does
+	Expiry time.Time
+	// FileID is the ID of the data file which has the record of the key, and Offset
+	// is where the record starts in it
+	FileID uint32
+	Offset int64
+	// Size is the size of the record in the data file
+	Size int
+	// ValueSize is the size the value takes in the record. It is the compressed size
+	// for a compressed value, the size of the pointer for a value in a value log, and
+	// it includes the encryption overhead for an encrypted record
+	ValueSize int
+}
+
+// Metadata returns the Meta of the key, and false if the key does not exist or is
+// expired. It is answered from the keyDir alone, without reading the disk or waiting
+// for the writes in progress
+func (d *DiskStore) Metadata(key string) (Meta, bool) {
+	kEntry, ok := d.keyDir.get(key)
+	if !ok || kEntry.expired(uint32(d.now().Unix())) {
+		return Meta{}, false
+	}
+	return kEntry.meta(key), true
+}
+
+// meta returns the Meta of the key with the entry
+func (k KeyEntry) meta(key string) Meta {
+	return Meta{
+		Timestamp: time.Unix(int64(k.timestamp), 0),
+		Expiry:    expiryTime(k.expiry),
+		FileID:    k.fileID,
+		Offset:    int64(k.position),
+		Size:      int(k.totalSize),
+		ValueSize: int(k.totalSize) - format.HeaderSizeOf(k.version) - len(key),
+	}
+}
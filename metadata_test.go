@@ -0,0 +1,53 @@
+package caskdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+func TestDiskStore_Metadata(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	before := time.Now().Truncate(time.Second)
+	store.Set("hamlet", "shakespeare")
+	store.SetWithTTL("othello", "shakespeare", time.Hour)
+	store.Set("dune", "herbert")
+	store.Delete("dune")
+
+	meta, ok := store.Metadata("hamlet")
+	if !ok {
+		t.Fatalf("Metadata(hamlet) = false, want true")
+	}
+	want := Meta{
+		Timestamp: meta.Timestamp,
+		FileID:    1,
+		Offset:    format.FileHeaderSize,
+		Size:      format.HeaderSize + len("hamletshakespeare"),
+		ValueSize: len("shakespeare"),
+	}
+	if meta != want || meta.Timestamp.Before(before) || meta.Timestamp.After(time.Now()) {
+		t.Errorf("Metadata(hamlet) = %+v, want %+v written now", meta, want)
+	}
+	meta, ok = store.Metadata("othello")
+	if !ok || meta.Offset != int64(format.FileHeaderSize+want.Size) || meta.Expiry.Before(before.Add(time.Hour)) {
+		t.Errorf("Metadata(othello) = %+v, %v, want the record after hamlet expiring in an hour", meta, ok)
+	}
+	for _, key := range []string{"dune", "missing"} {
+		if _, ok := store.Metadata(key); ok {
+			t.Errorf("Metadata(%v) = true, want false", key)
+		}
+	}
+
+	// the metadata follows the record when Merge moves it
+	store.Merge()
+	if meta, ok := store.Metadata("hamlet"); !ok || meta.FileID != 2 {
+		t.Errorf("Metadata(hamlet) after Merge() = %+v, %v, want file 2", meta, ok)
+	}
+	store.Close()
+}
@@ -0,0 +1,56 @@
+package caskdb
+
+import "time"
+
+// metrics file has the hooks through which the store reports its operations, for
+// monitoring. The store calls a Metrics after every read and write of a key, every
+// fsync, and every merge. The sizes, like the keyDir memory and the dead bytes, are not
+// reported as they change, Stats has them whenever they are wanted.
+//
+// The metrics package exports both to Prometheus.
+
+// The kinds of the operations reported to Metrics.ObserveOp
+const (
+	OpGet    = "get"
+	OpSet    = "set"
+	OpDelete = "delete"
+	OpBatch  = "batch"
+)
+
+// Metrics is where the store reports its operations. The methods are called with the
+// store locked, so they must be quick, and must not use the store
+type Metrics interface {
+	// ObserveOp is called after a read or a write of a key, with its kind, one of
+	// OpGet, OpSet, OpDelete or OpBatch, how long it took, and its error. A batch,
+	// or a transaction, is a single OpBatch
+	ObserveOp(op string, took time.Duration, err error)
+	// ObserveSync is called after every fsync of a file of the store
+	ObserveSync(took time.Duration, err error)
+	// ObserveMerge is called after every merge
+	ObserveMerge(took time.Duration, err error)
+}
+
+// nopMetrics is the Metrics of a store without any
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveOp(string, time.Duration, error) {}
+func (nopMetrics) ObserveSync(time.Duration, error)       {}
+func (nopMetrics) ObserveMerge(time.Duration, error)      {}
+
+// SetMetrics changes where the store reports its operations, nil stops reporting them
+func (d *DiskStore) SetMetrics(m Metrics) {
+	if m == nil {
+		m = nopMetrics{}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.metrics = m
+}
+
+// syncFile syncs the file to the disk, and reports it to the metrics
+func (d *DiskStore) syncFile(file File) error {
+	start := time.Now()
+	err := file.Sync()
+	d.metrics.ObserveSync(time.Since(start), err)
+	return err
+}
@@ -0,0 +1,183 @@
+// Package metrics exports the metrics of a caskdb.DiskStore to Prometheus: the
+// operations by kind and their latencies, the fsyncs, the merges, and the sizes of
+// the store from its Stats, like the keyDir memory, the dead bytes and the open files.
+//
+// A Collector is mounted on any registry:
+//
+//	store, _ := caskdb.Open("books.db")
+//	prometheus.MustRegister(metrics.New(store, "books"))
+//
+// All the metrics have the store label, so that the stores of a process can share a
+// registry.
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/avinassh/go-caskdb"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "caskdb"
+
+// The results of the operations, in the result label
+const (
+	resultOK       = "ok"
+	resultNotFound = "not_found"
+	resultError    = "error"
+)
+
+// Collector is the prometheus.Collector of a store. It is also the caskdb.Metrics
+// the store reports its operations to
+type Collector struct {
+	store *caskdb.DiskStore
+
+	ops           *prometheus.CounterVec
+	opDuration    *prometheus.HistogramVec
+	syncs         *prometheus.CounterVec
+	syncDuration  prometheus.Histogram
+	merges        *prometheus.CounterVec
+	mergeDuration prometheus.Histogram
+
+	// the gauges are read from the Stats of the store on every scrape
+	keys           *prometheus.Desc
+	expiredKeys    *prometheus.Desc
+	expiredRemoved *prometheus.Desc
+	dataFiles      *prometheus.Desc
+	dataSize       *prometheus.Desc
+	deadSize       *prometheus.Desc
+	keyDirSize     *prometheus.Desc
+	openFiles      *prometheus.Desc
+}
+
+// New returns the Collector of the store, named name in the store label, and makes
+// the store report its operations to it. A store has a single caskdb.Metrics, so
+// this replaces the one it had
+func New(store *caskdb.DiskStore, name string) *Collector {
+	labels := prometheus.Labels{"store": name}
+	desc := func(metric string, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", metric), help, nil, labels)
+	}
+	c := &Collector{
+		store: store,
+		ops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "ops_total",
+			Help:        "Operations on the keys, by kind and result.",
+			ConstLabels: labels,
+		}, []string{"op", "result"}),
+		opDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "op_duration_seconds",
+			Help:        "Latency of the operations on the keys, by kind.",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"op"}),
+		syncs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "fsyncs_total",
+			Help:        "Fsyncs of the files of the store, by result.",
+			ConstLabels: labels,
+		}, []string{"result"}),
+		syncDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "fsync_duration_seconds",
+			Help:        "Latency of the fsyncs.",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}),
+		merges: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   namespace,
+			Name:        "merges_total",
+			Help:        "Merges of the store, by result.",
+			ConstLabels: labels,
+		}, []string{"result"}),
+		mergeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   namespace,
+			Name:        "merge_duration_seconds",
+			Help:        "Duration of the merges.",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.01, 4, 10),
+		}),
+		keys:           desc("keys", "Live keys."),
+		expiredKeys:    desc("expired_keys", "Expired keys not removed yet."),
+		expiredRemoved: desc("expired_keys_removed_total", "Expired keys removed by the janitor."),
+		dataFiles:      desc("data_files", "Data files."),
+		dataSize:       desc("data_bytes", "Size of the data files."),
+		deadSize:       desc("dead_bytes", "Size of the dead records in the data files, which a merge reclaims."),
+		keyDirSize:     desc("keydir_bytes", "Estimated memory taken by the keyDir."),
+		openFiles:      desc("open_files", "Files held open by the store."),
+	}
+	store.SetMetrics(c)
+	return c
+}
+
+// result returns the result label of the error
+func result(err error) string {
+	switch {
+	case err == nil:
+		return resultOK
+	case errors.Is(err, caskdb.ErrKeyNotFound):
+		return resultNotFound
+	}
+	return resultError
+}
+
+func (c *Collector) ObserveOp(op string, took time.Duration, err error) {
+	c.ops.WithLabelValues(op, result(err)).Inc()
+	c.opDuration.WithLabelValues(op).Observe(took.Seconds())
+}
+
+func (c *Collector) ObserveSync(took time.Duration, err error) {
+	c.syncs.WithLabelValues(result(err)).Inc()
+	c.syncDuration.Observe(took.Seconds())
+}
+
+func (c *Collector) ObserveMerge(took time.Duration, err error) {
+	c.merges.WithLabelValues(result(err)).Inc()
+	c.mergeDuration.Observe(took.Seconds())
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.ops.Describe(ch)
+	c.opDuration.Describe(ch)
+	c.syncs.Describe(ch)
+	c.syncDuration.Describe(ch)
+	c.merges.Describe(ch)
+	c.mergeDuration.Describe(ch)
+	ch <- c.keys
+	ch <- c.expiredKeys
+	ch <- c.expiredRemoved
+	ch <- c.dataFiles
+	ch <- c.dataSize
+	ch <- c.deadSize
+	ch <- c.keyDirSize
+	ch <- c.openFiles
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.ops.Collect(ch)
+	c.opDuration.Collect(ch)
+	c.syncs.Collect(ch)
+	c.syncDuration.Collect(ch)
+	c.merges.Collect(ch)
+	c.mergeDuration.Collect(ch)
+	stats, err := c.store.Stats()
+	if err != nil {
+		// the scrape fails, rather than report the sizes as zero
+		ch <- prometheus.NewInvalidMetric(c.dataSize, err)
+		return
+	}
+	gauge := func(desc *prometheus.Desc, value float64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value)
+	}
+	gauge(c.keys, float64(stats.Keys))
+	gauge(c.expiredKeys, float64(stats.ExpiredKeys))
+	ch <- prometheus.MustNewConstMetric(c.expiredRemoved, prometheus.CounterValue, float64(stats.ExpiredRemoved))
+	gauge(c.dataFiles, float64(stats.DataFiles))
+	gauge(c.dataSize, float64(stats.DataSize))
+	gauge(c.deadSize, float64(stats.DeadSize))
+	gauge(c.keyDirSize, float64(stats.KeyDirSize))
+	gauge(c.openFiles, float64(stats.OpenFiles))
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/avinassh/go-caskdb"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	store, err := caskdb.Open("test.db", caskdb.WithLogger(caskdb.NewLogger(os.Stderr, caskdb.LevelWarn)))
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer os.RemoveAll("test.db")
+	defer store.Close()
+	c := New(store, "books")
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	store.Set("othello", "shakespeare")
+	store.Set("othello", "shakespeare")
+	store.Get("othello")
+	store.Get("missing")
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		metric prometheus.Collector
+		want   float64
+	}{
+		{"sets", c.ops.WithLabelValues(caskdb.OpSet, resultOK), 2},
+		{"gets", c.ops.WithLabelValues(caskdb.OpGet, resultOK), 1},
+		{"gets of missing keys", c.ops.WithLabelValues(caskdb.OpGet, resultNotFound), 1},
+		{"merges", c.merges.WithLabelValues(resultOK), 1},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(tt.metric); got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if got := testutil.ToFloat64(c.syncs.WithLabelValues(resultOK)); got < 2 {
+		t.Errorf("fsyncs = %v, want at least %v", got, 2)
+	}
+
+	want := `
+# HELP caskdb_keys Live keys.
+# TYPE caskdb_keys gauge
+caskdb_keys{store="books"} 1
+# HELP caskdb_open_files Files held open by the store.
+# TYPE caskdb_open_files gauge
+caskdb_open_files{store="books"} 2
+# HELP caskdb_dead_bytes Size of the dead records in the data files, which a merge reclaims.
+# TYPE caskdb_dead_bytes gauge
+caskdb_dead_bytes{store="books"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want), "caskdb_keys", "caskdb_open_files", "caskdb_dead_bytes"); err != nil {
+		t.Errorf("GatherAndCompare() error = %v", err)
+	}
+	if n, err := testutil.GatherAndCount(registry); err != nil || n == 0 {
+		t.Errorf("GatherAndCount() = %v, %v, want the metrics", n, err)
+	}
+}
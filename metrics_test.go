@@ -0,0 +1,91 @@
+package caskdb
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingMetrics counts the operations reported to it, with their errors
+type recordingMetrics struct {
+	mu     sync.Mutex
+	ops    map[string]int
+	errs   map[string]int
+	syncs  int
+	merges int
+}
+
+func newRecordingMetrics() *recordingMetrics {
+	return &recordingMetrics{ops: make(map[string]int), errs: make(map[string]int)}
+}
+
+func (m *recordingMetrics) ObserveOp(op string, _ time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ops[op]++
+	if err != nil {
+		m.errs[op]++
+	}
+}
+
+func (m *recordingMetrics) ObserveSync(time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.syncs++
+}
+
+func (m *recordingMetrics) ObserveMerge(time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.merges++
+}
+
+func TestDiskStore_SetMetrics(t *testing.T) {
+	metrics := newRecordingMetrics()
+	store, err := Open("test.db", WithMetrics(metrics))
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("othello", "shakespeare")
+	store.SetWithTTL("session", "jojo", time.Minute)
+	store.Get("othello")
+	store.Get("missing")
+	store.GetBytes([]byte("othello"))
+	store.Delete("othello")
+	batch := NewBatch()
+	batch.Set("dune", "frank herbert")
+	batch.Delete("session")
+	store.Commit(batch)
+	store.Update(func(tx *Tx) error {
+		return tx.Set("hamlet", "shakespeare")
+	})
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	wantOps := map[string]int{OpSet: 2, OpGet: 3, OpDelete: 1, OpBatch: 2}
+	if !reflect.DeepEqual(metrics.ops, wantOps) {
+		t.Errorf("ops = %v, want %v", metrics.ops, wantOps)
+	}
+	if wantErrs := map[string]int{OpGet: 1}; !reflect.DeepEqual(metrics.errs, wantErrs) {
+		t.Errorf("errors = %v, want %v", metrics.errs, wantErrs)
+	}
+	// every write is synced, and the merge syncs the active file and its new one
+	if metrics.syncs < 6 {
+		t.Errorf("syncs = %v, want at least %v", metrics.syncs, 6)
+	}
+	if metrics.merges != 1 {
+		t.Errorf("merges = %v, want %v", metrics.merges, 1)
+	}
+
+	// nil stops reporting
+	store.SetMetrics(nil)
+	store.Set("othello", "shakespeare")
+	if metrics.ops[OpSet] != 2 {
+		t.Errorf("ops after SetMetrics(nil) = %v, want %v", metrics.ops[OpSet], 2)
+	}
+	store.Close()
+}
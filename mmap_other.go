@@ -0,0 +1,18 @@
+//go:build !unix && !windows
+
+package caskdb
+
+import (
+	"io"
+	"os"
+)
+
+// mmapFile falls back to reading the whole file into memory on the platforms
+// which do not support memory mapping
+func mmapFile(file *os.File) ([]byte, error) {
+	return io.ReadAll(file)
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,64 @@
+package caskdb
+
+// mmap_read file has the memory mapped read path. With it, the data files which are
+// not written to anymore, all but the active one, are memory mapped, and a read copies
+// the record out of the mapping instead of making a pread syscall. The reads take
+// just the read lock of the store, so they run in parallel as before, but without the
+// syscall per read they are cheaper, more so for the small values. The pages are
+// shared with the page cache, so the mapping does not take more memory than the page
+// cache would.
+//
+// The active file keeps growing, and the mapping would have to follow it, so it is
+// read with pread. It is mapped once we rotate away from it. A merge maps its new files
+// and unmaps the old ones, under the write lock, so no read is in the middle of them.
+//
+// The mapping is done only for the files of OSStorage, the files of the other storages
+// are read as before. If mapping a file fails, it is logged, and the file is read with
+// pread.
+
+// mapDataFile memory maps the data file with the ID, if the mmap reads are enabled
+func (d *DiskStore) mapDataFile(fileID uint32) {
+	if !d.options.MmapReads {
+		return
+	}
+	file, ok := d.files[fileID].(osFile)
+	if !ok {
+		return
+	}
+	data, err := mmapFile(file.File)
+	if err != nil {
+		d.options.Logger.Log(LevelWarn, "failed to memory map data file", "file", fileID, "err", err)
+		return
+	}
+	// an empty file is not mapped, and has no records to read anyway
+	if data != nil {
+		d.mmaps[fileID] = data
+	}
+}
+
+// unmapDataFile unmaps the data file with the ID, if it is mapped
+func (d *DiskStore) unmapDataFile(fileID uint32) {
+	if data, ok := d.mmaps[fileID]; ok {
+		munmapFile(data)
+		delete(d.mmaps, fileID)
+	}
+}
+
+// readBytesInto is like readBytes, but reads the record into data, which must be as
+// long as the record. The returned value is a slice of data, unless the value had to
+// be decrypted, decompressed or read from a value log
+func (d *DiskStore) readBytesInto(kEntry KeyEntry, data []byte) ([]byte, error) {
+	if d.closed {
+		return nil, ErrClosed
+	}
+	mapped, ok := d.mmaps[kEntry.fileID]
+	if !ok {
+		return readRecordValueInto(d.aead, d.files, d.valueLogs, kEntry, data)
+	}
+	end := int(kEntry.position) + int(kEntry.totalSize)
+	if end > len(mapped) {
+		return nil, ErrChecksumMismatch
+	}
+	copy(data, mapped[kEntry.position:end])
+	return decodeRecordValue(d.aead, d.valueLogs, kEntry.version, data)
+}
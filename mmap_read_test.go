@@ -0,0 +1,75 @@
+package caskdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestOpen_MmapReads(t *testing.T) {
+	store, err := Open("test.db", WithMmapReads(), WithMaxFileSize(64))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer removeStore("test.db")
+
+	want := make(map[string]string)
+	for i := 0; i < 20; i++ {
+		key, value := fmt.Sprintf("key%02d", i), fmt.Sprintf("value%02d", i)
+		store.Set(key, value)
+		want[key] = value
+	}
+	check := func() {
+		t.Helper()
+		for key, value := range want {
+			if got, err := store.Get(key); err != nil || got != value {
+				t.Errorf("Get(%q) = %q, %v, want %q", key, got, err, value)
+			}
+		}
+		if err := store.View("key07", func(value []byte) error {
+			if string(value) != "value07" {
+				t.Errorf("View() value = %q, want %q", value, "value07")
+			}
+			return nil
+		}); err != nil {
+			t.Errorf("View() error = %v", err)
+		}
+	}
+	// all the files but the active one are mapped
+	if len(store.mmaps) != len(store.files)-1 {
+		t.Errorf("%d of the %d data files are mapped, want all but the active one", len(store.mmaps), len(store.files))
+	}
+	if _, ok := store.mmaps[store.activeFileID]; ok {
+		t.Errorf("the active file is mapped")
+	}
+	check()
+
+	for i := 0; i < 10; i++ {
+		store.Delete(fmt.Sprintf("key%02d", i))
+		delete(want, fmt.Sprintf("key%02d", i))
+	}
+	want["key07"] = "value07"
+	store.Set("key07", "value07")
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	for fileID := range store.mmaps {
+		if _, ok := store.files[fileID]; !ok {
+			t.Errorf("the data file %d removed by Merge() is still mapped", fileID)
+		}
+	}
+	check()
+	store.Close()
+	if len(store.mmaps) != 0 {
+		t.Errorf("Close() left %d data files mapped", len(store.mmaps))
+	}
+
+	store, err = Open("test.db", WithMmapReads(), WithReadOnly())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if len(store.mmaps) != len(store.files) {
+		t.Errorf("%d of the %d data files are mapped in read-only mode, want all", len(store.mmaps), len(store.files))
+	}
+	check()
+	store.Close()
+}
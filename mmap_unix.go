@@ -0,0 +1,33 @@
+//go:build unix
+
+package caskdb
+
+import (
+	"os"
+	"syscall"
+)
+
+// mmapFile maps the whole file into memory as read only. The returned slice is
+// backed by the page cache, so reading it does not copy the file contents into the
+// Go heap. An empty file returns a nil slice, since mmap does not accept zero length
+func mmapFile(file *os.File) ([]byte, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, nil
+	}
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, os.NewSyscallError("mmap", err)
+	}
+	return data, nil
+}
+
+func munmapFile(data []byte) error {
+	if data == nil {
+		return nil
+	}
+	return os.NewSyscallError("munmap", syscall.Munmap(data))
+}
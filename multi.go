@@ -0,0 +1,39 @@
+package caskdb
+
+import "sort"
+
+// GetMulti returns the values of the keys, under a single lock of the store. The
+// keys which do not exist or are expired are left out of the map. It stops at the
+// first error from reading a value, and returns it
+func (d *DiskStore) GetMulti(keys []string) (map[string]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, err := d.get(key)
+		if err == ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+// SetMulti stores all the keys and values of the map. It is a Commit of a Batch with
+// them, so either all of them are stored or none, with a single write and fsync. The
+// records are written in the key order
+func (d *DiskStore) SetMulti(values map[string]string) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	batch := NewBatch()
+	for _, key := range keys {
+		batch.Set(key, values[key])
+	}
+	return d.Commit(batch)
+}
@@ -0,0 +1,37 @@
+package caskdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDiskStore_SetMulti(t *testing.T) {
+	store, err := Open("test.db", WithMaxValueSize(12))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	values := map[string]string{"hamlet": "shakespeare", "othello": "shakespeare", "dune": "herbert"}
+	if err := store.SetMulti(values); err != nil {
+		t.Fatalf("SetMulti() error = %v", err)
+	}
+	// a value too large fails the whole SetMulti
+	if err := store.SetMulti(map[string]string{"war and peace": "tolstoy", "dune": "frank herbert"}); err != ErrValueTooLarge {
+		t.Errorf("SetMulti() error = %v, want %v", err, ErrValueTooLarge)
+	}
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	got, err := store.GetMulti([]string{"hamlet", "dune", "war and peace", "othello"})
+	if err != nil {
+		t.Fatalf("GetMulti() error = %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(values) {
+		t.Errorf("GetMulti() = %v, want %v", got, values)
+	}
+	store.Close()
+}
@@ -0,0 +1,242 @@
+package caskdb
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+var (
+	// ErrReadOnly is returned when writing to a store opened in read-only mode
+	ErrReadOnly = errors.New("store is read-only")
+	// ErrKeyTooLarge is returned when a key is larger than the max key size of the store,
+	// or than format.MaxKeySize
+	ErrKeyTooLarge = errors.New("key too large")
+	// ErrValueTooLarge is returned when a value is larger than the max value size of the
+	// store, or than format.MaxValueSize
+	ErrValueTooLarge = errors.New("value too large")
+	// ErrEmptyKey is returned when writing an empty key
+	ErrEmptyKey = errors.New("key is empty")
+)
+
+// Options are the settings of a DiskStore. Use the Option functions to change them
+// when opening the store with Open
+type Options struct {
+	// ReadOnly opens the store without writing to it. The writes return ErrReadOnly,
+	// and the data directory must exist already
+	ReadOnly bool
+	// MaxKeySize and MaxValueSize are the largest key and value, in bytes, which can
+	// be written. Zero means no limit, other than the limits of the format, which
+	// apply in any case. Check format.MaxKeySize and format.MaxValueSize
+	MaxKeySize   int
+	MaxValueSize int
+	// SyncPolicy decides when the writes are synced to the disk, and SyncInterval is
+	// how often they are synced with SyncInterval. Check sync.go for more details
+	SyncPolicy   SyncPolicy
+	SyncInterval time.Duration
+	// MaxFileSize is the size after which the active data file is rotated
+	MaxFileSize int
+	// AutoMerge is when the store merges itself in the background, the zero value
+	// never does. Check auto_merge.go for more details
+	AutoMerge AutoMerge
+	// Janitor is how the store removes its expired keys in the background, the zero
+	// value never does. Check janitor.go for more details
+	Janitor Janitor
+	// Metrics is where the store reports its operations, for monitoring. Nil
+	// reports nothing. Check metrics.go for more details
+	Metrics Metrics
+	// ValueThreshold is the size, in bytes, above which a value is written to a value
+	// log instead of the data file. Zero keeps all the values in the data files.
+	// Check value_log.go for more details
+	ValueThreshold int
+	// CompressionThreshold is the size, in bytes, above which a value is compressed.
+	// Zero does not compress any value. Check compression.go for more details
+	CompressionThreshold int
+	// EncryptionKey is the 256 bit AES key which the data is encrypted with, nil
+	// does not encrypt it. Check encryption.go for more details
+	EncryptionKey []byte
+	// FileMode is the permission bits of the data files and the hint file, before
+	// the umask
+	FileMode os.FileMode
+	// Logger is where the store logs to, the warnings and the errors go to the
+	// standard error by default
+	Logger Logger
+	// MmapReads memory maps the data files which are not written to anymore, and
+	// reads the records from the mappings. Check mmap_read.go for more details
+	MmapReads bool
+	// Storage is where the store keeps its files, OSStorage by default. Check
+	// storage.go for more details
+	Storage Storage
+	// CompactKeyDir keeps the keyDir in compact tables instead of Go maps, which
+	// take a fraction of the memory for slightly slower lookups. Check
+	// compact_keydir.go for more details
+	CompactKeyDir bool
+}
+
+// DefaultOptions returns the options used when no Option is given
+func DefaultOptions() Options {
+	return Options{
+		SyncPolicy:   SyncAlways,
+		SyncInterval: DefaultSyncInterval,
+		MaxFileSize:  DefaultMaxFileSize,
+		FileMode:     0666,
+		Logger:       NewLogger(os.Stderr, LevelWarn),
+		Storage:      OSStorage{},
+	}
+}
+
+// Option changes one of the Options
+type Option func(*Options)
+
+// WithReadOnly opens the store in read-only mode
+func WithReadOnly() Option {
+	return func(o *Options) {
+		o.ReadOnly = true
+	}
+}
+
+// WithMaxKeySize limits the size of the keys, writing a larger key returns
+// ErrKeyTooLarge
+func WithMaxKeySize(size int) Option {
+	return func(o *Options) {
+		o.MaxKeySize = size
+	}
+}
+
+// WithMaxValueSize limits the size of the values, writing a larger value returns
+// ErrValueTooLarge
+func WithMaxValueSize(size int) Option {
+	return func(o *Options) {
+		o.MaxValueSize = size
+	}
+}
+
+// WithSyncPolicy sets when the writes are synced to the disk. Check
+// DiskStore.SetSyncPolicy
+func WithSyncPolicy(policy SyncPolicy, interval time.Duration) Option {
+	return func(o *Options) {
+		o.SyncPolicy = policy
+		o.SyncInterval = interval
+	}
+}
+
+// WithMaxFileSize sets the size after which the active data file is rotated. Check
+// DiskStore.SetMaxFileSize
+func WithMaxFileSize(size int) Option {
+	return func(o *Options) {
+		o.MaxFileSize = size
+	}
+}
+
+// WithAutoMerge merges the store in the background once it crosses any of the
+// thresholds. Check DiskStore.SetAutoMerge
+func WithAutoMerge(a AutoMerge) Option {
+	return func(o *Options) {
+		o.AutoMerge = a
+	}
+}
+
+// WithJanitor removes the expired keys in the background. Check DiskStore.SetJanitor
+func WithJanitor(j Janitor) Option {
+	return func(o *Options) {
+		o.Janitor = j
+	}
+}
+
+// WithMetrics reports the operations of the store to m. Check DiskStore.SetMetrics
+func WithMetrics(m Metrics) Option {
+	return func(o *Options) {
+		o.Metrics = m
+	}
+}
+
+// WithValueThreshold moves the values larger than size bytes out to value logs, so
+// that Merge does not copy them
+func WithValueThreshold(size int) Option {
+	return func(o *Options) {
+		o.ValueThreshold = size
+	}
+}
+
+// WithCompression compresses the values larger than threshold bytes, which saves a
+// lot of space for text and JSON values
+func WithCompression(threshold int) Option {
+	return func(o *Options) {
+		o.CompressionThreshold = threshold
+	}
+}
+
+// WithEncryptionKey encrypts the data with the 256 bit AES key. The same key must be
+// given every time the store is opened
+func WithEncryptionKey(key []byte) Option {
+	return func(o *Options) {
+		o.EncryptionKey = key
+	}
+}
+
+// WithFileMode sets the permission bits of the files created by the store
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *Options) {
+		o.FileMode = mode
+	}
+}
+
+// WithLogger sets where the store logs to, nil drops all the messages
+func WithLogger(logger Logger) Option {
+	return func(o *Options) {
+		if logger == nil {
+			logger = discardLogger{}
+		}
+		o.Logger = logger
+	}
+}
+
+// WithMmapReads reads the records from memory mapped data files, which saves a
+// syscall per read
+func WithMmapReads() Option {
+	return func(o *Options) {
+		o.MmapReads = true
+	}
+}
+
+// WithStorage sets where the store keeps its files
+func WithStorage(storage Storage) Option {
+	return func(o *Options) {
+		o.Storage = storage
+	}
+}
+
+// WithCompactKeyDir keeps the keyDir in compact tables, for the stores with more keys
+// than fit in memory otherwise
+func WithCompactKeyDir() Option {
+	return func(o *Options) {
+		o.CompactKeyDir = true
+	}
+}
+
+// MaxValueSize returns the size of the largest value the store takes, the one set with
+// WithMaxValueSize, or else format.MaxValueSize
+func (d *DiskStore) MaxValueSize() int64 {
+	if d.options.MaxValueSize > 0 {
+		return int64(d.options.MaxValueSize)
+	}
+	return format.MaxValueSize
+}
+
+// checkSize returns an error if the key is empty, or the key or the value is over the
+// limits of the store or of the format. The sizes beyond the format limits would not
+// fit in the header of the record, and would be read back as something else
+func (d *DiskStore) checkSize(key string, value string) error {
+	if len(key) == 0 {
+		return ErrEmptyKey
+	}
+	if len(key) > format.MaxKeySize || (d.options.MaxKeySize > 0 && len(key) > d.options.MaxKeySize) {
+		return ErrKeyTooLarge
+	}
+	if uint64(len(value)) > format.MaxValueSize || (d.options.MaxValueSize > 0 && len(value) > d.options.MaxValueSize) {
+		return ErrValueTooLarge
+	}
+	return nil
+}
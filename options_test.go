@@ -0,0 +1,141 @@
+package caskdb
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpen_ReadOnly(t *testing.T) {
+	if _, err := Open("test.db", WithReadOnly()); err == nil {
+		t.Errorf("Open() of a missing store in read-only mode, want an error")
+	}
+	if isFileExists("test.db") {
+		t.Errorf("Open() in read-only mode created the data directory")
+	}
+
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("name", "jojo")
+	store.Close()
+	os.Remove(hintFileName("test.db"))
+
+	store, err = Open("test.db", WithReadOnly())
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if val, _ := store.Get("name"); val != "jojo" {
+		t.Errorf("Get() = %v, want %v", val, "jojo")
+	}
+	if err := store.Set("name", "dio"); err != ErrReadOnly {
+		t.Errorf("Set() error = %v, want %v", err, ErrReadOnly)
+	}
+	if err := store.Delete("name"); err != ErrReadOnly {
+		t.Errorf("Delete() error = %v, want %v", err, ErrReadOnly)
+	}
+	if err := store.Merge(); err != ErrReadOnly {
+		t.Errorf("Merge() error = %v, want %v", err, ErrReadOnly)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if isFileExists(hintFileName("test.db")) {
+		t.Errorf("Close() in read-only mode wrote the hint file")
+	}
+}
+
+func TestOpen_MaxSize(t *testing.T) {
+	store, err := Open("test.db", WithMaxKeySize(4), WithMaxValueSize(8))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer removeStore("test.db")
+
+	if err := store.Set("name", "jojo"); err != nil {
+		t.Errorf("Set() error = %v", err)
+	}
+	if err := store.Set("names", "jojo"); err != ErrKeyTooLarge {
+		t.Errorf("Set() error = %v, want %v", err, ErrKeyTooLarge)
+	}
+	if err := store.Set("name", "jonathan joestar"); err != ErrValueTooLarge {
+		t.Errorf("Set() error = %v, want %v", err, ErrValueTooLarge)
+	}
+	if err := store.Set("", "jojo"); err != ErrEmptyKey {
+		t.Errorf("Set() error = %v, want %v", err, ErrEmptyKey)
+	}
+	b := NewBatch()
+	b.Set("dio", "brando")
+	b.Set("jotaro", "kujo")
+	if err := store.Commit(b); err != ErrKeyTooLarge {
+		t.Errorf("Commit() error = %v, want %v", err, ErrKeyTooLarge)
+	}
+	if _, err := store.Get("dio"); err != ErrKeyNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	store.Close()
+}
+
+func TestOpen_Options(t *testing.T) {
+	var buf bytes.Buffer
+	store, err := Open("test.db",
+		WithFileMode(0600),
+		WithLogger(NewLogger(&buf, LevelDebug)),
+		WithMaxFileSize(64),
+		WithSyncPolicy(SyncInterval, time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer removeStore("test.db")
+	if store.maxFileSize != 64 || store.syncPolicy != SyncInterval || store.syncStop == nil {
+		t.Errorf("the max file size and the sync policy are not set")
+	}
+	store.Set("name", "jojo")
+	store.Close()
+
+	info, err := os.Stat(dataFileName("test.db", 1))
+	if err != nil {
+		t.Fatalf("failed to stat data file: %v", err)
+	}
+	if info.Mode().Perm()&^0600 != 0 {
+		t.Errorf("Mode() = %v, want at most %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	os.Remove(hintFileName("test.db"))
+	store, err = Open("test.db", WithLogger(NewLogger(&buf, LevelDebug)))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), `msg="loaded key" key=name`) {
+		t.Errorf("the logger got %q, want the loaded keys", buf.String())
+	}
+	// the values must never end up in the logs
+	if strings.Contains(buf.String(), "jojo") {
+		t.Errorf("the logger got %q, want no values", buf.String())
+	}
+	store.Close()
+}
+
+func TestWithLogger(t *testing.T) {
+	// the store is quiet unless something goes wrong
+	if logger, ok := DefaultOptions().Logger.(*textLogger); !ok || logger.level != LevelWarn {
+		t.Errorf("the default logger = %+v, want one of the warnings", DefaultOptions().Logger)
+	}
+	store, err := Open("test.db", WithLogger(nil))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer removeStore("test.db")
+	if err := store.Set("name", "jojo"); err != nil {
+		t.Errorf("Set() error = %v", err)
+	}
+	if err := store.Merge(); err != nil {
+		t.Errorf("Merge() error = %v", err)
+	}
+	store.Close()
+}
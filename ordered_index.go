@@ -0,0 +1,193 @@
+package caskdb
+
+import (
+	"math/rand"
+	"strings"
+)
+
+// ordered index file provides an index of the keys in their sorted order. The keyDir
+// is a hash map, so answering "all the keys starting with user:" needs a scan of
+// every key, and a sort on top of it. With the ordered index, we seek to the first
+// key of the range and walk from there.
+//
+// The index is a skip list: a sorted linked list, with extra levels of links which
+// skip over more and more nodes. Every node is on the bottom level, and on every
+// level above it with a probability of 1/4. A search starts at the top level, and
+// goes down a level whenever the next node is past the key, so it visits O(log n)
+// nodes. Unlike a sorted slice, an insert or a delete does not move the other
+// entries.
+//
+// Read more about it here: https://en.wikipedia.org/wiki/Skip_list
+//
+// Like the other indexes, the ordered index lives in memory and needs to be created
+// again after opening the store.
+
+// orderedIndexMaxLevel is the number of levels of the skip list. With a probability
+// of 1/4 per level, it is good for about 4^16 keys
+const orderedIndexMaxLevel = 16
+
+type skipNode struct {
+	key string
+	// next has the next node on every level this node is on
+	next []*skipNode
+}
+
+// orderedIndex keeps the keys in their sorted order
+type orderedIndex struct {
+	// head is a sentinel before the first key, it is on all the levels
+	head *skipNode
+	// level is the number of levels in use
+	level int
+}
+
+// newOrderedIndex builds the ordered index of the keys in the keyDir
+func newOrderedIndex(keyDir *keyDir) *orderedIndex {
+	idx := &orderedIndex{head: &skipNode{next: make([]*skipNode, orderedIndexMaxLevel)}, level: 1}
+	keyDir.each(func(key string, _ KeyEntry) error {
+		idx.insert(key)
+		return nil
+	})
+	return idx
+}
+
+// randomLevel returns the number of levels of a new node
+func randomLevel() int {
+	level := 1
+	for level < orderedIndexMaxLevel && rand.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
+
+// path returns the last node before the key on every level
+func (idx *orderedIndex) path(key string) []*skipNode {
+	path := make([]*skipNode, orderedIndexMaxLevel)
+	node := idx.head
+	for level := idx.level - 1; level >= 0; level-- {
+		for node.next[level] != nil && node.next[level].key < key {
+			node = node.next[level]
+		}
+		path[level] = node
+	}
+	return path
+}
+
+// insert adds the key, if it is not in the index already
+func (idx *orderedIndex) insert(key string) {
+	path := idx.path(key)
+	if next := path[0].next[0]; next != nil && next.key == key {
+		return
+	}
+	level := randomLevel()
+	for ; idx.level < level; idx.level++ {
+		path[idx.level] = idx.head
+	}
+	node := &skipNode{key: key, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		node.next[i] = path[i].next[i]
+		path[i].next[i] = node
+	}
+}
+
+func (idx *orderedIndex) remove(key string) {
+	path := idx.path(key)
+	node := path[0].next[0]
+	if node == nil || node.key != key {
+		return
+	}
+	for i := range node.next {
+		path[i].next[i] = node.next[i]
+	}
+	for idx.level > 1 && idx.head.next[idx.level-1] == nil {
+		idx.level--
+	}
+}
+
+// rekey makes the node of the key hold the given string. A write of a key replaces
+// the key string in the keyDir with its own, and the index follows, so that it does
+// not keep the string of the first write alive as a second copy of the key
+func (idx *orderedIndex) rekey(key string) {
+	if node := idx.seek(key); node != nil && node.key == key {
+		node.key = key
+	}
+}
+
+// seek returns the node of the first key at or after key, nil if there is none
+func (idx *orderedIndex) seek(key string) *skipNode {
+	return idx.path(key)[0].next[0]
+}
+
+func (idx *orderedIndex) equal(other *orderedIndex) bool {
+	a, b := idx.head.next[0], other.head.next[0]
+	for ; a != nil && b != nil; a, b = a.next[0], b.next[0] {
+		if a.key != b.key {
+			return false
+		}
+	}
+	return a == nil && b == nil
+}
+
+// CreateOrderedIndex creates the index of the keys in their sorted order, which
+// Scan and Range use
+func (d *DiskStore) CreateOrderedIndex() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.orderedIndex != nil {
+		return ErrIndexExists
+	}
+	d.orderedIndex = newOrderedIndex(d.keyDir)
+	return nil
+}
+
+// DropOrderedIndex removes the index of the keys in their sorted order
+func (d *DiskStore) DropOrderedIndex() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.orderedIndex == nil {
+		return ErrIndexNotFound
+	}
+	d.orderedIndex = nil
+	return nil
+}
+
+// Scan calls fn for every key starting with the prefix and its value, in the key
+// order. It needs the ordered index, else it returns ErrIndexNotFound. Like Fold, it
+// stops at the first error and returns it, and the store is not locked while fn runs
+func (d *DiskStore) Scan(prefix string, fn func(key string, value string) error) error {
+	keys, err := d.orderedKeys(prefix, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+	if err != nil {
+		return err
+	}
+	return d.foldKeys(keys, fn)
+}
+
+// Range calls fn for every key from start, included, to end, excluded, and its value,
+// in the key order. An empty end means there is no upper bound. It needs the ordered
+// index, else it returns ErrIndexNotFound. Like Fold, it stops at the first error and
+// returns it, and the store is not locked while fn runs
+func (d *DiskStore) Range(start string, end string, fn func(key string, value string) error) error {
+	keys, err := d.orderedKeys(start, func(key string) bool {
+		return end == "" || key < end
+	})
+	if err != nil {
+		return err
+	}
+	return d.foldKeys(keys, fn)
+}
+
+// orderedKeys returns the live keys from start on, for as long as within says they
+// are in the range
+func (d *DiskStore) orderedKeys(start string, within func(key string) bool) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.orderedIndex == nil {
+		return nil, ErrIndexNotFound
+	}
+	var keys []string
+	for node := d.orderedIndex.seek(start); node != nil && within(node.key); node = node.next[0] {
+		keys = append(keys, node.key)
+	}
+	return d.liveKeys(keys), nil
+}
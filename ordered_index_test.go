@@ -0,0 +1,99 @@
+package caskdb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// collect returns a fold function which appends the keys and values to pairs
+func collect(pairs *[]string) func(key string, value string) error {
+	return func(key string, value string) error {
+		*pairs = append(*pairs, key+"="+value)
+		return nil
+	}
+}
+
+func TestDiskStore_Scan(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("user:2", "dio")
+	if err := store.Scan("user:", collect(new([]string))); err != ErrIndexNotFound {
+		t.Errorf("Scan() error = %v, want %v", err, ErrIndexNotFound)
+	}
+	if err := store.CreateOrderedIndex(); err != nil {
+		t.Fatalf("CreateOrderedIndex() error = %v", err)
+	}
+	store.Set("user:1", "jojo")
+	store.Set("user:3", "jotaro")
+	store.Set("user:1", "jonathan")
+	store.Set("users", "3")
+	store.Set("post:1", "hello")
+	store.Delete("user:3")
+
+	var pairs []string
+	if err := store.Scan("user:", collect(&pairs)); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if want := []string{"user:1=jonathan", "user:2=dio"}; !reflect.DeepEqual(pairs, want) {
+		t.Errorf("Scan() = %v, want %v", pairs, want)
+	}
+
+	tests := []struct {
+		start, end string
+		want       []string
+	}{
+		{"", "", []string{"post:1=hello", "user:1=jonathan", "user:2=dio", "users=3"}},
+		{"user:1", "user:2", []string{"user:1=jonathan"}},
+		{"user:15", "", []string{"user:2=dio", "users=3"}},
+		{"z", "", nil},
+	}
+	for _, tt := range tests {
+		var pairs []string
+		if err := store.Range(tt.start, tt.end, collect(&pairs)); err != nil {
+			t.Fatalf("Range() error = %v", err)
+		}
+		if !reflect.DeepEqual(pairs, tt.want) {
+			t.Errorf("Range(%q, %q) = %v, want %v", tt.start, tt.end, pairs, tt.want)
+		}
+	}
+	if mismatched, _ := store.RebuildIndexes(); len(mismatched) != 0 {
+		t.Errorf("RebuildIndexes() = %v, want none", mismatched)
+	}
+	if err := store.DropOrderedIndex(); err != nil {
+		t.Errorf("DropOrderedIndex() error = %v", err)
+	}
+	store.Close()
+}
+
+func TestOrderedIndex(t *testing.T) {
+	idx := newOrderedIndex(newKeyDir(0, false))
+	var keys []string
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", (i*7919)%1000)
+		idx.insert(key)
+		idx.insert(key)
+		keys = append(keys, key)
+	}
+	var want []string
+	for i, key := range keys {
+		if i%2 == 0 {
+			idx.remove(key)
+		} else {
+			want = append(want, key)
+		}
+	}
+	sort.Strings(want)
+	var got []string
+	for node := idx.head.next[0]; node != nil; node = node.next[0] {
+		got = append(got, node.key)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+}
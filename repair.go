@@ -0,0 +1,77 @@
+package caskdb
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// Repair makes the store in the data directory loadable again, after its data files
+// got corrupt. Every data file is cut off at its first corrupt record, so the records
+// after it are lost too, and the hint file is removed. What is discarded is reported
+// to the logger of the options. The store must not be open, Repair returns
+// ErrDatabaseLocked if it is
+func Repair(dirName string, opts ...Option) error {
+	options := DefaultOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	d := &DiskStore{dirName: dirName, options: options}
+	if _, err := options.Storage.ReadDir(dirName); err != nil {
+		return err
+	}
+	if err := d.lock(); err != nil {
+		return err
+	}
+	defer d.closeFiles()
+	fileNames, err := dataFiles(options.Storage, dirName)
+	if err != nil {
+		return err
+	}
+	for _, fileName := range fileNames {
+		if err := d.repairDataFile(fileName); err != nil {
+			return err
+		}
+	}
+	if err := options.Storage.Remove(hintFileName(dirName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// repairDataFile truncates the data file at its first corrupt or partially written
+// record, if it has one
+func (d *DiskStore) repairDataFile(fileName string) error {
+	file, err := d.options.Storage.OpenFile(fileName, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	reader := format.NewReader(io.NewSectionReader(file, 0, math.MaxInt64))
+	// a file in a newer format is not corrupt, we must leave it alone
+	header, err := reader.Header()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	valid := int64(header.Size())
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			file.Close()
+			return nil
+		}
+		if err != nil {
+			d.options.Logger.Log(LevelWarn, "discarding corrupt records", "file", fileName, "offset", valid, "err", err)
+			break
+		}
+		// the records of a batch are returned only if the whole batch is valid,
+		// and the last of them ends where the batch ends
+		valid = record.Offset + int64(record.Size)
+	}
+	file.Close()
+	return d.options.Storage.Truncate(fileName, valid)
+}
@@ -0,0 +1,51 @@
+package caskdb
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("hamlet", "shakespeare")
+	store.Set("othello", "shakespeare")
+	store.Set("dune", "frank herbert")
+	if err := Repair("test.db"); err != ErrDatabaseLocked {
+		t.Errorf("Repair() of an open store error = %v, want %v", err, ErrDatabaseLocked)
+	}
+	store.Close()
+
+	// flip a bit of the second value, the records from it on are lost
+	data, err := os.ReadFile(dataFileName("test.db", 1))
+	if err != nil {
+		t.Fatalf("failed to read data file: %v", err)
+	}
+	kEntry, _ := store.keyDir.get("othello")
+	position := kEntry.position
+	data[position+kEntry.totalSize-1] ^= 0x01
+	if err := os.WriteFile(dataFileName("test.db", 1), data, 0666); err != nil {
+		t.Fatalf("failed to write data file: %v", err)
+	}
+	if err := Repair("test.db"); err != nil {
+		t.Fatalf("Repair() error = %v", err)
+	}
+	if info, _ := os.Stat(dataFileName("test.db", 1)); info.Size() != int64(position) {
+		t.Errorf("data file size = %v, want %v", info.Size(), position)
+	}
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store after Repair(): %v", err)
+	}
+	tests := map[string]string{"hamlet": "shakespeare", "othello": "", "dune": ""}
+	for key, val := range tests {
+		if got, _ := store.Get(key); got != val {
+			t.Errorf("Get(%v) = %v, want %v", key, got, val)
+		}
+	}
+	store.Close()
+}
@@ -0,0 +1,326 @@
+package caskdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// replica file has the replica side of the replication, check replication.go for how
+// it works. A replica is a DiskStore which follows a primary: it serves the reads,
+// while the writes return ErrReadOnly, since the only writes it takes are the ones of
+// the primary.
+
+const (
+	// replicaDialTimeout is how long a replica waits to connect to the primary
+	replicaDialTimeout = 5 * time.Second
+	// replicaRetryInterval is how long a replica waits before connecting again,
+	// after it lost the primary
+	replicaRetryInterval = time.Second
+)
+
+// replica is the state of a store following a primary
+type replica struct {
+	// addr is the address of the primary
+	addr string
+	// pending has the data received after the last complete record, which is
+	// written once the rest of the record arrives
+	pending []byte
+	// mu guards conn, the connection to the primary, which is closed to stop
+	// the replica
+	mu   sync.Mutex
+	conn net.Conn
+	// stop stops the replica, and done is closed once it has stopped
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenReplica opens the store in the data directory, like Open, as a replica of the
+// primary at the TCP address. It returns once the store is open, and follows the
+// primary in the background, connecting to it again whenever the connection is lost,
+// until the store is closed. The writes to the store return ErrReadOnly.
+//
+// A replica which is reset by the primary, after a merge, drops all its keys first,
+// so it can miss some of them until it has caught up again. The watches see every
+// key deleted, and written again.
+//
+// It returns ErrReplicationUnsupported with a value threshold, since the value logs
+// are not replicated
+func OpenReplica(dirName string, addr string, opts ...Option) (*DiskStore, error) {
+	store, err := Open(dirName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if store.options.ReadOnly || store.options.ValueThreshold > 0 {
+		store.Close()
+		if store.options.ReadOnly {
+			return nil, ErrReadOnly
+		}
+		return nil, ErrReplicationUnsupported
+	}
+	r := &replica{
+		addr: addr,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	store.mu.Lock()
+	store.replica = r
+	store.mu.Unlock()
+	// the replica is merged by its primary, which removes the expired keys too
+	store.stopAutoMerge()
+	store.stopJanitor()
+	go store.runReplica(r)
+	return store, nil
+}
+
+// runReplica follows the primary until the replica is stopped. It closes done when it
+// returns
+func (d *DiskStore) runReplica(r *replica) {
+	defer close(r.done)
+	for {
+		err := d.follow(r)
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+		d.options.Logger.Log(LevelWarn, "lost the primary", "dir", d.dirName, "primary", r.addr, "err", err)
+		select {
+		case <-time.After(replicaRetryInterval):
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// follow connects to the primary, and applies the frames it sends until the
+// connection fails
+func (d *DiskStore) follow(r *replica) error {
+	conn, err := net.DialTimeout("tcp", r.addr, replicaDialTimeout)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	select {
+	case <-r.stop:
+		r.mu.Unlock()
+		conn.Close()
+		return nil
+	default:
+	}
+	r.conn = conn
+	r.mu.Unlock()
+	defer conn.Close()
+
+	d.mu.Lock()
+	// the primary sends again whatever is after the last complete record
+	r.pending = nil
+	fileID, offset := d.activeFileID, int64(d.writePosition)
+	id, err := d.storeID()
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := writeHello(conn, fileID, offset, id); err != nil {
+		return err
+	}
+	d.options.Logger.Log(LevelInfo, "following primary", "dir", d.dirName, "primary", r.addr, "file", fileID, "offset", offset)
+	reader := bufio.NewReader(conn)
+	for {
+		f, err := readFrame(reader)
+		if err != nil {
+			return err
+		}
+		if err := d.applyFrame(r, f); err != nil {
+			return err
+		}
+	}
+}
+
+// stopReplica stops following the primary, if the store is a replica, and waits for
+// it
+func (d *DiskStore) stopReplica() {
+	d.mu.Lock()
+	r := d.replica
+	d.mu.Unlock()
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	select {
+	case <-r.stop:
+	default:
+		close(r.stop)
+	}
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	r.mu.Unlock()
+	<-r.done
+}
+
+// applyFrame applies the frame from the primary to the data files and the keyDir
+func (d *DiskStore) applyFrame(r *replica, f frame) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if f.kind == frameReset {
+		return d.resetReplica(r, f.fileID, binary.LittleEndian.Uint64(f.data))
+	}
+	if f.fileID != d.activeFileID {
+		// the primary moved on to its next file, after sending all of this one
+		if f.fileID < d.activeFileID || f.offset != 0 || len(r.pending) > 0 {
+			return ErrReplicationProtocol
+		}
+		if err := d.startReplicaFile(f.fileID); err != nil {
+			return err
+		}
+	}
+	if f.offset != int64(d.writePosition+len(r.pending)) {
+		return ErrReplicationProtocol
+	}
+	return d.appendReplica(r, f.data)
+}
+
+// appendReplica appends the data from the primary to the active file. Only the
+// complete records are written, and added to the keyDir, the rest waits in pending
+func (d *DiskStore) appendReplica(r *replica, data []byte) error {
+	data = append(r.pending, data...)
+	start := 0
+	if d.writePosition == 0 {
+		// a file starts with the file header, unless it is older than the header
+		if len(data) < format.FileHeaderSize {
+			r.pending = data
+			return nil
+		}
+		header, err := format.DecodeFileHeader(data)
+		if err != nil {
+			return err
+		}
+		start = header.Size()
+		// the files are copied as they are, in the version of the primary
+		d.activeVersion = header.Version
+	}
+	type received struct {
+		position int
+		record   []byte
+	}
+	var records []received
+	end, err := walkRecords(d.activeVersion, data, start, func(position int, record []byte) error {
+		records = append(records, received{position, record})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if end == 0 {
+		r.pending = data
+		return nil
+	}
+	// the records go to the disk before the keyDir points to them
+	if _, err := d.activeFile().Write(data[:end]); err != nil {
+		return err
+	}
+	if d.syncPolicy == SyncAlways {
+		if err := d.syncFile(d.activeFile()); err != nil {
+			return err
+		}
+	} else {
+		d.dirty = true
+	}
+	for _, rec := range records {
+		if err := d.applyRecord(d.writePosition+rec.position, rec.record); err != nil {
+			return err
+		}
+	}
+	d.writePosition += end
+	r.pending = append([]byte(nil), data[end:]...)
+	// a replica can be the primary of other replicas
+	d.signalAppend()
+	return nil
+}
+
+// applyRecord applies the record from the primary, at the position in the active
+// file, to the keyDir
+func (d *DiskStore) applyRecord(position int, record []byte) error {
+	plain, err := decryptRecord(d.aead, d.activeVersion, record)
+	if err != nil {
+		return err
+	}
+	headerSize := format.HeaderSizeOf(d.activeVersion)
+	timestamp, expiry, keySize, valueSize := format.DecodeHeader(d.activeVersion, plain)
+	key := string(plain[headerSize : headerSize+int(keySize)])
+	if format.IsTombstone(valueSize) {
+		d.removeEntry(key, timestamp)
+		return nil
+	}
+	if format.IsValuePointer(valueSize) {
+		return ErrReplicationUnsupported
+	}
+	value, err := decodeRecordValue(d.aead, d.valueLogs, d.activeVersion, record)
+	if err != nil {
+		return err
+	}
+	d.putEntry(key, string(value), NewKeyEntry(timestamp, expiry, d.activeFileID, d.activeVersion, uint64(position), uint64(len(record))))
+	return nil
+}
+
+// startReplicaFile makes the file with the ID the active file. The file is empty,
+// the primary sends its file header along with its records
+func (d *DiskStore) startReplicaFile(fileID uint32) error {
+	if file := d.activeFile(); file != nil {
+		if err := d.syncFile(file); err != nil {
+			return err
+		}
+		d.mapDataFile(d.activeFileID)
+	}
+	file, err := d.options.Storage.OpenFile(dataFileName(d.dirName, fileID), os.O_APPEND|os.O_RDWR|os.O_CREATE|os.O_TRUNC, d.options.FileMode)
+	if err != nil {
+		return err
+	}
+	d.files[fileID] = file
+	d.activeFileID = fileID
+	d.writePosition = 0
+	d.dirty = false
+	return nil
+}
+
+// resetReplica drops all the data files and the keys, and starts over at the file
+// with the ID, as a replica of the primary with the store ID
+func (d *DiskStore) resetReplica(r *replica, fileID uint32, id uint64) error {
+	d.options.Logger.Log(LevelWarn, "primary reset the replica", "dir", d.dirName, "primary", r.addr, "file", fileID)
+	// the hint file points to the files we remove
+	if err := d.options.Storage.Remove(hintFileName(d.dirName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	now := uint32(d.now().Unix())
+	var keys []string
+	d.keyDir.each(func(key string, _ KeyEntry) error {
+		keys = append(keys, key)
+		return nil
+	})
+	for _, key := range keys {
+		d.removeEntry(key, now)
+	}
+	for id, file := range d.files {
+		d.unmapDataFile(id)
+		d.closeFile(file)
+		delete(d.files, id)
+		if err := d.options.Storage.Remove(dataFileName(d.dirName, id)); err != nil {
+			return err
+		}
+	}
+	r.pending = nil
+	// the ID is taken once the files of the old one are gone, a crash before that
+	// leaves the old ID, and the primary resets the replica again
+	if err := d.setStoreID(id); err != nil {
+		return err
+	}
+	return d.startReplicaFile(fileID)
+}
@@ -0,0 +1,461 @@
+package caskdb
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// replication file has the primary side of the replication, which ships the data
+// files to the replicas as they are written. Since the data files are append only,
+// they are the write-ahead log of the store: a replica which has the same bytes in
+// its data files has the same data. The primary serves its data files over TCP, and
+// every replica keeps a byte for byte copy of them, applying the records to its
+// keyDir as they arrive. Check replica.go for the replica side.
+//
+// The position of a replica is the ID of its active data file and the size of it, so
+// a replica which reconnects, or restarts, resumes from where it stopped. Merge
+// rewrites the data files of the primary into new ones, and removes the old ones. A
+// replica at a file which is gone is reset: it drops all its data files, and copies
+// all of the new ones from the start.
+//
+// Every store has an ID, random and kept in the data directory, and a replica takes
+// the ID of its primary. The position means something only in a store with the same
+// ID: a replica pointed at another primary, or at one whose data directory was made
+// anew, could be at a position which exists there too, and append the records of
+// another store to its own. The replica with another ID is reset.
+//
+// The protocol has two messages. The replica sends its position and its ID once, when
+// it connects:
+//
+//	┌──────────────┬────────────┬────────────┬──────────────┐
+//	│ magic "CRPL" │ file_id(4) │ offset(8B) │ store_id(8B) │
+//	└──────────────┴────────────┴────────────┴──────────────┘
+//
+// and the primary sends the frames after it, for as long as the connection lasts:
+//
+//	┌─────────┬────────────┬────────────┬───────────┬──────┐
+//	│ kind(1) │ file_id(4) │ offset(8B) │ length(4) │ data │
+//	└─────────┴────────────┴────────────┴───────────┴──────┘
+//
+// An append frame has the data of the file at the offset, which is always where the
+// replica is. A reset frame has the ID of the primary as its data, 8 bytes, and tells
+// the replica to take the ID, and start over at the file, from the offset zero.
+//
+// The values in the value logs are not replicated, so a store with a value threshold
+// cannot be a primary. The records are shipped as they are on the disk, so the
+// replicas of an encrypted store need the same encryption key.
+
+var (
+	// ErrPrimaryClosed is returned by Serve and ListenAndServe after Close
+	ErrPrimaryClosed = errors.New("primary closed")
+	// ErrReplicationUnsupported is returned when replicating a store with a value
+	// threshold, since the value logs are not replicated
+	ErrReplicationUnsupported = errors.New("replication does not support the value logs")
+	// ErrReplicationProtocol is returned when the other side of the replication
+	// sends something we don't expect
+	ErrReplicationProtocol = errors.New("replication protocol error")
+)
+
+const (
+	// replicationMagic starts the message a replica sends when it connects
+	replicationMagic = "CRPL"
+	// helloSize is the size of the message a replica sends when it connects
+	helloSize = 24
+	// storeIDFileName is the name of the file in the data directory which has the
+	// ID of the store, in hex
+	storeIDFileName = "STORE_ID"
+	// frameHeaderSize is the size of the frame header, before the data
+	frameHeaderSize = 17
+	// maxFrameSize is the most data the primary sends in one frame
+	maxFrameSize = 1 << 20
+)
+
+// the kinds of the frames
+const (
+	frameAppend byte = iota + 1
+	frameReset
+)
+
+// frame is what the primary sends to a replica. Check the protocol above
+type frame struct {
+	kind   byte
+	fileID uint32
+	offset int64
+	data   []byte
+}
+
+// Primary serves the data files of a store to its replicas. The replicas connect to
+// it with OpenReplica.
+//
+// Typical usage example:
+//
+//	store, _ := caskdb.Open("books.db")
+//	primary, _ := caskdb.NewPrimary(store)
+//	go primary.ListenAndServe(":7380")
+//
+// and on another machine:
+//
+//	replica, _ := caskdb.OpenReplica("books.db", "primary:7380")
+type Primary struct {
+	store *DiskStore
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	// done is closed by Close, to stop the replicas waiting for the writes
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewPrimary returns a primary for the store. The primary does not own the store,
+// the caller closes the store after closing the primary. It returns
+// ErrReplicationUnsupported if the store has a value threshold
+func NewPrimary(store *DiskStore) (*Primary, error) {
+	if store.options.ValueThreshold > 0 {
+		return nil, ErrReplicationUnsupported
+	}
+	return &Primary{
+		store:     store,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		done:      make(chan struct{}),
+	}, nil
+}
+
+// ListenAndServe listens on the TCP address and serves the replicas connecting to it
+func (p *Primary) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(l)
+}
+
+// Serve accepts the replicas on the listener and serves each of them on its own
+// goroutine. It returns when the listener fails, or ErrPrimaryClosed after Close
+func (p *Primary) Serve(l net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		l.Close()
+		return ErrPrimaryClosed
+	}
+	p.listeners[l] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.listeners, l)
+		p.mu.Unlock()
+	}()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return ErrPrimaryClosed
+			}
+			return err
+		}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			conn.Close()
+			return ErrPrimaryClosed
+		}
+		p.conns[conn] = struct{}{}
+		p.wg.Add(1)
+		p.mu.Unlock()
+		go p.serveReplica(conn)
+	}
+}
+
+// Close stops the listeners and disconnects the replicas
+func (p *Primary) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	var closeErr error
+	for l := range p.listeners {
+		if err := l.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+	return closeErr
+}
+
+// serveReplica sends the data files to the replica from its position on, and then
+// every write as it happens
+func (p *Primary) serveReplica(conn net.Conn) {
+	defer p.wg.Done()
+	defer func() {
+		p.mu.Lock()
+		delete(p.conns, conn)
+		p.mu.Unlock()
+		conn.Close()
+	}()
+	logger := p.store.options.Logger
+	fileID, offset, replicaID, err := readHello(conn)
+	if err != nil {
+		logger.Log(LevelWarn, "replica failed to connect", "replica", conn.RemoteAddr(), "err", err)
+		return
+	}
+	id, err := p.store.lockedStoreID()
+	if err != nil {
+		logger.Log(LevelError, "failed to load the store ID for a replica", "replica", conn.RemoteAddr(), "err", err)
+		return
+	}
+	logger.Log(LevelInfo, "replica connected", "replica", conn.RemoteAddr(), "file", fileID, "offset", offset)
+	if replicaID != id {
+		// the files of a replica of another store are not ours, whatever their
+		// position. There is no file 0, so the replica is reset
+		logger.Log(LevelWarn, "replica is of another store, resetting it", "replica", conn.RemoteAddr(), "replica_id", replicaID, "id", id)
+		fileID, offset = 0, 0
+	}
+	// the replica sends nothing after its position, we read only to see it go
+	gone := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(gone)
+	}()
+	w := bufio.NewWriter(conn)
+	for {
+		// we take the signal before reading, so that we don't miss a write
+		// which happens in between
+		appended := p.store.appendSignal()
+		f, ok, err := p.store.readLog(fileID, offset)
+		if err != nil {
+			logger.Log(LevelError, "failed to read the data files for a replica", "replica", conn.RemoteAddr(), "err", err)
+			return
+		}
+		if !ok {
+			if err := w.Flush(); err != nil {
+				return
+			}
+			select {
+			case <-appended:
+				continue
+			case <-gone:
+			case <-p.done:
+			}
+			logger.Log(LevelInfo, "replica disconnected", "replica", conn.RemoteAddr(), "file", fileID, "offset", offset)
+			return
+		}
+		if f.kind == frameReset {
+			// a replica can be the primary of other replicas, and take another
+			// ID when it is reset itself
+			id, err := p.store.lockedStoreID()
+			if err != nil {
+				logger.Log(LevelError, "failed to load the store ID for a replica", "replica", conn.RemoteAddr(), "err", err)
+				return
+			}
+			f.data = binary.LittleEndian.AppendUint64(nil, id)
+		}
+		if err := writeFrame(w, f); err != nil {
+			return
+		}
+		fileID, offset = f.fileID, f.offset+int64(len(f.data))
+	}
+}
+
+// readLog returns the frame a replica at the position needs next, and false if it
+// has everything there is
+func (d *DiskStore) readLog(fileID uint32, offset int64) (frame, bool, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for {
+		file, ok := d.files[fileID]
+		if !ok {
+			// a merge removed the file, or the replica is of some other store
+			return frame{kind: frameReset, fileID: d.firstFileID()}, true, nil
+		}
+		end := int64(d.writePosition)
+		if fileID != d.activeFileID {
+			size, err := file.Size()
+			if err != nil {
+				return frame{}, false, err
+			}
+			end = size
+		}
+		if offset > end {
+			return frame{kind: frameReset, fileID: d.firstFileID()}, true, nil
+		}
+		if offset < end {
+			size := end - offset
+			if size > maxFrameSize {
+				size = maxFrameSize
+			}
+			data := make([]byte, size)
+			if _, err := file.ReadAt(data, offset); err != nil {
+				return frame{}, false, err
+			}
+			return frame{kind: frameAppend, fileID: fileID, offset: offset, data: data}, true, nil
+		}
+		if fileID == d.activeFileID {
+			return frame{}, false, nil
+		}
+		fileID, offset = d.nextFileID(fileID), 0
+	}
+}
+
+// firstFileID returns the ID of the oldest data file
+func (d *DiskStore) firstFileID() uint32 {
+	first := d.activeFileID
+	for fileID := range d.files {
+		if fileID < first {
+			first = fileID
+		}
+	}
+	return first
+}
+
+// nextFileID returns the ID of the data file after the one with the ID
+func (d *DiskStore) nextFileID(fileID uint32) uint32 {
+	next := d.activeFileID
+	for id := range d.files {
+		if id > fileID && id < next {
+			next = id
+		}
+	}
+	return next
+}
+
+// appendSignal returns a channel which is closed after the next write to the data
+// files
+func (d *DiskStore) appendSignal() <-chan struct{} {
+	d.appendMu.Lock()
+	defer d.appendMu.Unlock()
+	if d.appended == nil {
+		d.appended = make(chan struct{})
+	}
+	return d.appended
+}
+
+// signalAppend wakes up everyone waiting on appendSignal
+func (d *DiskStore) signalAppend() {
+	d.appendMu.Lock()
+	defer d.appendMu.Unlock()
+	if d.appended != nil {
+		close(d.appended)
+		d.appended = nil
+	}
+}
+
+// storeID returns the ID of the store. It is made at random the first time it is
+// asked for, and kept in the data directory, except for a read-only store, which
+// makes a new one every time it is opened. The store must be locked exclusively
+func (d *DiskStore) storeID() (uint64, error) {
+	if d.id != 0 {
+		return d.id, nil
+	}
+	data, err := readFile(d.options.Storage, filepath.Join(d.dirName, storeIDFileName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	// a corrupt ID is replaced too, the replicas are reset once
+	if id, err := strconv.ParseUint(string(data), 16, 64); err == nil && id != 0 {
+		d.id = id
+		return id, nil
+	}
+	var random [8]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return 0, err
+	}
+	id := binary.LittleEndian.Uint64(random[:]) | 1
+	if d.options.ReadOnly {
+		d.id = id
+		return id, nil
+	}
+	return id, d.setStoreID(id)
+}
+
+// lockedStoreID is storeID, for the callers which don't hold the store lock
+func (d *DiskStore) lockedStoreID() (uint64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.storeID()
+}
+
+// setStoreID sets the ID of the store, and writes it to the data directory. The store
+// must be locked exclusively
+func (d *DiskStore) setStoreID(id uint64) error {
+	if err := d.replaceFile(filepath.Join(d.dirName, storeIDFileName), []byte(strconv.FormatUint(id, 16))); err != nil {
+		return err
+	}
+	d.id = id
+	return nil
+}
+
+func writeHello(w io.Writer, fileID uint32, offset int64, id uint64) error {
+	data := make([]byte, 0, helloSize)
+	data = append(data, replicationMagic...)
+	data = binary.LittleEndian.AppendUint32(data, fileID)
+	data = binary.LittleEndian.AppendUint64(data, uint64(offset))
+	data = binary.LittleEndian.AppendUint64(data, id)
+	_, err := w.Write(data)
+	return err
+}
+
+func readHello(r io.Reader) (uint32, int64, uint64, error) {
+	data := make([]byte, helloSize)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, 0, 0, err
+	}
+	if string(data[:4]) != replicationMagic {
+		return 0, 0, 0, ErrReplicationProtocol
+	}
+	return binary.LittleEndian.Uint32(data[4:8]), int64(binary.LittleEndian.Uint64(data[8:16])), binary.LittleEndian.Uint64(data[16:24]), nil
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	header := make([]byte, 0, frameHeaderSize)
+	header = append(header, f.kind)
+	header = binary.LittleEndian.AppendUint32(header, f.fileID)
+	header = binary.LittleEndian.AppendUint64(header, uint64(f.offset))
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(f.data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(f.data)
+	return err
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return frame{}, err
+	}
+	f := frame{
+		kind:   header[0],
+		fileID: binary.LittleEndian.Uint32(header[1:5]),
+		offset: int64(binary.LittleEndian.Uint64(header[5:13])),
+	}
+	length := binary.LittleEndian.Uint32(header[13:17])
+	if (f.kind != frameAppend && f.kind != frameReset) || (f.kind == frameReset && length != 8) || length > maxFrameSize {
+		return frame{}, ErrReplicationProtocol
+	}
+	f.data = make([]byte, length)
+	if _, err := io.ReadFull(r, f.data); err != nil {
+		return frame{}, err
+	}
+	return f, nil
+}
@@ -0,0 +1,185 @@
+package caskdb
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitForReplica waits until the replica has the same keys and values as the primary
+func waitForReplica(t *testing.T, primary *DiskStore, replica *DiskStore) {
+	t.Helper()
+	want := storeContents(primary)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := storeContents(replica)
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replica = %v, want %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func storeContents(store *DiskStore) string {
+	contents := make(map[string]string)
+	store.Fold(func(key string, value string) error {
+		contents[key] = value
+		return nil
+	})
+	return fmt.Sprint(contents)
+}
+
+func TestOpenReplica(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	defer removeStore("replica.db")
+	store.SetMaxFileSize(100)
+	for i := 0; i < 10; i++ {
+		store.Set(fmt.Sprintf("key-%d", i), "value")
+	}
+	store.Delete("key-0")
+	batch := NewBatch()
+	batch.Set("hamlet", "shakespeare")
+	batch.Delete("key-1")
+	store.Commit(batch)
+
+	primary, err := NewPrimary(store)
+	if err != nil {
+		t.Fatalf("NewPrimary() error = %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go primary.Serve(l)
+	addr := l.Addr().String()
+
+	replica, err := OpenReplica("replica.db", addr)
+	if err != nil {
+		t.Fatalf("OpenReplica() error = %v", err)
+	}
+	waitForReplica(t, store, replica)
+	// the writes go to the primary only
+	if err := replica.Set("othello", "shakespeare"); err != ErrReadOnly {
+		t.Errorf("Set() error = %v, want %v", err, ErrReadOnly)
+	}
+	if err := replica.Merge(); err != ErrReadOnly {
+		t.Errorf("Merge() error = %v, want %v", err, ErrReadOnly)
+	}
+	store.Set("othello", "shakespeare")
+	store.Delete("key-2")
+	waitForReplica(t, store, replica)
+	replica.Close()
+
+	// the replica resumes from where it stopped
+	store.SetWithTTL("dune", "herbert", time.Hour)
+	replica, err = OpenReplica("replica.db", addr)
+	if err != nil {
+		t.Fatalf("OpenReplica() error = %v", err)
+	}
+	waitForReplica(t, store, replica)
+
+	// after a merge, the replica starts over with the new files
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	store.Set("key-3", "new")
+	waitForReplica(t, store, replica)
+	primaryFiles, _ := listDataFiles(OSStorage{}, "test.db")
+	replicaFiles, _ := listDataFiles(OSStorage{}, "replica.db")
+	if fmt.Sprint(replicaFiles) != fmt.Sprint(primaryFiles) {
+		t.Errorf("replica data files = %v, want %v", replicaFiles, primaryFiles)
+	}
+	replica.Close()
+
+	// the replica has everything on its own disk
+	replica, err = NewDiskStore("replica.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if got, want := storeContents(replica), storeContents(store); got != want {
+		t.Errorf("replica = %v, want %v", got, want)
+	}
+	replica.Close()
+
+	if err := primary.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	store.Close()
+}
+
+func TestOpenReplica_OtherStore(t *testing.T) {
+	defer removeStore("test.db")
+	defer removeStore("other.db")
+	defer removeStore("replica.db")
+	// two stores whose data files are at the same position, which the replica of
+	// one would resume from in the other
+	serve := func(dirName string, value string) (*DiskStore, *Primary, string) {
+		store, err := NewDiskStore(dirName)
+		if err != nil {
+			t.Fatalf("failed to create disk store: %v", err)
+		}
+		store.Set("hamlet", value)
+		primary, err := NewPrimary(store)
+		if err != nil {
+			t.Fatalf("NewPrimary() error = %v", err)
+		}
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		go primary.Serve(l)
+		return store, primary, l.Addr().String()
+	}
+	store, primary, addr := serve("test.db", "shakespeare")
+	other, otherPrimary, otherAddr := serve("other.db", "marlowe")
+	replica, err := OpenReplica("replica.db", addr)
+	if err != nil {
+		t.Fatalf("OpenReplica() error = %v", err)
+	}
+	waitForReplica(t, store, replica)
+	replica.Close()
+
+	// the replica is reset, instead of appending the records of the other store
+	// to its own
+	other.Set("dune", "herbert")
+	replica, err = OpenReplica("replica.db", otherAddr)
+	if err != nil {
+		t.Fatalf("OpenReplica() error = %v", err)
+	}
+	waitForReplica(t, other, replica)
+	replica.Close()
+	id, _ := other.lockedStoreID()
+	replica, err = NewDiskStore("replica.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if got, _ := replica.lockedStoreID(); got != id {
+		t.Errorf("the ID of the replica = %x, want the ID of its primary %x", got, id)
+	}
+	replica.Close()
+
+	primary.Close()
+	otherPrimary.Close()
+	store.Close()
+	other.Close()
+}
+
+func TestNewPrimary_ValueThreshold(t *testing.T) {
+	store, err := Open("test.db", WithValueThreshold(64))
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer removeStore("test.db")
+	if _, err := NewPrimary(store); err != ErrReplicationUnsupported {
+		t.Errorf("NewPrimary() error = %v, want %v", err, ErrReplicationUnsupported)
+	}
+	store.Close()
+}
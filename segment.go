@@ -0,0 +1,215 @@
+package caskdb
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// DefaultMaxFileSize is the size after which the active data file is closed for
+// writes and a new one is started. Check DiskStore.SetMaxFileSize
+const DefaultMaxFileSize = 1 << 30
+
+// dataFileExt is the extension of the data files in the data directory
+const dataFileExt = ".data"
+
+// ErrSingleFileStore is returned when opening a store from before the data
+// directories, a single data file, in read-only mode. Opening it in read-write mode
+// once moves it into a data directory
+var ErrSingleFileStore = errors.New("store is a single file, open it in read-write mode to move it into a data directory")
+
+// dataFileName returns the path of the data file with the ID in the data directory.
+// The IDs are zero padded, so that the files are listed in the order they were
+// written
+func dataFileName(dirName string, fileID uint32) string {
+	return filepath.Join(dirName, fmt.Sprintf("%010d%s", fileID, dataFileExt))
+}
+
+// migrateSingleFile moves the store at the path, if it is a single data file, into a
+// new data directory at the path, as its first data file. The stores were single
+// files before the data directories, and their records are still read. The file is
+// renamed aside first, since the directory can't be made while it is there. If we
+// crash before it is in the directory, the next call finishes the move
+func migrateSingleFile(storage Storage, dirName string, readOnly bool) error {
+	aside := filepath.Clean(dirName) + ".migrating"
+	_, err := storage.ReadDir(dirName)
+	single := err != nil && !errors.Is(err, fs.ErrNotExist) && isStorageFile(storage, dirName)
+	if !single && !isStorageFile(storage, aside) {
+		return nil
+	}
+	if readOnly {
+		return ErrSingleFileStore
+	}
+	if single {
+		if err := storage.Rename(dirName, aside); err != nil {
+			return err
+		}
+	}
+	if err := storage.MkdirAll(dirName); err != nil {
+		return err
+	}
+	return storage.Rename(aside, dataFileName(dirName, 1))
+}
+
+// isStorageFile tells whether the file exists in the storage, and can be opened
+func isStorageFile(storage Storage, name string) bool {
+	file, err := storage.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return false
+	}
+	file.Close()
+	return true
+}
+
+// listDataFiles returns the IDs of the data files in the data directory, oldest
+// first. Files which are not data files are ignored
+func listDataFiles(storage Storage, dirName string) ([]uint32, error) {
+	return listFiles(storage, dirName, dataFileExt)
+}
+
+// listFiles returns the IDs of the files with the extension in the data directory,
+// in increasing order
+func listFiles(storage Storage, dirName string, ext string) ([]uint32, error) {
+	names, err := storage.ReadDir(dirName)
+	if err != nil {
+		return nil, err
+	}
+	var fileIDs []uint32
+	for _, name := range names {
+		if !strings.HasSuffix(name, ext) {
+			continue
+		}
+		fileID, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 32)
+		if err != nil {
+			continue
+		}
+		fileIDs = append(fileIDs, uint32(fileID))
+	}
+	sort.Slice(fileIDs, func(i, j int) bool { return fileIDs[i] < fileIDs[j] })
+	return fileIDs, nil
+}
+
+// DataFiles returns the paths of the data files of the store in the data directory,
+// in the order they were written. Tools which read the data files on their own, with
+// format.Reader, use it to find them
+func DataFiles(dirName string) ([]string, error) {
+	return dataFiles(OSStorage{}, dirName)
+}
+
+// dataFiles is DataFiles for the store in the storage
+func dataFiles(storage Storage, dirName string) ([]string, error) {
+	fileIDs, err := listDataFiles(storage, dirName)
+	if err != nil {
+		return nil, err
+	}
+	fileNames := make([]string, len(fileIDs))
+	for i, fileID := range fileIDs {
+		fileNames[i] = dataFileName(dirName, fileID)
+	}
+	return fileNames, nil
+}
+
+// openDataFile opens the data file with the ID for reads and appends, creating it
+// if it does not exist. In read-only mode, the file is opened only for reads. A new
+// file gets the file header, and the header of an existing file is checked, so that
+// we never append to a file in a format we don't know. Check format/file_header.go
+func (d *DiskStore) openDataFile(fileID uint32) (File, error) {
+	var file File
+	var err error
+	if d.options.ReadOnly {
+		file, err = d.options.Storage.OpenFile(dataFileName(d.dirName, fileID), os.O_RDONLY, 0)
+	} else {
+		// we open the file in following modes:
+		//	os.O_APPEND - says that the writes are append only.
+		// 	os.O_RDWR - says we can read and write to the file
+		// 	os.O_CREATE - creates the file if it does not exist
+		file, err = d.options.Storage.OpenFile(dataFileName(d.dirName, fileID), os.O_APPEND|os.O_RDWR|os.O_CREATE, d.options.FileMode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkFileHeader(fileID, file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// checkFileHeader writes the file header to the data file with the ID if it is empty,
+// and checks the version of its header otherwise
+func (d *DiskStore) checkFileHeader(fileID uint32, file File) error {
+	size, err := file.Size()
+	if err != nil {
+		return err
+	}
+	if size == 0 {
+		if d.options.ReadOnly {
+			return nil
+		}
+		if _, err := file.Write(format.EncodeFileHeader(uint32(d.now().Unix()))); err != nil {
+			// a partially written header would be taken for the start of a file
+			// of the first version of the format, which had none, so the file is
+			// emptied again
+			d.options.Storage.Truncate(dataFileName(d.dirName, fileID), 0)
+			return err
+		}
+	}
+	data := make([]byte, format.FileHeaderSize)
+	n, err := file.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	_, err = format.DecodeFileHeader(data[:n])
+	return err
+}
+
+// rotate makes a new data file the active one. The old active file stays open, since
+// the keyDir still points to its records, but nothing is written to it anymore
+func (d *DiskStore) rotate() error {
+	if err := d.syncFile(d.activeFile()); err != nil {
+		return err
+	}
+	file, err := d.openDataFile(d.activeFileID + 1)
+	if err != nil {
+		return err
+	}
+	d.mapDataFile(d.activeFileID)
+	d.activeFileID++
+	d.activeVersion = format.Version
+	d.files[d.activeFileID] = file
+	// the new file has just the file header
+	d.writePosition = format.FileHeaderSize
+	return nil
+}
+
+// truncateTail cuts off the partially written record at the end of the data file with
+// the ID, if there is one. A crash in the middle of a write leaves such a record
+// behind, and if we appended after it, the next startup would read the garbage as
+// the header of a record. Only the last data file is written to, so it is the only one
+// cut off. It must be called right after scanDataFile read the file, so
+// that the writePosition is at the end of the last complete record
+func (d *DiskStore) truncateTail(fileID uint32) error {
+	fileName := dataFileName(d.dirName, fileID)
+	size, err := fileSize(d.options.Storage, fileName)
+	if err != nil {
+		return err
+	}
+	if size <= int64(d.writePosition) {
+		return nil
+	}
+	d.options.Logger.Log(LevelWarn, "discarding partially written record", "file", fileName,
+		"offset", d.writePosition, "bytes", size-int64(d.writePosition))
+	// a read-only store does not write to the files, it just ignores the record
+	if d.options.ReadOnly {
+		return nil
+	}
+	return d.options.Storage.Truncate(fileName, int64(d.writePosition))
+}
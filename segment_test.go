@@ -0,0 +1,397 @@
+package caskdb
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+func TestDiskStore_Rotation(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	// every record is HeaderSize+len("key-0")+len("value") bytes, so each data
+	// file fits the file header and three of them
+	recordSize := format.HeaderSize + len("key-0value")
+	store.SetMaxFileSize(format.FileHeaderSize + 3*recordSize)
+
+	for i := 0; i < 10; i++ {
+		store.Set(fmt.Sprintf("key-%d", i), "value")
+	}
+	store.Delete("key-0")
+	// a record larger than the maximum size gets a file of its own
+	large := string(make([]byte, 4*recordSize))
+	store.Set("large", large)
+	store.Set("key-1", "other")
+
+	fileIDs, _ := listDataFiles(OSStorage{}, "test.db")
+	if want := []uint32{1, 2, 3, 4, 5, 6}; fmt.Sprint(fileIDs) != fmt.Sprint(want) {
+		t.Errorf("data files = %v, want %v", fileIDs, want)
+	}
+	if kEntry, _ := store.keyDir.get("key-4"); kEntry.fileID != 2 || kEntry.position != uint64(format.FileHeaderSize+recordSize) {
+		t.Errorf("keyDir[key-4] = %+v, want file 2 at %v", kEntry, format.FileHeaderSize+recordSize)
+	}
+
+	tests := map[string]string{"key-0": "", "key-1": "other", "key-9": "value", "large": large}
+	for key, val := range tests {
+		if got, _ := store.Get(key); got != val {
+			t.Errorf("Get(%v) = %v, want %v", key, got, val)
+		}
+	}
+	store.Close()
+
+	// the keyDir is built from all the data files, with and without the hint file
+	for _, withHint := range []bool{true, false} {
+		if !withHint {
+			os.Remove(hintFileName("test.db"))
+		}
+		store, err = NewDiskStore("test.db")
+		if err != nil {
+			t.Fatalf("failed to create disk store: %v", err)
+		}
+		for key, val := range tests {
+			if got, _ := store.Get(key); got != val {
+				t.Errorf("Get(%v) = %v, want %v", key, got, val)
+			}
+		}
+		if store.activeFileID != 6 {
+			t.Errorf("activeFileID = %v, want %v", store.activeFileID, 6)
+		}
+		store.Close()
+	}
+}
+
+func TestDiskStore_MergeRotation(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	recordSize := format.HeaderSize + len("key-0value")
+	store.SetMaxFileSize(format.FileHeaderSize + 3*recordSize)
+
+	for i := 0; i < 10; i++ {
+		store.Set(fmt.Sprintf("key-%d", i), "value")
+		store.Set(fmt.Sprintf("key-%d", i), "VALUE")
+	}
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	// the live records are written to new files after the seven old ones
+	fileIDs, _ := listDataFiles(OSStorage{}, "test.db")
+	if want := []uint32{8, 9, 10, 11}; fmt.Sprint(fileIDs) != fmt.Sprint(want) {
+		t.Errorf("data files = %v, want %v", fileIDs, want)
+	}
+	store.Set("key-0", "new")
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		key, want := fmt.Sprintf("key-%d", i), "VALUE"
+		if i == 0 {
+			want = "new"
+		}
+		if got, _ := store.Get(key); got != want {
+			t.Errorf("Get(%v) = %v, want %v", key, got, want)
+		}
+	}
+	store.Close()
+}
+
+func TestDiskStore_TruncateTail(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("hamlet", "shakespeare")
+	store.Set("othello", "shakespeare")
+	store.closeFiles()
+
+	// append the first half of a record, as if we crashed in the middle of
+	// writing it
+	fileName := dataFileName("test.db", 1)
+	info, _ := os.Stat(fileName)
+	validSize := info.Size()
+	_, data := format.EncodeKV(0, "dune", "frank herbert")
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatalf("failed to open data file: %v", err)
+	}
+	file.Write(data[:len(data)/2])
+	file.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if info, _ := os.Stat(fileName); info.Size() != validSize {
+		t.Errorf("data file size = %v, want %v", info.Size(), validSize)
+	}
+	store.Set("dune", "herbert")
+	store.Close()
+	os.Remove(hintFileName("test.db"))
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	tests := map[string]string{"hamlet": "shakespeare", "othello": "shakespeare", "dune": "herbert"}
+	for key, val := range tests {
+		if got, _ := store.Get(key); got != val {
+			t.Errorf("Get(%v) = %v, want %v", key, got, val)
+		}
+	}
+	store.Close()
+}
+
+func TestDiskStore_TruncateTailSealed(t *testing.T) {
+	store, err := Open("test.db", WithMaxFileSize(100))
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	for _, key := range []string{"hamlet", "othello", "dune", "emma"} {
+		store.Set(key, "a value long enough to fill a file")
+	}
+	store.Close()
+	os.Remove(hintFileName("test.db"))
+
+	// an incomplete record at the end of a file which is not the last one is
+	// corruption, not a crash in the middle of a write, and the file is kept whole
+	fileName := dataFileName("test.db", 1)
+	info, _ := os.Stat(fileName)
+	_, data := format.EncodeKV(0, "dune", "frank herbert")
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatalf("failed to open data file: %v", err)
+	}
+	file.Write(data[:len(data)/2])
+	file.Close()
+	if _, err := NewDiskStore("test.db"); err != ErrChecksumMismatch {
+		t.Errorf("NewDiskStore() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	if got, _ := os.Stat(fileName); got.Size() != info.Size()+int64(len(data)/2) {
+		t.Errorf("data file size = %v, want %v", got.Size(), info.Size()+int64(len(data)/2))
+	}
+}
+
+func TestDataFiles(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.SetMaxFileSize(1)
+	store.Set("hamlet", "shakespeare")
+	store.Set("othello", "shakespeare")
+	store.Close()
+
+	fileNames, err := DataFiles("test.db")
+	if err != nil {
+		t.Fatalf("DataFiles() error = %v", err)
+	}
+	want := []string{dataFileName("test.db", 1), dataFileName("test.db", 2)}
+	if fmt.Sprint(fileNames) != fmt.Sprint(want) {
+		t.Errorf("DataFiles() = %v, want %v", fileNames, want)
+	}
+}
+
+func TestDiskStore_FileHeader(t *testing.T) {
+	defer removeStore("test.db")
+	// a data file written before the file header was added starts with its records
+	os.MkdirAll("test.db", 0755)
+	record := encodeKVV1(nil, uint32(time.Now().Unix()), "hamlet", "shakespeare")
+	os.WriteFile(dataFileName("test.db", 1), record, 0644)
+
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if got, _ := store.Get("hamlet"); got != "shakespeare" {
+		t.Errorf("Get(hamlet) = %v, want %v", got, "shakespeare")
+	}
+	store.Set("othello", "shakespeare")
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	store.Close()
+	// the new files have the header, the first write went to the file 2 since the
+	// file 1 was in an older version, and the merge wrote the file 3
+	data, _ := os.ReadFile(dataFileName("test.db", 3))
+	if header, err := format.DecodeFileHeader(data); err != nil || header.Version != format.Version {
+		t.Errorf("DecodeFileHeader() = %+v, %v, want version %v", header, err, format.Version)
+	}
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	for _, key := range []string{"hamlet", "othello"} {
+		if got, _ := store.Get(key); got != "shakespeare" {
+			t.Errorf("Get(%v) = %v, want %v", key, got, "shakespeare")
+		}
+	}
+	store.Close()
+
+	// a file in a newer format is refused, and repair leaves it alone
+	binary.LittleEndian.PutUint32(data[4:8], format.Version+1)
+	binary.LittleEndian.PutUint32(data[12:16], crc32.ChecksumIEEE(data[:12]))
+	os.WriteFile(dataFileName("test.db", 3), data, 0644)
+	if _, err := NewDiskStore("test.db"); !errors.Is(err, format.ErrUnsupportedVersion) {
+		t.Errorf("NewDiskStore() error = %v, want %v", err, format.ErrUnsupportedVersion)
+	}
+	if err := Repair("test.db"); !errors.Is(err, format.ErrUnsupportedVersion) {
+		t.Errorf("Repair() error = %v, want %v", err, format.ErrUnsupportedVersion)
+	}
+	if after, _ := os.ReadFile(dataFileName("test.db", 3)); !bytes.Equal(after, data) {
+		t.Errorf("Repair() changed the data file")
+	}
+}
+
+func TestDiskStore_SingleFile(t *testing.T) {
+	defer removeStore("test.db")
+	// a store from before the data directories is a single file at the path
+	record := encodeKVV1(nil, uint32(time.Now().Unix()), "hamlet", "shakespeare")
+	os.WriteFile("test.db", record, 0644)
+
+	if _, err := Open("test.db", WithReadOnly()); !errors.Is(err, ErrSingleFileStore) {
+		t.Errorf("Open() in read-only mode error = %v, want %v", err, ErrSingleFileStore)
+	}
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if got, _ := store.Get("hamlet"); got != "shakespeare" {
+		t.Errorf("Get(hamlet) = %v, want %v", got, "shakespeare")
+	}
+	store.Close()
+	if data, _ := os.ReadFile(dataFileName("test.db", 1)); !bytes.Equal(data, record) {
+		t.Errorf("the data file 1 = %x, want the single file %x", data, record)
+	}
+
+	// a move cut off before the file got into the directory is finished
+	removeStore("test.db")
+	os.WriteFile("test.db.migrating", record, 0644)
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if got, _ := store.Get("hamlet"); got != "shakespeare" {
+		t.Errorf("Get(hamlet) after a cut off move = %v, want %v", got, "shakespeare")
+	}
+	store.Close()
+	if isFileExists("test.db.migrating") {
+		t.Errorf("the single file is left aside")
+	}
+}
+
+// encodeKVV1 encodes the record in the version 1 of the format, whose value_size is
+// 4 bytes, and encrypts it with the aead unless it is nil
+func encodeKVV1(aead cipher.AEAD, timestamp uint32, key string, value string) []byte {
+	data := make([]byte, format.HeaderSizeV1)
+	binary.LittleEndian.PutUint32(data[4:8], timestamp)
+	binary.LittleEndian.PutUint32(data[12:16], uint32(len(key)))
+	binary.LittleEndian.PutUint32(data[16:20], uint32(len(value)))
+	if aead != nil {
+		binary.LittleEndian.PutUint32(data[12:16], uint32(len(key))|format.EncryptedFlag)
+		data = append(data, format.Seal(aead, []byte(key+value), data[4:format.HeaderSizeV1])...)
+	} else {
+		data = append(data, key+value...)
+	}
+	binary.LittleEndian.PutUint32(data[0:4], crc32.ChecksumIEEE(data[4:]))
+	return data
+}
+
+func TestDiskStore_FormatV1(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	aead, _ := newCipher(key)
+	tests := []struct {
+		name string
+		aead cipher.AEAD
+		opts []Option
+	}{
+		{"plain", nil, nil},
+		{"encrypted", aead, []Option{WithEncryptionKey(key)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer removeStore("test.db")
+			// a file of the version 1, with its records in the version 1
+			header := format.EncodeFileHeader(1000)[:12]
+			binary.LittleEndian.PutUint32(header[4:8], 1)
+			header = binary.LittleEndian.AppendUint32(header, crc32.ChecksumIEEE(header))
+			ts := uint32(time.Now().Unix())
+			data := append(header, encodeKVV1(tt.aead, ts, "hamlet", "shakespeare")...)
+			data = append(data, encodeKVV1(tt.aead, ts, "dune", "herbert")...)
+			os.MkdirAll("test.db", 0755)
+			os.WriteFile(dataFileName("test.db", 1), data, 0644)
+
+			want := map[string]string{"hamlet": "shakespeare", "dune": "herbert"}
+			check := func(store *DiskStore) {
+				t.Helper()
+				for key, value := range want {
+					if got, err := store.Get(key); err != nil || got != value {
+						t.Errorf("Get(%v) = %v, %v, want %v", key, got, err, value)
+					}
+				}
+			}
+			store, err := Open("test.db", tt.opts...)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			check(store)
+			// the new records are not appended to a file of an older version
+			store.Set("othello", "shakespeare")
+			want["othello"] = "shakespeare"
+			if store.activeFileID != 2 {
+				t.Errorf("activeFileID = %v, want %v", store.activeFileID, 2)
+			}
+			if after, _ := os.ReadFile(dataFileName("test.db", 1)); !bytes.Equal(after, data) {
+				t.Errorf("Set() changed the data file of the version 1")
+			}
+			check(store)
+			store.Close()
+
+			// the hint file has the versions of the files
+			if store, err = Open("test.db", tt.opts...); err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			if store.recordsScanned != 0 {
+				t.Errorf("recordsScanned = %v, want the keys loaded from the hint file", store.recordsScanned)
+			}
+			check(store)
+			// merge upgrades the records to the current version
+			if err := store.Merge(); err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+			check(store)
+			store.Close()
+			fileNames, _ := DataFiles("test.db")
+			for _, fileName := range fileNames {
+				data, _ := os.ReadFile(fileName)
+				if header, err := format.DecodeFileHeader(data); err != nil || header.Version != format.Version {
+					t.Errorf("DecodeFileHeader(%v) = %+v, %v, want version %v", fileName, header, err, format.Version)
+				}
+			}
+			os.Remove(hintFileName("test.db"))
+			if store, err = Open("test.db", tt.opts...); err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			check(store)
+			store.Close()
+		})
+	}
+}
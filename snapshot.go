@@ -0,0 +1,142 @@
+package caskdb
+
+import (
+	"errors"
+	"sort"
+)
+
+// snapshot file provides a consistent, read only view of the store as of a point in
+// time. A backup or an export can walk every key of a snapshot while the writes go
+// on, without the store changing under it.
+//
+// Taking a snapshot copies the keyDir, and keeps the data files and the value logs it
+// points into. The records are never changed once written, so the copied keyDir
+// reads the same values for as long as the files are around. The writes only append
+// to the files and change the keyDir of the store, not the copy.
+//
+// Merge removes the old files, though. The files are pinned while a snapshot has
+// them: Merge still removes them from the data directory, so that they are not loaded
+// at the next startup, but does not close them, and the snapshot keeps reading them.
+// Unix keeps a removed file around till it is closed, which happens when the last
+// snapshot having it is released. Windows does not allow removing an open file, so
+// there Merge fails while a snapshot is open.
+
+// ErrSnapshotReleased is returned when reading a snapshot after Release
+var ErrSnapshotReleased = errors.New("snapshot is released")
+
+// Snapshot is a read only view of the store as of the time it was taken. It must be
+// released with Release once done, so that the files it pins can be closed. A
+// snapshot is safe for concurrent use
+type Snapshot struct {
+	store     *DiskStore
+	keyDir    *keyDir
+	files     map[uint32]File
+	valueLogs map[uint32]File
+	// now is when the snapshot was taken, the keys which expire later are still
+	// live in the snapshot
+	now      uint32
+	released bool
+}
+
+// Snapshot takes a snapshot of the store. It copies the keyDir, so it takes time and
+// memory in proportion to the number of keys
+func (d *DiskStore) Snapshot() *Snapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := &Snapshot{
+		store:     d,
+		keyDir:    d.keyDir.clone(),
+		files:     make(map[uint32]File, len(d.files)),
+		valueLogs: make(map[uint32]File, len(d.valueLogs)),
+		now:       uint32(d.now().Unix()),
+	}
+	for fileID, file := range d.files {
+		s.files[fileID] = file
+		d.pins[file]++
+	}
+	for fileID, file := range d.valueLogs {
+		s.valueLogs[fileID] = file
+		d.pins[file]++
+	}
+	return s
+}
+
+// closeFile closes a file the store does not use any more, unless a snapshot has it
+// pinned. Then it is closed when the snapshot is released
+func (d *DiskStore) closeFile(file File) {
+	if d.pins[file] > 0 {
+		d.retired[file] = true
+		return
+	}
+	file.Close()
+}
+
+// Get returns the value of the key as of the snapshot
+func (s *Snapshot) Get(key string) (string, error) {
+	s.store.mu.RLock()
+	defer s.store.mu.RUnlock()
+	if s.released {
+		return "", ErrSnapshotReleased
+	}
+	kEntry, ok := s.keyDir.get(key)
+	if !ok || kEntry.expired(s.now) {
+		return "", ErrKeyNotFound
+	}
+	value, err := readRecordValue(s.store.aead, s.files, s.valueLogs, kEntry)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+// Keys returns all the keys of the snapshot, in sorted order
+func (s *Snapshot) Keys() []string {
+	keys := make([]string, 0, s.keyDir.len())
+	s.keyDir.each(func(key string, kEntry KeyEntry) error {
+		if !kEntry.expired(s.now) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// Fold calls fn for every key of the snapshot and its value, in the key order. It
+// stops at the first error, from reading a value or returned by fn, and returns it
+func (s *Snapshot) Fold(fn func(key string, value string) error) error {
+	for _, key := range s.Keys() {
+		value, err := s.Get(key)
+		if err != nil {
+			return err
+		}
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release releases the files of the snapshot. The snapshot cannot be read after it
+func (s *Snapshot) Release() {
+	d := s.store
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	for _, files := range []map[uint32]File{s.files, s.valueLogs} {
+		for _, file := range files {
+			d.pins[file]--
+			if d.pins[file] > 0 {
+				continue
+			}
+			delete(d.pins, file)
+			if d.retired[file] {
+				delete(d.retired, file)
+				file.Close()
+			}
+		}
+	}
+}
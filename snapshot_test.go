@@ -0,0 +1,67 @@
+package caskdb
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiskStore_Snapshot(t *testing.T) {
+	store, err := Open("test.db", WithMaxFileSize(64), WithValueThreshold(16))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("name", "jojo")
+	store.Set("stand", strings.Repeat("star platinum ", 4))
+	store.Set("villain", "dio")
+	snap := store.Snapshot()
+
+	// the writes after the snapshot must not change it
+	store.Set("name", "jotaro")
+	store.Delete("villain")
+	store.Set("part", "3")
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	wantKeys := []string{"name", "stand", "villain"}
+	if keys := snap.Keys(); !reflect.DeepEqual(keys, wantKeys) {
+		t.Errorf("Keys() = %v, want %v", keys, wantKeys)
+	}
+	want := map[string]string{
+		"name":    "jojo",
+		"stand":   strings.Repeat("star platinum ", 4),
+		"villain": "dio",
+	}
+	got := make(map[string]string)
+	if err := snap.Fold(func(key string, value string) error {
+		got[key] = value
+		return nil
+	}); err != nil {
+		t.Fatalf("Fold() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Fold() got %v, want %v", got, want)
+	}
+	if _, err := snap.Get("part"); err != ErrKeyNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if val, _ := store.Get("name"); val != "jotaro" {
+		t.Errorf("Get() = %v, want %v", val, "jotaro")
+	}
+	if len(store.retired) == 0 {
+		t.Errorf("Merge() closed the files pinned by the snapshot")
+	}
+
+	snap.Release()
+	snap.Release()
+	if len(store.pins) != 0 || len(store.retired) != 0 {
+		t.Errorf("Release() left %d pinned and %d retired files", len(store.pins), len(store.retired))
+	}
+	if _, err := snap.Get("name"); err != ErrSnapshotReleased {
+		t.Errorf("Get() error = %v, want %v", err, ErrSnapshotReleased)
+	}
+	store.Close()
+}
@@ -0,0 +1,88 @@
+package caskdb
+
+import (
+	"time"
+	"unsafe"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// keyDirEntryOverhead estimates the memory taken by an entry of a keyDir of maps
+// besides its key: the KeyEntry, the string header of the key, and the bookkeeping of
+// the map
+const keyDirEntryOverhead = int64(unsafe.Sizeof(KeyEntry{})+unsafe.Sizeof("")) + 8
+
+// Stats describes the size and the state of a DiskStore, to help decide when to merge
+// it
+type Stats struct {
+	// Keys is the number of live keys, which is the same as Len
+	Keys int
+	// ExpiredKeys is the number of expired keys still in the keyDir, and
+	// ExpiredRemoved is the number of expired keys the janitor removed since the
+	// store was opened. Check janitor.go
+	ExpiredKeys    int
+	ExpiredRemoved int
+	// RecordsScanned is the number of records read from the data files when the
+	// store was opened. The records covered by the hint file are not read
+	RecordsScanned int
+	// DataFiles is the number of data files, and DataSize is their total size
+	DataFiles int
+	DataSize  int64
+	// LiveSize is the size of the records the live keys point to. The rest of the
+	// data files is taken by the overwritten and deleted keys, and the expired ones
+	LiveSize int64
+	// DeadSize is the part of DataSize not taken by the live records and the file
+	// headers, in bytes, and DeadRatio is the same from 0 to 1. Merge would reclaim
+	// it
+	DeadSize  int64
+	DeadRatio float64
+	// LastMerge is when Merge last completed, the zero time if it did not run since
+	// the store was opened
+	LastMerge time.Time
+	// KeyDirSize is an estimate of the memory taken by the keyDir, in bytes
+	KeyDirSize int64
+	// OpenFiles is the number of files the store holds open: the data files, the
+	// value logs, and the lock of the data directory
+	OpenFiles int
+}
+
+// Stats returns the current Stats of the store
+func (d *DiskStore) Stats() (Stats, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	stats := Stats{
+		RecordsScanned: d.recordsScanned,
+		DataFiles:      len(d.files),
+		LastMerge:      d.lastMerge,
+		ExpiredRemoved: d.expiredRemoved,
+		OpenFiles:      len(d.files) + len(d.valueLogs),
+	}
+	if d.lockFile != nil {
+		stats.OpenFiles++
+	}
+	for _, file := range d.files {
+		size, err := file.Size()
+		if err != nil {
+			return Stats{}, err
+		}
+		stats.DataSize += size
+	}
+	now := uint32(d.now().Unix())
+	stats.KeyDirSize = d.keyDir.memory()
+	d.keyDir.each(func(_ string, kEntry KeyEntry) error {
+		if kEntry.expired(now) {
+			stats.ExpiredKeys++
+			return nil
+		}
+		stats.Keys++
+		stats.LiveSize += int64(kEntry.totalSize)
+		return nil
+	})
+	// the files written before the file header was added don't have one, but it is
+	// too small to skew the ratio
+	if dead := stats.DataSize - stats.LiveSize - int64(len(d.files))*format.FileHeaderSize; dead > 0 {
+		stats.DeadSize = dead
+		stats.DeadRatio = float64(dead) / float64(stats.DataSize)
+	}
+	return stats, nil
+}
@@ -0,0 +1,66 @@
+package caskdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+func TestDiskStore_Stats(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	recordSize := int64(format.HeaderSize + len("key-0value"))
+	for i := 0; i < 4; i++ {
+		store.Set("key-0", "value")
+		store.Set("key-1", "value")
+	}
+	stats, err := store.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	dataSize := format.FileHeaderSize + 8*recordSize
+	if stats.Keys != 2 || stats.DataFiles != 1 || stats.DataSize != dataSize || stats.LiveSize != 2*recordSize {
+		t.Errorf("Stats() = %+v, want 2 keys in 1 file of %v bytes, %v of them live", stats, dataSize, 2*recordSize)
+	}
+	if stats.DeadSize != 6*recordSize {
+		t.Errorf("DeadSize = %v, want %v", stats.DeadSize, 6*recordSize)
+	}
+	if want := float64(6*recordSize) / float64(dataSize); stats.DeadRatio != want {
+		t.Errorf("DeadRatio = %v, want %v", stats.DeadRatio, want)
+	}
+	if !stats.LastMerge.IsZero() {
+		t.Errorf("LastMerge = %v, want the zero time", stats.LastMerge)
+	}
+	if stats.KeyDirSize <= 0 {
+		t.Errorf("KeyDirSize = %v, want more than 0", stats.KeyDirSize)
+	}
+	// the data file and the lock
+	if stats.OpenFiles != 2 {
+		t.Errorf("OpenFiles = %v, want %v", stats.OpenFiles, 2)
+	}
+
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	stats, _ = store.Stats()
+	if stats.DataSize != format.FileHeaderSize+2*recordSize || stats.DeadSize != 0 || stats.DeadRatio != 0 || stats.LastMerge.IsZero() {
+		t.Errorf("Stats() after Merge() = %+v", stats)
+	}
+	store.Close()
+
+	// without the hint file, all the records are read at the startup
+	os.Remove(hintFileName("test.db"))
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if stats, _ := store.Stats(); stats.RecordsScanned != 2 {
+		t.Errorf("RecordsScanned = %v, want %v", stats.RecordsScanned, 2)
+	}
+	store.Close()
+}
@@ -0,0 +1,165 @@
+package caskdb
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// storage file has the interface between the store and the file system. The store
+// does every file operation through a Storage, so that the files can live somewhere
+// else than in a directory on the disk, like in the memory for the tests, or later
+// in an object storage. OSStorage, the default, keeps them on the disk, and
+// MemoryStorage keeps them in the memory.
+//
+// A Storage deals in paths, like the os package, and the store builds them from the
+// data directory as usual. It needs only a few kinds of files: the data files and the
+// value logs, which are appended to and read at random, the hint file, which is
+// written once, and the lock file.
+
+// ErrLockUnsupported is returned by Storage.Lock when the file cannot be locked
+var ErrLockUnsupported = errors.New("storage does not support locking the file")
+
+// File is a file opened from a Storage
+type File interface {
+	io.ReaderAt
+	// Write appends to the file, a File is never written at an offset
+	io.Writer
+	Sync() error
+	Close() error
+	// Size returns the current size of the file
+	Size() (int64, error)
+}
+
+// Storage is where the store keeps its files. The errors for the missing files must
+// match fs.ErrNotExist with errors.Is, like the ones of the os package
+type Storage interface {
+	// OpenFile opens the file with the flags of os.OpenFile. The store opens the
+	// files read only, for appends with os.O_APPEND|os.O_RDWR|os.O_CREATE, or to
+	// write them from the start with os.O_CREATE|os.O_TRUNC|os.O_WRONLY
+	OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+	Remove(name string) error
+	Rename(oldName string, newName string) error
+	Truncate(name string, size int64) error
+	// ReadDir returns the names of the files in the directory, without the
+	// directory
+	ReadDir(name string) ([]string, error)
+	MkdirAll(name string) error
+	// Lock locks the open file, shared or exclusive, without waiting. It returns
+	// ErrDatabaseLocked if the file is locked already. The lock is released when
+	// the file is closed
+	Lock(file File, exclusive bool) error
+}
+
+// OSStorage keeps the files on the disk, with the os package
+type OSStorage struct{}
+
+// osFile is a File of OSStorage
+type osFile struct {
+	*os.File
+}
+
+func (f osFile) Size() (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (OSStorage) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	file, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return osFile{file}, nil
+}
+
+func (OSStorage) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSStorage) Rename(oldName string, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (OSStorage) Truncate(name string, size int64) error {
+	return os.Truncate(name, size)
+}
+
+func (OSStorage) ReadDir(name string) ([]string, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+func (OSStorage) MkdirAll(name string) error {
+	return os.MkdirAll(name, 0777)
+}
+
+func (OSStorage) Lock(file File, exclusive bool) error {
+	f, ok := file.(osFile)
+	if !ok {
+		return ErrLockUnsupported
+	}
+	return tryLock(f.File, exclusive)
+}
+
+// readFile reads the whole file from the storage
+func readFile(storage Storage, name string) ([]byte, error) {
+	file, err := storage.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	size, err := file.Size()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// fileSize returns the size of the file in the storage
+func fileSize(storage Storage, name string) (int64, error) {
+	file, err := storage.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return file.Size()
+}
+
+// mapFile returns the whole contents of the file, and the func which releases them.
+// The files on the disk are memory mapped, check mmapFile, the rest are read into
+// the memory
+func mapFile(file File) ([]byte, func() error, error) {
+	if f, ok := file.(osFile); ok {
+		data, err := mmapFile(f.File)
+		if err != nil {
+			return nil, nil, err
+		}
+		return data, func() error { return munmapFile(data) }, nil
+	}
+	size, err := file.Size()
+	if err != nil {
+		return nil, nil, err
+	}
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -1,7 +1,28 @@
 package caskdb
 
+import "errors"
+
+// ErrKeyNotFound is returned by Get when the key does not exist in the store
+var ErrKeyNotFound = errors.New("key not found")
+
+// Store is the interface of the key value stores. DiskStore, MemoryStore, CachedStore
+// and HybridStore implement it, so code written against it can use a MemoryStore in
+// its unit tests
 type Store interface {
-	Get(key string) string
-	Set(key string, value string)
-	Close() bool
+	Get(key string) (string, error)
+	Set(key string, value string) error
+	// Delete deletes the key, deleting a missing key is not an error
+	Delete(key string) error
+	// Fold calls fn for every key in the store and its value, in the key order.
+	// It stops at the first error, from reading a value or returned by fn, and
+	// returns it
+	Fold(fn func(key string, value string) error) error
+	Close() error
 }
+
+var (
+	_ Store = (*DiskStore)(nil)
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*CachedStore)(nil)
+	_ Store = (*HybridStore)(nil)
+)
@@ -0,0 +1,103 @@
+package caskdb
+
+import "time"
+
+// SyncPolicy decides when the writes are synced to the disk. Syncing after every
+// write is the safest, but it caps the write throughput at the fsync latency
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the active file after every write, so a write is on the disk
+	// once it returns. This is the default
+	SyncAlways SyncPolicy = iota
+	// SyncInterval syncs the active file periodically from a background flusher. A
+	// crash may lose the writes made since the last sync
+	SyncInterval
+	// SyncNever leaves the syncing to the OS. The files are still synced when we
+	// rotate away from them, on Close, and on an explicit Sync
+	SyncNever
+)
+
+// DefaultSyncInterval is how often the writes are synced with SyncInterval, when no
+// interval is given
+const DefaultSyncInterval = time.Second
+
+// SetSyncPolicy changes when the writes are synced to the disk. The interval is used
+// only with SyncInterval, and if it is not positive, DefaultSyncInterval is used
+func (d *DiskStore) SetSyncPolicy(policy SyncPolicy, interval time.Duration) {
+	d.mu.Lock()
+	stop, done := d.syncStop, d.syncDone
+	d.syncPolicy = policy
+	d.syncStop, d.syncDone = nil, nil
+	if policy == SyncInterval {
+		if interval <= 0 {
+			interval = DefaultSyncInterval
+		}
+		d.syncStop, d.syncDone = make(chan struct{}), make(chan struct{})
+		go d.runFlusher(interval, d.syncStop, d.syncDone)
+	}
+	d.mu.Unlock()
+	// the old flusher takes the lock to sync, so we wait for it only after we
+	// have released the lock
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// Sync writes the data not yet synced to the disk
+func (d *DiskStore) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sync()
+}
+
+// sync syncs the active file, if it has writes which are not synced yet
+func (d *DiskStore) sync() error {
+	if d.closed {
+		return ErrClosed
+	}
+	if !d.dirty {
+		return nil
+	}
+	// the values in the value log go first, so that the records pointing to them
+	// are never on the disk without them
+	if err := d.syncValueLog(); err != nil {
+		return err
+	}
+	if err := d.syncFile(d.activeFile()); err != nil {
+		return err
+	}
+	d.dirty = false
+	return nil
+}
+
+// runFlusher syncs the writes every interval, until stop is closed. It closes done
+// when it returns
+func (d *DiskStore) runFlusher(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := d.Sync(); err != nil {
+				d.options.Logger.Log(LevelError, "failed to sync", "dir", d.dirName, "err", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// stopFlusher stops the background flusher, if there is one, and waits for it
+func (d *DiskStore) stopFlusher() {
+	d.mu.Lock()
+	stop, done := d.syncStop, d.syncDone
+	d.syncStop, d.syncDone = nil, nil
+	d.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
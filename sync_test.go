@@ -0,0 +1,80 @@
+package caskdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDiskStore_SyncNever(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.SetSyncPolicy(SyncNever, 0)
+
+	store.Set("name", "jojo")
+	if !store.dirty {
+		t.Errorf("dirty = %v, want %v", store.dirty, true)
+	}
+	if val, _ := store.Get("name"); val != "jojo" {
+		t.Errorf("Get() = %v, want %v", val, "jojo")
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if store.dirty {
+		t.Errorf("dirty = %v, want %v", store.dirty, false)
+	}
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if val, _ := store.Get("name"); val != "jojo" {
+		t.Errorf("Get() = %v, want %v", val, "jojo")
+	}
+	store.Close()
+}
+
+func TestDiskStore_SyncInterval(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.SetSyncPolicy(SyncInterval, time.Millisecond)
+
+	store.Set("name", "jojo")
+	deadline := time.Now().Add(time.Second)
+	for {
+		store.mu.RLock()
+		dirty := store.dirty
+		store.mu.RUnlock()
+		if !dirty {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the flusher did not sync the write")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// going back to SyncAlways stops the flusher
+	store.SetSyncPolicy(SyncAlways, 0)
+	if store.syncStop != nil {
+		t.Errorf("the flusher is still running")
+	}
+	store.Set("name", "dio")
+	if store.dirty {
+		t.Errorf("dirty = %v, want %v", store.dirty, false)
+	}
+	store.SetSyncPolicy(SyncInterval, time.Hour)
+	if err := store.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if store.syncStop != nil {
+		t.Errorf("the flusher is still running after Close")
+	}
+}
@@ -0,0 +1,189 @@
+package caskdb
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// text index file provides a full-text inverted index over the values. It answers
+// "which keys have values containing these words" without reading every value from
+// the disk.
+//
+// Every value is split into terms: lower cased runs of letters and digits. For JSON
+// values, only the strings inside the document are split, so that the field names
+// and the punctuation don't end up in the index:
+//
+//	{"title": "War and Peace", "author": "Tolstoy"} -> war, and, peace, tolstoy
+//
+// The inverted index maps every term to the keys whose value contains it (called
+// the postings of the term), along with the number of times it occurs. A search
+// looks up the postings of every term of the query and returns the keys having all
+// of them, the ones with more occurrences first.
+//
+// Like the JSON indexes, the text index lives in memory and needs to be created
+// again after opening the store.
+
+// textIndex is an inverted index of terms to the keys having them
+type textIndex struct {
+	// postings maps the term to the keys containing it, and the number of times
+	// it occurs in the key's value
+	postings map[string]map[string]int
+	// terms maps the key to the terms of its value. On Set, we use it to remove
+	// the key from the postings of its previous value
+	terms map[string]map[string]int
+}
+
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// collectJSONStrings appends all the strings within a decoded JSON document
+func collectJSONStrings(doc interface{}, strs []string) []string {
+	switch doc := doc.(type) {
+	case string:
+		strs = append(strs, doc)
+	case []interface{}:
+		for _, item := range doc {
+			strs = collectJSONStrings(item, strs)
+		}
+	case map[string]interface{}:
+		for _, item := range doc {
+			strs = collectJSONStrings(item, strs)
+		}
+	}
+	return strs
+}
+
+// valueTerms returns the terms of the value and the number of times each occurs
+func valueTerms(value string) map[string]int {
+	texts := []string{value}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(value), &doc); err == nil {
+		texts = collectJSONStrings(doc, nil)
+	}
+	terms := make(map[string]int)
+	for _, text := range texts {
+		for _, term := range tokenize(text) {
+			terms[term]++
+		}
+	}
+	return terms
+}
+
+func newTextIndex() *textIndex {
+	return &textIndex{
+		postings: make(map[string]map[string]int),
+		terms:    make(map[string]map[string]int),
+	}
+}
+
+func (idx *textIndex) equal(other *textIndex) bool {
+	return reflect.DeepEqual(idx.postings, other.postings) && reflect.DeepEqual(idx.terms, other.terms)
+}
+
+func (idx *textIndex) update(key string, value string) {
+	for term := range idx.terms[key] {
+		delete(idx.postings[term], key)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	delete(idx.terms, key)
+	terms := valueTerms(value)
+	if len(terms) == 0 {
+		return
+	}
+	for term, count := range terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]int)
+		}
+		idx.postings[term][key] = count
+	}
+	idx.terms[key] = terms
+}
+
+// CreateTextIndex creates the full-text index and builds it from the existing data.
+// It reads every value from the disk, so it takes time accordingly
+func (d *DiskStore) CreateTextIndex() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.textIndex != nil {
+		return ErrIndexExists
+	}
+	idx := newTextIndex()
+	err := d.keyDir.each(func(key string, kEntry KeyEntry) error {
+		value, err := d.read(kEntry)
+		if err != nil {
+			return err
+		}
+		idx.update(key, value)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	d.textIndex = idx
+	return nil
+}
+
+// DropTextIndex removes the full-text index
+func (d *DiskStore) DropTextIndex() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.textIndex == nil {
+		return ErrIndexNotFound
+	}
+	d.textIndex = nil
+	return nil
+}
+
+// Search returns up to limit keys whose values contain all the terms of the query.
+// The keys with more occurrences of the terms come first, ties are broken by the
+// key order. A limit of zero or less returns all the matching keys
+func (d *DiskStore) Search(query string, limit int) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.textIndex == nil {
+		return nil, ErrIndexNotFound
+	}
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return []string{}, nil
+	}
+	scores := make(map[string]int)
+	for key, count := range d.textIndex.postings[terms[0]] {
+		scores[key] = count
+	}
+	for _, term := range terms[1:] {
+		postings := d.textIndex.postings[term]
+		for key := range scores {
+			count, ok := postings[key]
+			if !ok {
+				delete(scores, key)
+				continue
+			}
+			scores[key] += count
+		}
+	}
+	keys := make([]string, 0, len(scores))
+	for key := range scores {
+		if d.isLive(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if scores[keys[i]] != scores[keys[j]] {
+			return scores[keys[i]] > scores[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
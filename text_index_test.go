@@ -0,0 +1,68 @@
+package caskdb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_valueTerms(t *testing.T) {
+	tests := []struct {
+		value string
+		terms map[string]int
+	}{
+		{"War and Peace", map[string]int{"war": 1, "and": 1, "peace": 1}},
+		{`{"title": "Peace, peace!", "year": 1869}`, map[string]int{"peace": 2}},
+		{`["Crime", {"and": "Punishment"}]`, map[string]int{"crime": 1, "punishment": 1}},
+		{"", map[string]int{}},
+	}
+	for _, tt := range tests {
+		if terms := valueTerms(tt.value); !reflect.DeepEqual(terms, tt.terms) {
+			t.Errorf("valueTerms(%v) = %v, want %v", tt.value, terms, tt.terms)
+		}
+	}
+}
+
+func TestDiskStore_Search(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("book:1", `{"title": "War and Peace", "author": "Tolstoy"}`)
+	store.Set("book:2", "Anna Karenina by Tolstoy")
+	if _, err := store.Search("tolstoy", 0); err != ErrIndexNotFound {
+		t.Errorf("Search() error = %v, want %v", err, ErrIndexNotFound)
+	}
+	if err := store.CreateTextIndex(); err != nil {
+		t.Fatalf("CreateTextIndex() error = %v", err)
+	}
+	store.Set("book:3", "Tolstoy, the life of Tolstoy")
+	store.Set("book:2", "Crime and Punishment by Dostoevsky")
+
+	tests := []struct {
+		query string
+		limit int
+		keys  []string
+	}{
+		{"tolstoy", 0, []string{"book:3", "book:1"}},
+		{"TOLSTOY", 1, []string{"book:3"}},
+		{"war tolstoy", 0, []string{"book:1"}},
+		{"and", 0, []string{"book:1", "book:2"}},
+		{"karenina", 0, []string{}},
+		{"", 0, []string{}},
+	}
+	for _, tt := range tests {
+		keys, err := store.Search(tt.query, tt.limit)
+		if err != nil {
+			t.Fatalf("Search() error = %v", err)
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("Search(%v) = %v, want %v", tt.query, keys, tt.keys)
+		}
+	}
+	if err := store.DropTextIndex(); err != nil {
+		t.Errorf("DropTextIndex() error = %v", err)
+	}
+	store.Close()
+}
@@ -0,0 +1,133 @@
+package caskdb
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// time index file provides an index of the keys by the time they were last written,
+// answering "which keys were written or updated between t1 and t2". This is handy
+// for incremental ETL jobs, which only want the keys changed since their last run,
+// and for audits.
+//
+// The index is a list of (timestamp, key) entries sorted by the timestamp, and we
+// find the entries within a time range with a binary search. The timestamps come
+// from the keyDir, so building the index does not read anything from the disk.
+// Timestamps are stored with a precision of seconds, so are the queries.
+//
+// Like the other indexes, the time index lives in memory and needs to be created
+// again after opening the store.
+
+type timeEntry struct {
+	timestamp uint32
+	key       string
+}
+
+// timeIndex keeps the keys sorted by their last write time
+type timeIndex struct {
+	entries []timeEntry
+}
+
+// newTimeIndex builds the time index from the timestamps in the keyDir
+func newTimeIndex(keyDir *keyDir) *timeIndex {
+	idx := &timeIndex{entries: make([]timeEntry, 0, keyDir.len())}
+	keyDir.each(func(key string, kEntry KeyEntry) error {
+		idx.entries = append(idx.entries, timeEntry{kEntry.timestamp, key})
+		return nil
+	})
+	sort.Slice(idx.entries, func(i, j int) bool {
+		if idx.entries[i].timestamp != idx.entries[j].timestamp {
+			return idx.entries[i].timestamp < idx.entries[j].timestamp
+		}
+		return idx.entries[i].key < idx.entries[j].key
+	})
+	return idx
+}
+
+func (idx *timeIndex) equal(other *timeIndex) bool {
+	if len(idx.entries) != len(other.entries) {
+		return false
+	}
+	for i := range idx.entries {
+		if idx.entries[i] != other.entries[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (idx *timeIndex) search(entry timeEntry) int {
+	return sort.Search(len(idx.entries), func(i int) bool {
+		if idx.entries[i].timestamp != entry.timestamp {
+			return idx.entries[i].timestamp > entry.timestamp
+		}
+		return strings.Compare(idx.entries[i].key, entry.key) >= 0
+	})
+}
+
+// update moves the key from its previous timestamp, if any, to the new one
+func (idx *timeIndex) update(key string, previous KeyEntry, exists bool, timestamp uint32) {
+	if exists {
+		idx.remove(key, previous)
+	}
+	entry := timeEntry{timestamp, key}
+	i := idx.search(entry)
+	idx.entries = append(idx.entries, timeEntry{})
+	copy(idx.entries[i+1:], idx.entries[i:])
+	idx.entries[i] = entry
+}
+
+func (idx *timeIndex) remove(key string, previous KeyEntry) {
+	i := idx.search(timeEntry{previous.timestamp, key})
+	idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+}
+
+// CreateTimeIndex creates the index of the keys by their last write time
+func (d *DiskStore) CreateTimeIndex() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timeIndex != nil {
+		return ErrIndexExists
+	}
+	idx := newTimeIndex(d.keyDir)
+	d.timeIndex = idx
+	return nil
+}
+
+// DropTimeIndex removes the index of the keys by their last write time
+func (d *DiskStore) DropTimeIndex() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timeIndex == nil {
+		return ErrIndexNotFound
+	}
+	d.timeIndex = nil
+	return nil
+}
+
+// KeysBetween returns the keys last written at or after start and before end,
+// ordered by their write time
+func (d *DiskStore) KeysBetween(start time.Time, end time.Time) ([]string, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.timeIndex == nil {
+		return nil, ErrIndexNotFound
+	}
+	keys := []string{}
+	// the timestamps are unsigned seconds, clamp the range to what they can hold
+	from, to := start.Unix(), end.Unix()
+	if from < 0 {
+		from = 0
+	}
+	if to <= from {
+		return keys, nil
+	}
+	i := sort.Search(len(d.timeIndex.entries), func(i int) bool {
+		return int64(d.timeIndex.entries[i].timestamp) >= from
+	})
+	for ; i < len(d.timeIndex.entries) && int64(d.timeIndex.entries[i].timestamp) < to; i++ {
+		keys = append(keys, d.timeIndex.entries[i].key)
+	}
+	return d.liveKeys(keys), nil
+}
@@ -0,0 +1,55 @@
+package caskdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiskStore_KeysBetween(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("hamlet", "shakespeare")
+	if _, err := store.KeysBetween(time.Unix(0, 0), time.Now()); err != ErrIndexNotFound {
+		t.Errorf("KeysBetween() error = %v, want %v", err, ErrIndexNotFound)
+	}
+	if err := store.CreateTimeIndex(); err != nil {
+		t.Fatalf("CreateTimeIndex() error = %v", err)
+	}
+	store.Set("dune", "frank herbert")
+	store.Set("othello", "shakespeare")
+	// move the existing entries back in time, as if they were written earlier
+	hamlet, _ := store.keyDir.get("hamlet")
+	dune, _ := store.keyDir.get("dune")
+	store.timeIndex.update("hamlet", hamlet, true, 100)
+	store.timeIndex.update("dune", dune, true, 200)
+
+	tests := []struct {
+		start, end int64
+		keys       []string
+	}{
+		{0, 100, []string{}},
+		{0, 101, []string{"hamlet"}},
+		{100, 201, []string{"hamlet", "dune"}},
+		{150, time.Now().Unix() + 1, []string{"dune", "othello"}},
+		{-10, 300, []string{"hamlet", "dune"}},
+		{300, 200, []string{}},
+	}
+	for _, tt := range tests {
+		keys, err := store.KeysBetween(time.Unix(tt.start, 0), time.Unix(tt.end, 0))
+		if err != nil {
+			t.Fatalf("KeysBetween() error = %v", err)
+		}
+		if !reflect.DeepEqual(keys, tt.keys) {
+			t.Errorf("KeysBetween(%v, %v) = %v, want %v", tt.start, tt.end, keys, tt.keys)
+		}
+	}
+	if err := store.DropTimeIndex(); err != nil {
+		t.Errorf("DropTimeIndex() error = %v", err)
+	}
+	store.Close()
+}
@@ -0,0 +1,97 @@
+package caskdb
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidTTL is returned by SetWithTTL when the TTL is not positive
+var ErrInvalidTTL = errors.New("ttl must be positive")
+
+// NoExpiry is returned by TTL for a key which never expires
+const NoExpiry time.Duration = -1
+
+// expiryFromTime converts t to the expiry stored in the records, in unix epoch
+// seconds. The expiry is rounded up to the next second, so that a key never expires
+// before its TTL. The zero time converts to zero, which never expires
+func expiryFromTime(t time.Time) uint32 {
+	if t.IsZero() {
+		return 0
+	}
+	expiry := t.Unix()
+	if t.Nanosecond() > 0 {
+		expiry++
+	}
+	return uint32(expiry)
+}
+
+// expiryTime converts the expiry stored in the records to time, zero converts to
+// the zero time
+func expiryTime(expiry uint32) time.Time {
+	if expiry == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(expiry), 0)
+}
+
+// SetWithTTL stores the key and value, like Set, and expires the key after ttl. An
+// expired key is treated as missing: Get returns ErrKeyNotFound, and the key is left
+// out of Keys and the index queries. Merge drops the expired keys from the disk.
+//
+// The expiry is stored in the record with a precision of seconds, rounded up. A
+// later Set of the key removes the expiry, while the read-modify-write operations
+// like SetBit and IncrCounter keep it
+func (d *DiskStore) SetWithTTL(key string, value string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+	if err := checkReserved(key); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.set(key, value, uint32(time.Now().Unix()), expiryFromTime(d.now().Add(ttl)))
+}
+
+// TTL returns the remaining lifetime of the key, or NoExpiry if the key never
+// expires. It returns ErrKeyNotFound if the key does not exist or is expired. Like
+// Has, it does not wait for the writes in progress
+func (d *DiskStore) TTL(key string) (time.Duration, error) {
+	now := d.now()
+	kEntry, ok := d.keyDir.get(key)
+	if !ok || kEntry.expired(uint32(now.Unix())) {
+		return 0, ErrKeyNotFound
+	}
+	if kEntry.expiry == 0 {
+		return NoExpiry, nil
+	}
+	return expiryTime(kEntry.expiry).Sub(now), nil
+}
+
+// isLive tells whether the key exists and is not expired
+func (d *DiskStore) isLive(key string) bool {
+	kEntry, ok := d.keyDir.get(key)
+	return ok && !kEntry.expired(uint32(d.now().Unix()))
+}
+
+// liveExpiry returns the expiry of the key, or zero if the key does not exist or is
+// expired. The read-modify-write operations write the new value with it, so that
+// they keep the expiry of the key
+func (d *DiskStore) liveExpiry(key string) uint32 {
+	if !d.isLive(key) {
+		return 0
+	}
+	kEntry, _ := d.keyDir.get(key)
+	return kEntry.expiry
+}
+
+// liveKeys filters out the keys which are expired, in place
+func (d *DiskStore) liveKeys(keys []string) []string {
+	live := keys[:0]
+	for _, key := range keys {
+		if d.isLive(key) {
+			live = append(live, key)
+		}
+	}
+	return live
+}
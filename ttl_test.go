@@ -0,0 +1,133 @@
+package caskdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiskStore_SetWithTTL(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+
+	if err := store.SetWithTTL("session", "jojo", 0); err != ErrInvalidTTL {
+		t.Errorf("SetWithTTL() error = %v, want %v", err, ErrInvalidTTL)
+	}
+	if err := store.SetWithTTL("session", "jojo", time.Minute); err != nil {
+		t.Fatalf("SetWithTTL() error = %v", err)
+	}
+	store.Set("name", "jojo")
+	if ttl, err := store.TTL("session"); err != nil || ttl != time.Minute {
+		t.Errorf("TTL() = %v, %v, want %v", ttl, err, time.Minute)
+	}
+	if ttl, err := store.TTL("name"); err != nil || ttl != NoExpiry {
+		t.Errorf("TTL() = %v, %v, want %v", ttl, err, NoExpiry)
+	}
+	if _, err := store.TTL("missing"); err != ErrKeyNotFound {
+		t.Errorf("TTL() error = %v, want %v", err, ErrKeyNotFound)
+	}
+
+	now = now.Add(59 * time.Second)
+	if val, err := store.Get("session"); err != nil || val != "jojo" {
+		t.Errorf("Get() = %v, %v, want %v", val, err, "jojo")
+	}
+	now = now.Add(time.Second)
+	if _, err := store.Get("session"); err != ErrKeyNotFound {
+		t.Errorf("Get() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if _, err := store.TTL("session"); err != ErrKeyNotFound {
+		t.Errorf("TTL() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	if keys, want := store.Keys(), []string{"name"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("Keys() = %v, want %v", keys, want)
+	}
+	// an expired key can be written again, without the old expiry
+	if applied, _ := store.SetIfNewer("session", "dio", now.Add(-time.Hour)); !applied {
+		t.Errorf("SetIfNewer() = %v, want %v", applied, true)
+	}
+	if ttl, err := store.TTL("session"); err != nil || ttl != NoExpiry {
+		t.Errorf("TTL() = %v, %v, want %v", ttl, err, NoExpiry)
+	}
+	store.Close()
+}
+
+func TestDiskStore_TTLKeptByReadModifyWrite(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	now := time.Unix(1700000000, 0)
+	store.now = func() time.Time { return now }
+
+	store.SetWithTTL("visits", "", time.Minute)
+	store.IncrCounter("visits", "a", 1)
+	if ttl, _ := store.TTL("visits"); ttl != time.Minute {
+		t.Errorf("TTL() after IncrCounter() = %v, want %v", ttl, time.Minute)
+	}
+	store.Set("visits", "")
+	if ttl, _ := store.TTL("visits"); ttl != NoExpiry {
+		t.Errorf("TTL() after Set() = %v, want %v", ttl, NoExpiry)
+	}
+
+	// a read-modify-write on an expired key starts from an empty value, and does
+	// not inherit the old expiry
+	store.SetWithTTL("flags", "\xff", time.Minute)
+	now = now.Add(time.Hour)
+	if prev, _ := store.SetBit("flags", 7, true); prev {
+		t.Errorf("SetBit() = %v, want %v", prev, false)
+	}
+	if count, _ := store.BitCount("flags"); count != 1 {
+		t.Errorf("BitCount() = %v, want %v", count, 1)
+	}
+	if ttl, _ := store.TTL("flags"); ttl != NoExpiry {
+		t.Errorf("TTL() = %v, want %v", ttl, NoExpiry)
+	}
+	store.Close()
+}
+
+func TestDiskStore_TTLWithPersistence(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.CreateIndex("$.author")
+
+	store.SetWithTTL("hamlet", `{"author": "shakespeare"}`, time.Hour)
+	// write a key which is already expired in real time
+	store.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	store.SetWithTTL("othello", `{"author": "shakespeare"}`, time.Hour)
+	store.now = time.Now
+	if keys, _ := store.QueryIndex("$.author", "shakespeare"); !reflect.DeepEqual(keys, []string{"hamlet"}) {
+		t.Errorf("QueryIndex() = %v, want %v", keys, []string{"hamlet"})
+	}
+	before := storeSize("test.db")
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if _, ok := store.keyDir.get("othello"); ok {
+		t.Errorf("Merge() kept the expired key")
+	}
+	if after := storeSize("test.db"); after >= before {
+		t.Errorf("Merge() size = %v, want less than %v", after, before)
+	}
+	if mismatched, _ := store.RebuildIndexes(); len(mismatched) != 0 {
+		t.Errorf("RebuildIndexes() = %v, want []", mismatched)
+	}
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if ttl, err := store.TTL("hamlet"); err != nil || ttl <= 59*time.Minute || ttl > time.Hour+time.Second {
+		t.Errorf("TTL() = %v, %v, want about %v", ttl, err, time.Hour)
+	}
+	store.Close()
+}
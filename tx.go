@@ -0,0 +1,146 @@
+package caskdb
+
+import "errors"
+
+// tx file has the transactions, which read and write several keys as one. A
+// transaction reads a consistent view of the store, and sees its own writes before
+// they are committed.
+//
+// Update runs a read-write transaction with the store locked, so no other write gets
+// in between its reads and its writes, and the reads of the other goroutines wait for
+// it too. The writes are kept in the transaction until fn returns, and then committed
+// as a single Batch: either all of them are applied, or none. ViewTx runs a read-only
+// transaction, with the store locked shared, so it runs in parallel with the other
+// reads.
+//
+// Since the store is locked while fn runs, fn must not use the store other than
+// through the transaction, and should be quick.
+
+var (
+	// ErrTxClosed is returned when using a transaction after its fn returned
+	ErrTxClosed = errors.New("transaction is closed")
+	// ErrTxReadOnly is returned when writing in a transaction of ViewTx
+	ErrTxReadOnly = errors.New("transaction is read-only")
+)
+
+// Tx is a transaction, passed to the fn of Update and ViewTx. It must not be used
+// after fn returns, nor by other goroutines while fn runs
+type Tx struct {
+	store    *DiskStore
+	writable bool
+	closed   bool
+	// batch has the writes of the transaction, in their order, and pending has
+	// the value of every key written, nil for a deleted key
+	batch   *Batch
+	pending map[string]*string
+}
+
+// Update runs fn in a read-write transaction. If fn returns nil, the writes of the
+// transaction are committed, else they are dropped, and the error of fn is returned
+//
+// Typical usage example:
+//
+//	err := store.Update(func(tx *Tx) error {
+//		stock, err := tx.Get("othello")
+//		if err != nil {
+//			return err
+//		}
+//		return tx.Set("othello", stock+"!")
+//	})
+func (d *DiskStore) Update(fn func(tx *Tx) error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	tx := &Tx{store: d, writable: true, batch: NewBatch(), pending: make(map[string]*string)}
+	defer func() { tx.closed = true }()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.batch.ops) == 0 {
+		return nil
+	}
+	return d.commit(tx.batch)
+}
+
+// ViewTx runs fn in a read-only transaction, and returns the error of fn. The writes
+// in it return ErrTxReadOnly. It is named apart from View, which reads a single value
+// without copying it
+func (d *DiskStore) ViewTx(fn func(tx *Tx) error) error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	tx := &Tx{store: d}
+	defer func() { tx.closed = true }()
+	return fn(tx)
+}
+
+// Get returns the value of the key, as written in the transaction if it was, else as
+// in the store. It returns ErrKeyNotFound if the key does not exist, or was deleted
+// in the transaction
+func (tx *Tx) Get(key string) (string, error) {
+	if tx.closed {
+		return "", ErrTxClosed
+	}
+	if value, ok := tx.pending[key]; ok {
+		if value == nil {
+			return "", ErrKeyNotFound
+		}
+		return *value, nil
+	}
+	return tx.store.get(key)
+}
+
+// Has tells whether the key exists, as of the writes of the transaction
+func (tx *Tx) Has(key string) bool {
+	if tx.closed {
+		return false
+	}
+	if value, ok := tx.pending[key]; ok {
+		return value != nil
+	}
+	return tx.store.isLive(key)
+}
+
+// Set stores the key and value when the transaction is committed. The sizes are
+// checked right away, so it returns ErrKeyTooLarge and the like now rather than on
+// commit
+func (tx *Tx) Set(key string, value string) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := checkReserved(key); err != nil {
+		return err
+	}
+	if err := tx.store.checkSize(key, value); err != nil {
+		return err
+	}
+	tx.batch.Set(key, value)
+	tx.pending[key] = &value
+	return nil
+}
+
+// Delete removes the key when the transaction is committed. Deleting a key which
+// does not exist is a no-op
+func (tx *Tx) Delete(key string) error {
+	if err := tx.checkWritable(); err != nil {
+		return err
+	}
+	if err := checkReserved(key); err != nil {
+		return err
+	}
+	if !tx.Has(key) {
+		return nil
+	}
+	tx.batch.Delete(key)
+	tx.pending[key] = nil
+	return nil
+}
+
+// checkWritable returns an error if the transaction cannot be written to
+func (tx *Tx) checkWritable() error {
+	if tx.closed {
+		return ErrTxClosed
+	}
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package caskdb
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestDiskStore_Update(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("othello", "shakespeare")
+	store.Set("hamlet", "shakespeare")
+
+	var leaked *Tx
+	err = store.Update(func(tx *Tx) error {
+		leaked = tx
+		if err := tx.Set("emma", "austen"); err != nil {
+			return err
+		}
+		if err := tx.Delete("othello"); err != nil {
+			return err
+		}
+		// the reads see the writes of the transaction
+		if val, err := tx.Get("emma"); err != nil || val != "austen" {
+			t.Errorf("Get() in the transaction = %v, %v, want %v", val, err, "austen")
+		}
+		if _, err := tx.Get("othello"); err != ErrKeyNotFound {
+			t.Errorf("Get() of a key deleted in the transaction error = %v, want %v", err, ErrKeyNotFound)
+		}
+		if val, _ := tx.Get("hamlet"); val != "shakespeare" {
+			t.Errorf("Get() of a key not written in the transaction = %v, want %v", val, "shakespeare")
+		}
+		// the store does not, until the commit
+		if _, err := store.get("emma"); err != ErrKeyNotFound {
+			t.Errorf("get() before the commit error = %v, want %v", err, ErrKeyNotFound)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if val, _ := store.Get("emma"); val != "austen" {
+		t.Errorf("Get() after Update() = %v, want %v", val, "austen")
+	}
+	if store.Has("othello") {
+		t.Errorf("Has() of a key deleted by Update() = %v, want %v", true, false)
+	}
+	if _, err := leaked.Get("emma"); err != ErrTxClosed {
+		t.Errorf("Get() after Update() returned error = %v, want %v", err, ErrTxClosed)
+	}
+
+	// an error drops all the writes
+	errAbort := errors.New("abort")
+	err = store.Update(func(tx *Tx) error {
+		tx.Set("hamlet", "marlowe")
+		tx.Delete("emma")
+		return errAbort
+	})
+	if err != errAbort {
+		t.Errorf("Update() error = %v, want %v", err, errAbort)
+	}
+	if val, _ := store.Get("hamlet"); val != "shakespeare" {
+		t.Errorf("Get() after a failed Update() = %v, want %v", val, "shakespeare")
+	}
+	if !store.Has("emma") {
+		t.Errorf("Has() after a failed Update() = %v, want %v", false, true)
+	}
+
+	err = store.Update(func(tx *Tx) error {
+		return tx.Set("", "austen")
+	})
+	if err != ErrEmptyKey {
+		t.Errorf("Update() with an empty key error = %v, want %v", err, ErrEmptyKey)
+	}
+	store.Close()
+
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if val, _ := store.Get("emma"); val != "austen" || store.Has("othello") {
+		t.Errorf("the writes of Update() are not there after reopening the store")
+	}
+	store.Close()
+}
+
+func TestDiskStore_UpdateConcurrent(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.SetSyncPolicy(SyncNever, 0)
+	store.Set("count", "0")
+
+	// no increment is lost, since no write gets in between the read and the write
+	// of a transaction
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				store.Update(func(tx *Tx) error {
+					val, _ := tx.Get("count")
+					n, _ := strconv.Atoi(val)
+					return tx.Set("count", strconv.Itoa(n+1))
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	if val, _ := store.Get("count"); val != "100" {
+		t.Errorf("Get() = %v, want %v", val, "100")
+	}
+	store.Close()
+}
+
+func TestDiskStore_ViewTx(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("othello", "shakespeare")
+
+	err = store.ViewTx(func(tx *Tx) error {
+		if val, err := tx.Get("othello"); err != nil || val != "shakespeare" {
+			t.Errorf("Get() = %v, %v, want %v", val, err, "shakespeare")
+		}
+		if !tx.Has("othello") || tx.Has("hamlet") {
+			t.Errorf("Has() does not match the store")
+		}
+		if err := tx.Set("hamlet", "shakespeare"); err != ErrTxReadOnly {
+			t.Errorf("Set() error = %v, want %v", err, ErrTxReadOnly)
+		}
+		if err := tx.Delete("othello"); err != ErrTxReadOnly {
+			t.Errorf("Delete() error = %v, want %v", err, ErrTxReadOnly)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("ViewTx() error = %v", err)
+	}
+	store.Close()
+}
@@ -0,0 +1,167 @@
+package caskdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/avinassh/go-caskdb/format"
+)
+
+// value log file moves the large values out of the data files, as in WiscKey. Merge
+// copies every live record to new files, so with multi-megabyte values inline, every
+// merge rewrites all of them even if they never change. With the value threshold
+// option, a value larger than it is appended to a value log file, and the data file
+// gets a small record with a pointer to it instead (check format.ValuePointerFlag).
+// Merge then copies just the pointers, and a Get reads the value straight from the
+// value log.
+//
+// The value log files are numbered like the data files: the values are appended to
+// the value log with the ID of the active data file, so a new value log is started
+// along with a new data file. Merge does not rewrite the value logs, instead it
+// removes the ones which no live record points to any more. A value log with even one
+// live value is kept as a whole, so the space of the dead values in it is reclaimed
+// only when all of its values are dead.
+//
+// The value is written before the record pointing to it, so a crash in between leaves
+// just some unreferenced bytes in the value log.
+
+// valueLogExt is the extension of the value log files in the data directory
+const valueLogExt = ".vlog"
+
+// ErrValueLogMissing is returned when a record points to a value log file which does
+// not exist
+var ErrValueLogMissing = errors.New("value log file not found")
+
+// valueLogFileName returns the path of the value log file with the ID in the data
+// directory
+func valueLogFileName(dirName string, fileID uint32) string {
+	return filepath.Join(dirName, fmt.Sprintf("%010d%s", fileID, valueLogExt))
+}
+
+// openValueLogs opens all the value log files in the data directory for reads
+func (d *DiskStore) openValueLogs() error {
+	fileIDs, err := listFiles(d.options.Storage, d.dirName, valueLogExt)
+	if err != nil {
+		return err
+	}
+	for _, fileID := range fileIDs {
+		file, err := d.options.Storage.OpenFile(valueLogFileName(d.dirName, fileID), os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		d.valueLogs[fileID] = file
+	}
+	return nil
+}
+
+// encodeKV encodes the record of the key and value, compressing the value if it is
+// larger than the compression threshold, and moving it out to the value log if it is
+// still larger than the value threshold
+func (d *DiskStore) encodeKV(timestamp uint32, expiry uint32, key string, value string) (int, []byte, error) {
+	value, compressed := d.compressValue(value)
+	var size int
+	var data []byte
+	if d.options.ValueThreshold <= 0 || len(value) <= d.options.ValueThreshold {
+		size, data = format.EncodeKVWithExpiry(timestamp, expiry, key, value)
+	} else {
+		if d.options.ReadOnly {
+			return 0, nil, ErrReadOnly
+		}
+		pointer, err := d.writeValue(d.sealValue(key, []byte(value)))
+		if err != nil {
+			return 0, nil, err
+		}
+		size, data = format.EncodeValuePointer(timestamp, expiry, key, pointer)
+	}
+	if compressed {
+		format.MarkCompressed(data)
+	}
+	if d.aead != nil {
+		data = d.encrypt(data)
+		size = len(data)
+	}
+	return size, data, nil
+}
+
+// writeValue appends the value to the value log of the active data file, and returns
+// the pointer to it
+func (d *DiskStore) writeValue(value []byte) (format.ValuePointer, error) {
+	if d.activeValueLog != d.activeFileID {
+		// the value log is opened for appends only now, the ones opened at the
+		// startup are read only
+		file, err := d.options.Storage.OpenFile(valueLogFileName(d.dirName, d.activeFileID), os.O_APPEND|os.O_RDWR|os.O_CREATE, d.options.FileMode)
+		if err != nil {
+			return format.ValuePointer{}, err
+		}
+		size, err := file.Size()
+		if err != nil {
+			file.Close()
+			return format.ValuePointer{}, err
+		}
+		if old, ok := d.valueLogs[d.activeFileID]; ok {
+			d.closeFile(old)
+		}
+		d.valueLogs[d.activeFileID] = file
+		d.activeValueLog, d.valueLogPosition = d.activeFileID, size
+	}
+	file := d.valueLogs[d.activeValueLog]
+	if _, err := file.Write(value); err != nil {
+		return format.ValuePointer{}, err
+	}
+	pointer := format.NewValuePointer(d.activeValueLog, uint64(d.valueLogPosition), value)
+	d.valueLogPosition += int64(len(value))
+	// the value must be on the disk before the record pointing to it
+	if d.syncPolicy == SyncAlways {
+		if err := d.syncFile(file); err != nil {
+			return format.ValuePointer{}, err
+		}
+	}
+	return pointer, nil
+}
+
+// syncValueLog syncs the value log being appended to, if there is one
+func (d *DiskStore) syncValueLog() error {
+	if file, ok := d.valueLogs[d.activeValueLog]; ok {
+		return d.syncFile(file)
+	}
+	return nil
+}
+
+// readValue reads the value which the encoded pointer points to, from the given
+// value logs
+func readValue(valueLogs map[uint32]File, encoded []byte) ([]byte, error) {
+	pointer, err := format.DecodeValuePointer(encoded)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := valueLogs[pointer.FileID]
+	if !ok {
+		return nil, ErrValueLogMissing
+	}
+	value := make([]byte, pointer.Size)
+	if _, err := file.ReadAt(value, int64(pointer.Offset)); err != nil {
+		return nil, err
+	}
+	if err := pointer.Verify(value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// removeDeadValueLogs removes the value logs which none of the live records point to.
+// live has the IDs of the value logs which they do point to
+func (d *DiskStore) removeDeadValueLogs(live map[uint32]bool) error {
+	for fileID, file := range d.valueLogs {
+		if live[fileID] || fileID == d.activeValueLog {
+			continue
+		}
+		d.closeFile(file)
+		delete(d.valueLogs, fileID)
+		if err := d.options.Storage.Remove(valueLogFileName(d.dirName, fileID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package caskdb
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDiskStore_ValueLog(t *testing.T) {
+	store, err := Open("test.db", WithValueThreshold(16))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer removeStore("test.db")
+
+	large := strings.Repeat("shakespeare", 10)
+	store.Set("hamlet", large)
+	store.Set("dune", "herbert")
+	batch := NewBatch()
+	batch.Set("othello", large+"!")
+	store.Commit(batch)
+	if !isFileExists(valueLogFileName("test.db", 1)) {
+		t.Fatalf("the large values were not written to the value log")
+	}
+	// only the pointers go to the data file
+	if info, _ := os.Stat(valueLogFileName("test.db", 1)); info.Size() != int64(2*len(large)+1) {
+		t.Errorf("value log size = %v, want %v", info.Size(), 2*len(large)+1)
+	}
+	if size := storeSize("test.db"); size >= int64(2*len(large)) {
+		t.Errorf("data files size = %v, want less than %v", size, 2*len(large))
+	}
+	store.Close()
+
+	store, err = Open("test.db", WithValueThreshold(16))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	tests := map[string]string{"hamlet": large, "dune": "herbert", "othello": large + "!"}
+	for key, val := range tests {
+		if got, _ := store.Get(key); got != val {
+			t.Errorf("Get(%v) = %v, want %v", key, got, val)
+		}
+	}
+
+	// once no live record points to a value log, merge removes it. The new value
+	// goes to the value log of the new data file
+	store.SetMaxFileSize(1)
+	store.Set("dune", "frank herbert")
+	store.Set("hamlet", large)
+	store.Delete("othello")
+	if err := store.Merge(); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if isFileExists(valueLogFileName("test.db", 1)) {
+		t.Errorf("Merge() did not remove the dead value log")
+	}
+	if got, _ := store.Get("hamlet"); got != large {
+		t.Errorf("Get() after Merge() = %v, want %v", got, large)
+	}
+	store.Close()
+}
+
+func TestDiskStore_ValueLogCorrupt(t *testing.T) {
+	store, err := Open("test.db", WithValueThreshold(4))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer removeStore("test.db")
+	store.Set("hamlet", "shakespeare")
+
+	data, _ := os.ReadFile(valueLogFileName("test.db", 1))
+	data[0] ^= 0x01
+	os.WriteFile(valueLogFileName("test.db", 1), data, 0666)
+	if _, err := store.Get("hamlet"); err != ErrChecksumMismatch {
+		t.Errorf("Get() error = %v, want %v", err, ErrChecksumMismatch)
+	}
+	store.Close()
+
+	os.Remove(valueLogFileName("test.db", 1))
+	store, err = NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	if _, err := store.Get("hamlet"); err != ErrValueLogMissing {
+		t.Errorf("Get() error = %v, want %v", err, ErrValueLogMissing)
+	}
+	store.Close()
+}
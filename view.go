@@ -0,0 +1,44 @@
+package caskdb
+
+import "sync"
+
+// viewBufferMaxSize is the size of the largest buffer which View returns to the pool,
+// the larger ones are left to the garbage collector, so that a few large values do
+// not keep a lot of memory around
+const viewBufferMaxSize = 1 << 20
+
+// viewBuffers are the buffers which View reads the records into
+var viewBuffers = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+// View calls fn with the value of the key, without copying it into a new string like
+// Get does. The record is read into a buffer from a pool, which is reused once fn
+// returns, so a read heavy workload does not allocate for every read. The value is
+// valid only while fn runs, fn must copy it to keep it. View returns ErrKeyNotFound if
+// the key does not exist, and otherwise the error returned by fn.
+//
+// The store is not locked while fn runs, so fn may read and write the store. The
+// values which are compressed, encrypted or in a value log are read into a new slice,
+// as Get does.
+func (d *DiskStore) View(key string, fn func(value []byte) error) error {
+	d.mu.RLock()
+	kEntry, ok := d.keyDir.get(key)
+	if !ok || kEntry.expired(uint32(d.now().Unix())) {
+		d.mu.RUnlock()
+		return ErrKeyNotFound
+	}
+	buf := viewBuffers.Get().(*[]byte)
+	if cap(*buf) < int(kEntry.totalSize) {
+		*buf = make([]byte, kEntry.totalSize)
+	}
+	value, err := d.readBytesInto(kEntry, (*buf)[:kEntry.totalSize])
+	d.mu.RUnlock()
+	if err == nil {
+		err = fn(value)
+	}
+	if cap(*buf) <= viewBufferMaxSize {
+		viewBuffers.Put(buf)
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package caskdb
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiskStore_View(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	store.Set("othello", "shakespeare")
+	store.Set("dune", "frank herbert")
+	for key, want := range map[string]string{"othello": "shakespeare", "dune": "frank herbert"} {
+		var got string
+		if err := store.View(key, func(value []byte) error {
+			got = string(value)
+			return nil
+		}); err != nil {
+			t.Fatalf("View() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("View() value = %q, want %q", got, want)
+		}
+	}
+	if err := store.View("emma", func(value []byte) error { return nil }); err != ErrKeyNotFound {
+		t.Errorf("View() error = %v, want %v", err, ErrKeyNotFound)
+	}
+	stop := errors.New("stop")
+	if err := store.View("dune", func(value []byte) error { return stop }); err != stop {
+		t.Errorf("View() error = %v, want %v", err, stop)
+	}
+	// fn may write to the store
+	if err := store.View("dune", func(value []byte) error { return store.Set("dune", "herbert") }); err != nil {
+		t.Errorf("View() error = %v", err)
+	}
+
+	var n int
+	allocs := testing.AllocsPerRun(100, func() {
+		store.View("othello", func(value []byte) error {
+			n += len(value)
+			return nil
+		})
+	})
+	if allocs > 0 {
+		t.Errorf("View() allocates %v times per call, want none", allocs)
+	}
+	store.Close()
+}
@@ -0,0 +1,143 @@
+package caskdb
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of change an Event is about
+type EventType int
+
+const (
+	// EventSet is a write of the key, by Set or any of the other writes
+	EventSet EventType = iota
+	// EventDelete is a delete of the key
+	EventDelete
+)
+
+// String returns the name of the event type
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	}
+	return "unknown"
+}
+
+// Event is a change of a key, which Watch delivers
+type Event struct {
+	Type EventType
+	Key  string
+	// Value is the new value of the key, empty for EventDelete
+	Value string
+	// Timestamp is the timestamp of the record of the change
+	Timestamp time.Time
+}
+
+// CancelFunc stops a watch
+type CancelFunc func()
+
+// watcher delivers the events of a Watch. The writes queue the events without ever
+// blocking, and run forwards them to the channel, so a slow reader does not hold up
+// the store
+type watcher struct {
+	prefix string
+	events chan Event
+	// mu guards the queue, and ready tells run that there is something in it
+	mu    sync.Mutex
+	queue []Event
+	ready chan struct{}
+	// done is closed when the watch is cancelled
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Watch returns a channel, which receives an Event for every change of the keys with
+// the prefix, in the order they happen. The empty prefix watches all the keys. The
+// event is sent after the change is written, and is visible to the reads. The keys
+// expiring and Merge dropping them are not changes.
+//
+// The events are queued for the reader, so the writes don't wait for it, but the
+// queue grows as long as the reader falls behind. The watch runs until the
+// CancelFunc is called or the store is closed, and then the channel is closed. The
+// events not received by then are dropped
+func (d *DiskStore) Watch(prefix string) (<-chan Event, CancelFunc) {
+	w := &watcher{
+		prefix: prefix,
+		events: make(chan Event),
+		ready:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	d.mu.Lock()
+	if d.watchers == nil {
+		d.watchers = make(map[*watcher]bool)
+	}
+	d.watchers[w] = true
+	d.mu.Unlock()
+	go w.run()
+	cancel := func() {
+		d.mu.Lock()
+		delete(d.watchers, w)
+		d.mu.Unlock()
+		w.stop()
+	}
+	return w.events, cancel
+}
+
+// notify queues the event for the watchers of its key
+func (d *DiskStore) notify(event Event) {
+	for w := range d.watchers {
+		if strings.HasPrefix(event.Key, w.prefix) {
+			w.push(event)
+		}
+	}
+}
+
+// stopWatchers stops all the watches, when the store is closed
+func (d *DiskStore) stopWatchers() {
+	for w := range d.watchers {
+		w.stop()
+		delete(d.watchers, w)
+	}
+}
+
+func (w *watcher) push(event Event) {
+	w.mu.Lock()
+	w.queue = append(w.queue, event)
+	w.mu.Unlock()
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (w *watcher) stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+}
+
+// run sends the queued events to the channel until the watch is stopped, and then
+// closes it
+func (w *watcher) run() {
+	defer close(w.events)
+	for {
+		select {
+		case <-w.ready:
+		case <-w.done:
+			return
+		}
+		w.mu.Lock()
+		queue := w.queue
+		w.queue = nil
+		w.mu.Unlock()
+		for _, event := range queue {
+			select {
+			case w.events <- event:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
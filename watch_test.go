@@ -0,0 +1,61 @@
+package caskdb
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDiskStore_Watch(t *testing.T) {
+	store, err := NewDiskStore("test.db")
+	if err != nil {
+		t.Fatalf("failed to create disk store: %v", err)
+	}
+	defer removeStore("test.db")
+
+	events, cancel := store.Watch("user:")
+	all, _ := store.Watch("")
+	store.Set("user:1", "hamlet")
+	store.Set("book:1", "dune")
+	store.Delete("user:1")
+	store.Delete("user:2")
+	batch := NewBatch()
+	batch.Set("user:2", "othello")
+	batch.Delete("book:1")
+	store.Commit(batch)
+
+	want := []string{"set user:1=hamlet", "delete user:1=", "set user:2=othello"}
+	for _, w := range want {
+		select {
+		case event := <-events:
+			if got := fmt.Sprintf("%v %v=%v", event.Type, event.Key, event.Value); got != w {
+				t.Errorf("Watch() event = %v, want %v", got, w)
+			}
+			if event.Timestamp.IsZero() {
+				t.Errorf("Watch() event %v has no timestamp", w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Watch() did not deliver %v", w)
+		}
+	}
+	cancel()
+	cancel()
+	if _, ok := <-events; ok {
+		t.Errorf("Watch() channel is open after cancel, want closed")
+	}
+	store.Set("user:3", "lear")
+
+	// the watch of all the keys gets all the changes, and stops when the store is
+	// closed
+	for i := 0; i < 6; i++ {
+		select {
+		case <-all:
+		case <-time.After(time.Second):
+			t.Fatalf("Watch(\"\") delivered %v events, want 6", i)
+		}
+	}
+	store.Close()
+	if _, ok := <-all; ok {
+		t.Errorf("Watch() channel is open after Close, want closed")
+	}
+}